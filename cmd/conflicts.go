@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for listing and resolving sync conflicts
+
+sfs client conflicts
+sfs client conflicts --id --keep-local
+sfs client conflicts --id --keep-remote
+sfs client conflicts --id --keep-both
+*/
+
+var conflictsCmd = &cobra.Command{
+	Use:   "conflicts",
+	Short: "List and resolve files that were changed on this device and on the server",
+	Run:   RunConflictsCmd,
+}
+
+func init() {
+	flags := FlagPole{}
+	conflictsCmd.Flags().StringVar(&flags.id, "id", "", "id of the conflict to resolve")
+	conflictsCmd.Flags().BoolVar(&flags.keep_local, "keep-local", false, "keep the local version and overwrite the server's version")
+	conflictsCmd.Flags().BoolVar(&flags.keep_remote, "keep-remote", false, "keep the server's version and discard the local changes")
+	conflictsCmd.Flags().BoolVar(&flags.keep_both, "keep-both", false, "keep both versions. the local version is added as a new file")
+
+	viper.BindPFlag("id", conflictsCmd.Flags().Lookup("id"))
+	viper.BindPFlag("keep-local", conflictsCmd.Flags().Lookup("keep-local"))
+	viper.BindPFlag("keep-remote", conflictsCmd.Flags().Lookup("keep-remote"))
+	viper.BindPFlag("keep-both", conflictsCmd.Flags().Lookup("keep-both"))
+
+	clientCmd.AddCommand(conflictsCmd)
+}
+
+func RunConflictsCmd(cmd *cobra.Command, args []string) {
+	id, _ := cmd.Flags().GetString("id")
+	keepLocal, _ := cmd.Flags().GetBool("keep-local")
+	keepRemote, _ := cmd.Flags().GetBool("keep-remote")
+	keepBoth, _ := cmd.Flags().GetBool("keep-both")
+
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if id == "" {
+		if err := c.ListConflicts(); err != nil {
+			showerr(err)
+		}
+		return
+	}
+
+	var resolution string
+	var n int
+	if keepLocal {
+		resolution = svc.KeepLocal
+		n++
+	}
+	if keepRemote {
+		resolution = svc.KeepRemote
+		n++
+	}
+	if keepBoth {
+		resolution = svc.KeepBoth
+		n++
+	}
+	if n != 1 {
+		showerr(fmt.Errorf("specify exactly one of --keep-local, --keep-remote, or --keep-both"))
+		return
+	}
+	if err := c.ResolveConflict(id, resolution); err != nil {
+		showerr(err)
+	}
+}
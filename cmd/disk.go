@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for mirroring a directory to another local disk.
+Doesn't need a client or a server.
+
+sfs disk mirror --src --dest
+sfs disk mirror --src --dest --daemon --interval
+*/
+
+var (
+	diskCmd = &cobra.Command{
+		Use:   "disk",
+		Short: "Command for backing up to other local disks",
+		Run:   RunDiskCmd,
+	}
+	diskMirrorCmd = &cobra.Command{
+		Use:   "mirror",
+		Short: "Mirror a directory to another disk, keeping its layout",
+		Run:   RunDiskMirrorCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	diskMirrorCmd.Flags().StringVar(&flags.src, "src", "", "directory to mirror")
+	diskMirrorCmd.Flags().StringVar(&flags.dest, "dest", "", "directory on the other disk to mirror to")
+	diskMirrorCmd.Flags().BoolVar(&flags.daemon, "daemon", false, "wait for the destination to become available (i.e. the disk is mounted) and keep it mirrored")
+	diskMirrorCmd.Flags().StringVar(&flags.interval, "interval", "1h", "how often to mirror while the destination is available. only used with --daemon")
+
+	viper.BindPFlag("src", diskMirrorCmd.Flags().Lookup("src"))
+	viper.BindPFlag("dest", diskMirrorCmd.Flags().Lookup("dest"))
+	viper.BindPFlag("daemon", diskMirrorCmd.Flags().Lookup("daemon"))
+	viper.BindPFlag("interval", diskMirrorCmd.Flags().Lookup("interval"))
+
+	diskCmd.AddCommand(diskMirrorCmd)
+	rootCmd.AddCommand(diskCmd)
+}
+
+func RunDiskCmd(cmd *cobra.Command, args []string) {
+	cmd.Help()
+}
+
+func RunDiskMirrorCmd(cmd *cobra.Command, args []string) {
+	src, _ := cmd.Flags().GetString("src")
+	dest, _ := cmd.Flags().GetString("dest")
+	if src == "" || dest == "" {
+		showerr(fmt.Errorf("both --src and --dest are required"))
+		return
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	daemon, _ := cmd.Flags().GetBool("daemon")
+	if !daemon {
+		if err := svc.MirrorDisk(src, dest); err != nil {
+			showerr(err)
+		}
+		return
+	}
+	interval, _ := cmd.Flags().GetString("interval")
+	every, err := time.ParseDuration(interval)
+	if err != nil || every <= 0 {
+		showerr(fmt.Errorf("invalid mirror interval: %q", interval))
+		return
+	}
+	stop := make(chan bool)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+	fmt.Printf("mirroring %s to %s every %v while it's available. ctrl+c to stop\n", src, dest, every)
+	if err := svc.DiskDaemon(src, dest, every, stop); err != nil {
+		showerr(err)
+	}
+}
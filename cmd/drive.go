@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/sfs/pkg/client"
 	"github.com/spf13/cobra"
@@ -17,11 +18,30 @@ sfs drive --register
 sfs drive --refresh
 sfs drive --list-files
 sfs drive --list-dirs
+sfs drive --max-versions --version-age
+sfs drive --checksum sha256|blake2b|xxh64
 
 // add or remove files
 
 sfs drive add --path
 sfs drive remove --path
+
+// storage usage
+
+sfs drive usage
+
+// recycle bin
+
+sfs drive trash list|restore|empty
+
+// snapshots
+
+sfs drive snapshot create|list|diff|restore|remove
+sfs drive snapshot --interval --keep
+
+// end-to-end encryption
+
+sfs drive e2ee enable|export|import
 */
 
 var (
@@ -38,11 +58,17 @@ func init() {
 	drvCmd.Flags().BoolVar(&flags.list_files, "list-files", false, "list all local files managed by the sfs client service")
 	drvCmd.Flags().BoolVar(&flags.list_dirs, "list-dirs", false, "list all local directories managed by the sfs client service")
 	drvCmd.Flags().BoolVar(&flags.remote, "remote", false, "list all files stored on the sfs server")
+	drvCmd.Flags().IntVar(&flags.max_versions, "max-versions", -1, "max number of versions of each file to keep on the server. 0 for no limit")
+	drvCmd.Flags().StringVar(&flags.version_age, "version-age", "", "max age of saved file versions (i.e. 720h). 0 for no limit")
+	drvCmd.Flags().StringVar(&flags.checksum, "checksum", "", "checksum algorithm for the drive's files (sha256, blake2b, or xxh64)")
 
 	viper.BindPFlag("register", drvCmd.PersistentFlags().Lookup("register"))
 	viper.BindPFlag("list-files", drvCmd.PersistentFlags().Lookup("list-files"))
 	viper.BindPFlag("list-dirs", drvCmd.PersistentFlags().Lookup("list-dirs"))
 	viper.BindPFlag("remote", drvCmd.Flags().Lookup("remote"))
+	viper.BindPFlag("max-versions", drvCmd.Flags().Lookup("max-versions"))
+	viper.BindPFlag("version-age", drvCmd.Flags().Lookup("version-age"))
+	viper.BindPFlag("checksum", drvCmd.Flags().Lookup("checksum"))
 
 	rootCmd.AddCommand(drvCmd)
 }
@@ -52,12 +78,18 @@ func getDrvflags(cmd *cobra.Command) FlagPole {
 	list_files, _ := cmd.Flags().GetBool("list-files")
 	list_dirs, _ := cmd.Flags().GetBool("list-dirs")
 	remote, _ := cmd.Flags().GetBool("remote")
+	max_versions, _ := cmd.Flags().GetInt("max-versions")
+	version_age, _ := cmd.Flags().GetString("version-age")
+	checksum, _ := cmd.Flags().GetString("checksum")
 
 	return FlagPole{
-		register:   register,
-		list_files: list_files,
-		list_dirs:  list_dirs,
-		remote:     remote,
+		register:     register,
+		list_files:   list_files,
+		list_dirs:    list_dirs,
+		remote:       remote,
+		max_versions: max_versions,
+		version_age:  version_age,
+		checksum:     checksum,
 	}
 }
 
@@ -87,5 +119,31 @@ func RunDrvCmd(cmd *cobra.Command, args []string) {
 		}
 	case f.refresh:
 		c.RefreshDrive()
+	case f.max_versions >= 0 || f.version_age != "":
+		if err := setVersionRetention(c, f); err != nil {
+			showerr(err)
+		}
+	case f.checksum != "":
+		if err := c.SetAlgorithm(f.checksum); err != nil {
+			showerr(err)
+		}
+	}
+}
+
+// update file version retention settings. any setting that
+// wasn't specified keeps its current value.
+func setVersionRetention(c *client.Client, f FlagPole) error {
+	maxVersions := c.Drive.MaxVersions
+	if f.max_versions >= 0 {
+		maxVersions = f.max_versions
+	}
+	maxAge := c.Drive.VersionMaxAge
+	if f.version_age != "" {
+		age, err := time.ParseDuration(f.version_age)
+		if err != nil {
+			return fmt.Errorf("invalid version age: %v", err)
+		}
+		maxAge = age
 	}
+	return c.SetVersionRetention(maxVersions, maxAge)
 }
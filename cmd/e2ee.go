@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for end-to-end encrypting the drive
+
+sfs drive e2ee enable --names
+sfs drive e2ee export
+sfs drive e2ee import --key
+
+the passphrase for the drive's keys on this device is read from
+CLIENT_E2EE_PASSPHRASE if it's set, otherwise it's prompted for.
+*/
+
+var (
+	e2eeCmd = &cobra.Command{
+		Use:   "e2ee",
+		Short: "Manage end-to-end encryption for the drive",
+	}
+	e2eeEnableCmd = &cobra.Command{
+		Use:   "enable",
+		Short: "Encrypt the drive's files on this device so the server never sees them",
+		Run:   RunE2EEEnableCmd,
+	}
+	e2eeExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "Export the drive's keys so they can be imported on another device",
+		Run:   RunE2EEExportCmd,
+	}
+	e2eeImportCmd = &cobra.Command{
+		Use:   "import",
+		Short: "Import the drive's keys from another device",
+		Run:   RunE2EEImportCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	e2eeEnableCmd.Flags().BoolVar(&flags.names, "names", false, "encrypt file and directory names too")
+	e2eeImportCmd.Flags().StringVar(&flags.key, "key", "", "keys from 'sfs drive e2ee export' on another device")
+
+	viper.BindPFlag("names", e2eeEnableCmd.Flags().Lookup("names"))
+	viper.BindPFlag("key", e2eeImportCmd.Flags().Lookup("key"))
+
+	e2eeCmd.AddCommand(e2eeEnableCmd)
+	e2eeCmd.AddCommand(e2eeExportCmd)
+	e2eeCmd.AddCommand(e2eeImportCmd)
+	drvCmd.AddCommand(e2eeCmd)
+}
+
+// get a new passphrase for the drive's keys on this device
+func newPassphrase(c *client.Client) (string, error) {
+	if p := os.Getenv(client.PassphraseEnv); p != "" {
+		return p, nil
+	}
+	p := c.Passphrase("new passphrase")
+	if p != c.Passphrase("confirm passphrase") {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return p, nil
+}
+
+func RunE2EEEnableCmd(cmd *cobra.Command, args []string) {
+	names, _ := cmd.Flags().GetBool("names")
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	fmt.Println("files will be uploaded again, encrypted. keep your passphrase somewhere safe: they can't be recovered without it.")
+	if !c.Continue() {
+		return
+	}
+	passphrase, err := newPassphrase(c)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if err := c.EnableE2EE(passphrase, names); err != nil {
+		showerr(err)
+	}
+}
+
+func RunE2EEExportCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	exported, err := c.ExportKeys(c.Passphrase("passphrase for the exported keys"))
+	if err != nil {
+		showerr(err)
+		return
+	}
+	fmt.Println(exported)
+}
+
+func RunE2EEImportCmd(cmd *cobra.Command, args []string) {
+	key, _ := cmd.Flags().GetString("key")
+	if key == "" {
+		showerr(fmt.Errorf("no keys specified"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	exportPassphrase := c.Passphrase("passphrase the keys were exported with")
+	passphrase, err := newPassphrase(c)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if err := c.ImportKeys(key, exportPassphrase, passphrase); err != nil {
+		showerr(err)
+	}
+}
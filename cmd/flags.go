@@ -12,9 +12,10 @@ type FlagPole struct {
 	info    bool // get information about the client
 
 	// drive command flags
-	register   bool // register a new drive with the sfs server
-	list_files bool // list all files
-	list_dirs  bool // list all directories
+	register   bool   // register a new drive with the sfs server
+	list_files bool   // list all files
+	list_dirs  bool   // list all directories
+	checksum   string // checksum algorithm to use for the drive's files
 
 	// discover command flags
 	daemon bool // run in daemon mode
@@ -37,6 +38,43 @@ type FlagPole struct {
 	// ignore list flag
 	ignore string
 
+	// file version cmd flags
+	rev          int    // revision number to restore
+	max_versions int    // max number of versions to keep per file
+	version_age  string // max age of a saved version (i.e. 720h)
+
+	// recycle bin cmd flags
+	id        string // id of the item to restore
+	retention string // how long to keep deleted items (i.e. 720h)
+
+	// snapshot cmd flags
+	to       string // id of a later snapshot to compare with
+	interval string // how often to snapshot the drive (i.e. 24h)
+	keep     int    // number of scheduled snapshots to keep
+
+	// conflict cmd flags
+	keep_local  bool // resolve a conflict by keeping the local version
+	keep_remote bool // resolve a conflict by keeping the remote version
+	keep_both   bool // resolve a conflict by keeping both versions
+
+	// transfer queue cmd flags
+	retry  bool // try a failed transfer again
+	cancel bool // remove a transfer from the queue
+	clear  bool // remove finished transfers from the queue
+
+	// bandwidth limit cmd flags
+	upload   string // upload limit (i.e. 1MB)
+	download string // download limit (i.e. 1MB)
+	window   string // time of day the limits apply (i.e. 08:00-23:00)
+	reset    bool   // remove all bandwidth limits
+
+	// pull cmd flags
+	format string // archive format for directory downloads
+
+	// e2ee cmd flags
+	names bool   // seal file and directory names too
+	key   string // keys exported from another device
+
 	// remove cmd
 	delete bool // true to delete. false to just stop monitoring the item.
 
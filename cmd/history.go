@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+List saved versions of a file on the SFS server
+
+sfs client history --path
+*/
+
+var (
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "List saved versions of a file on the SFS server",
+		Run:   RunHistoryCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	historyCmd.PersistentFlags().StringVar(&flags.path, "path", "", "path to the file to list versions of")
+
+	viper.BindPFlag("path", historyCmd.PersistentFlags().Lookup("path"))
+
+	clientCmd.AddCommand(historyCmd)
+}
+
+func RunHistoryCmd(cmd *cobra.Command, args []string) {
+	filePath, _ := cmd.Flags().GetString("path")
+	if filePath == "" {
+		showerr(fmt.Errorf("no file path specified"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	file, err := c.GetFileByPath(filePath)
+	if err != nil {
+		showerr(fmt.Errorf("failed to get file: %v", err))
+		return
+	}
+	if file == nil {
+		showerr(fmt.Errorf("file not found"))
+		return
+	}
+	if err := c.ListVersions(file); err != nil {
+		showerr(fmt.Errorf("failed to list versions: %v", err))
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for managing exclusion rules. Items matching a global pattern
+(CLIENT_IGNORE) or a .sfsignore file aren't discovered, monitored, or synced.
+
+sfs client ignore             list global patterns
+sfs client ignore --add       add a pattern to the root .sfsignore file
+sfs client ignore check path  explain which rule (if any) matches an item
+*/
+
+var (
+	ignoreCmd = &cobra.Command{
+		Use:   "ignore",
+		Short: "Manage patterns for files and directories SFS should ignore",
+		Run:   RunIgnoreCmd,
+	}
+	ignoreCheckCmd = &cobra.Command{
+		Use:   "check <path>",
+		Short: "Show whether a file or directory is ignored, and which rule matched",
+		Args:  cobra.ExactArgs(1),
+		Run:   RunIgnoreCheckCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	ignoreCmd.PersistentFlags().StringVar(&flags.ignore, "add", "", "pattern to add to the root .sfsignore file")
+
+	viper.BindPFlag("add", ignoreCmd.PersistentFlags().Lookup("add"))
+
+	ignoreCmd.AddCommand(ignoreCheckCmd)
+	clientCmd.AddCommand(ignoreCmd)
+}
+
+func RunIgnoreCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	pattern, _ := cmd.Flags().GetString("add")
+	if pattern != "" {
+		if err := c.AddIgnore(pattern); err != nil {
+			showerr(fmt.Errorf("failed to add pattern: %v", err))
+		}
+		return
+	}
+	rules := c.Ignores().Global()
+	if len(rules) == 0 {
+		fmt.Println("no global ignore patterns")
+		return
+	}
+	fmt.Println("global ignore patterns:")
+	for _, rule := range rules {
+		fmt.Printf("  %s\n", rule.Pattern)
+	}
+}
+
+func RunIgnoreCheckCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	result, err := c.CheckIgnore(args[0])
+	if err != nil {
+		showerr(err)
+		return
+	}
+	fmt.Println(result)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+	"github.com/sfs/pkg/transfer"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for limiting upload and download bandwidth
+
+sfs client limit
+sfs client limit --upload 1MB --download 2MB
+sfs client limit --window 08:00-23:00 --upload 1MB --download 1MB
+sfs client limit --reset
+sfs client limit --window 08:00-23:00 --reset
+
+limits apply to a running client within a few seconds.
+a limit of 0 is unlimited.
+*/
+
+var limitCmd = &cobra.Command{
+	Use:   "limit",
+	Short: "Show or set upload and download bandwidth limits",
+	Run:   RunLimitCmd,
+}
+
+func init() {
+	flags := FlagPole{}
+	limitCmd.Flags().StringVar(&flags.upload, "upload", "", "upload limit per second (i.e. 512KB, 1MB). 0 is unlimited")
+	limitCmd.Flags().StringVar(&flags.download, "download", "", "download limit per second (i.e. 512KB, 1MB). 0 is unlimited")
+	limitCmd.Flags().StringVar(&flags.window, "window", "", "only apply the limits during this time of day (i.e. 08:00-23:00)")
+	limitCmd.Flags().BoolVar(&flags.reset, "reset", false, "remove all limits, or just the limits for --window")
+
+	viper.BindPFlag("upload", limitCmd.Flags().Lookup("upload"))
+	viper.BindPFlag("download", limitCmd.Flags().Lookup("download"))
+	viper.BindPFlag("window", limitCmd.Flags().Lookup("window"))
+	viper.BindPFlag("reset", limitCmd.Flags().Lookup("reset"))
+
+	clientCmd.AddCommand(limitCmd)
+}
+
+func RunLimitCmd(cmd *cobra.Command, args []string) {
+	window, _ := cmd.Flags().GetString("window")
+	reset, _ := cmd.Flags().GetBool("reset")
+
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	limits, err := c.LoadLimits()
+	if err != nil {
+		showerr(err)
+		return
+	}
+	setUp, setDown := cmd.Flags().Changed("upload"), cmd.Flags().Changed("download")
+	if !reset && !setUp && !setDown {
+		if err := c.ShowLimits(); err != nil {
+			showerr(err)
+		}
+		return
+	}
+
+	switch {
+	case reset && window == "":
+		limits = new(transfer.Limits)
+	case reset:
+		kept := make([]*transfer.Window, 0, len(limits.Windows))
+		for _, w := range limits.Windows {
+			if w.Span() != window {
+				kept = append(kept, w)
+			}
+		}
+		if len(kept) == len(limits.Windows) {
+			showerr(fmt.Errorf("no limits set for %s", window))
+			return
+		}
+		limits.Windows = kept
+	default:
+		up, err := rateFlag(cmd, "upload")
+		if err != nil {
+			showerr(err)
+			return
+		}
+		down, err := rateFlag(cmd, "download")
+		if err != nil {
+			showerr(err)
+			return
+		}
+		if window == "" {
+			if setUp {
+				limits.Upload = up
+			}
+			if setDown {
+				limits.Download = down
+			}
+			break
+		}
+		w, err := transfer.NewWindow(window, up, down)
+		if err != nil {
+			showerr(err)
+			return
+		}
+		// keep whichever limit wasn't given for an existing window
+		for _, cur := range limits.Windows {
+			if cur.Span() == w.Span() {
+				if !setUp {
+					w.Upload = cur.Upload
+				}
+				if !setDown {
+					w.Download = cur.Download
+				}
+			}
+		}
+		limits.SetWindow(w)
+	}
+	if err := c.SetLimits(limits); err != nil {
+		showerr(err)
+		return
+	}
+	if err := c.ShowLimits(); err != nil {
+		showerr(err)
+	}
+}
+
+// parse a rate flag. returns 0 (unlimited) if it wasn't given.
+func rateFlag(cmd *cobra.Command, name string) (int64, error) {
+	if !cmd.Flags().Changed(name) {
+		return 0, nil
+	}
+	rate, _ := cmd.Flags().GetString(name)
+	return transfer.ParseRate(rate)
+}
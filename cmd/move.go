@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Command for renaming or moving directories within the SFS filesystem.
+changes are sent to the server during the next sync (or right away if
+auto sync is enabled.)
+
+sfs drive move --src <dir path> --dest <new dir path>
+*/
+
+var (
+	MoveCmd = &cobra.Command{
+		Use:   "move",
+		Short: "Rename or move a directory within the SFS filesystem",
+		Run:   moveCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	MoveCmd.PersistentFlags().StringVar(&flags.src, "src", "", "absolute path of the directory to move")
+	MoveCmd.PersistentFlags().StringVar(&flags.dest, "dest", "", "absolute path of the directory's new location, including its (new) name")
+
+	viper.BindPFlag("src", MoveCmd.PersistentFlags().Lookup("src"))
+	viper.BindPFlag("dest", MoveCmd.PersistentFlags().Lookup("dest"))
+
+	drvCmd.AddCommand(MoveCmd)
+}
+
+func moveCmd(cmd *cobra.Command, args []string) {
+	src, _ := cmd.Flags().GetString("src")
+	dest, _ := cmd.Flags().GetString("dest")
+	if src == "" || dest == "" {
+		showerr(fmt.Errorf("both --src and --dest are required"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	dir, err := c.GetDirByPath(src)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if err := c.MoveDir(dir, dest); err != nil {
+		showerr(fmt.Errorf("failed to move directory: %v", err))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/sfs/pkg/client"
+	"github.com/sfs/pkg/transfer"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -24,6 +25,8 @@ var (
 func init() {
 	flags := FlagPole{}
 	pullCmd.Flags().StringVar(&flags.name, "name", "", "name of the item to pull")
+	pullCmd.Flags().StringVar(&flags.format, "format", transfer.FormatZip, "archive format for directories (zip, tar, tar.gz, tar.zst)")
+	pullCmd.Flags().StringVar(&flags.dest, "dest", "", "where to save a directory's archive")
 
 	viper.BindPFlag("pull", pullCmd.PersistentFlags().Lookup("name"))
 
@@ -49,7 +52,20 @@ func RunPullCmd(cmd *cobra.Command, args []string) {
 		return
 	}
 	if file == nil {
-		showerr(fmt.Errorf("file %s not found", name))
+		// directories are downloaded as an archive
+		dir, err := c.GetDirByName(name)
+		if err != nil {
+			showerr(fmt.Errorf("%s not found: %v", name, err))
+			return
+		}
+		format, _ := cmd.Flags().GetString("format")
+		dest, _ := cmd.Flags().GetString("dest")
+		saved, err := c.DownloadDir(dir, format, dest)
+		if err != nil {
+			showerr(fmt.Errorf("failed to pull directory: %v", err))
+			return
+		}
+		fmt.Printf("saved %s to %s\n", dir.Name, saved)
 		return
 	}
 
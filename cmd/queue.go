@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for managing the transfer queue
+
+sfs client queue
+sfs client queue --retry
+sfs client queue --id --retry
+sfs client queue --id --cancel
+sfs client queue --clear
+*/
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Show, retry, or cancel queued uploads and downloads",
+	Run:   RunQueueCmd,
+}
+
+func init() {
+	flags := FlagPole{}
+	queueCmd.Flags().StringVar(&flags.id, "id", "", "id of the transfer to retry or cancel")
+	queueCmd.Flags().BoolVar(&flags.retry, "retry", false, "try a failed transfer again. retries every failed transfer if no --id is given")
+	queueCmd.Flags().BoolVar(&flags.cancel, "cancel", false, "remove a transfer from the queue without finishing it")
+	queueCmd.Flags().BoolVar(&flags.clear, "clear", false, "remove finished transfers from the queue")
+
+	viper.BindPFlag("id", queueCmd.Flags().Lookup("id"))
+	viper.BindPFlag("retry", queueCmd.Flags().Lookup("retry"))
+	viper.BindPFlag("cancel", queueCmd.Flags().Lookup("cancel"))
+	viper.BindPFlag("clear", queueCmd.Flags().Lookup("clear"))
+
+	clientCmd.AddCommand(queueCmd)
+}
+
+func RunQueueCmd(cmd *cobra.Command, args []string) {
+	id, _ := cmd.Flags().GetString("id")
+	retry, _ := cmd.Flags().GetBool("retry")
+	cancel, _ := cmd.Flags().GetBool("cancel")
+	clearDone, _ := cmd.Flags().GetBool("clear")
+
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	switch {
+	case retry && cancel:
+		showerr(fmt.Errorf("specify only one of --retry or --cancel"))
+	case retry:
+		if err := c.RetryTransfers(id); err != nil {
+			showerr(err)
+		}
+	case cancel:
+		if id == "" {
+			showerr(fmt.Errorf("no transfer id specified"))
+			return
+		}
+		if err := c.CancelTransfer(id); err != nil {
+			showerr(err)
+		}
+	case clearDone:
+		if err := c.ClearTransfers(); err != nil {
+			showerr(err)
+		}
+	default:
+		if err := c.ListTransfers(); err != nil {
+			showerr(err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Restore a file to a previous version saved on the SFS server
+
+sfs client restore --path --rev
+*/
+
+var (
+	restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a file to a previous version saved on the SFS server",
+		Run:   RunRestoreCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	restoreCmd.PersistentFlags().StringVar(&flags.path, "path", "", "path to the file to restore")
+	restoreCmd.PersistentFlags().IntVar(&flags.rev, "rev", 0, "revision number to restore. use 'sfs client history' to list available revisions")
+
+	viper.BindPFlag("path", restoreCmd.PersistentFlags().Lookup("path"))
+	viper.BindPFlag("rev", restoreCmd.PersistentFlags().Lookup("rev"))
+
+	clientCmd.AddCommand(restoreCmd)
+}
+
+func RunRestoreCmd(cmd *cobra.Command, args []string) {
+	filePath, _ := cmd.Flags().GetString("path")
+	if filePath == "" {
+		showerr(fmt.Errorf("no file path specified"))
+		return
+	}
+	rev, _ := cmd.Flags().GetInt("rev")
+	if rev <= 0 {
+		showerr(fmt.Errorf("no revision specified"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	file, err := c.GetFileByPath(filePath)
+	if err != nil {
+		showerr(fmt.Errorf("failed to get file: %v", err))
+		return
+	}
+	if file == nil {
+		showerr(fmt.Errorf("file not found"))
+		return
+	}
+	if err := c.RestoreVersion(file, rev); err != nil {
+		showerr(fmt.Errorf("failed to restore file: %v", err))
+		return
+	}
+	fmt.Printf("%s restored to version %d\n", file.Name, rev)
+}
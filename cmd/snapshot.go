@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for managing snapshots of the drive on the server
+
+sfs drive snapshot create --name
+sfs drive snapshot list
+sfs drive snapshot diff --id --to
+sfs drive snapshot restore --id --path
+sfs drive snapshot remove --id
+sfs drive snapshot --interval --keep
+*/
+
+var (
+	snapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Take, compare, and restore point-in-time snapshots of the drive",
+		Run:   RunSnapshotCmd,
+	}
+	snapshotCreateCmd = &cobra.Command{
+		Use:   "create",
+		Short: "Take a snapshot of the drive",
+		Run:   RunSnapshotCreateCmd,
+	}
+	snapshotListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all snapshots of the drive, newest first",
+		Run:   RunSnapshotListCmd,
+	}
+	snapshotDiffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Show what was added, removed, or changed since a snapshot was taken",
+		Run:   RunSnapshotDiffCmd,
+	}
+	snapshotRestoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a file, a directory, or the whole drive from a snapshot",
+		Run:   RunSnapshotRestoreCmd,
+	}
+	snapshotRemoveCmd = &cobra.Command{
+		Use:   "remove",
+		Short: "Remove a snapshot",
+		Run:   RunSnapshotRemoveCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	snapshotCmd.Flags().StringVar(&flags.interval, "interval", "", "how often to snapshot the drive (i.e. 24h). 0 to turn scheduled snapshots off")
+	snapshotCmd.Flags().IntVar(&flags.keep, "keep", 0, "number of scheduled snapshots to keep. 0 to keep all of them")
+	snapshotCreateCmd.Flags().StringVar(&flags.name, "name", "", "optional label for the snapshot")
+	snapshotDiffCmd.Flags().StringVar(&flags.id, "id", "", "id of the snapshot to compare. use 'sfs drive snapshot list' to find snapshot ids")
+	snapshotDiffCmd.Flags().StringVar(&flags.to, "to", "", "id of a later snapshot to compare with. defaults to the drive as it is now")
+	snapshotRestoreCmd.Flags().StringVar(&flags.id, "id", "", "id of the snapshot to restore from")
+	snapshotRestoreCmd.Flags().StringVar(&flags.path, "path", "", "path of a file or directory relative to the drive's root to restore by itself. defaults to the whole drive")
+	snapshotRemoveCmd.Flags().StringVar(&flags.id, "id", "", "id of the snapshot to remove")
+
+	viper.BindPFlag("interval", snapshotCmd.Flags().Lookup("interval"))
+	viper.BindPFlag("keep", snapshotCmd.Flags().Lookup("keep"))
+	viper.BindPFlag("name", snapshotCreateCmd.Flags().Lookup("name"))
+	viper.BindPFlag("id", snapshotDiffCmd.Flags().Lookup("id"))
+	viper.BindPFlag("to", snapshotDiffCmd.Flags().Lookup("to"))
+	viper.BindPFlag("id", snapshotRestoreCmd.Flags().Lookup("id"))
+	viper.BindPFlag("path", snapshotRestoreCmd.Flags().Lookup("path"))
+	viper.BindPFlag("id", snapshotRemoveCmd.Flags().Lookup("id"))
+
+	snapshotCmd.AddCommand(snapshotCreateCmd)
+	snapshotCmd.AddCommand(snapshotListCmd)
+	snapshotCmd.AddCommand(snapshotDiffCmd)
+	snapshotCmd.AddCommand(snapshotRestoreCmd)
+	snapshotCmd.AddCommand(snapshotRemoveCmd)
+	drvCmd.AddCommand(snapshotCmd)
+}
+
+func RunSnapshotCmd(cmd *cobra.Command, args []string) {
+	interval, _ := cmd.Flags().GetString("interval")
+	if interval == "" {
+		cmd.Help()
+		return
+	}
+	every, err := time.ParseDuration(interval)
+	if err != nil || every < 0 {
+		showerr(fmt.Errorf("invalid snapshot interval: %q", interval))
+		return
+	}
+	keep, _ := cmd.Flags().GetInt("keep")
+	if keep < 0 {
+		showerr(fmt.Errorf("number of snapshots to keep can't be negative"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.SetSnapshotSchedule(every, keep); err != nil {
+		showerr(err)
+	}
+}
+
+func RunSnapshotCreateCmd(cmd *cobra.Command, args []string) {
+	name, _ := cmd.Flags().GetString("name")
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.CreateSnapshot(name); err != nil {
+		showerr(err)
+	}
+}
+
+func RunSnapshotListCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.ListSnapshots(); err != nil {
+		showerr(err)
+	}
+}
+
+func RunSnapshotDiffCmd(cmd *cobra.Command, args []string) {
+	id, _ := cmd.Flags().GetString("id")
+	if id == "" {
+		showerr(fmt.Errorf("no snapshot id specified"))
+		return
+	}
+	to, _ := cmd.Flags().GetString("to")
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.DiffSnapshot(id, to); err != nil {
+		showerr(err)
+	}
+}
+
+func RunSnapshotRestoreCmd(cmd *cobra.Command, args []string) {
+	id, _ := cmd.Flags().GetString("id")
+	if id == "" {
+		showerr(fmt.Errorf("no snapshot id specified"))
+		return
+	}
+	path, _ := cmd.Flags().GetString("path")
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.RestoreSnapshot(id, path); err != nil {
+		showerr(err)
+	}
+}
+
+func RunSnapshotRemoveCmd(cmd *cobra.Command, args []string) {
+	id, _ := cmd.Flags().GetString("id")
+	if id == "" {
+		showerr(fmt.Errorf("no snapshot id specified"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.RemoveSnapshot(id); err != nil {
+		showerr(err)
+	}
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Commands for managing the drive's recycle bin on the server
+
+sfs drive trash list
+sfs drive trash restore --id
+sfs drive trash empty
+sfs drive trash --retention
+*/
+
+var (
+	trashCmd = &cobra.Command{
+		Use:   "trash",
+		Short: "Manage deleted files and directories in the recycle bin",
+		Run:   RunTrashCmd,
+	}
+	trashListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List all items in the recycle bin",
+		Run:   RunTrashListCmd,
+	}
+	trashRestoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore an item from the recycle bin to its original location",
+		Run:   RunTrashRestoreCmd,
+	}
+	trashEmptyCmd = &cobra.Command{
+		Use:   "empty",
+		Short: "Permanently remove everything in the recycle bin",
+		Run:   RunTrashEmptyCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	trashCmd.Flags().StringVar(&flags.retention, "retention", "", "how long to keep deleted items before removing them permanently (i.e. 720h). 0 to keep indefinitely")
+	trashRestoreCmd.Flags().StringVar(&flags.id, "id", "", "id of the item to restore. use 'sfs drive trash list' to find item ids")
+
+	viper.BindPFlag("retention", trashCmd.Flags().Lookup("retention"))
+	viper.BindPFlag("id", trashRestoreCmd.Flags().Lookup("id"))
+
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+	drvCmd.AddCommand(trashCmd)
+}
+
+func RunTrashCmd(cmd *cobra.Command, args []string) {
+	retention, _ := cmd.Flags().GetString("retention")
+	if retention == "" {
+		cmd.Help()
+		return
+	}
+	age, err := time.ParseDuration(retention)
+	if err != nil {
+		showerr(fmt.Errorf("invalid retention period: %v", err))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.SetTrashRetention(age); err != nil {
+		showerr(err)
+	}
+}
+
+func RunTrashListCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.ListRecycleBin(); err != nil {
+		showerr(err)
+	}
+}
+
+func RunTrashRestoreCmd(cmd *cobra.Command, args []string) {
+	id, _ := cmd.Flags().GetString("id")
+	if id == "" {
+		showerr(fmt.Errorf("no item id specified"))
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.RestoreRecycled(id); err != nil {
+		showerr(err)
+	}
+}
+
+func RunTrashEmptyCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.EmptyRecycleBin(); err != nil {
+		showerr(err)
+	}
+}
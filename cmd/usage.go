@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+/*
+Command for showing how much of the drive's storage quota on the server
+is in use, broken down by top-level directory.
+
+sfs drive usage
+*/
+
+var (
+	usageCmd = &cobra.Command{
+		Use:   "usage",
+		Short: "Show storage usage on the server, broken down by top-level directory",
+		Run:   RunUsageCmd,
+	}
+)
+
+func init() {
+	drvCmd.AddCommand(usageCmd)
+}
+
+func RunUsageCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if err := c.ShowUsage(); err != nil {
+		showerr(err)
+	}
+}
@@ -26,6 +26,7 @@ type Client struct {
 	User       *auth.User     `json:"user"`            // user object
 	UserID     string         `json:"user_id"`         // usersID for this client
 	DriveID    string         `json:"drive_id"`        // drive ID for this client
+	DeviceID   string         `json:"device_id"`       // id of this device. used in file version vectors.
 	Root       string         `json:"root"`            // path to root sfs directory for users files and directories
 	SfDir      string         `json:"state_file_dir"`  // path to state file
 	RecycleBin string         `json:"recycle_bin"`     // path to recycle bin. "deleted" items live here.
@@ -58,6 +59,12 @@ type Client struct {
 
 	// http client. used mainly for small-scope calls to the server.
 	Client *http.Client `json:"-"`
+
+	// exclusion rules from the client config and .sfsignore files. see Ignores()
+	ignore *svc.Ignore
+
+	// keys for an end-to-end encrypted drive, sealed with the user's passphrase. see e2ee.go
+	E2EEKeys string `json:"e2ee_keys,omitempty"`
 }
 
 // remove previous state file(s)
@@ -129,11 +136,23 @@ func (c *Client) start(shutDown chan os.Signal) error {
 	if err := c.SaveState(); err != nil {
 		return fmt.Errorf("failed to save initial state: %v", err)
 	}
+	// finish anything that was left in the transfer queue
+	// the last time the client was stopped
+	go func() {
+		if err := c.ResumeTransfers(); err != nil {
+			c.log.Error(fmt.Sprintf("failed to resume transfers: %v", err))
+		}
+	}()
+	// pick up changes to bandwidth limits while running
+	stopLimits := make(chan bool)
+	go c.watchLimits(stopLimits)
+
 	// wait for signal (such as ctrl-c or some other syscall) to shutdown client.
 	// we want to make start a blocking process so all the goroutines
 	// that are monitoring files (and all their event listeners)
 	// can actually run.
 	<-shutDown
+	close(stopLimits)
 
 	// "gracefully" shutdown when we receive a signal.
 	c.ShutDown()
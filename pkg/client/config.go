@@ -23,6 +23,17 @@ type Conf struct {
 	Addr           string `env:"CLIENT_ADDRESS,required"`      // address for http client
 	NewService     bool   `env:"CLIENT_NEW_SERVICE, required"` // whether we need to initialize a new client service instance.
 	LogDir         string `env:"CLIENT_LOG_DIR,required"`      // location of log directory
+
+	// gitignore-style patterns for items that should never be discovered, monitored, or synced.
+	// applied before any .sfsignore files. separated by semicolons in the .env file.
+	Ignore []string `env:"CLIENT_IGNORE,default=.git/;node_modules/;*.swp;*.swo;*~;.DS_Store"`
+
+	// number of uploads or downloads to run at once during a sync
+	TransferWorkers int `env:"CLIENT_TRANSFER_WORKERS,default=4"`
+
+	// files or directories to transfer before anything else during a sync.
+	// relative paths are relative to the client's root. separated by semicolons in the .env file.
+	Pinned []string `env:"CLIENT_PINNED"`
 }
 
 func ClientConfig() *Conf {
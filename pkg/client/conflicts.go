@@ -0,0 +1,157 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+handling for files that were modified on both the client and the server
+since the last sync. see Client.Sync() and service/conflict.go
+*/
+
+// move the local version of a conflicted file to its conflict copy
+// and record the conflict so the user can resolve it later.
+func (c *Client) saveConflictCopy(conflict *svc.Conflict) error {
+	conflict.CopyPath = uniquePath(conflict.CopyPath)
+	if err := os.Rename(conflict.Path, conflict.CopyPath); err != nil {
+		return fmt.Errorf("failed to create conflict copy of %s: %v", conflict.Name, err)
+	}
+	if err := c.Db.AddConflict(conflict); err != nil {
+		return err
+	}
+	c.log.Warn(fmt.Sprintf(
+		"%s was modified on this device and on the server. local changes saved to %s",
+		conflict.Name, filepath.Base(conflict.CopyPath),
+	))
+	return nil
+}
+
+// add a number to the end of a file name if something already exists at path
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		p := fmt.Sprintf("%s %d%s", base, i, ext)
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			return p
+		}
+	}
+}
+
+// list all unresolved sync conflicts
+func (c *Client) ListConflicts() error {
+	conflicts, err := c.Db.GetConflicts()
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		fmt.Println("no conflicts")
+		return nil
+	}
+	for _, cf := range conflicts {
+		local := "local copy"
+		if cf.IsDir {
+			local = "local location"
+		}
+		fmt.Printf(
+			"%s\t%s\tdetected %s\n\toriginal: %s\n\t%s: %s\n",
+			cf.ID, cf.Name, cf.DetectedAt.Local().Format(time.RFC822), cf.Path, local, cf.CopyPath,
+		)
+	}
+	return nil
+}
+
+// resolve a sync conflict.
+//
+// svc.KeepLocal replaces the remote version with the local changes,
+// svc.KeepRemote discards the local changes, and svc.KeepBoth keeps
+// the conflict copy as a new file. directories can't keep both.
+func (c *Client) ResolveConflict(conflictID string, resolution string) error {
+	conflict, err := c.Db.GetConflict(conflictID)
+	if err != nil {
+		return err
+	}
+	if conflict == nil {
+		return fmt.Errorf("conflict (id=%s) not found", conflictID)
+	}
+	if conflict.IsDir {
+		err = c.resolveDirConflict(conflict, resolution)
+	} else {
+		err = c.resolveFileConflict(conflict, resolution)
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.Db.RemoveConflict(conflict.ID); err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("conflict for %s resolved (%s)", conflict.Name, resolution))
+	return nil
+}
+
+func (c *Client) resolveFileConflict(conflict *svc.Conflict, resolution string) error {
+	switch resolution {
+	case svc.KeepLocal:
+		file, err := c.GetFileByID(conflict.FileID)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(conflict.CopyPath, conflict.Path); err != nil {
+			return fmt.Errorf("failed to restore local version of %s: %v", conflict.Name, err)
+		}
+		// the local version now replaces everything the server has seen
+		file.IncrementVersion(c.DeviceID)
+		if err := c.Db.UpdateFile(file); err != nil {
+			return err
+		}
+		if err := c.PushFile(file); err != nil {
+			return fmt.Errorf("failed to push local version of %s: %v", conflict.Name, err)
+		}
+	case svc.KeepRemote:
+		if err := os.Remove(conflict.CopyPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove conflict copy: %v", err)
+		}
+	case svc.KeepBoth:
+		if err := c.AddItem(conflict.CopyPath); err != nil {
+			return fmt.Errorf("failed to add conflict copy: %v", err)
+		}
+	default:
+		return fmt.Errorf("unknown conflict resolution: %q", resolution)
+	}
+	return nil
+}
+
+// the server's rename or move was already applied when the conflict
+// was found, so only svc.KeepLocal has anything to do.
+func (c *Client) resolveDirConflict(conflict *svc.Conflict, resolution string) error {
+	switch resolution {
+	case svc.KeepLocal:
+		dir := c.Drive.GetDir(conflict.FileID)
+		if dir == nil {
+			return fmt.Errorf("directory %s (id=%s) not found", conflict.Name, conflict.FileID)
+		}
+		if err := c.moveDir(dir, conflict.CopyPath); err != nil {
+			return fmt.Errorf("failed to restore local location of %s: %v", conflict.Name, err)
+		}
+		if err := c.pushDir(dir, false); err != nil {
+			return fmt.Errorf("failed to send local location of %s: %v", conflict.Name, err)
+		}
+	case svc.KeepRemote:
+		// already applied
+	case svc.KeepBoth:
+		return fmt.Errorf("%s is a directory and can't keep both names", conflict.Name)
+	default:
+		return fmt.Errorf("unknown conflict resolution: %q", resolution)
+	}
+	return nil
+}
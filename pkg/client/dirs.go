@@ -0,0 +1,402 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+directory synchronization.
+
+directories are exchanged as metadata in the sync index: their name, and the id
+of their parent directory. new directories are created on whichever side doesn't
+have them, and directories that were renamed or moved are updated on the other
+side. version vectors decide which side's change is newer (see service/vclock.go),
+falling back to the most recent change for directories without one. directories
+that were renamed or moved on both sides are treated as conflicts (see
+service/conflict.go). this runs before any files are pushed or pulled so files
+always have somewhere to go.
+*/
+
+// sync the directory structure with the server. returns true if anything changed.
+// deleted are the ids of items removed on either side during this sync.
+func (c *Client) syncDirs(svrIdx *svc.SyncIndex, deleted map[string]bool) (bool, error) {
+	// older servers don't send directories
+	if svrIdx.Format < svc.SyncIndexV4 {
+		return false, nil
+	}
+	var changed bool
+
+	// apply new, renamed, and moved directories from the server
+	for _, remote := range parentsFirst(svrIdx.Dirs) {
+		if deleted[remote.ID] || svrIdx.IsDeleted(remote.ID) {
+			continue
+		}
+		local := c.Drive.GetDir(remote.ID)
+		if local == nil {
+			if c.ignored(filepath.Join(c.localParent(remote.ParentID).Path, remote.Name), true) {
+				continue
+			}
+			if err := c.pullDir(remote); err != nil {
+				c.log.Error(fmt.Sprintf("failed to create directory %s: %v", remote.Name, err))
+				continue
+			}
+			changed = true
+			continue
+		}
+		if !svc.DirChanged(c.dirMeta(local), remote) {
+			continue
+		}
+		switch dirOrder(local, remote) {
+		case svc.Before:
+			if err := c.pullDirChange(local, remote); err != nil {
+				c.log.Error(fmt.Sprintf("failed to update directory %s: %v", local.Name, err))
+				continue
+			}
+		case svc.Concurrent:
+			// don't pile up conflicts for a directory that's already in conflict
+			if existing, err := c.Db.GetFileConflict(local.ID); err != nil {
+				return changed, err
+			} else if existing != nil {
+				c.log.Warn(fmt.Sprintf("%s has an unresolved conflict (id=%s). skipping", local.Name, existing.ID))
+				continue
+			}
+			if err := c.dirConflict(local, remote); err != nil {
+				c.log.Error(fmt.Sprintf("failed to update directory %s: %v", local.Name, err))
+				continue
+			}
+		default:
+			if err := c.pushDir(local, false); err != nil {
+				c.log.Error(fmt.Sprintf("failed to send directory %s: %v", local.Name, err))
+				continue
+			}
+		}
+		changed = true
+	}
+
+	// send directories the server doesn't know about
+	local := make(map[string]*svc.Directory)
+	for id, dir := range c.Drive.GetDirsMap() {
+		if _, known := svrIdx.Dirs[id]; !known && !deleted[id] && !c.ignored(dir.Path, true) {
+			local[id] = dir
+		}
+	}
+	for _, dir := range parentsFirst(local) {
+		if err := c.pushDir(dir, true); err != nil {
+			c.log.Error(fmt.Sprintf("failed to send directory %s: %v", dir.Name, err))
+			continue
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// figure out which side renamed or moved a directory. version vectors are used
+// when both sides have one, otherwise the most recent change wins.
+func dirOrder(local *svc.Directory, remote *svc.Directory) svc.Ordering {
+	if len(local.Version) > 0 && len(remote.Version) > 0 {
+		// the same version with different names means the change
+		// was made outside of the monitor, so use the sync times.
+		if o := local.Version.Compare(remote.Version); o != svc.Equal {
+			return o
+		}
+	}
+	if remote.LastSync.After(local.LastSync) {
+		return svc.Before
+	}
+	return svc.After
+}
+
+// apply a rename or move from the server to a local directory
+func (c *Client) pullDirChange(local *svc.Directory, remote *svc.Directory) error {
+	if err := c.relocateDir(local, remote.ParentID, remote.Name, remote.LastSync); err != nil {
+		return err
+	}
+	// the local directory now matches the server's
+	local.Version = remote.Version.Copy()
+	return c.Db.UpdateDir(local)
+}
+
+// a directory was renamed or moved here and on the server. the server's change
+// is applied, and the local one is kept with the conflict so it can be restored.
+func (c *Client) dirConflict(local *svc.Directory, remote *svc.Directory) error {
+	localPath := local.Path
+	if err := c.pullDirChange(local, remote); err != nil {
+		return err
+	}
+	conflict := svc.NewDirConflict(local, device(), local.Path, localPath)
+	if err := c.Db.AddConflict(conflict); err != nil {
+		return err
+	}
+	c.log.Warn(fmt.Sprintf(
+		"%s was renamed or moved on this device and on the server. kept the server's change. local location was %s",
+		local.Name, localPath,
+	))
+	return nil
+}
+
+// order directories so parents always come before their children
+func parentsFirst(dirs map[string]*svc.Directory) []*svc.Directory {
+	depth := func(dir *svc.Directory) int {
+		n := 0
+		for d := dir; d.ParentID != "" && n <= len(dirs); n++ {
+			parent, ok := dirs[d.ParentID]
+			if !ok {
+				break
+			}
+			d = parent
+		}
+		return n
+	}
+	ordered := make([]*svc.Directory, 0, len(dirs))
+	for _, dir := range dirs {
+		ordered = append(ordered, dir)
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return depth(ordered[i]) < depth(ordered[j])
+	})
+	return ordered
+}
+
+// the metadata of a local directory as it would appear in a sync index
+func (c *Client) dirMeta(dir *svc.Directory) *svc.Directory {
+	meta := &svc.Directory{ID: dir.ID, Name: dir.Name, ParentID: dir.ParentID}
+	if dir.Parent == nil || dir.Parent.IsRoot() || dir.ParentID == c.Drive.RootID {
+		meta.ParentID = ""
+	}
+	return meta
+}
+
+// get the local parent for a directory from the server.
+// directories without a known parent go under the root directory.
+func (c *Client) localParent(parentID string) *svc.Directory {
+	if parent := c.Drive.GetDir(parentID); parent != nil {
+		return parent
+	}
+	return c.Drive.Root
+}
+
+// create a local copy of a directory from the server
+func (c *Client) pullDir(remote *svc.Directory) error {
+	parent := c.localParent(remote.ParentID)
+	dirPath := filepath.Join(parent.Path, remote.Name)
+	if err := os.MkdirAll(dirPath, svc.PERMS); err != nil {
+		return err
+	}
+	dir := *remote
+	dir.Path = dirPath
+	dir.ClientPath = dirPath
+	dir.Dirs = make(map[string]*svc.Directory, 0)
+	dir.Files = make(map[string]*svc.File, 0)
+	if err := c.Drive.AddSubDir(parent.ID, &dir); err != nil {
+		return err
+	}
+	dir.LastSync = remote.LastSync
+	if err := c.Db.AddDir(&dir); err != nil {
+		return err
+	}
+	if err := c.WatchItem(dir.Path); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to monitor %s: %v", dir.Name, err))
+	}
+	c.log.Info(fmt.Sprintf("directory (%s) created from server", dir.Name))
+	return nil
+}
+
+// send a new or updated directory to the server
+func (c *Client) pushDir(dir *svc.Directory, isNew bool) error {
+	var (
+		req *http.Request
+		err error
+	)
+	if isNew {
+		req, err = c.NewDirectoryRequest(dir)
+	} else {
+		req, err = c.UpdateDirectoryRequest(dir)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("server responded with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// rename and/or move a local directory under a new parent.
+// files and subdirectories are moved along with it.
+func (c *Client) relocateDir(dir *svc.Directory, parentID string, name string, lastSync time.Time) error {
+	if dir.IsRoot() {
+		return fmt.Errorf("can't move root directory")
+	}
+	parent := c.localParent(parentID)
+	if parent.ID == dir.ID || dir.WalkD(parent.ID) != nil {
+		return fmt.Errorf("can't move %s into itself", dir.Name)
+	}
+	newPath := filepath.Join(parent.Path, name)
+	if newPath != dir.Path {
+		if _, err := os.Stat(newPath); err == nil {
+			return fmt.Errorf("%s already exists", newPath)
+		}
+	}
+	// stop monitoring the old locations
+	files := dir.GetFiles()
+	dirs := dir.WalkDs()
+	dirs[dir.ID] = dir
+	for _, file := range files {
+		c.unwatch(file.Path)
+	}
+	for _, d := range dirs {
+		c.unwatch(d.Path)
+	}
+	if err := os.Rename(dir.Path, newPath); err != nil {
+		return fmt.Errorf("failed to move directory: %v", err)
+	}
+	dir.Relocate(newPath)
+	dir.Name = name
+	dir.ParentID = parent.ID
+	dir.LastSync = lastSync
+	if err := c.Drive.UpdateDir(dir.ID, dir); err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := c.Db.UpdateDir(d); err != nil {
+			return fmt.Errorf("failed to update directory in database: %v", err)
+		}
+	}
+	for _, file := range files {
+		if err := c.Db.UpdateFile(file); err != nil {
+			return fmt.Errorf("failed to update file in database: %v", err)
+		}
+	}
+	// monitor the new locations
+	for _, d := range dirs {
+		if err := c.WatchItem(d.Path); err != nil {
+			c.log.Warn(fmt.Sprintf("failed to monitor %s: %v", d.Name, err))
+		}
+	}
+	for _, file := range files {
+		if err := c.WatchItem(file.Path); err != nil {
+			c.log.Warn(fmt.Sprintf("failed to monitor %s: %v", file.Name, err))
+		}
+	}
+	c.log.Info(fmt.Sprintf("directory (%s) moved to %s", dir.Name, newPath))
+	return nil
+}
+
+// stop monitoring an item and remove its event handler
+func (c *Client) unwatch(path string) {
+	if !c.Monitor.IsMonitored(path) {
+		return
+	}
+	// the handler may not be running, so don't wait on it
+	if off, ok := c.OffSwitches[path]; ok {
+		go func() { off <- true }()
+	}
+	c.Monitor.StopWatching(path)
+	delete(c.Handlers, path)
+	delete(c.OffSwitches, path)
+}
+
+// rename or move a directory. destPath must be the absolute path for the
+// directory's new location (i.e. end with the directory's new name), and its
+// parent must be the sfs root or another directory known to sfs.
+func (c *Client) MoveDir(dir *svc.Directory, destPath string) error {
+	// use the drive's copy so we have its contents
+	if d := c.Drive.GetDir(dir.ID); d != nil {
+		dir = d
+	}
+	if err := c.moveDir(dir, destPath); err != nil {
+		return err
+	}
+	if c.autoSync() {
+		if err := c.pushDir(dir, false); err != nil {
+			c.log.Error(fmt.Sprintf("failed to send directory %s: %v", dir.Name, err))
+		}
+	}
+	return nil
+}
+
+// rename or move a directory locally, and record the change in its version
+func (c *Client) moveDir(dir *svc.Directory, destPath string) error {
+	destPath = filepath.Clean(destPath)
+	parentPath := filepath.Dir(destPath)
+	var parentID string
+	if parentPath == filepath.Clean(c.Drive.Root.Path) {
+		parentID = c.Drive.Root.ID
+	} else {
+		parent, err := c.Db.GetDirectoryByPath(parentPath)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return fmt.Errorf("%s is not managed by sfs", parentPath)
+		}
+		parentID = parent.ID
+	}
+	if strings.HasPrefix(destPath, dir.Path+string(filepath.Separator)) {
+		return fmt.Errorf("can't move %s into itself", dir.Name)
+	}
+	dir.IncrementVersion(c.DeviceID)
+	return c.relocateDir(dir, parentID, filepath.Base(destPath), time.Now().UTC())
+}
+
+// download an archive of a directory and its contents from the server.
+// format is one of transfer.ArchiveFormats (zip if it's empty), and dest
+// defaults to the directory's name with the format's extension in the
+// current directory. returns where the archive was saved.
+func (c *Client) DownloadDir(dir *svc.Directory, format string, dest string) (string, error) {
+	if format == "" {
+		format = transfer.FormatZip
+	}
+	if !transfer.ValidArchiveFormat(format) {
+		return "", fmt.Errorf("%w: %q", transfer.ErrArchiveFormat, format)
+	}
+	if dest == "" {
+		dest = dir.Name + transfer.ArchiveExt(format)
+	}
+	req, err := c.GetDirRequest(dir)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	q.Set("format", format)
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return "", fmt.Errorf("server responded with %d", resp.StatusCode)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", fmt.Errorf("failed to save archive of %s: %v", dir.Name, err)
+	}
+	return dest, nil
+}
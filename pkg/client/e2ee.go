@@ -0,0 +1,159 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+end-to-end encryption for the client's drive (see transfer/e2ee.go).
+
+the drive's keys are kept in the state file, sealed with a passphrase. it's
+read from CLIENT_E2EE_PASSPHRASE if that's set, otherwise the user is asked
+for it whenever the client is loaded. other devices get the keys by
+importing a copy exported from a device that already has them.
+*/
+
+// environment variable the E2EE passphrase can be read from
+const PassphraseEnv = "CLIENT_E2EE_PASSPHRASE"
+
+// keys for the drive, if it's end-to-end encrypted and they've been unlocked
+func (c *Client) keys() *transfer.Keys {
+	if c.Transfer == nil {
+		return nil
+	}
+	return c.Transfer.Keys
+}
+
+func (c *Client) passphrase() string {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return p
+	}
+	return c.Passphrase("passphrase")
+}
+
+// unseal the drive's keys so files can be encrypted and decrypted
+func (c *Client) unlockKeys() error {
+	if c.Drive == nil || !c.Drive.E2EE {
+		return nil
+	}
+	if c.E2EEKeys == "" {
+		c.log.Warn("drive is end-to-end encrypted, but this device doesn't have its keys. use 'sfs drive e2ee import' to add them")
+		return nil
+	}
+	keys, err := transfer.OpenKeys(c.E2EEKeys, c.passphrase())
+	if err != nil {
+		return fmt.Errorf("failed to unlock end-to-end encryption keys: %v", err)
+	}
+	c.Transfer.Keys = keys
+	return nil
+}
+
+// keep keys for the drive, sealed with passphrase
+func (c *Client) setKeys(keys *transfer.Keys, passphrase string) error {
+	sealed, err := keys.Seal(passphrase)
+	if err != nil {
+		return err
+	}
+	c.Drive.E2EE = true
+	c.E2EEKeys = sealed
+	c.Transfer.Keys = keys
+	if err := c.Db.UpdateDrive(c.Drive); err != nil {
+		return err
+	}
+	return c.SaveState()
+}
+
+// turn on end-to-end encryption for the drive. new keys are sealed with
+// passphrase, and names are sealed too if names is set. everything on
+// the server is uploaded again once it's turned on, this time encrypted.
+func (c *Client) EnableE2EE(passphrase string, names bool) error {
+	if c.Drive.E2EE {
+		return fmt.Errorf("drive is already end-to-end encrypted")
+	}
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required")
+	}
+	keys, err := transfer.NewKeys(names)
+	if err != nil {
+		return err
+	}
+	if c.Drive.IsRegistered() {
+		req, err := c.E2EERequest()
+		if err != nil {
+			return err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			c.dump(resp, true)
+			return fmt.Errorf("failed to turn on end-to-end encryption on the server")
+		}
+	}
+	if err := c.setKeys(keys, passphrase); err != nil {
+		return err
+	}
+	// replace the server's copies with encrypted ones
+	if c.Drive.IsRegistered() {
+		for _, file := range c.Drive.GetFiles() {
+			if err := c.PushFile(file); err != nil {
+				c.log.Warn(fmt.Sprintf("failed to upload %s: %v", file.Name, err))
+			}
+		}
+	}
+	c.log.Info("drive is now end-to-end encrypted")
+	return nil
+}
+
+// export the drive's keys, sealed with passphrase, so they can
+// be imported on another device.
+func (c *Client) ExportKeys(passphrase string) (string, error) {
+	keys := c.keys()
+	if keys == nil {
+		return "", fmt.Errorf("drive isn't end-to-end encrypted, or its keys are locked")
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("a passphrase is required")
+	}
+	return keys.Export(passphrase)
+}
+
+// import keys exported from another device. exportPassphrase is the one they
+// were exported with, and passphrase is the one to keep them with on this one.
+func (c *Client) ImportKeys(exported string, exportPassphrase string, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("a passphrase is required")
+	}
+	keys, err := transfer.ImportKeys(exported, exportPassphrase)
+	if err != nil {
+		return fmt.Errorf("failed to import keys: %v", err)
+	}
+	if err := c.setKeys(keys, passphrase); err != nil {
+		return err
+	}
+	c.log.Info("end-to-end encryption keys imported")
+	return nil
+}
+
+// make sure a downloaded file matches the checksum the server has for it
+func (c *Client) validateChecksum(file *svc.File) error {
+	keys := c.keys()
+	if keys == nil {
+		return file.ValidateChecksum()
+	}
+	cs, err := keys.Checksum(file.ClientPath)
+	if err != nil {
+		return fmt.Errorf("unable to calculate checksum: %v", err)
+	}
+	if cs != file.CheckSum {
+		return fmt.Errorf("checksum mismatch! orig: %s, new: %s", cs, file.CheckSum)
+	}
+	return nil
+}
@@ -29,18 +29,19 @@ func (c *Client) StartMonitor() error {
 			return err
 		}
 	}
-	// NOTE: monitoring directories is currently not supported.
-	// leaving this for future implementation iterations.
-	// dirs := c.Drive.GetDirs()
-	// dirs, err := c.Db.GetUsersDirectories(c.UserID)
-	// if err != nil {
-	// 	return err
-	// }
-	// for _, d := range dirs {
-	// 	if err := c.WatchItem(d.Path); err != nil {
-	// 		return err
-	// 	}
-	// }
+	// directories are watched for new and removed subdirectories
+	dirs, err := c.Db.GetUsersDirectories(c.UserID)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if d.IsRoot() {
+			continue
+		}
+		if err := c.WatchItem(d.Path); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -63,14 +64,15 @@ func (c *Client) WatchItem(path string) error {
 	if err != nil {
 		return err
 	}
-	// directory monitoring is not supported for the moment.
+	if c.ignored(path, isDir) {
+		return nil
+	}
 	if isDir {
-		return fmt.Errorf(
-			"%s is a directory. directory monitoring is not supporetd",
-			filepath.Dir(path),
-		)
+		err = c.Monitor.WatchDir(path)
+	} else {
+		err = c.Monitor.Watch(path)
 	}
-	if err := c.Monitor.Watch(path); err != nil {
+	if err != nil {
 		return err
 	}
 	if err := c.NewHandler(path); err != nil {
@@ -161,20 +163,17 @@ func (c *Client) StartHandlers() error {
 			return err
 		}
 	}
-	// NOTE: directory monitoring is not supported, but may be
-	// in future versions, so this code will stay here for now.
 	// start directory handlers
-	// dirs := c.Drive.GetDirs()
-	// dirs, err := c.Db.GetUsersDirectories(c.UserID)
-	// if err != nil {
-	// 	return nil
-	// }
-	// c.log.Info(fmt.Sprintf("starting %d directory handler(s)...", len(dirs)))
-	// for _, d := range dirs {
-	// 	if err := c.StartHandler(d.Path); err != nil {
-	// 		return err
-	// 	}
-	// }
+	dirs, err := c.Db.GetUsersDirectories(c.UserID)
+	if err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("starting %d directory handler(s)...", len(dirs)))
+	for _, d := range dirs {
+		if err := c.StartHandler(d.Path); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -216,23 +215,20 @@ func (c *Client) BuildHandlers() error {
 			}
 		}
 	}
-	// NOTE: monitoring directories is not supported, but may be in the future.
-	// os.ReadDir() was using a lot of resources.
-	// dirs, err := c.Db.GetUsersDirectories(c.UserID)
-	// if err != nil {
-	// 	return err
-	// }
-	// for _, dir := range dirs {
-	// 	if _, exists := c.Handlers[dir.Path]; !exists {
-	// 		if err := c.NewEHandler(dir.Path); err != nil {
-	// 			return err
-	// 		}
-	// 	}
-	// }
-	// // watch root
-	// if err := c.WatchItem(c.Drive.Root.Path); err != nil {
-	// 	return err
-	// }
+	dirs, err := c.Db.GetUsersDirectories(c.UserID)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if dir.IsRoot() {
+			continue
+		}
+		if _, exists := c.Handlers[dir.Path]; !exists {
+			if err := c.NewEHandler(dir.Path); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -268,39 +264,38 @@ func (c *Client) handler(itemPath string, stop chan bool) error {
 			return nil
 		case evt := <-evtChan:
 			switch evt.Type {
-			// NOTE: this is no longer supported, but may be used in the future versions.
-			// new files or directories were added to a monitored directory
+			// new items were added to a monitored directory.
+			// new subdirectories are registered and will be sent to the
+			// server during the next sync. files need to be added explicitly.
 			case monitor.Add:
-				// for _, eitem := range evt.Items {
-				// 	// if this is a known item and it has just changed locations,
-				// 	// then we just need to update the metadata, otherwise
-				// 	// create a new object and register
-				// 	if c.KnownItem(eitem.Path()) {
-				// 		if err := c.UpdateItem(eitem); err != nil {
-				// 			c.log.Error(fmt.Sprintf("failed to update item %s: %v", eitem.Path(), err))
-				// 		}
-				// 	}
-				// 	// otherwise create new object and register
-				// 	if eitem.IsDir() {
-				// 		newDir := svc.NewDirectory(eitem.Name(), c.UserID, c.DriveID, eitem.Path())
-				// 		if err := c.AddDirWithID(itemID, newDir); err != nil {
-				// 			c.log.Error(fmt.Sprintf("failed to add new directory: %v", err))
-				// 		}
-				// 	} else {
-				// 		newFile := svc.NewFile(eitem.Name(), c.DriveID, c.UserID, eitem.Path())
-				// 		if err := c.AddFileWithDirID(itemID, newFile); err != nil {
-				// 			c.log.Error(fmt.Sprintf("failed to add new file: %v", err))
-				// 		}
-				// 	}
-				// }
-				// NOTE: these new items may not be pushed to the server
-				// since they will be added with their initial last sync times.
-				// they will be added to the server after some modifications are detected,
-				// and if auto sync is enabled.
+				for _, eitem := range evt.Items {
+					if !eitem.IsDir() || c.ignored(eitem.Path(), true) {
+						continue
+					}
+					if dir, err := c.GetDirByPath(eitem.Path()); err == nil && dir != nil {
+						continue // already known
+					}
+					if err := c.AddDir(eitem.Path()); err != nil {
+						c.log.Error(fmt.Sprintf("failed to add new directory: %v", err))
+					}
+				}
 				evtBuf.AddEvent(evt)
+			// items were removed from a monitored directory.
+			// removed files are handled by their own monitors.
 			case monitor.Remove:
-				// TODO: handle for cases when items are removed from a directory
-				// and possibly moved to another location.
+				for _, eitem := range evt.Items {
+					if !eitem.IsDir() {
+						continue
+					}
+					dir, err := c.GetDirByPath(eitem.Path())
+					if err != nil || dir == nil {
+						continue
+					}
+					if err := c.RemoveDir(dir); err != nil {
+						c.log.Error(fmt.Sprintf("failed to remove directory: %v", err))
+					}
+				}
+				evtBuf.AddEvent(evt)
 
 			// item name change
 			case monitor.Name:
@@ -338,6 +333,15 @@ func (c *Client) handler(itemPath string, stop chan bool) error {
 				}
 				evtBuf.AddEvent(evt)
 			case monitor.Delete:
+				// leave a tombstone for deleted directories. files are
+				// tombstoned when they're removed through the client.
+				if evt.IsDir() {
+					if dir, err := c.GetDirByPath(itemPath); err == nil && dir != nil {
+						if err := c.RemoveDir(dir); err != nil {
+							c.log.Error(fmt.Sprintf("failed to remove directory: %v", err))
+						}
+					}
+				}
 				c.log.Log("INFO", fmt.Sprintf("handler for item (id=%s) stopping. item was deleted", itemID))
 				return nil
 			case monitor.Error:
@@ -347,7 +351,7 @@ func (c *Client) handler(itemPath string, stop chan bool) error {
 			// *** trigger synchronization operations once the event buffer has reached capacity ***
 			if evtBuf.AtCap {
 				// build update map and push changes if auto sync is enabled.
-				c.Drive.SyncIndex = svc.BuildToUpdate(c.Drive.Root, c.Drive.SyncIndex)
+				c.Drive.SyncIndex = c.filterIndex(svc.BuildToUpdate(c.Drive.Root, c.Drive.SyncIndex))
 				if c.autoSync() {
 					if err := c.Push(); err != nil {
 						return err
@@ -370,36 +374,24 @@ func (c *Client) apply(itemPath string, action string) error {
 		return err
 	}
 	if item.IsDir() {
-		// *** NOTE: Directory actions are not supported
-		// dir, err := c.GetDirByPath(itemPath)
-		// if err != nil {
-		// 	return err
-		// }
-		// switch action {
-		// case "name":
-		// 	dir.Name = item.Name()
-		// 	if err := c.UpdateDirectory(dir); err != nil {
-		// 		return err
-		// 	}
-		// case "size":
-		// 	dir.Size = item.Size()
-		// 	if err := c.UpdateDirectory(dir); err != nil {
-		// 		return err
-		// 	}
-		// case "modtime":
-		// 	dir.LastSync = item.ModTime()
-		// 	if err := c.UpdateDirectory(dir); err != nil {
-		// 		return err
-		// 	}
-		// case "change":
-		// 	break
-		// case "delete":
-		// 	if err := c.RemoveDir(dir); err != nil {
-		// 		return err
-		// 	}
-		// default:
-		// 	return nil
-		// }
+		dir, err := c.GetDirByPath(itemPath)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case "name":
+			if err := c.MoveDir(dir, filepath.Join(filepath.Dir(itemPath), item.Name())); err != nil {
+				return err
+			}
+		case "delete":
+			if err := c.RemoveDir(dir); err != nil {
+				return err
+			}
+		default:
+			// size and mod time changes are picked up by the
+			// files in the directory. nothing to sync here.
+			return nil
+		}
 	} else {
 		file, err := c.GetFileByPath(itemPath)
 		if err != nil {
@@ -418,11 +410,13 @@ func (c *Client) apply(itemPath string, action string) error {
 			}
 		case "size":
 			file.Size = item.Size()
+			file.IncrementVersion(c.DeviceID)
 			if err := c.UpdateFile(file); err != nil {
 				return err
 			}
 		case "modtime":
 			file.LastSync = item.ModTime()
+			file.IncrementVersion(c.DeviceID)
 			if err := c.UpdateFile(file); err != nil {
 				return err
 			}
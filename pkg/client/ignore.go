@@ -0,0 +1,101 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+exclusion rules.
+
+items matching the global patterns in the client config (CLIENT_IGNORE) or
+a .sfsignore file are skipped during discovery, aren't monitored, and are left
+out of the sync index and upload batches. see service/ignore.go for the syntax.
+*/
+
+// get the client's exclusion rules. loaded on first use.
+func (c *Client) Ignores() *svc.Ignore {
+	if c.ignore == nil {
+		var patterns []string
+		if c.Conf != nil {
+			patterns = c.Conf.Ignore
+		}
+		c.ignore = svc.NewIgnore(c.Root, patterns)
+	}
+	return c.ignore
+}
+
+// whether an item should be skipped. partial downloads are always skipped.
+func (c *Client) ignored(path string, isDir bool) bool {
+	if !isDir && strings.HasSuffix(path, transfer.PartialSuffix) {
+		return true
+	}
+	return c.Ignores().Ignored(path, isDir)
+}
+
+// remove ignored files and directories from a sync index
+func (c *Client) filterIndex(idx *svc.SyncIndex) *svc.SyncIndex {
+	if idx == nil {
+		return nil
+	}
+	for _, file := range c.Drive.GetFiles() {
+		if c.ignored(file.ClientPath, false) {
+			idx.Drop(file.ID)
+		}
+	}
+	for _, dir := range c.Drive.GetDirs() {
+		if !dir.IsRoot() && c.ignored(dir.Path, true) {
+			idx.Drop(dir.ID)
+		}
+	}
+	// files from the server won't necessarily be in the local drive
+	for id, file := range idx.FilesToUpdate {
+		if c.ignored(file.ClientPath, false) {
+			idx.Drop(id)
+		}
+	}
+	return idx
+}
+
+// explain whether an item is ignored, and by which rule
+func (c *Client) CheckIgnore(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	var isDir bool
+	if info, err := os.Stat(path); err == nil {
+		isDir = info.IsDir()
+	}
+	rule, ignored := c.Ignores().Match(path, isDir)
+	switch {
+	case rule == nil:
+		return fmt.Sprintf("%s is not ignored. no rules matched", path), nil
+	case ignored:
+		return fmt.Sprintf("%s is ignored\n  rule: %s", path, rule), nil
+	default:
+		return fmt.Sprintf("%s is not ignored\n  rule: %s", path, rule), nil
+	}
+}
+
+// add a pattern to the .sfsignore file in the root directory
+func (c *Client) AddIgnore(pattern string) error {
+	if svc.ParseIgnoreRule(pattern, c.Root, "", 0) == nil {
+		return fmt.Errorf("invalid ignore pattern: %q", pattern)
+	}
+	f, err := os.OpenFile(filepath.Join(c.Root, svc.IgnoreFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, svc.PERMS)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(pattern + "\n"); err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("added %q to %s", pattern, f.Name()))
+	return nil
+}
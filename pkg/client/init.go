@@ -197,6 +197,17 @@ func LoadClient(persist bool) (*Client, error) {
 	// initialize DB connection
 	client.Db = db.NewQuery(client.Db.DBPath, true)
 
+	// bring any databases created by older versions up to date
+	if err := db.MigrateClientDBs(client.Db.DBPath); err != nil {
+		initLog.Log("ERROR", fmt.Sprintf("failed to migrate databases: %v", err))
+		return nil, fmt.Errorf("failed to migrate databases: %v", err)
+	}
+
+	// clients created by older versions of sfs won't have a device ID
+	if client.DeviceID == "" {
+		client.DeviceID = auth.NewUUID()
+	}
+
 	// load user info
 	if err := client.LoadUser(); err != nil {
 		initLog.Log("ERROR", fmt.Sprintf("failed to load user: %v", err))
@@ -222,8 +233,20 @@ func LoadClient(persist bool) (*Client, error) {
 	// add transfer component
 	client.Transfer = transfer.NewTransfer()
 
+	// apply bandwidth limits, if any have been set
+	if err := client.applyLimits(); err != nil {
+		initLog.Log("WARN", err.Error())
+	}
+
+	// unlock end-to-end encryption keys, if the drive uses them
+	if err := client.unlockKeys(); err != nil {
+		initLog.Log("ERROR", err.Error())
+		return nil, err
+	}
+
 	// add monitoring component
 	client.Monitor = monitor.NewMonitor(client.Root)
+	client.Monitor.Ignore = client.ignored
 
 	// initialize event maps
 	client.InitHandlerMaps()
@@ -277,6 +300,8 @@ func (c *Client) setEndpoints() {
 	EndpointRootWithPort := fmt.Sprint(EndpointRoot, ":", c.Conf.Port)
 	// general purpose endpoints.
 	// files and directories have their endpoints defined in their respective structures.
+	c.Endpoints["files"] = EndpointRootWithPort + "/v1/files/" // NOTE: this will need to be concatenated with a file ID
+	c.Endpoints["dirs"] = EndpointRootWithPort + "/v1/dirs/"   // NOTE: this will need to be concatenated with a directory ID
 	c.Endpoints["all files"] = EndpointRootWithPort + "/v1/files/i/all/" + c.UserID
 	c.Endpoints["new file"] = EndpointRootWithPort + "/v1/files/new"
 	c.Endpoints["file info"] = EndpointRootWithPort + "/v1/files/i/" // NOTE: this will need to be concatenated with a file ID
@@ -284,10 +309,12 @@ func (c *Client) setEndpoints() {
 	c.Endpoints["dir info"] = EndpointRootWithPort + "/v1/dirs/i/" // NOTE: this will need to be concatenated with a directory ID
 	c.Endpoints["new dir"] = EndpointRootWithPort + "/v1/dirs/new"
 	c.Endpoints["drive"] = EndpointRootWithPort + "/v1/drive/" + c.DriveID
+	c.Endpoints["trash"] = EndpointRootWithPort + "/v1/drive/" + c.DriveID + "/trash"
+	c.Endpoints["snapshots"] = EndpointRootWithPort + "/v1/drive/" + c.DriveID + "/snapshots"
 	c.Endpoints["new drive"] = EndpointRootWithPort + "/v1/drive/new"
 	c.Endpoints["sync"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID
 	c.Endpoints["get index"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID
-	c.Endpoints["gen index"] = EndpointRootWithPort + "/v1/sync/index/" + c.DriveID + "/index"
+	c.Endpoints["gen index"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID + "/index"
 	c.Endpoints["gen updates"] = EndpointRootWithPort + "/v1/sync/update/" + c.DriveID + "/update"
 	c.Endpoints["user"] = EndpointRootWithPort + "/v1/users/" + c.UserID
 	c.Endpoints["new user"] = EndpointRootWithPort + "/v1/users/new"
@@ -313,6 +340,7 @@ func NewClient(user *auth.User) (*Client, error) {
 		StartTime:   time.Now().UTC(),
 		Conf:        ccfg,
 		UserID:      user.ID,
+		DeviceID:    auth.NewUUID(),
 		User:        user,
 		Root:        filepath.Join(svcRoot, "root"),
 		SfDir:       filepath.Join(svcRoot, "state"),
@@ -329,6 +357,7 @@ func NewClient(user *auth.User) (*Client, error) {
 		Transfer:    transfer.NewTransfer(),
 		Client:      newHttpClient(),
 	}
+	c.Monitor.Ignore = c.ignored
 
 	// run discover to populate the database and internal data structures
 	// with users files and directories (if present in the SFS filesystem/root directory)
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+bandwidth limits for uploads and downloads (see transfer/limit.go).
+
+limits are kept in their own file next to the state file directory rather
+than in the state file itself, since a running client overwrites its state
+file whenever it saves. a running client checks the limits file every
+limitsPollInterval and picks up any changes, so limits set with
+'sfs client limit' apply without restarting the client.
+*/
+
+const (
+	limitsFileName     = "limits.json"
+	limitsPollInterval = 10 * time.Second
+)
+
+func (c *Client) limitsFile() string {
+	return filepath.Join(filepath.Dir(c.SfDir), limitsFileName)
+}
+
+// read the client's bandwidth limits. returns empty limits if none have been set.
+func (c *Client) LoadLimits() (*transfer.Limits, error) {
+	data, err := os.ReadFile(c.limitsFile())
+	if os.IsNotExist(err) {
+		return new(transfer.Limits), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read bandwidth limits: %v", err)
+	}
+	limits := new(transfer.Limits)
+	if err := json.Unmarshal(data, limits); err != nil {
+		return nil, fmt.Errorf("failed to decode bandwidth limits: %v", err)
+	}
+	return limits, nil
+}
+
+// save bandwidth limits and apply them. running clients pick up
+// the new limits the next time they check the limits file.
+func (c *Client) SetLimits(limits *transfer.Limits) error {
+	if limits.IsEmpty() {
+		if err := os.Remove(c.limitsFile()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.Transfer.SetLimits(nil)
+		return nil
+	}
+	data, err := json.MarshalIndent(limits, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bandwidth limits: %v", err)
+	}
+	if err := os.WriteFile(c.limitsFile(), data, svc.PERMS); err != nil {
+		return err
+	}
+	c.Transfer.SetLimits(limits)
+	return nil
+}
+
+// apply the limits in the limits file, if there are any
+func (c *Client) applyLimits() error {
+	limits, err := c.LoadLimits()
+	if err != nil {
+		return err
+	}
+	if limits.IsEmpty() {
+		c.Transfer.SetLimits(nil)
+	} else {
+		c.Transfer.SetLimits(limits)
+	}
+	return nil
+}
+
+// check the limits file for changes until stop is closed
+func (c *Client) watchLimits(stop chan bool) {
+	var last time.Time
+	if info, err := os.Stat(c.limitsFile()); err == nil {
+		last = info.ModTime()
+	}
+	ticker := time.NewTicker(limitsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			var mod time.Time
+			if info, err := os.Stat(c.limitsFile()); err == nil {
+				mod = info.ModTime()
+			}
+			if mod.Equal(last) {
+				continue
+			}
+			last = mod
+			if err := c.applyLimits(); err != nil {
+				c.log.Warn(err.Error())
+				continue
+			}
+			c.log.Info("bandwidth limits updated")
+		}
+	}
+}
+
+// print the client's bandwidth limits
+func (c *Client) ShowLimits() error {
+	limits, err := c.LoadLimits()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("upload:   %s\n", transfer.FormatRate(limits.Upload))
+	fmt.Printf("download: %s\n", transfer.FormatRate(limits.Download))
+	for _, w := range limits.Windows {
+		fmt.Printf("%s\tupload: %s\tdownload: %s\n",
+			w.Span(), transfer.FormatRate(w.Upload), transfer.FormatRate(w.Download))
+	}
+	up, down := limits.Rates(time.Now())
+	fmt.Printf("\nnow: upload %s, download %s\n", transfer.FormatRate(up), transfer.FormatRate(down))
+	return nil
+}
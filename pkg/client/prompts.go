@@ -1,7 +1,9 @@
 package client
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"strings"
 )
 
@@ -12,3 +14,10 @@ func (c *Client) Continue() bool {
 	fmt.Scanln(&ans)
 	return strings.ToLower(ans) == "y"
 }
+
+// prompt the user for a passphrase
+func (c *Client) Passphrase(prompt string) string {
+	fmt.Print(prompt + ": ")
+	ans, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(ans, "\r\n")
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+the transfer queue (see service/transfers.go).
+
+every upload and download made during a sync goes through the queue in the
+client's database, so if the client is stopped partway through, whatever
+didn't finish is picked up again the next time it starts.
+*/
+
+// how long to wait before trying a failed transfer again.
+// multiplied by the number of attempts so far.
+const transferBackoff = time.Second
+
+// add files to the transfer queue. anything already queued for
+// the same file in the same direction is replaced.
+func (c *Client) queueTransfers(direction string, files []*svc.File) ([]*svc.TransferItem, error) {
+	items := make([]*svc.TransferItem, 0, len(files))
+	for _, file := range files {
+		item := svc.NewTransferItem(file, direction)
+		if err := c.Db.AddTransfer(item); err != nil {
+			return nil, fmt.Errorf("failed to queue %s: %v", file.Name, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// scheduler for this client's transfers. see transfer/scheduler.go
+func (c *Client) scheduler() *transfer.Scheduler {
+	pinned := make([]string, 0, len(c.Conf.Pinned))
+	for _, p := range c.Conf.Pinned {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(c.Root, p)
+		}
+		pinned = append(pinned, p)
+	}
+	return transfer.NewScheduler(c.Conf.TransferWorkers, pinned)
+}
+
+// run queued transfers, recording each one's progress as it goes. downloads
+// run first, then uploads, each with a bounded number of workers. each
+// transfer is tried until it succeeds or runs out of attempts. after is
+// called with each file that was transferred. returns the files that were,
+// along with why the others weren't.
+func (c *Client) runTransfers(items []*svc.TransferItem, after func(item *svc.TransferItem)) ([]*svc.File, error) {
+	var (
+		mu   sync.Mutex // the database connection isn't safe to share
+		done = make([]*svc.File, 0, len(items))
+		errs = make(transfer.Errors, 0)
+	)
+	save := func(item *svc.TransferItem) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := c.Db.UpdateTransfer(item); err != nil {
+			c.log.Warn(fmt.Sprintf("failed to record transfer progress for %s: %v", item.Name, err))
+		}
+	}
+	sched := c.scheduler()
+	for _, direction := range []string{svc.Download, svc.Upload} {
+		// a file can only be queued once per direction
+		queued := make(map[string]*svc.TransferItem)
+		files := make([]*svc.File, 0)
+		for _, item := range items {
+			if item.Direction == direction {
+				queued[item.FileID] = item
+				files = append(files, item.File)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		errs = append(errs, sched.Run(sched.Queue(files), func(file *svc.File) error {
+			item := queued[file.ID]
+			for !item.Finished() {
+				if item.Attempts > 0 {
+					time.Sleep(time.Duration(item.Attempts) * transferBackoff)
+				}
+				item.Start()
+				save(item)
+				if err := c.transfer(item); err != nil {
+					c.log.Warn(fmt.Sprintf("failed to %s %s (attempt %d of %d): %v",
+						item.Direction, item.Name, item.Attempts, svc.MaxTransferAttempts, err))
+					item.Fail(err)
+				} else {
+					item.Done()
+				}
+				save(item)
+			}
+			if item.State != svc.TransferDone {
+				return errors.New(item.LastError)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if after != nil {
+				after(item)
+			}
+			done = append(done, item.File)
+			return nil
+		})...)
+	}
+	return done, errs.Err()
+}
+
+// upload or download a queued file
+func (c *Client) transfer(item *svc.TransferItem) error {
+	file := item.File
+	switch item.Direction {
+	case svc.Upload:
+		return c.Transfer.UploadDelta(file, file.Endpoint)
+	case svc.Download:
+		return c.Transfer.DownloadDelta(file, file.Endpoint)
+	}
+	return fmt.Errorf("unknown transfer direction: %q", item.Direction)
+}
+
+// update the local copy of a downloaded file
+func (c *Client) pulled(item *svc.TransferItem) {
+	file := item.File
+	if err := c.validateChecksum(file); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to validate checksum for %s: %v", file.Name, err))
+	}
+	if err := c.Db.UpdateFile(file); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to update files database: %v", err))
+	}
+}
+
+// bring the local side up to date after a transfer that
+// was queued by an earlier sync finishes
+func (c *Client) finishTransfer(item *svc.TransferItem) {
+	if item.Direction == svc.Download {
+		c.pulled(item)
+	}
+	if err := c.setSyncBase(item.File); err != nil {
+		c.log.Warn(err.Error())
+	}
+}
+
+// pick up anything left in the transfer queue from an earlier run.
+// transfers that were in flight when the client stopped are started over.
+func (c *Client) ResumeTransfers() error {
+	items, err := c.Db.GetTransfers()
+	if err != nil {
+		return err
+	}
+	pending := make([]*svc.TransferItem, 0)
+	for _, item := range items {
+		if item.Finished() {
+			continue
+		}
+		if item.State == svc.TransferInFlight {
+			item.State = svc.TransferPending
+		}
+		pending = append(pending, item)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	c.log.Info(fmt.Sprintf("resuming %d unfinished transfer(s)...", len(pending)))
+	done, err := c.runTransfers(pending, c.finishTransfer)
+	c.log.Info(fmt.Sprintf("%d of %d transfer(s) finished", len(done), len(pending)))
+	return err
+}
+
+// list everything in the transfer queue
+func (c *Client) ListTransfers() error {
+	items, err := c.Db.GetTransfers()
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		fmt.Println("transfer queue is empty")
+		return nil
+	}
+	for _, item := range items {
+		fmt.Printf("%s\t%s\t%s\t%s\t%d attempt(s)\tupdated %s\n",
+			item.ID, item.Direction, item.State, item.Name, item.Attempts, item.UpdatedAt.Local().Format(time.RFC822))
+		if item.LastError != "" && item.State != svc.TransferDone {
+			fmt.Printf("\tlast error: %s\n", item.LastError)
+		}
+	}
+	return nil
+}
+
+// try a failed transfer again. if transferID is empty,
+// every failed transfer is tried again.
+func (c *Client) RetryTransfers(transferID string) error {
+	var items []*svc.TransferItem
+	if transferID != "" {
+		item, err := c.Db.GetTransfer(transferID)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			return fmt.Errorf("transfer (id=%s) not found", transferID)
+		}
+		items = append(items, item)
+	} else {
+		all, err := c.Db.GetTransfers()
+		if err != nil {
+			return err
+		}
+		for _, item := range all {
+			if item.State == svc.TransferFailed {
+				items = append(items, item)
+			}
+		}
+	}
+	if len(items) == 0 {
+		fmt.Println("no failed transfers")
+		return nil
+	}
+	for _, item := range items {
+		if item.State == svc.TransferDone {
+			return fmt.Errorf("%s was already transferred (id=%s)", item.Name, item.ID)
+		}
+		item.Retry()
+		if err := c.Db.UpdateTransfer(item); err != nil {
+			return err
+		}
+	}
+	if _, err := c.runTransfers(items, c.finishTransfer); err != nil {
+		return fmt.Errorf("transfers failed again: %v", err)
+	}
+	return nil
+}
+
+// remove a transfer from the queue without finishing it
+func (c *Client) CancelTransfer(transferID string) error {
+	item, err := c.Db.GetTransfer(transferID)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return fmt.Errorf("transfer (id=%s) not found", transferID)
+	}
+	if err := c.Db.RemoveTransfer(transferID); err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("canceled %s of %s", item.Direction, item.Name))
+	return nil
+}
+
+// remove finished transfers from the queue
+func (c *Client) ClearTransfers() error {
+	return c.Db.ClearDoneTransfers()
+}
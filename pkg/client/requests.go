@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 	svc "github.com/sfs/pkg/service"
@@ -39,6 +41,13 @@ func (c *Client) encodeUser(user *auth.User) (string, error) {
 }
 
 func (c *Client) encodeFile(file *svc.File) (string, error) {
+	if keys := c.keys(); keys != nil {
+		sealed, err := keys.SealFile(file)
+		if err != nil {
+			return "", err
+		}
+		file = sealed
+	}
 	payload, err := file.ToJSON()
 	if err != nil {
 		return "", err
@@ -47,6 +56,13 @@ func (c *Client) encodeFile(file *svc.File) (string, error) {
 }
 
 func (c *Client) encodeDir(dir *svc.Directory) (string, error) {
+	if keys := c.keys(); keys != nil {
+		sealed, err := keys.SealDir(dir)
+		if err != nil {
+			return "", err
+		}
+		dir = sealed
+	}
 	payload, err := dir.ToJSON()
 	if err != nil {
 		return "", err
@@ -385,6 +401,33 @@ func (c *Client) DeleteDirectoryRequest(dir *svc.Directory) (*http.Request, erro
 	return req, nil
 }
 
+// send a local deletion to the server. the request token carries
+// the device the item was deleted on and when.
+func (c *Client) TombstoneRequest(t *svc.Tombstone) (*http.Request, error) {
+	var (
+		endpoint string
+		reqToken string
+		err      error
+	)
+	if t.IsDir {
+		endpoint = c.Endpoints["dirs"] + t.ID
+		reqToken, err = c.encodeDir(&svc.Directory{ID: t.ID, DeletedBy: t.DeviceID, DeletedAt: t.DeletedAt})
+	} else {
+		endpoint = c.Endpoints["files"] + t.ID
+		reqToken, err = c.encodeFile(&svc.File{ID: t.ID, DeletedBy: t.DeviceID, DeletedAt: t.DeletedAt})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	var buf bytes.Buffer
+	req, err := http.NewRequest(http.MethodDelete, endpoint, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
 func (c *Client) DeleteDriveRequest(drv *svc.Drive) (*http.Request, error) {
 	var buf bytes.Buffer
 	req, err := http.NewRequest(http.MethodDelete, c.Endpoints["drive"], &buf)
@@ -441,3 +484,149 @@ func (c *Client) GenUpdateIdxRequest() (*http.Request, error) {
 	}
 	return req, nil
 }
+
+// ------- file versions --------------------------------
+
+func (c *Client) GetVersionsRequest(file *svc.File) (*http.Request, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequest(http.MethodGet, file.Endpoint+"/versions", &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+func (c *Client) RestoreVersionRequest(file *svc.File, rev int) (*http.Request, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/versions/%d/restore", file.Endpoint, rev), &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+func (c *Client) VersionRetentionRequest(maxVersions int, maxAge time.Duration) (*http.Request, error) {
+	var buf bytes.Buffer
+	endpoint := fmt.Sprintf("%s/versions?versions=%d&age=%s", c.Endpoints["drive"], maxVersions, maxAge)
+	req, err := http.NewRequest(http.MethodPut, endpoint, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeDrive(c.Drive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+func (c *Client) ChecksumRequest(algo string) (*http.Request, error) {
+	var buf bytes.Buffer
+	endpoint := fmt.Sprintf("%s/checksum?algo=%s", c.Endpoints["drive"], url.QueryEscape(algo))
+	req, err := http.NewRequest(http.MethodPut, endpoint, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeDrive(c.Drive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+func (c *Client) E2EERequest() (*http.Request, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequest(http.MethodPut, c.Endpoints["drive"]+"/e2ee", &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeDrive(c.Drive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+func (c *Client) DriveUsageRequest() (*http.Request, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequest(http.MethodGet, c.Endpoints["drive"]+"/usage", &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeDrive(c.Drive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+// ------- recycle bin --------------------------------
+
+func (c *Client) RecycleBinRequest(method string, endpoint string) (*http.Request, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequest(method, endpoint, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeDrive(c.Drive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+reqToken)
+	return req, nil
+}
+
+func (c *Client) GetRecycleBinRequest() (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodGet, c.Endpoints["trash"])
+}
+
+func (c *Client) RestoreRecycledRequest(itemID string) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodPost, c.Endpoints["trash"]+"/"+itemID+"/restore")
+}
+
+func (c *Client) EmptyRecycleBinRequest() (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodDelete, c.Endpoints["trash"])
+}
+
+func (c *Client) TrashRetentionRequest(retention time.Duration) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodPut, fmt.Sprintf("%s?age=%s", c.Endpoints["trash"], retention))
+}
+
+// ------- snapshots --------------------------------
+
+func (c *Client) GetSnapshotsRequest() (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodGet, c.Endpoints["snapshots"])
+}
+
+func (c *Client) NewSnapshotRequest(name string) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodPost, c.Endpoints["snapshots"]+"?name="+url.QueryEscape(name))
+}
+
+func (c *Client) SnapshotScheduleRequest(interval time.Duration, keep int) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodPut, fmt.Sprintf("%s?interval=%s&keep=%d", c.Endpoints["snapshots"], interval, keep))
+}
+
+func (c *Client) DiffSnapshotRequest(snapshotID string, to string) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodGet, c.Endpoints["snapshots"]+"/"+snapshotID+"/diff?to="+url.QueryEscape(to))
+}
+
+func (c *Client) RestoreSnapshotRequest(snapshotID string, path string) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodPost, c.Endpoints["snapshots"]+"/"+snapshotID+"/restore?path="+url.QueryEscape(path))
+}
+
+func (c *Client) DeleteSnapshotRequest(snapshotID string) (*http.Request, error) {
+	return c.RecycleBinRequest(http.MethodDelete, c.Endpoints["snapshots"]+"/"+snapshotID)
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/sfs/pkg/logger"
 	"github.com/sfs/pkg/monitor"
@@ -295,6 +296,472 @@ func (c *Client) ListRemoteFiles() error {
 	return nil
 }
 
+// ------ file versions --------------------------------
+
+// list all saved versions of a file on the server
+func (c *Client) ListVersions(file *svc.File) error {
+	req, err := c.GetVersionsRequest(file)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return nil
+	}
+
+	var versions []*svc.Version
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return fmt.Errorf("failed to decode versions: %v", err)
+	}
+	if len(versions) == 0 {
+		fmt.Printf("no saved versions of %s\n", file.Name)
+		return nil
+	}
+	for _, v := range versions {
+		fmt.Printf("rev %d\t%s\t%d bytes\t%s\n", v.Rev, v.CreatedAt.Local().Format(time.RFC822), v.Size, v.CheckSum)
+	}
+	return nil
+}
+
+// restore a file to a previous version on the server,
+// then pull the restored version down to the client.
+func (c *Client) RestoreVersion(file *svc.File, rev int) error {
+	req, err := c.RestoreVersionRequest(file, rev)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to restore version %d of %s", rev, file.Name)
+	}
+	if err := c.PullFile(file); err != nil {
+		return fmt.Errorf("failed to pull restored file: %v", err)
+	}
+	return c.Db.UpdateFile(file)
+}
+
+// update how many versions of each file the server keeps, and for how long.
+// 0 disables either limit.
+func (c *Client) SetVersionRetention(maxVersions int, maxAge time.Duration) error {
+	req, err := c.VersionRetentionRequest(maxVersions, maxAge)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to update version retention settings")
+	}
+	c.Drive.MaxVersions = maxVersions
+	c.Drive.VersionMaxAge = maxAge
+	return c.Db.UpdateDrive(c.Drive)
+}
+
+// change the checksum algorithm used by this drive. the server recalculates
+// its checksums first, then the local ones are recalculated to match.
+func (c *Client) SetAlgorithm(algo string) error {
+	if c.Drive.E2EE {
+		return fmt.Errorf("checksums for end-to-end encrypted drives are always keyed")
+	}
+	if !svc.ValidAlgorithm(algo) {
+		return fmt.Errorf("unsupported checksum algorithm: %q. must be one of %v", algo, svc.ChecksumAlgorithms())
+	}
+	if c.Drive.IsRegistered() {
+		req, err := c.ChecksumRequest(algo)
+		if err != nil {
+			return err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			c.dump(resp, true)
+			return fmt.Errorf("failed to update checksum algorithm on the server")
+		}
+	}
+	updated, err := c.Drive.SetAlgorithm(algo)
+	if err != nil {
+		return err
+	}
+	for _, file := range updated {
+		if err := c.Db.UpdateFile(file); err != nil {
+			return fmt.Errorf("failed to update file database: %v", err)
+		}
+	}
+	if err := c.Db.UpdateDrive(c.Drive); err != nil {
+		return err
+	}
+	c.BuildSyncIndex()
+	c.log.Info(fmt.Sprintf("drive now uses %s checksums. %d files updated", algo, len(updated)))
+	return nil
+}
+
+// ------ storage usage --------------------------------
+
+// show how much of the drive's storage quota on the server is in use,
+// broken down by top-level directory.
+func (c *Client) ShowUsage() error {
+	req, err := c.DriveUsageRequest()
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to get drive usage")
+	}
+
+	var usage svc.DriveUsage
+	if err := json.NewDecoder(resp.Body).Decode(&usage); err != nil {
+		return fmt.Errorf("failed to decode drive usage: %v", err)
+	}
+	var pct float64
+	if usage.TotalSize > 0 {
+		pct = float64(usage.UsedSpace) / float64(usage.TotalSize) * 100
+	}
+	fmt.Printf("used %s of %s (%.1f%%), %s free\n\n",
+		formatSize(usage.UsedSpace), formatSize(usage.TotalSize), pct, formatSize(usage.FreeSpace))
+	for _, dir := range usage.Dirs {
+		fmt.Printf("%10s\t%d files\t%s/\n", formatSize(dir.Size), dir.Files, dir.Name)
+	}
+	if usage.RootFiles > 0 {
+		fmt.Printf("%10s\t%d files\t(root)\n", formatSize(usage.RootSize), usage.RootFiles)
+	}
+	return nil
+}
+
+// format a size in bytes for display, i.e. 1.5 MB
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ------ recycle bin --------------------------------
+
+// list everything in the drive's recycle bin on the server
+func (c *Client) ListRecycleBin() error {
+	req, err := c.GetRecycleBinRequest()
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return nil
+	}
+
+	var items []*svc.RecycledItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return fmt.Errorf("failed to decode recycle bin items: %v", err)
+	}
+	if len(items) == 0 {
+		fmt.Println("recycle bin is empty")
+		return nil
+	}
+	for _, item := range items {
+		kind := "file"
+		if item.IsDir {
+			kind = "dir"
+		}
+		fmt.Printf("%s\t%s\t%s\t%d bytes\tdeleted %s\n", item.ID, kind, item.Name, item.Size, item.DeletedAt.Local().Format(time.RFC822))
+	}
+	return nil
+}
+
+// restore a file or directory from the server's recycle bin. restored
+// files are added back to the client and downloaded to their original location.
+func (c *Client) RestoreRecycled(itemID string) error {
+	req, err := c.RestoreRecycledRequest(itemID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to restore item (id=%s)", itemID)
+	}
+	item := new(svc.RecycledItem)
+	if err := json.NewDecoder(resp.Body).Decode(item); err != nil {
+		return fmt.Errorf("failed to decode restored item: %v", err)
+	}
+	if item.IsDir {
+		// TODO: pull restored directories once directory downloads are supported
+		c.log.Info(fmt.Sprintf("directory %s restored on the server", item.Name))
+		return nil
+	}
+	return c.pullRestoredFile(item.ID)
+}
+
+// get a restored file's metadata from the server, add it back to
+// the client, then download it.
+func (c *Client) pullRestoredFile(fileID string) error {
+	req, err := c.GetInfoRequest(c.Endpoints["file info"] + fileID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to get restored file info (id=%s)", fileID)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return err
+	}
+	file, err := svc.UnmarshalFileStr(buf.String())
+	if err != nil {
+		return fmt.Errorf("failed to decode file info: %v", err)
+	}
+	if keys := c.keys(); keys != nil {
+		keys.OpenFile(file)
+	}
+	// put it back where it was if we still have the directory,
+	// otherwise it goes in the root
+	dirID := file.DirID
+	if c.Drive.GetDir(dirID) == nil {
+		dirID = c.Drive.Root.ID
+	}
+	if err := c.PullFile(file); err != nil {
+		return err
+	}
+	if err := c.Drive.AddFile(dirID, file); err != nil {
+		return err
+	}
+	if err := c.Db.AddFile(file); err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("%s restored to %s", file.Name, file.ClientPath))
+	return nil
+}
+
+// permanently remove everything in the drive's recycle bin on the server
+func (c *Client) EmptyRecycleBin() error {
+	req, err := c.EmptyRecycleBinRequest()
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to empty recycle bin")
+	}
+	return nil
+}
+
+// update how long the server keeps deleted items. 0 keeps them indefinitely.
+func (c *Client) SetTrashRetention(retention time.Duration) error {
+	req, err := c.TrashRetentionRequest(retention)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		return fmt.Errorf("failed to update recycle bin retention period")
+	}
+	c.Drive.TrashRetention = retention
+	return c.Db.UpdateDrive(c.Drive)
+}
+
+// ------ snapshots --------------------------------
+
+// send a snapshot request and check that it succeeded
+func (c *Client) doSnapshotRequest(req *http.Request, failMsg string) (*http.Response, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp, true)
+		resp.Body.Close()
+		return nil, errors.New(failMsg)
+	}
+	return resp, nil
+}
+
+// list all of the drive's snapshots on the server, newest first
+func (c *Client) ListSnapshots() error {
+	req, err := c.GetSnapshotsRequest()
+	if err != nil {
+		return err
+	}
+	resp, err := c.doSnapshotRequest(req, "failed to get snapshots")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var snaps []*svc.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snaps); err != nil {
+		return fmt.Errorf("failed to decode snapshots: %v", err)
+	}
+	if len(snaps) == 0 {
+		fmt.Println("no snapshots")
+		return nil
+	}
+	for _, snap := range snaps {
+		kind := "manual"
+		if snap.Scheduled {
+			kind = "scheduled"
+		}
+		fmt.Printf("%s\t%s\t%s\t%d files\t%s\t%s\n", snap.ID, snap.CreatedAt.Local().Format(time.RFC822), kind, snap.Files, formatSize(snap.Size), snap.Name)
+	}
+	return nil
+}
+
+// take a snapshot of the drive on the server
+func (c *Client) CreateSnapshot(name string) error {
+	req, err := c.NewSnapshotRequest(name)
+	if err != nil {
+		return err
+	}
+	resp, err := c.doSnapshotRequest(req, "failed to create snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	snap := new(svc.Snapshot)
+	if err := json.NewDecoder(resp.Body).Decode(snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+	fmt.Printf("snapshot %s created (%d files, %s)\n", snap.ID, snap.Files, formatSize(snap.Size))
+	return nil
+}
+
+// show what changed since a snapshot was taken. if to is empty, the
+// snapshot is compared with the drive as it is now on the server,
+// otherwise it's compared with the snapshot whose id is to.
+func (c *Client) DiffSnapshot(snapshotID string, to string) error {
+	req, err := c.DiffSnapshotRequest(snapshotID, to)
+	if err != nil {
+		return err
+	}
+	resp, err := c.doSnapshotRequest(req, fmt.Sprintf("failed to diff snapshot (id=%s)", snapshotID))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var changes []*svc.SnapshotChange
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return fmt.Errorf("failed to decode snapshot changes: %v", err)
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+		return nil
+	}
+	for _, change := range changes {
+		path := change.Path
+		if change.IsDir {
+			path += "/"
+		}
+		if change.OldPath != "" {
+			path = change.OldPath + " -> " + path
+		}
+		fmt.Printf("%s\t%s\n", change.Change, path)
+	}
+	return nil
+}
+
+// restore the drive on the server from a snapshot, then sync so the
+// restored files are pulled down. path is the location of a file or
+// directory in the snapshot, relative to the drive's root, to restore
+// by itself. if path is empty the whole drive is restored.
+func (c *Client) RestoreSnapshot(snapshotID string, path string) error {
+	req, err := c.RestoreSnapshotRequest(snapshotID, filepath.ToSlash(path))
+	if err != nil {
+		return err
+	}
+	resp, err := c.doSnapshotRequest(req, fmt.Sprintf("failed to restore snapshot (id=%s)", snapshotID))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	c.log.Info(fmt.Sprintf("drive restored from snapshot (id=%s) on the server", snapshotID))
+	return c.Sync()
+}
+
+// remove one of the drive's snapshots on the server
+func (c *Client) RemoveSnapshot(snapshotID string) error {
+	req, err := c.DeleteSnapshotRequest(snapshotID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.doSnapshotRequest(req, fmt.Sprintf("failed to remove snapshot (id=%s)", snapshotID))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// update how often the server snapshots the drive, and how many of those
+// snapshots it keeps. an interval of 0 turns scheduled snapshots off, and
+// keeping 0 keeps all of them.
+func (c *Client) SetSnapshotSchedule(interval time.Duration, keep int) error {
+	req, err := c.SnapshotScheduleRequest(interval, keep)
+	if err != nil {
+		return err
+	}
+	resp, err := c.doSnapshotRequest(req, "failed to update snapshot schedule")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	c.Drive.SnapshotInterval = interval
+	c.Drive.MaxSnapshots = keep
+	return c.Db.UpdateDrive(c.Drive)
+}
+
 // retrieve a local file using its ID. returns nil if the file is not found.
 func (c *Client) GetFileByID(fileID string) (*svc.File, error) {
 	file := c.Drive.GetFile(fileID)
@@ -530,21 +997,13 @@ func (c *Client) UpdateFile(updatedFile *svc.File) error {
 
 // remove a file in a specied directory. removes the file from the server too.
 func (c *Client) RemoveFile(file *svc.File) error {
-	// stop monitoring the file
-	c.Monitor.StopWatching(file.Path)
-
-	// we're implementing "soft" deletes here. if a user wants to
-	// actually Delete a file, we can implement another function for that later.
-	// remove from drive and database
-	if err := file.Copy(filepath.Join(c.RecycleBin, file.Name)); err != nil {
-		return fmt.Errorf("failed to copy file to recyle directory: %v", err)
-	}
-
-	// remove physical file from original location
-	if err := c.Drive.RemoveFile(file.DirID, file); err != nil {
+	if err := c.trashFile(file); err != nil {
 		return err
 	}
-	if err := c.Db.RemoveFile(file.ID); err != nil {
+	// keep a tombstone in the database so the deletion
+	// can be sent to the server (and other devices) during the next sync
+	file.MarkDeleted(c.DeviceID)
+	if err := c.Db.TombstoneFile(file.ID, c.DeviceID, file.DeletedAt); err != nil {
 		return err
 	}
 	c.log.Info(fmt.Sprintf("%s was moved to the recycle bin", file.Name))
@@ -566,6 +1025,23 @@ func (c *Client) RemoveFile(file *svc.File) error {
 	return nil
 }
 
+// stop monitoring a file, copy it to the recycle bin, then
+// remove it from its original location and the drive.
+func (c *Client) trashFile(file *svc.File) error {
+	c.Monitor.StopWatching(file.Path)
+
+	// we're implementing "soft" deletes here. if a user wants to
+	// actually Delete a file, we can implement another function for that later.
+	if err := file.Copy(filepath.Join(c.RecycleBin, file.Name)); err != nil {
+		return fmt.Errorf("failed to copy file to recyle directory: %v", err)
+	}
+	// remove physical file from original location
+	if err := c.Drive.RemoveFile(file.DirID, file); err != nil {
+		return err
+	}
+	return nil
+}
+
 // move a file from one location to another on a users computer via
 // the sfs client. set keepOrig to true to keep the original copy in the original location.
 // sfs will only monitor the new copy after the move.
@@ -716,11 +1192,9 @@ func (c *Client) AddDir(dirPath string) error {
 	if err := c.Db.AddDir(newDir); err != nil {
 		return err
 	}
-	// NOTE: directory monitoring is not currently supported.
-	// keeping this for future implementation iterations.
-	// if err := c.WatchItem(dirPath); err != nil {
-	// 	return err
-	// }
+	if err := c.WatchItem(dirPath); err != nil {
+		return err
+	}
 	// push metadata to server if autosync is enabled
 	if c.autoSync() {
 		req, err := c.NewDirectoryRequest(newDir)
@@ -741,20 +1215,35 @@ func (c *Client) AddDir(dirPath string) error {
 
 // remove a directory from local and remote service instances.
 func (c *Client) RemoveDir(dir *svc.Directory) error {
+	// use the drive's copy so we have its contents
+	if d := c.Drive.GetDir(dir.ID); d != nil {
+		dir = d
+	}
+	// collect everything under this directory before it's removed
+	// so each item can be tombstoned individually
+	files := dir.GetFiles()
+	subDirs := dir.WalkDs()
+
 	if err := c.Drive.RemoveDir(dir.ID); err != nil {
 		return err
 	}
-	if err := c.Db.RemoveDirectory(dir.ID); err != nil {
+
+	dir.MarkDeleted(c.DeviceID)
+	for _, file := range files {
+		c.Monitor.StopWatching(file.Path)
+		if err := c.Db.TombstoneFile(file.ID, c.DeviceID, dir.DeletedAt); err != nil {
+			return err
+		}
+	}
+	for _, subDir := range subDirs {
+		if err := c.Db.TombstoneDir(subDir.ID, c.DeviceID, dir.DeletedAt); err != nil {
+			return err
+		}
+	}
+	if err := c.Db.TombstoneDir(dir.ID, c.DeviceID, dir.DeletedAt); err != nil {
 		return err
 	}
-
-	// TODO: remove files and subdirectories for this directory.
-	//
-	// need to think about this. this could easily be a recursive operation,
-	// but there's a lot that needs to be accounted for if that's the route we want to go
-	// subDirs := dir.GetSubDirs()
-	// files := dir.GetFiles()
-
+	c.log.Info(fmt.Sprintf("directory (%s) removed", dir.Name))
 	return nil
 }
 
@@ -892,7 +1381,7 @@ func (c *Client) LoadDrive() error {
 	if err != nil {
 		return err
 	}
-	if err := c.Drive.Root.AddSubDirs(dirs); err != nil {
+	if err := c.Drive.AttachDirs(dirs); err != nil {
 		return err
 	}
 	c.Drive.IsLoaded = true
@@ -1021,12 +1510,14 @@ func (c *Client) RefreshFromServer() error { return nil }
 // this should ideally be used for starting a new sfs service in a
 // users root directly that already has files and/or subdirectories.
 func (c *Client) DiscoverInRoot(root *svc.Directory) (*svc.Directory, error) {
-	// traverse users SFS file system and populate internal structures
-	root.Walk()
+	// traverse users SFS file system and populate internal structures.
+	// anything matching an ignore rule is skipped.
+	root.WalkIgnoring(c.ignored)
 
 	// send everything to the database
 	files := root.GetFiles()
 	c.log.Info(fmt.Sprintf("adding %d files...", len(files)))
+	c.setAlgorithm(files)
 
 	if err := c.Db.AddFiles(files); err != nil {
 		return root, fmt.Errorf("failed to add file to database: %v", err)
@@ -1049,9 +1540,9 @@ func (c *Client) DiscoverInRoot(root *svc.Directory) (*svc.Directory, error) {
 		return root, fmt.Errorf("failed to add directory to database: %v", err)
 	}
 	for _, subDir := range dirs {
-		// if err := c.WatchItem(subDir.Path); err != nil {
-		// 	return root, err
-		// }
+		if err := c.WatchItem(subDir.Path); err != nil {
+			return root, err
+		}
 		if c.autoSync() {
 			if err := c.RegisterDirectory(subDir); err != nil {
 				return root, err
@@ -1070,6 +1561,15 @@ func (c *Client) DiscoverInRoot(root *svc.Directory) (*svc.Directory, error) {
 	return root, nil
 }
 
+// make sure newly discovered files use the drive's checksum algorithm
+func (c *Client) setAlgorithm(files []*svc.File) {
+	for _, file := range files {
+		if err := file.SetAlgorithm(c.algorithm()); err != nil {
+			c.log.Warn(fmt.Sprintf("failed to calculate checksum for %s: %v", file.Name, err))
+		}
+	}
+}
+
 // similar to DiscoverInRoot, but uses a specified directory path
 // and does not return a new directory object.
 func (c *Client) DiscoverWithPath(dirPath string) error {
@@ -1087,11 +1587,13 @@ func (c *Client) DiscoverWithPath(dirPath string) error {
 	c.log.Info(fmt.Sprintf("traversing %s...", dirPath))
 	newDir := svc.NewDirectory(filepath.Base(dirPath), c.UserID, c.DriveID, dirPath)
 	newDir.Parent = c.Drive.Root
-	newDir.Walk()
+	newDir.ParentID = c.Drive.Root.ID
+	newDir.WalkIgnoring(c.ignored)
 
 	// add newly discovered files and directories to the service
 	files := newDir.GetFiles()
 	c.log.Info(fmt.Sprintf("adding %d files...", len(files)))
+	c.setAlgorithm(files)
 
 	if err := c.Db.AddFiles(files); err != nil {
 		return fmt.Errorf("failed to add files to database: %v", err)
@@ -1107,7 +1609,7 @@ func (c *Client) DiscoverWithPath(dirPath string) error {
 		}
 	}
 
-	// add directories to the database. not monitored (for now)
+	// add directories to the database
 	dirs := newDir.GetSubDirs()
 	c.log.Info(fmt.Sprintf("adding %d directories...", len(dirs)))
 
@@ -1115,9 +1617,9 @@ func (c *Client) DiscoverWithPath(dirPath string) error {
 		return err
 	}
 	for _, subDir := range dirs {
-		// if err := c.WatchItem(subDir.Path); err != nil {
-		// 	return err
-		// }
+		if err := c.WatchItem(subDir.Path); err != nil {
+			return err
+		}
 		if c.autoSync() {
 			if err := c.RegisterDirectory(subDir); err != nil {
 				return err
@@ -1125,14 +1627,14 @@ func (c *Client) DiscoverWithPath(dirPath string) error {
 		}
 	}
 
-	// add new directory itself. not monitored (for now)
+	// add new directory itself
 	c.log.Info(fmt.Sprintf("adding %s...", filepath.Base(dirPath)))
 	if err := c.Db.AddDir(newDir); err != nil {
 		return fmt.Errorf("failed to add root to database: %v", err)
 	}
-	// if err := c.WatchItem(newDir.Path); err != nil {
-	// 	return err
-	// }
+	if err := c.WatchItem(newDir.Path); err != nil {
+		return err
+	}
 	if err := c.RegisterDirectory(newDir); err != nil {
 		return err
 	}
@@ -7,12 +7,10 @@ May need to build SyncIndex.ToUpdate map, or transfer individual files or direct
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
-	"sync"
 	"time"
 
 	"github.com/sfs/pkg/logger"
@@ -28,6 +26,23 @@ const (
 // whether auto sync is enabled
 func (c *Client) autoSync() bool { return c.Conf.AutoSync }
 
+// checksum algorithm used by this client's drive
+func (c *Client) algorithm() string {
+	if c.Drive == nil {
+		return svc.DefaultAlgorithm
+	}
+	return c.Drive.GetAlgorithm()
+}
+
+// checksum of a local file that can be compared with other, i.e. one from
+// the server. end-to-end encrypted drives always use keyed checksums.
+func (c *Client) checksumLike(path string, other string) (string, error) {
+	if keys := c.keys(); keys != nil {
+		return keys.Checksum(path)
+	}
+	return svc.CalculateChecksumLike(path, other, c.algorithm())
+}
+
 // resets client side sync mechanisms with a
 // new baseline for item last sync times.
 func (c *Client) reset() {
@@ -52,15 +67,21 @@ func (c *Client) dump(resp *http.Response, body bool) {
 // build client sync index.
 func (c *Client) BuildSyncIndex() {
 	files := c.Drive.GetFiles()
-	if len(files) == 0 {
-		c.log.Warn("no files. sync index is not set.")
+	dirs := c.Drive.GetDirs()
+	if len(files) == 0 && len(dirs) == 0 {
+		c.log.Warn("no files or directories. sync index is not set.")
 		return
 	}
 	idx := svc.BuildRootSyncIndex(c.Drive.Root)
-	idx = svc.BuildDistSyncIndex(files, nil, idx) // NOTE: the dir arg is set to nil until dir monitoring is supported
+	idx = c.filterIndex(svc.BuildDistSyncIndex(files, dirs, idx))
+	if tombstones, err := c.Db.GetTombstones(c.DriveID); err != nil {
+		c.log.Error("failed to get tombstones: " + err.Error())
+	} else {
+		idx.AddTombstones(tombstones)
+	}
 	c.Drive.SyncIndex = idx
 
-	c.log.Log(logger.INFO, fmt.Sprintf("%d files have been indexed", len(files)))
+	c.log.Log(logger.INFO, fmt.Sprintf("%d files and %d directories have been indexed", len(files), len(dirs)))
 }
 
 // enable or disable auto sync with the server.
@@ -78,18 +99,28 @@ func (c *Client) SetAutoSync(mode bool) {
 }
 
 type SyncItems struct {
-	pull []*svc.File
-	push []*svc.File
+	pull      []*svc.File
+	push      []*svc.File
+	conflicts map[string]*svc.Conflict // key = file id
 }
 
 // sync items between the client and the server.
 //
+// files that were only changed on one side are pushed or pulled. files that
+// were changed on both sides since the last sync are treated as conflicts: the
+// local version is moved to a conflict copy and the remote version is pulled
+// in its place. see conflicts.go.
+//
+// deletions are exchanged as tombstones before anything is pushed or pulled
+// so removed items aren't brought back. see tombstones.go. new, renamed, and
+// moved directories are synced next so files have somewhere to go. see dirs.go.
+//
 // NOTE: this assumes that both the client and the server have
 // a record of the files. if the server has a file the client doesn't
 // know about, then this doesn't handle it, and vice-versa
 //
-// TODO: handle when a server has a file/directory the client doesn't have,
-// and handle when the client has a file/directory the server doesn't have.
+// TODO: handle when a server has a file the client doesn't have,
+// and handle when the client has a file the server doesn't have.
 func (c *Client) Sync() error {
 	// get latest server sync index
 	svrIdx, err := c.GetServerIdx(true)
@@ -97,138 +128,219 @@ func (c *Client) Sync() error {
 		return err
 	}
 
-	var syncItems = new(SyncItems)
+	// apply deletions from either side first
+	deleted, err := c.syncDeletes(svrIdx)
+	if err != nil {
+		return err
+	}
+	// then directories
+	dirsChanged, err := c.syncDirs(svrIdx, deleted)
+	if err != nil {
+		return err
+	}
+
+	var syncItems = &SyncItems{conflicts: make(map[string]*svc.Conflict)}
 	var localIndex = c.Drive.SyncIndex
 
 	// figure out which items to push and pull
-	for id, svrLastSync := range svrIdx.LastSync {
-		if localIndex.HasItem(id) {
-			if svrLastSync.After(localIndex.LastSync[id]) {
-				file, err := c.GetFileByID(id)
-				if err != nil {
-					return err
-				}
-				syncItems.pull = append(syncItems.pull, file)
-			} else if localIndex.LastSync[id].After(svrIdx.LastSync[id]) {
-				file, err := c.GetFileByID(id)
-				if err != nil {
-					return err
-				}
-				syncItems.push = append(syncItems.push, file)
-			}
+	for id := range svrIdx.LastSync {
+		if !localIndex.HasItem(id) || deleted[id] || svrIdx.IsDeleted(id) || svrIdx.IsDir(id) {
+			continue
+		}
+		file, err := c.GetFileByID(id)
+		if err != nil {
+			return err
+		}
+		if c.ignored(file.ClientPath, false) {
+			continue
+		}
+		op, conflict, err := c.syncOp(file, svrIdx)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case svc.SyncPull:
+			syncItems.pull = append(syncItems.pull, file)
+		case svc.SyncPush:
+			syncItems.push = append(syncItems.push, file)
+		case svc.SyncConflict:
+			syncItems.conflicts[file.ID] = conflict
+			syncItems.pull = append(syncItems.pull, file)
 		}
 	}
 	if len(syncItems.pull) == 0 && len(syncItems.push) == 0 {
+		if len(deleted) > 0 || dirsChanged {
+			c.reset()
+		}
 		c.log.Info("no sync operation necessary. exiting...")
 		return nil
 	}
 
-	// pull items
-	var wg sync.WaitGroup
-	c.log.Info(fmt.Sprintf("pulling %d files from the server...", len(syncItems.pull)))
-	for _, file := range syncItems.pull {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := c.PullFile(file); err != nil {
-				c.log.Error(fmt.Sprintf("failed to pull file: %v", err))
-			}
-		}()
-	}
-	wg.Wait()
-
-	// push items
-	c.log.Info(fmt.Sprintf("pushing %d files to the server...", len(syncItems.push)))
-	for _, file := range syncItems.push {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := c.PushFile(file); err != nil {
-				c.log.Error("failed to push file: " + err.Error())
+	// move conflicted local versions out of the way before
+	// the remote versions are pulled down
+	for _, conflict := range syncItems.conflicts {
+		if err := c.saveConflictCopy(conflict); err != nil {
+			return err
+		}
+	}
+
+	// queue everything first so an interrupted sync can be resumed
+	if err := c.Db.ClearDoneTransfers(); err != nil {
+		return err
+	}
+	pulls, err := c.queueTransfers(svc.Download, syncItems.pull)
+	if err != nil {
+		return err
+	}
+	pushes, err := c.queueTransfers(svc.Upload, syncItems.push)
+	if err != nil {
+		return err
+	}
+
+	// downloads run first, then uploads. files that fail are reported once
+	// everything else is done, and stay in the queue to be retried.
+	c.log.Info(fmt.Sprintf("pulling %d files from and pushing %d files to the server...", len(pulls), len(pushes)))
+	synced, syncErr := c.runTransfers(append(pulls, pushes...), nil)
+
+	// both sides now agree on these files
+	for _, file := range synced {
+		if err := c.setSyncBase(file); err != nil {
+			c.log.Error(err.Error())
+		}
+		// pulled files now include every change the server has seen
+		if v, ok := svrIdx.Versions[file.ID]; ok {
+			file.MergeVersion(v)
+			if err := c.Db.UpdateFile(file); err != nil {
+				c.log.Error(fmt.Sprintf("failed to update version for %s: %v", file.Name, err))
 			}
-		}()
+		}
 	}
-	wg.Wait()
 
 	// reset local sync mechanisms
 	c.reset()
 
-	return nil
+	return syncErr
+}
+
+// figure out whether a file needs to be pushed, pulled, or is in conflict.
+// returns a new conflict if the file was changed on both sides since the last sync.
+//
+// version vectors are used to decide which side is newer when both the client
+// and the server have one for the file. otherwise this falls back to comparing
+// checksums against the last synced version, and then to last sync times.
+func (c *Client) syncOp(file *svc.File, svrIdx *svc.SyncIndex) (svc.SyncOp, *svc.Conflict, error) {
+	// use the same algorithm as the server so the checksums can be compared
+	remote, hasRemote := svrIdx.CheckSums[file.ID]
+	local, err := c.checksumLike(file.ClientPath, remote)
+	if err != nil {
+		return svc.SyncNone, nil, fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+	}
+	base, err := c.Db.GetSyncBase(file.ID)
+	if err != nil {
+		return svc.SyncNone, nil, err
+	}
+	// bases recorded with a different algorithm can't be compared
+	if base != "" && svc.ChecksumAlgorithm(base) != svc.ChecksumAlgorithm(local) {
+		base = ""
+	}
+	if hasRemote && local == remote {
+		// already in sync. make sure we have a record of it.
+		if base != local {
+			if err := c.Db.SetSyncBase(file.ID, local); err != nil {
+				return svc.SyncNone, nil, err
+			}
+		}
+		return svc.SyncNone, nil, nil
+	}
+
+	var op svc.SyncOp
+	switch {
+	case len(file.Version) > 0 && len(svrIdx.Versions[file.ID]) > 0:
+		switch file.Version.Compare(svrIdx.Versions[file.ID]) {
+		case svc.After:
+			op = svc.SyncPush
+		case svc.Before:
+			op = svc.SyncPull
+		case svc.Concurrent:
+			op = svc.SyncConflict
+		default:
+			// same version, but the contents differ. the change was made
+			// outside of the monitor, so use the checksums to find out where.
+			if !hasRemote || base == "" {
+				return svc.SyncNone, nil, nil
+			}
+			op = svc.ThreeWay(base, local, remote)
+		}
+	case hasRemote && base != "":
+		op = svc.ThreeWay(base, local, remote)
+	default:
+		switch svrIdx.Order(file.ID, nil, c.Drive.SyncIndex.LastSync[file.ID]) {
+		case svc.Before:
+			return svc.SyncPull, nil, nil
+		case svc.After:
+			return svc.SyncPush, nil, nil
+		}
+		return svc.SyncNone, nil, nil
+	}
+	if op != svc.SyncConflict {
+		return op, nil, nil
+	}
+	// don't pile up conflict copies for a file that's already in conflict
+	if existing, err := c.Db.GetFileConflict(file.ID); err != nil {
+		return svc.SyncNone, nil, err
+	} else if existing != nil {
+		c.log.Warn(fmt.Sprintf("%s has an unresolved conflict (id=%s). skipping", file.Name, existing.ID))
+		return svc.SyncNone, nil, nil
+	}
+	return op, svc.NewConflict(file, device(), base, local, remote), nil
 }
 
 // take a given sync index, build a queue of files to be pushed to the
-// server, then upload each in their own goroutines. Each file is assumed to be
+// server, then upload them with the transfer scheduler. Each file is assumed to be
 // already registered with the server, otherwise this will receive a 404 response
 // and the upload will fail.
 func (c *Client) Push() error {
 	if len(c.Drive.SyncIndex.FilesToUpdate) == 0 {
 		return fmt.Errorf("no files marked for uploading. SyncIndex.ToUpdate is empty")
 	}
-	queue := svc.BuildQ(c.Drive.SyncIndex)
-	if queue == nil {
+	idx := c.filterIndex(c.Drive.SyncIndex)
+	if len(idx.FilesToUpdate) == 0 {
 		return fmt.Errorf("unable to build queue: no files found for syncing")
 	}
-	var wg sync.WaitGroup
-	for len(queue.Queue) > 0 {
-		batch := queue.Dequeue()
-		for _, file := range batch.Files {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				c.log.Info(fmt.Sprintf("uploading %s...", file.Name))
-				if err := c.Transfer.Upload(
-					http.MethodPut,
-					file,
-					file.Endpoint,
-				); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to upload file (name=%s id=%s): %v", file.Name, file.ID, err))
-				}
-			}()
-		}
+	if err := c.Db.ClearDoneTransfers(); err != nil {
+		return err
 	}
-	wg.Wait()
+	items, err := c.queueTransfers(svc.Upload, idx.GetFiles())
+	if err != nil {
+		return err
+	}
+	_, err = c.runTransfers(items, nil)
 	c.reset()
-	return nil
+	return err
 }
 
 // gets a sync index from the server, compares with the local one,
 // and pulls any files that are out of date on the client side from the server.
-// create goroutines for each download and 'fans-in' once all are complete.
+// downloads are run by the transfer scheduler, which 'fans-in' once each batch is complete.
 func (c *Client) Pull(idx *svc.SyncIndex) error {
 	if len(idx.FilesToUpdate) == 0 {
 		c.log.Warn("no sync index returned from the server. nothing to pull")
 		return nil
 	}
-	queue := svc.BuildQ(idx)
-	if len(queue.Queue) == 0 || queue == nil {
+	idx = c.filterIndex(idx)
+	if len(idx.FilesToUpdate) == 0 {
 		return fmt.Errorf("unable to build queue: no files found for syncing")
 	}
-	var wg sync.WaitGroup
-	for len(queue.Queue) > 0 {
-		batch := queue.Dequeue()
-		for _, file := range batch.Files {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := c.Transfer.Download(
-					file.ClientPath,
-					file.Endpoint,
-				); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to download %s: %v", file.Name, err))
-					return
-				}
-				if err := file.ValidateChecksum(); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to validate checksum for %s: %v", file.Name, err))
-				}
-				if err := c.Db.UpdateFile(file); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to update files database: %v", err))
-				}
-			}()
-		}
+	if err := c.Db.ClearDoneTransfers(); err != nil {
+		return err
 	}
-	wg.Wait()
+	items, err := c.queueTransfers(svc.Download, idx.GetFiles())
+	if err != nil {
+		return err
+	}
+	_, err = c.runTransfers(items, c.pulled)
 	c.reset()
-	return nil
+	return err
 }
 
 // retrieve the current sync index for this user from the server
@@ -239,7 +351,9 @@ func (c *Client) GetServerIdx(gen bool) (*svc.SyncIndex, error) {
 	} else {
 		endpoint = c.Endpoints["get index"]
 	}
-	resp, err := c.Client.Get(endpoint)
+	// older servers ignore the format and send the original index format
+	// the device id lets the server know which tombstones we've seen.
+	resp, err := c.Client.Get(fmt.Sprintf("%s?format=%d&device=%s", endpoint, svc.SyncIndexFormat, c.DeviceID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to contact server: %v", err)
 	}
@@ -254,9 +368,14 @@ func (c *Client) GetServerIdx(gen bool) (*svc.SyncIndex, error) {
 	if err != nil {
 		return nil, err
 	}
-	var idx = new(svc.SyncIndex)
-	if err = json.Unmarshal(buf.Bytes(), &idx); err != nil {
-		return nil, err
+	idx, err := svc.UnmarshalSyncIndex(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode server sync index: %v", err)
+	}
+	if keys := c.keys(); keys != nil {
+		for _, file := range idx.FilesToUpdate {
+			keys.OpenFile(file)
+		}
 	}
 	return idx, nil
 }
@@ -264,11 +383,12 @@ func (c *Client) GetServerIdx(gen bool) (*svc.SyncIndex, error) {
 // ------- single-operation pushes and pulls from the server -------------
 
 // send a known file to the server. For new files, use PushNewFile() instead.
+// only changed blocks are sent if the server already has a copy of the file.
 func (c *Client) PushFile(file *svc.File) error {
-	if err := c.Transfer.Upload(http.MethodPut, file, file.Endpoint); err != nil {
+	if err := c.Transfer.UploadDelta(file, file.Endpoint); err != nil {
 		return err
 	}
-	return nil
+	return c.setSyncBase(file)
 }
 
 // send a new file to the server. for updats to existing files,
@@ -285,9 +405,24 @@ func (c *Client) PushNewFile(file *svc.File) error {
 //
 // not intended for new files discovered on the server -- this will be handled by a
 // separate function PullNewFiles()
+//
+// only changed blocks are downloaded if there's already a local copy of the file.
 func (c *Client) PullFile(file *svc.File) error {
-	if err := c.Transfer.Download(file.ClientPath, file.Endpoint); err != nil {
+	if err := c.Transfer.DownloadDelta(file, file.Endpoint); err != nil {
 		return err
 	}
+	return c.setSyncBase(file)
+}
+
+// record the current checksum of the local copy of a file as the
+// last version both the client and the server agreed on.
+func (c *Client) setSyncBase(file *svc.File) error {
+	cs, err := c.checksumLike(file.ClientPath, "")
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+	}
+	if err := c.Db.SetSyncBase(file.ID, cs); err != nil {
+		return fmt.Errorf("failed to record sync base for %s: %v", file.Name, err)
+	}
 	return nil
 }
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+deletion tombstones.
+
+items removed on this device are kept in the database as tombstones until the
+server knows about them. items removed on other devices come down as tombstones
+in the server's sync index and are removed locally instead of being pushed back.
+*/
+
+// exchange deletions with the server. returns the ids of every item that
+// was deleted on either side so they can be skipped for the rest of the sync.
+func (c *Client) syncDeletes(svrIdx *svc.SyncIndex) (map[string]bool, error) {
+	deleted := make(map[string]bool)
+
+	// apply deletions made on other devices
+	for id, t := range svrIdx.Tombstones {
+		if t.DeviceID == c.DeviceID {
+			deleted[id] = true
+			continue
+		}
+		ok, err := c.applyTombstone(t)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			deleted[id] = true
+		}
+	}
+
+	// send our own deletions to the server
+	tombstones, err := c.Db.GetTombstones(c.DriveID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tombstones {
+		deleted[t.ID] = true
+		if svrIdx.IsDeleted(t.ID) || !svrIdx.HasItem(t.ID) {
+			// the server already has (or never had) this item,
+			// so the local tombstone is no longer needed
+			if err := c.purgeTombstone(t); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		req, err := c.TombstoneRequest(t)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			c.log.Error("failed to execute HTTP request: " + err.Error())
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			c.dump(resp, true)
+			resp.Body.Close()
+			continue
+		}
+		resp.Body.Close()
+		if err := c.purgeTombstone(t); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+// remove a local item that was deleted on another device.
+// files that were changed locally since the last sync are kept
+// so the changes aren't lost. returns true if the item was removed.
+func (c *Client) applyTombstone(t *svc.Tombstone) (bool, error) {
+	if t.IsDir {
+		dir := c.Drive.GetDir(t.ID)
+		if dir == nil {
+			return false, nil
+		}
+		for _, file := range dir.GetFiles() {
+			c.Monitor.StopWatching(file.Path)
+			if err := c.Db.RemoveFile(file.ID); err != nil {
+				return false, err
+			}
+		}
+		for _, subDir := range dir.WalkDs() {
+			if err := c.Db.RemoveDirectory(subDir.ID); err != nil {
+				return false, err
+			}
+		}
+		if err := c.Drive.RemoveDir(dir.ID); err != nil {
+			return false, err
+		}
+		if err := c.Db.RemoveDirectory(dir.ID); err != nil {
+			return false, err
+		}
+		c.log.Info(fmt.Sprintf("directory (%s) was deleted on %s", dir.Name, t.DeviceID))
+		return true, nil
+	}
+
+	file := c.Drive.GetFile(t.ID)
+	if file == nil {
+		return false, nil
+	}
+	base, err := c.Db.GetSyncBase(file.ID)
+	if err != nil {
+		return false, err
+	}
+	if base != "" {
+		local, err := c.checksumLike(file.ClientPath, base)
+		if err != nil {
+			return false, fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+		}
+		if local != base {
+			c.log.Warn(fmt.Sprintf("%s was deleted on %s but has local changes. keeping local copy", file.Name, t.DeviceID))
+			return false, nil
+		}
+	}
+	if err := c.trashFile(file); err != nil {
+		return false, err
+	}
+	if err := c.Db.RemoveFile(file.ID); err != nil {
+		return false, err
+	}
+	if err := c.Db.RemoveSyncBase(file.ID); err != nil {
+		return false, err
+	}
+	c.log.Info(fmt.Sprintf("%s was deleted on %s and moved to the recycle bin", file.Name, t.DeviceID))
+	return true, nil
+}
+
+// permanently remove a local tombstone
+func (c *Client) purgeTombstone(t *svc.Tombstone) error {
+	if t.IsDir {
+		return c.Db.RemoveDirectory(t.ID)
+	}
+	if err := c.Db.RemoveSyncBase(t.ID); err != nil {
+		return err
+	}
+	return c.Db.RemoveFile(t.ID)
+}
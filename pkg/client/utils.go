@@ -122,3 +122,12 @@ func structToMap(s interface{}) map[string]interface{} {
 
 	return m
 }
+
+// name of this device. used to label conflict copies.
+func device() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "unknown device"
+	}
+	return name
+}
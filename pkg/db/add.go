@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 
@@ -13,6 +14,11 @@ func (q *Query) AddFile(f *svc.File) error {
 	q.Connect()
 	defer q.Close()
 
+	// re-adding a deleted file replaces its tombstone
+	if _, err := q.Conn.Exec(ClearFileTombstoneQuery, f.ID); err != nil {
+		return fmt.Errorf("failed to clear tombstone: %v", err)
+	}
+
 	// prepare query
 	if err := q.Prepare(AddFileQuery); err != nil {
 		return fmt.Errorf("failed to prepare statement: %v", err)
@@ -38,6 +44,10 @@ func (q *Query) AddFile(f *svc.File) error {
 		&f.Endpoint,
 		&f.CheckSum,
 		&f.Algorithm,
+		&f.Version,
+		&f.DeletedBy,
+		&f.DeletedAt,
+		&f.Blob,
 	); err != nil {
 		return fmt.Errorf("failed to execute statement: %v", err)
 	}
@@ -51,6 +61,9 @@ func (q *Query) AddFiles(files []*svc.File) error {
 	defer q.Close()
 
 	for _, f := range files {
+		if _, err := q.Conn.Exec(ClearFileTombstoneQuery, f.ID); err != nil {
+			return fmt.Errorf("failed to clear tombstone: %v", err)
+		}
 		if err := q.Prepare(AddFileQuery); err != nil {
 			return fmt.Errorf("failed to prepare statement: %v", err)
 		}
@@ -72,6 +85,10 @@ func (q *Query) AddFiles(files []*svc.File) error {
 			&f.Endpoint,
 			&f.CheckSum,
 			&f.Algorithm,
+			&f.Version,
+			&f.DeletedBy,
+			&f.DeletedAt,
+			&f.Blob,
 		); err != nil {
 			return fmt.Errorf("failed to execute statement: %v", err)
 		}
@@ -116,6 +133,11 @@ func (q *Query) AddDir(d *svc.Directory) error {
 	q.Connect()
 	defer q.Close()
 
+	// re-adding a deleted directory replaces its tombstone
+	if _, err := q.Conn.Exec(ClearDirTombstoneQuery, d.ID); err != nil {
+		return fmt.Errorf("failed to clear tombstone: %v", err)
+	}
+
 	// prepare query
 	if err := q.Prepare(AddDirQuery); err != nil {
 		return fmt.Errorf("failed to prepare statement: %v", err)
@@ -139,6 +161,10 @@ func (q *Query) AddDir(d *svc.Directory) error {
 		&d.Endpoint,
 		&d.Root,
 		&d.RootPath,
+		&d.DeletedBy,
+		&d.DeletedAt,
+		&d.ParentID,
+		&d.Version,
 	); err != nil {
 		return fmt.Errorf("failed to add directory: %v", err)
 	}
@@ -151,6 +177,9 @@ func (q *Query) AddDirs(dirs []*svc.Directory) error {
 	defer q.Close()
 
 	for _, d := range dirs {
+		if _, err := q.Conn.Exec(ClearDirTombstoneQuery, d.ID); err != nil {
+			return fmt.Errorf("failed to clear tombstone: %v", err)
+		}
 		if err := q.Prepare(AddDirQuery); err != nil {
 			return fmt.Errorf("failed to prepare statement: %v", err)
 		}
@@ -171,6 +200,10 @@ func (q *Query) AddDirs(dirs []*svc.Directory) error {
 			&d.Endpoint,
 			&d.Root,
 			&d.RootPath,
+			&d.DeletedBy,
+			&d.DeletedAt,
+			&d.ParentID,
+			&d.Version,
 		); err != nil {
 			return fmt.Errorf("failed to add directory: %v", err)
 		}
@@ -206,8 +239,252 @@ func (q *Query) AddDrive(drv *svc.Drive) error {
 		&drv.RootID,
 		&drv.Registered,
 		&drv.RecycleBin,
+		&drv.MaxVersions,
+		&drv.VersionMaxAge,
+		&drv.TrashRetention,
+		&drv.Algorithm,
+		&drv.E2EE,
+		&drv.SnapshotInterval,
+		&drv.MaxSnapshots,
 	); err != nil {
 		return fmt.Errorf("failed to execute query: %v", err)
 	}
 	return nil
 }
+
+// add a file version entry to the versions database
+func (q *Query) AddVersion(v *svc.Version) error {
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddVersionQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&v.ID,
+		&v.FileID,
+		&v.DriveID,
+		&v.OwnerID,
+		&v.Rev,
+		&v.Name,
+		&v.Size,
+		&v.CheckSum,
+		&v.Path,
+		&v.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add a blob to the blob store database
+func (q *Query) AddBlob(b *svc.Blob) error {
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddBlobQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&b.CheckSum,
+		&b.Path,
+		&b.Size,
+		&b.Refs,
+		&b.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add a snapshot to the snapshots database
+func (q *Query) AddSnapshot(snap *svc.Snapshot) error {
+	q.WhichDB("snapshots")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddSnapshotQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&snap.ID,
+		&snap.DriveID,
+		&snap.OwnerID,
+		&snap.Name,
+		&snap.Scheduled,
+		&snap.Files,
+		&snap.Size,
+		&snap.CreatedAt,
+		&snap.Data,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add a deleted file or directory to the recycle bin database
+func (q *Query) AddRecycled(item *svc.RecycledItem) error {
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddRecycledQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&item.ID,
+		&item.Name,
+		&item.IsDir,
+		&item.OwnerID,
+		&item.DriveID,
+		&item.DirID,
+		&item.OrigPath,
+		&item.BinPath,
+		&item.Size,
+		&item.DeletedAt,
+		&item.Data,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// record the checksum a file had the last time it was in sync with the server.
+// replaces any previously recorded checksum for the file.
+func (q *Query) SetSyncBase(fileID string, checksum string) error {
+	q.WhichDB("bases")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(SetSyncBaseQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(fileID, checksum, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add a sync conflict to the conflicts database
+func (q *Query) AddConflict(c *svc.Conflict) error {
+	q.WhichDB("conflicts")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddConflictQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&c.ID,
+		&c.FileID,
+		&c.Name,
+		&c.Path,
+		&c.CopyPath,
+		&c.Device,
+		&c.LocalCheckSum,
+		&c.RemoteCheckSum,
+		&c.BaseCheckSum,
+		&c.DetectedAt,
+		&c.IsDir,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add a file to the transfer queue
+func (q *Query) AddTransfer(t *svc.TransferItem) error {
+	data, err := t.File.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode file: %v", err)
+	}
+
+	q.WhichDB("transfers")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddTransferQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&t.ID,
+		&t.FileID,
+		&t.DriveID,
+		&t.Name,
+		&t.Direction,
+		&t.State,
+		&t.Attempts,
+		&t.LastError,
+		&t.Size,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		string(data),
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add an upload session
+func (q *Query) AddUpload(u *svc.UploadSession) error {
+	received, err := u.ReceivedStr()
+	if err != nil {
+		return fmt.Errorf("failed to encode received parts: %v", err)
+	}
+
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(AddUploadQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(
+		&u.ID,
+		&u.FileID,
+		&u.DriveID,
+		&u.Size,
+		&u.CheckSum,
+		received,
+		&u.CreatedAt,
+		&u.ExpiresAt,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// record the last time a device synced with the server
+func (q *Query) SetDeviceSync(driveID string, deviceID string, lastSync time.Time) error {
+	q.WhichDB("devices")
+	q.Connect()
+	defer q.Close()
+
+	if err := q.Prepare(SetDeviceSyncQuery); err != nil {
+		return fmt.Errorf("failed to prepare statement: %v", err)
+	}
+	defer q.Stmt.Close()
+
+	if _, err := q.Stmt.Exec(deviceID, driveID, lastSync); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"log"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sfs/pkg/env"
 	svc "github.com/sfs/pkg/service"
@@ -176,3 +177,543 @@ func TestAddAndFindUser(t *testing.T) {
 		t.Errorf("[ERROR] unable to remove test directories: %v", err)
 	}
 }
+
+func TestAddAndFindVersions(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Versions"), CreateVersionTable)
+	q := NewQuery(filepath.Join(testDir, "Versions"), false)
+	q.Debug = true
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	// no versions yet
+	rev, err := q.GetLatestRev(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 0, rev)
+
+	for i := 1; i <= 3; i++ {
+		if err := q.AddVersion(svc.NewVersion(tmpFile, i, filepath.Join(testDir, fmt.Sprintf("%d", i)))); err != nil {
+			Fail(t, testDir, err)
+		}
+	}
+
+	rev, err = q.GetLatestRev(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 3, rev)
+
+	// newest first
+	versions, err := q.GetVersions(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 3, len(versions))
+	assert.Equal(t, 3, versions[0].Rev)
+
+	v, err := q.GetVersion(tmpFile.ID, 2)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, v)
+	assert.Equal(t, 2, v.Rev)
+
+	// remove and make sure it's gone
+	if err := q.RemoveVersion(v.ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	v, err = q.GetVersion(tmpFile.ID, 2)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, v)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestAddAndUpdateBlobs(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Blobs"), CreateBlobTable)
+	q := NewQuery(filepath.Join(testDir, "Blobs"), false)
+	q.Debug = true
+
+	checksum := "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	if err := q.AddBlob(svc.NewBlob(checksum, filepath.Join(testDir, "blob"), 4)); err != nil {
+		Fail(t, testDir, err)
+	}
+	b, err := q.GetBlob(checksum)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, b)
+	assert.Equal(t, 1, b.Refs)
+	assert.Equal(t, int64(4), b.Size)
+
+	// add and release references
+	if err := q.UpdateBlobRefs(checksum, 2); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.UpdateBlobRefs(checksum, -1); err != nil {
+		Fail(t, testDir, err)
+	}
+	b, err = q.GetBlob(checksum)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 2, b.Refs)
+
+	if err := q.RemoveBlob(checksum); err != nil {
+		Fail(t, testDir, err)
+	}
+	b, err = q.GetBlob(checksum)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, b)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestAddAndFindRecycled(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "RecycleBin"), CreateRecycleBinTable)
+	q := NewQuery(filepath.Join(testDir, "RecycleBin"), false)
+	q.Debug = true
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	item, err := svc.NewRecycledFile(tmpFile, filepath.Join(testDir, tmpFile.ID))
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.AddRecycled(item); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	r, err := q.GetRecycled(item.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, r)
+	assert.Equal(t, item.Data, r.Data)
+
+	items, err := q.GetRecycledItems(tmpFile.DriveID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(items))
+
+	if err := q.RemoveRecycled(item.ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	r, err = q.GetRecycled(item.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, r)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestAddAndFindSnapshot(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Snapshots"), CreateSnapshotTable)
+	q := NewQuery(filepath.Join(testDir, "Snapshots"), false)
+	q.Debug = true
+
+	drive, root, _ := MakeTestItems(t, testDir)
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := root.AddFile(tmpFile); err != nil {
+		Fail(t, testDir, err)
+	}
+	snap, err := svc.NewSnapshot(drive, "before", svc.SnapshotEntries(root))
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.AddSnapshot(snap); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	s, err := q.GetSnapshot(snap.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, s)
+	assert.Equal(t, "before", s.Name)
+	assert.Equal(t, 1, s.Files)
+	assert.Equal(t, snap.Data, s.Data)
+
+	snaps, err := q.GetSnapshots(drive.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(snaps))
+
+	if err := q.RemoveSnapshot(snap.ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	s, err = q.GetSnapshot(snap.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, s)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestSyncBasesAndConflicts(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Bases"), CreateSyncBaseTable)
+	NewTable(filepath.Join(testDir, "Conflicts"), CreateConflictTable)
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	q := NewQuery(filepath.Join(testDir, "Bases"), false)
+	q.Debug = true
+	base, err := q.GetSyncBase(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, "", base)
+	if err := q.SetSyncBase(tmpFile.ID, "abc"); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.SetSyncBase(tmpFile.ID, "def"); err != nil {
+		Fail(t, testDir, err)
+	}
+	base, err = q.GetSyncBase(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, "def", base)
+
+	q = NewQuery(filepath.Join(testDir, "Conflicts"), false)
+	q.Debug = true
+	conflict := svc.NewConflict(tmpFile, "laptop", "def", "ghi", "jkl")
+	if err := q.AddConflict(conflict); err != nil {
+		Fail(t, testDir, err)
+	}
+	c, err := q.GetFileConflict(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, c)
+	assert.Equal(t, conflict.CopyPath, c.CopyPath)
+
+	conflicts, err := q.GetConflicts()
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(conflicts))
+
+	if err := q.RemoveConflict(conflict.ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	c, err = q.GetConflict(conflict.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, c)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestFileVersionVector(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Files"), CreateFileTable)
+	q := NewQuery(filepath.Join(testDir, "Files"), false)
+	q.Debug = true
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	tmpFile.IncrementVersion("laptop")
+	if err := q.AddFile(tmpFile); err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err := q.GetFileByID(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, tmpFile.Version, f.Version)
+
+	f.IncrementVersion("server")
+	if err := q.UpdateFile(f); err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err = q.GetFileByID(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, uint64(1), f.Version["server"])
+	assert.Equal(t, uint64(1), f.Version["laptop"])
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "files"), CreateFileTable)
+	NewTable(filepath.Join(testDir, "directories"), CreateDirectoryTable)
+	NewTable(filepath.Join(testDir, "devices"), CreateDeviceTable)
+	q := NewQuery(testDir, true)
+	q.Debug = true
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.AddFile(tmpFile); err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err := q.GetFileByID(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, f)
+	assert.False(t, f.IsDeleted())
+
+	// deleted files are no longer found, but leave a tombstone behind
+	if err := q.TombstoneFile(tmpFile.ID, "laptop", time.Now().UTC()); err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err = q.GetFileByID(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, f)
+
+	tombstones, err := q.GetTombstones(tmpFile.DriveID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(tombstones))
+	assert.Equal(t, tmpFile.ID, tombstones[0].ID)
+	assert.Equal(t, "laptop", tombstones[0].DeviceID)
+	assert.False(t, tombstones[0].IsDir)
+
+	// a device that synced after the deletion has seen the tombstone
+	if err := q.SetDeviceSync(tmpFile.DriveID, "laptop", time.Now().UTC().Add(time.Minute)); err != nil {
+		Fail(t, testDir, err)
+	}
+	devices, err := q.GetDeviceSyncs(tmpFile.DriveID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(devices))
+	assert.True(t, tombstones[0].Seen(devices))
+
+	// re-adding the file replaces its tombstone
+	if err := q.AddFile(tmpFile); err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err = q.GetFileByID(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, f)
+	tombstones, err = q.GetTombstones(tmpFile.DriveID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 0, len(tombstones))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestTransferQueue(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Transfers"), CreateTransferTable)
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	q := NewQuery(filepath.Join(testDir, "Transfers"), false)
+	q.Debug = true
+	first := svc.NewTransferItem(tmpFile, svc.Upload)
+	if err := q.AddTransfer(first); err != nil {
+		Fail(t, testDir, err)
+	}
+	// queueing the same file again replaces what was there
+	item := svc.NewTransferItem(tmpFile, svc.Upload)
+	if err := q.AddTransfer(item); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.AddTransfer(svc.NewTransferItem(tmpFile, svc.Download)); err != nil {
+		Fail(t, testDir, err)
+	}
+	items, err := q.GetTransfers()
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 2, len(items))
+	old, err := q.GetTransfer(first.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, old)
+
+	item.Start()
+	item.Fail(fmt.Errorf("connection reset"))
+	if err := q.UpdateTransfer(item); err != nil {
+		Fail(t, testDir, err)
+	}
+	got, err := q.GetTransfer(item.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, svc.TransferPending, got.State)
+	assert.Equal(t, 1, got.Attempts)
+	assert.Equal(t, "connection reset", got.LastError)
+	assert.Equal(t, tmpFile.ID, got.File.ID)
+	assert.Equal(t, tmpFile.CheckSum, got.File.CheckSum)
+
+	item.Start()
+	item.Done()
+	if err := q.UpdateTransfer(item); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.ClearDoneTransfers(); err != nil {
+		Fail(t, testDir, err)
+	}
+	items, err = q.GetTransfers()
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, svc.Download, items[0].Direction)
+
+	if err := q.RemoveTransfer(items[0].ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	items, err = q.GetTransfers()
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 0, len(items))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestUploadSessions(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "Uploads"), CreateUploadTable)
+
+	tmpFile, err := MakeTmpTxtFile(filepath.Join(testDir, "temp.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	q := NewQuery(filepath.Join(testDir, "Uploads"), false)
+	q.Debug = true
+	u := svc.NewUploadSession(tmpFile, 100, tmpFile.CheckSum)
+	if err := q.AddUpload(u); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.AddUpload(svc.NewUploadSession(&svc.File{ID: "other-file", DriveID: "other-drive"}, 10, "")); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	u.Add(svc.ByteRange{Start: 0, End: 40})
+	u.Touch()
+	if err := q.UpdateUpload(u); err != nil {
+		Fail(t, testDir, err)
+	}
+	got, err := q.GetUpload(u.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, tmpFile.ID, got.FileID)
+	assert.Equal(t, int64(100), got.Size)
+	assert.Equal(t, int64(40), got.Acknowledged())
+	assert.True(t, got.ExpiresAt.Equal(u.ExpiresAt))
+
+	uploads, err := q.GetFileUploads(tmpFile.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(uploads))
+	all, err := q.GetUploads()
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 2, len(all))
+	drives, err := q.GetDriveUploads("other-drive")
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, 1, len(drives))
+	assert.Equal(t, "other-file", drives[0].FileID)
+
+	if err := q.RemoveUpload(u.ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	got, err = q.GetUpload(u.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, nil, got)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
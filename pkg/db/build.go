@@ -3,13 +3,22 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 
+	svc "github.com/sfs/pkg/service"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// databases used by the server and client services
+var (
+	serverDBs = []string{"files", "directories", "users", "drives", "versions", "recycled", "devices", "blobs", "snapshots", "uploads"}
+	clientDBs = []string{"users", "files", "drives", "directories", "bases", "conflicts", "transfers"}
+)
+
 func NewDB(dbName string, pathToNewDB string) error {
 	switch dbName {
 	case "users":
@@ -20,6 +29,24 @@ func NewDB(dbName string, pathToNewDB string) error {
 		NewTable(pathToNewDB, CreateDirectoryTable)
 	case "files":
 		NewTable(pathToNewDB, CreateFileTable)
+	case "versions":
+		NewTable(pathToNewDB, CreateVersionTable)
+	case "blobs":
+		NewTable(pathToNewDB, CreateBlobTable)
+	case "recycled":
+		NewTable(pathToNewDB, CreateRecycleBinTable)
+	case "snapshots":
+		NewTable(pathToNewDB, CreateSnapshotTable)
+	case "bases":
+		NewTable(pathToNewDB, CreateSyncBaseTable)
+	case "conflicts":
+		NewTable(pathToNewDB, CreateConflictTable)
+	case "transfers":
+		NewTable(pathToNewDB, CreateTransferTable)
+	case "uploads":
+		NewTable(pathToNewDB, CreateUploadTable)
+	case "devices":
+		NewTable(pathToNewDB, CreateDeviceTable)
 	default:
 		return fmt.Errorf("unsupported database: %v", dbName)
 	}
@@ -51,8 +78,7 @@ func InitDBs(dbPath string) error {
 		return fmt.Errorf("service database directory not empty! %v", entries)
 	}
 
-	dbs := []string{"files", "directories", "users", "drives"}
-	for _, dbName := range dbs {
+	for _, dbName := range serverDBs {
 		if err := NewDB(dbName, filepath.Join(dbPath, dbName)); err != nil {
 			return err
 		}
@@ -70,11 +96,297 @@ func InitClientDBs(dbPath string) error {
 		return fmt.Errorf("service database directory not empty! %v", entries)
 	}
 
-	dbs := []string{"users", "files", "drives", "directories"}
+	for _, dbName := range clientDBs {
+		if err := NewDB(dbName, filepath.Join(dbPath, dbName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------- migrations --------------------------------
+
+// a column added to an existing table after its initial release
+type column struct {
+	db    string // database name
+	table string // table name
+	name  string // column name
+	def   string // column type and default value
+}
+
+// columns that databases created by older versions of sfs won't have.
+// new columns should be added to the end of this list.
+var addedColumns = []column{
+	{"files", "Files", "version", "TEXT DEFAULT '{}'"},
+	{"files", "Files", "deleted_by", "VARCHAR(50) DEFAULT ''"},
+	{"files", "Files", "deleted_at", "DATETIME DEFAULT '0001-01-01 00:00:00+00:00'"},
+	{"directories", "Directories", "deleted_by", "VARCHAR(50) DEFAULT ''"},
+	{"directories", "Directories", "deleted_at", "DATETIME DEFAULT '0001-01-01 00:00:00+00:00'"},
+	{"directories", "Directories", "parent_id", "VARCHAR(50) DEFAULT ''"},
+	{"drives", "Drives", "max_versions", "INTEGER DEFAULT 0"},
+	{"drives", "Drives", "version_max_age", "INTEGER DEFAULT 0"},
+	{"drives", "Drives", "trash_retention", "INTEGER DEFAULT 0"},
+	{"drives", "Drives", "algorithm", "VARCHAR(50) DEFAULT 'sha256'"},
+	{"drives", "Drives", "e2ee", "BIT DEFAULT 0"},
+	{"files", "Files", "blob", "VARCHAR(255) DEFAULT ''"},
+	{"drives", "Drives", "snapshot_interval", "INTEGER DEFAULT 0"},
+	{"drives", "Drives", "max_snapshots", "INTEGER DEFAULT 0"},
+	{"directories", "Directories", "version", "TEXT DEFAULT '{}'"},
+	{"conflicts", "Conflicts", "is_dir", "BIT DEFAULT 0"},
+}
+
+// opens the contents of a file, given the path recorded for it in the files database
+type OpenFunc func(path string) (io.ReadCloser, error)
+
+// where the contents of the files in a files database can be read from
+type contents struct {
+	column string   // column holding each file's path
+	open   OpenFunc // opens the file at that path
+}
+
+// bring server databases created by an older version of sfs up to date.
+// creates any missing databases and adds any missing columns.
+// open reads the contents of a file from its server_path, which is
+// where the server's store keeps it rather than a path on the local disk.
+func MigrateDBs(dbPath string, open OpenFunc) error {
+	return migrate(dbPath, serverDBs, contents{column: "server_path", open: open})
+}
+
+// bring client databases created by an older version of sfs up to date.
+func MigrateClientDBs(dbPath string) error {
+	return migrate(dbPath, clientDBs, contents{column: "path", open: openLocal})
+}
+
+// open a file on the local disk
+func openLocal(path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
+
+func migrate(dbPath string, dbs []string, src contents) error {
 	for _, dbName := range dbs {
+		// tables are created with CREATE TABLE IF NOT EXISTS,
+		// so this is a no-op for databases that are already there
 		if err := NewDB(dbName, filepath.Join(dbPath, dbName)); err != nil {
 			return err
 		}
 	}
+	for _, col := range addedColumns {
+		for _, dbName := range dbs {
+			if col.db != dbName {
+				continue
+			}
+			if err := addColumn(filepath.Join(dbPath, dbName), col); err != nil {
+				return fmt.Errorf("failed to migrate %s database: %v", dbName, err)
+			}
+		}
+	}
+	if err := migrateChecksums(dbPath, dbs, src); err != nil {
+		return fmt.Errorf("failed to migrate checksums: %v", err)
+	}
+	return nil
+}
+
+// add a column to a table if it doesn't already have it
+func addColumn(path string, col column) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("unable to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", col.table))
+	if err != nil {
+		return fmt.Errorf("failed to get table info: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			colType string
+			notNull bool
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info: %v", err)
+		}
+		if name == col.name {
+			return nil
+		}
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", col.table, col.name, col.def)); err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %v", col.name, col.table, err)
+	}
+	return nil
+}
+
+// columns holding checksums of content that may no longer exist,
+// so they can only be converted rather than recalculated.
+var checksumColumns = []struct {
+	db    string // database name
+	table string // table name
+	key   string // primary key column
+	name  string // checksum column
+}{
+	{"versions", "Versions", "id", "checksum"},
+	{"bases", "SyncBases", "file_id", "checksum"},
+	{"conflicts", "Conflicts", "id", "local_checksum"},
+	{"conflicts", "Conflicts", "id", "remote_checksum"},
+	{"conflicts", "Conflicts", "id", "base_checksum"},
+}
+
+// older versions of sfs stored checksums as raw sha256 digest bytes.
+// files are rehashed with their drive's algorithm and stored as "algo:hex".
+// other checksums are converted to "sha256:hex". checksums that are
+// already in "algo:hex" form are left alone, so this only runs once.
+func migrateChecksums(dbPath string, dbs []string, src contents) error {
+	has := make(map[string]bool, len(dbs))
+	for _, dbName := range dbs {
+		has[dbName] = true
+	}
+	if has["files"] {
+		algos := make(map[string]string)
+		if has["drives"] {
+			var err error
+			if algos, err = driveAlgorithms(filepath.Join(dbPath, "drives")); err != nil {
+				return err
+			}
+		}
+		if err := rehashFiles(filepath.Join(dbPath, "files"), algos, src); err != nil {
+			return err
+		}
+	}
+	for _, col := range checksumColumns {
+		if !has[col.db] {
+			continue
+		}
+		if err := upgradeChecksums(filepath.Join(dbPath, col.db), col.table, col.key, col.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get the checksum algorithm used by each drive.
+// key = drive id, value = algorithm
+func driveAlgorithms(path string) (map[string]string, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, algorithm FROM Drives;")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query drives: %v", err)
+	}
+	defer rows.Close()
+
+	algos := make(map[string]string)
+	for rows.Next() {
+		var (
+			id   string
+			algo sql.NullString
+		)
+		if err := rows.Scan(&id, &algo); err != nil {
+			return nil, fmt.Errorf("failed to scan drive: %v", err)
+		}
+		if algo.Valid && svc.ValidAlgorithm(algo.String) {
+			algos[id] = algo.String
+		}
+	}
+	return algos, rows.Err()
+}
+
+// recalculate legacy file checksums from their contents in src.
+// files that no longer exist have their checksums converted instead.
+func rehashFiles(path string, algos map[string]string, src contents) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("unable to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT id, drive_id, %s, checksum FROM Files;", src.column))
+	if err != nil {
+		return fmt.Errorf("failed to query files: %v", err)
+	}
+	type update struct{ id, checksum, algo string }
+	updates := make([]update, 0)
+	for rows.Next() {
+		var id, driveID, filePath, checksum sql.NullString
+		if err := rows.Scan(&id, &driveID, &filePath, &checksum); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan file: %v", err)
+		}
+		if !svc.IsLegacyChecksum(checksum.String) {
+			continue
+		}
+		algo, ok := algos[driveID.String]
+		if !ok {
+			algo = svc.DefaultAlgorithm
+		}
+		cs, err := rehash(src.open, filePath.String, algo)
+		if err != nil {
+			cs, algo = svc.UpgradeChecksum(checksum.String), svc.SHA256
+		}
+		updates = append(updates, update{id.String, cs, algo})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, u := range updates {
+		if _, err := db.Exec("UPDATE Files SET checksum = ?, algorithm = ? WHERE id = ?;", u.checksum, u.algo, u.id); err != nil {
+			return fmt.Errorf("failed to update checksum for file (id=%s): %v", u.id, err)
+		}
+	}
+	return nil
+}
+
+// calculate the checksum of a file's contents with the given algorithm
+func rehash(open OpenFunc, path string, algo string) (string, error) {
+	r, err := open(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return svc.ChecksumOf(r, algo)
+}
+
+// convert legacy checksums in a column to "sha256:hex"
+func upgradeChecksums(path string, table string, key string, name string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("unable to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, %s FROM %s;", key, name, table))
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %v", table, err)
+	}
+	updates := make(map[string]string)
+	for rows.Next() {
+		var id, checksum sql.NullString
+		if err := rows.Scan(&id, &checksum); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s: %v", table, err)
+		}
+		if svc.IsLegacyChecksum(checksum.String) {
+			updates[id.String] = svc.UpgradeChecksum(checksum.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, checksum := range updates {
+		if _, err := db.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?;", table, name, key), checksum, id); err != nil {
+			return fmt.Errorf("failed to update %s (%s=%s): %v", table, key, id, err)
+		}
+	}
 	return nil
 }
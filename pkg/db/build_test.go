@@ -1,13 +1,21 @@
 package db
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/storage"
 )
 
 func TestBuildDbs(t *testing.T) {
@@ -43,3 +51,192 @@ func TestBuildDbs(t *testing.T) {
 		log.Fatal(err)
 	}
 }
+
+func TestMigrateDBs(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	// drives table from before file version settings were added
+	NewTable(filepath.Join(testDir, "drives"), `
+		CREATE TABLE IF NOT EXISTS Drives (
+			id VARCHAR(50) PRIMARY KEY,
+			name VARCHAR(255),
+			owner_id VARCHAR(50),
+			total_space DECIMAL(18, 2),
+			used_space DECIMAL(18, 2),
+			free_space DECIMAL(18, 2),
+			protected BIT,
+			key VARCHAR(100),
+			auth_type VARCHAR(50),
+			is_loaded BIT,
+			root_path VARCHAR(255),
+			root_id VARCHAR(50),
+			registered BIT, 
+			recycle_bin VARCHAR(255),
+			UNIQUE(id)
+		);`)
+
+	if err := MigrateDBs(testDir, openLocal); err != nil {
+		Fail(t, testDir, err)
+	}
+	// should be safe to run more than once
+	if err := MigrateDBs(testDir, openLocal); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	// any missing databases should have been created
+	for _, dbName := range serverDBs {
+		if _, err := os.Stat(filepath.Join(testDir, dbName)); err != nil {
+			Fail(t, testDir, err)
+		}
+	}
+
+	// new columns should be usable
+	q := NewQuery(filepath.Join(testDir, "drives"), false)
+	drv, _, _ := MakeTestItems(t, testDir)
+	drv.MaxVersions = 5
+	if err := q.AddDrive(drv); err != nil {
+		Fail(t, testDir, err)
+	}
+	d, err := q.GetDrive(drv.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.NotEqual(t, nil, d)
+	assert.Equal(t, 5, d.MaxVersions)
+
+	if err := Clean(t, testDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestMigrateChecksums(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	store := storage.NewMemory()
+	open := func(key string) (io.ReadCloser, error) { return store.Get(key) }
+	if err := MigrateDBs(testDir, open); err != nil {
+		Fail(t, testDir, err)
+	}
+	q := NewQuery(testDir, true)
+
+	// older versions of sfs stored raw sha256 digests
+	legacy := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return string(sum[:])
+	}
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "checksum.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	file.CheckSum = legacy(data)
+	file.ServerPath = "files/" + file.ID
+	if err := store.Put(file.ServerPath, bytes.NewReader(data)); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := q.AddFile(file); err != nil {
+		Fail(t, testDir, err)
+	}
+	// the server's copy is what's rehashed, not whatever is at the client's path
+	if err := os.WriteFile(file.Path, []byte("the client's copy"), svc.PERMS); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	// files that no longer exist can't be rehashed
+	gone := &svc.File{
+		ID:         auth.NewUUID(),
+		Name:       "gone.txt",
+		Path:       file.Path,
+		ServerPath: "files/gone",
+		CheckSum:   legacy([]byte("gone")),
+		Version:    svc.NewVersionVector(),
+	}
+	if err := q.AddFile(gone); err != nil {
+		Fail(t, testDir, err)
+	}
+	version := svc.NewVersion(file, 1, file.ServerPath)
+	if err := q.AddVersion(version); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	if err := MigrateDBs(testDir, open); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	want, err := svc.ChecksumOf(bytes.NewReader(data), svc.SHA256)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err := q.GetFileByID(file.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, want, f.CheckSum)
+	assert.Equal(t, svc.SHA256, f.Algorithm)
+
+	goneSum := sha256.Sum256([]byte("gone"))
+	g, err := q.GetFileByID(gone.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, "sha256:"+hex.EncodeToString(goneSum[:]), g.CheckSum)
+
+	v, err := q.GetVersion(file.ID, 1)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, want, v.CheckSum)
+
+	if err := Clean(t, testDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestMigrateClientChecksums(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	if err := MigrateClientDBs(testDir); err != nil {
+		Fail(t, testDir, err)
+	}
+	q := NewQuery(testDir, true)
+
+	// clients rehash the file at its path on their own disk
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "checksum.txt"), 10)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	sum := sha256.Sum256(data)
+	file.CheckSum = string(sum[:])
+	if err := q.AddFile(file); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	if err := MigrateClientDBs(testDir); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	want, err := svc.CalculateChecksum(file.Path)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	f, err := q.GetFileByID(file.ID)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, want, f.CheckSum)
+
+	if err := Clean(t, testDir); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 	svc "github.com/sfs/pkg/service"
@@ -153,6 +154,10 @@ func (q *Query) GetFileByID(fileID string) (*svc.File, error) {
 		&file.Endpoint,
 		&file.CheckSum,
 		&file.Algorithm,
+		&file.Version,
+		&file.DeletedBy,
+		&file.DeletedAt,
+		&file.Blob,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows returned (id=%s): %v", fileID, err))
@@ -188,6 +193,10 @@ func (q *Query) GetFileByPath(filePath string) (*svc.File, error) {
 		&file.Endpoint,
 		&file.CheckSum,
 		&file.Algorithm,
+		&file.Version,
+		&file.DeletedBy,
+		&file.DeletedAt,
+		&file.Blob,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows returned (path=%s): %v", filePath, err))
@@ -223,6 +232,10 @@ func (q *Query) GetFileByName(fileName string) (*svc.File, error) {
 		&file.Endpoint,
 		&file.CheckSum,
 		&file.Algorithm,
+		&file.Version,
+		&file.DeletedBy,
+		&file.DeletedAt,
+		&file.Blob,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows returned (file name=%s): %v", fileName, err))
@@ -284,6 +297,10 @@ func (q *Query) GetFiles() ([]*svc.File, error) {
 			&file.Endpoint,
 			&file.CheckSum,
 			&file.Algorithm,
+			&file.Version,
+			&file.DeletedBy,
+			&file.DeletedAt,
+			&file.Blob,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", "files found in database")
@@ -296,6 +313,52 @@ func (q *Query) GetFiles() ([]*svc.File, error) {
 	return fs, nil
 }
 
+// get all server-side files whose contents haven't been
+// moved into the blob store yet.
+func (q *Query) GetUnlinkedFiles() ([]*svc.File, error) {
+	q.WhichDB("files")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindUnlinkedFilesQuery)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	fs := make([]*svc.File, 0)
+	for rows.Next() {
+		file := new(svc.File)
+		if err := rows.Scan(
+			&file.ID,
+			&file.Name,
+			&file.OwnerID,
+			&file.DirID,
+			&file.DriveID,
+			&file.Mode,
+			&file.Size,
+			&file.Backup,
+			&file.Protected,
+			&file.Key,
+			&file.LastSync,
+			&file.Path,
+			&file.ServerPath,
+			&file.ClientPath,
+			&file.Endpoint,
+			&file.CheckSum,
+			&file.Algorithm,
+			&file.Version,
+			&file.DeletedBy,
+			&file.DeletedAt,
+			&file.Blob,
+		); err != nil {
+			return nil, fmt.Errorf("unable to scan rows: %v", err)
+		}
+		fs = append(fs, file)
+	}
+	return fs, nil
+}
+
 // populate a slice of *svc.File structs for all files
 // associated with the given user. will return an empty slice
 // if no files are found.
@@ -331,6 +394,10 @@ func (q *Query) GetUsersFiles(userID string) ([]*svc.File, error) {
 			&file.Endpoint,
 			&file.CheckSum,
 			&file.Algorithm,
+			&file.Version,
+			&file.DeletedBy,
+			&file.DeletedAt,
+			&file.Blob,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", fmt.Sprintf("files found for user (id=%s)", userID))
@@ -375,6 +442,10 @@ func (q *Query) GetFilesByDriveID(driveID string) ([]*svc.File, error) {
 			&file.Endpoint,
 			&file.CheckSum,
 			&file.Algorithm,
+			&file.Version,
+			&file.DeletedBy,
+			&file.DeletedAt,
+			&file.Blob,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", fmt.Sprintf("files found for user (id=%s)", driveID))
@@ -418,6 +489,10 @@ func (q *Query) GetDirectoryByID(dirID string) (*svc.Directory, error) {
 		&dir.Endpoint,
 		&dir.Root,
 		&dir.RootPath,
+		&dir.DeletedBy,
+		&dir.DeletedAt,
+		&dir.ParentID,
+		&dir.Version,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows found with dir id: %s", dirID))
@@ -455,6 +530,10 @@ func (q *Query) GetDirectoryByName(dirName string) (*svc.Directory, error) {
 		&dir.Endpoint,
 		&dir.Root,
 		&dir.RootPath,
+		&dir.DeletedBy,
+		&dir.DeletedAt,
+		&dir.ParentID,
+		&dir.Version,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows found with dir name: %s", dirName))
@@ -492,6 +571,10 @@ func (q *Query) GetDirectoryByPath(dirPath string) (*svc.Directory, error) {
 		&dir.Endpoint,
 		&dir.Root,
 		&dir.RootPath,
+		&dir.DeletedBy,
+		&dir.DeletedAt,
+		&dir.ParentID,
+		&dir.Version,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows found for dir: %s", filepath.Base(dirPath)))
@@ -555,6 +638,10 @@ func (q *Query) GetAllDirectories() ([]*svc.Directory, error) {
 			&dir.Endpoint,
 			&dir.Root,
 			&dir.RootPath,
+			&dir.DeletedBy,
+			&dir.DeletedAt,
+			&dir.ParentID,
+			&dir.Version,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", "no rows returned")
@@ -600,6 +687,10 @@ func (q *Query) GetUsersDirectories(userID string) ([]*svc.Directory, error) {
 			&dir.Endpoint,
 			&dir.Root,
 			&dir.RootPath,
+			&dir.DeletedBy,
+			&dir.DeletedAt,
+			&dir.ParentID,
+			&dir.Version,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", "no rows returned")
@@ -645,6 +736,10 @@ func (q *Query) GetDirsByDriveID(driveID string) ([]*svc.Directory, error) {
 			&dir.Endpoint,
 			&dir.Root,
 			&dir.RootPath,
+			&dir.DeletedBy,
+			&dir.DeletedAt,
+			&dir.ParentID,
+			&dir.Version,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", "no rows returned")
@@ -729,6 +824,13 @@ func (q *Query) GetDrive(driveID string) (*svc.Drive, error) {
 		&drv.RootID,
 		&drv.Registered,
 		&drv.RecycleBin,
+		&drv.MaxVersions,
+		&drv.VersionMaxAge,
+		&drv.TrashRetention,
+		&drv.Algorithm,
+		&drv.E2EE,
+		&drv.SnapshotInterval,
+		&drv.MaxSnapshots,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", "no rows returned")
@@ -773,6 +875,13 @@ func (q *Query) GetDrives() ([]*svc.Drive, error) {
 			&drv.RootID,
 			&drv.Registered,
 			&drv.RecycleBin,
+			&drv.MaxVersions,
+			&drv.VersionMaxAge,
+			&drv.TrashRetention,
+			&drv.Algorithm,
+			&drv.E2EE,
+			&drv.SnapshotInterval,
+			&drv.MaxSnapshots,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log("INFO", "no rows returned")
@@ -808,6 +917,13 @@ func (q *Query) GetDriveByUserID(userID string) (*svc.Drive, error) {
 		&drv.RootID,
 		&drv.Registered,
 		&drv.RecycleBin,
+		&drv.MaxVersions,
+		&drv.VersionMaxAge,
+		&drv.TrashRetention,
+		&drv.Algorithm,
+		&drv.E2EE,
+		&drv.SnapshotInterval,
+		&drv.MaxSnapshots,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", "no rows returned")
@@ -834,3 +950,547 @@ func (q *Query) GetDriveIDFromUserID(userID string) (string, error) {
 	}
 	return id, nil
 }
+
+// ------ versions --------------------------------
+
+// get all saved versions of a file, newest first.
+func (q *Query) GetVersions(fileID string) ([]*svc.Version, error) {
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindFileVersionsQuery, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	versions := make([]*svc.Version, 0)
+	for rows.Next() {
+		v := new(svc.Version)
+		if err := rows.Scan(
+			&v.ID,
+			&v.FileID,
+			&v.DriveID,
+			&v.OwnerID,
+			&v.Rev,
+			&v.Name,
+			&v.Size,
+			&v.CheckSum,
+			&v.Path,
+			&v.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("unable to query for version: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// get a specific revision of a file. returns nil if not found.
+func (q *Query) GetVersion(fileID string, rev int) (*svc.Version, error) {
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	v := new(svc.Version)
+	if err := q.Conn.QueryRow(FindVersionQuery, fileID, rev).Scan(
+		&v.ID,
+		&v.FileID,
+		&v.DriveID,
+		&v.OwnerID,
+		&v.Rev,
+		&v.Name,
+		&v.Size,
+		&v.CheckSum,
+		&v.Path,
+		&v.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			q.log.Log("INFO", fmt.Sprintf("no rows returned (file id=%s rev=%d): %v", fileID, rev, err))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version: %v", err)
+	}
+	return v, nil
+}
+
+// get the latest revision number for a file. returns 0 if
+// there are no saved versions of the file.
+func (q *Query) GetLatestRev(fileID string) (int, error) {
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	var rev int
+	if err := q.Conn.QueryRow(FindLatestRevQuery, fileID).Scan(&rev); err != nil {
+		return 0, fmt.Errorf("failed to query latest revision: %v", err)
+	}
+	return rev, nil
+}
+
+// ------ blobs --------------------------------
+
+// get a blob from the blob store database. returns nil if not found.
+func (q *Query) GetBlob(checksum string) (*svc.Blob, error) {
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	b := new(svc.Blob)
+	if err := q.Conn.QueryRow(FindBlobQuery, checksum).Scan(
+		&b.CheckSum,
+		&b.Path,
+		&b.Size,
+		&b.Refs,
+		&b.CreatedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get blob: %v", err)
+	}
+	return b, nil
+}
+
+// whether any of a drive's files have the contents of a blob
+func (q *Query) DriveHasBlob(driveID string, checksum string) (bool, error) {
+	q.WhichDB("files")
+	q.Connect()
+	defer q.Close()
+
+	var found bool
+	if err := q.Conn.QueryRow(FindDriveBlobQuery, driveID, checksum).Scan(&found); err != nil {
+		return false, fmt.Errorf("failed to query drive blobs: %v", err)
+	}
+	return found, nil
+}
+
+// ------ recycle bin --------------------------------
+
+// get a recycled file or directory. returns nil if not found.
+func (q *Query) GetRecycled(itemID string) (*svc.RecycledItem, error) {
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	item := new(svc.RecycledItem)
+	if err := q.Conn.QueryRow(FindRecycledQuery, itemID).Scan(
+		&item.ID,
+		&item.Name,
+		&item.IsDir,
+		&item.OwnerID,
+		&item.DriveID,
+		&item.DirID,
+		&item.OrigPath,
+		&item.BinPath,
+		&item.Size,
+		&item.DeletedAt,
+		&item.Data,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			q.log.Log("INFO", fmt.Sprintf("no rows returned (recycled item id=%s): %v", itemID, err))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get recycled item: %v", err)
+	}
+	return item, nil
+}
+
+// get all items in a drive's recycle bin, most recently deleted first.
+func (q *Query) GetRecycledItems(driveID string) ([]*svc.RecycledItem, error) {
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindDriveRecycledQuery, driveID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	items := make([]*svc.RecycledItem, 0)
+	for rows.Next() {
+		item := new(svc.RecycledItem)
+		if err := rows.Scan(
+			&item.ID,
+			&item.Name,
+			&item.IsDir,
+			&item.OwnerID,
+			&item.DriveID,
+			&item.DirID,
+			&item.OrigPath,
+			&item.BinPath,
+			&item.Size,
+			&item.DeletedAt,
+			&item.Data,
+		); err != nil {
+			return nil, fmt.Errorf("unable to query for recycled item: %v", err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ------ snapshots --------------------------------
+
+// get a snapshot. returns nil if not found.
+func (q *Query) GetSnapshot(snapshotID string) (*svc.Snapshot, error) {
+	q.WhichDB("snapshots")
+	q.Connect()
+	defer q.Close()
+
+	snap := new(svc.Snapshot)
+	if err := q.Conn.QueryRow(FindSnapshotQuery, snapshotID).Scan(
+		&snap.ID,
+		&snap.DriveID,
+		&snap.OwnerID,
+		&snap.Name,
+		&snap.Scheduled,
+		&snap.Files,
+		&snap.Size,
+		&snap.CreatedAt,
+		&snap.Data,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			q.log.Log("INFO", fmt.Sprintf("no rows returned (snapshot id=%s): %v", snapshotID, err))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get snapshot: %v", err)
+	}
+	return snap, nil
+}
+
+// get all snapshots of a drive, newest first.
+func (q *Query) GetSnapshots(driveID string) ([]*svc.Snapshot, error) {
+	q.WhichDB("snapshots")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindDriveSnapshotsQuery, driveID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	snaps := make([]*svc.Snapshot, 0)
+	for rows.Next() {
+		snap := new(svc.Snapshot)
+		if err := rows.Scan(
+			&snap.ID,
+			&snap.DriveID,
+			&snap.OwnerID,
+			&snap.Name,
+			&snap.Scheduled,
+			&snap.Files,
+			&snap.Size,
+			&snap.CreatedAt,
+			&snap.Data,
+		); err != nil {
+			return nil, fmt.Errorf("unable to query for snapshot: %v", err)
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
+
+// ------ sync bases & conflicts --------------------------------
+
+// get the checksum a file had the last time it was in sync with
+// the server. returns an empty string if there isn't one.
+func (q *Query) GetSyncBase(fileID string) (string, error) {
+	q.WhichDB("bases")
+	q.Connect()
+	defer q.Close()
+
+	var checksum string
+	if err := q.Conn.QueryRow(FindSyncBaseQuery, fileID).Scan(&checksum); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get sync base: %v", err)
+	}
+	return checksum, nil
+}
+
+func scanConflict(row interface{ Scan(...any) error }) (*svc.Conflict, error) {
+	c := new(svc.Conflict)
+	if err := row.Scan(
+		&c.ID,
+		&c.FileID,
+		&c.Name,
+		&c.Path,
+		&c.CopyPath,
+		&c.Device,
+		&c.LocalCheckSum,
+		&c.RemoteCheckSum,
+		&c.BaseCheckSum,
+		&c.DetectedAt,
+		&c.IsDir,
+	); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get a conflict by its id. returns nil if not found.
+func (q *Query) GetConflict(conflictID string) (*svc.Conflict, error) {
+	q.WhichDB("conflicts")
+	q.Connect()
+	defer q.Close()
+
+	c, err := scanConflict(q.Conn.QueryRow(FindConflictQuery, conflictID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			q.log.Log("INFO", fmt.Sprintf("no rows returned (conflict id=%s): %v", conflictID, err))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get conflict: %v", err)
+	}
+	return c, nil
+}
+
+// get the unresolved conflict for a file. returns nil if there isn't one.
+func (q *Query) GetFileConflict(fileID string) (*svc.Conflict, error) {
+	q.WhichDB("conflicts")
+	q.Connect()
+	defer q.Close()
+
+	c, err := scanConflict(q.Conn.QueryRow(FindFileConflictQuery, fileID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get conflict: %v", err)
+	}
+	return c, nil
+}
+
+// get all unresolved conflicts, most recent first.
+func (q *Query) GetConflicts() ([]*svc.Conflict, error) {
+	q.WhichDB("conflicts")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindAllConflictsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	conflicts := make([]*svc.Conflict, 0)
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query for conflict: %v", err)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, nil
+}
+
+// ------ transfer queue --------------------------------
+
+func scanTransfer(row interface{ Scan(...any) error }) (*svc.TransferItem, error) {
+	t := new(svc.TransferItem)
+	var data string
+	if err := row.Scan(
+		&t.ID,
+		&t.FileID,
+		&t.DriveID,
+		&t.Name,
+		&t.Direction,
+		&t.State,
+		&t.Attempts,
+		&t.LastError,
+		&t.Size,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&data,
+	); err != nil {
+		return nil, err
+	}
+	file, err := svc.UnmarshalFileStr(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file for transfer (id=%s): %v", t.ID, err)
+	}
+	t.File = file
+	return t, nil
+}
+
+// get a queued transfer by its id. returns nil if not found.
+func (q *Query) GetTransfer(transferID string) (*svc.TransferItem, error) {
+	q.WhichDB("transfers")
+	q.Connect()
+	defer q.Close()
+
+	t, err := scanTransfer(q.Conn.QueryRow(FindTransferQuery, transferID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get transfer: %v", err)
+	}
+	return t, nil
+}
+
+// get everything in the transfer queue, oldest first
+func (q *Query) GetTransfers() ([]*svc.TransferItem, error) {
+	q.WhichDB("transfers")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindAllTransfersQuery)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	transfers := make([]*svc.TransferItem, 0)
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query for transfer: %v", err)
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, nil
+}
+
+// ------ upload sessions --------------------------------
+
+func scanUpload(row interface{ Scan(...any) error }) (*svc.UploadSession, error) {
+	u := new(svc.UploadSession)
+	var received string
+	if err := row.Scan(
+		&u.ID,
+		&u.FileID,
+		&u.DriveID,
+		&u.Size,
+		&u.CheckSum,
+		&received,
+		&u.CreatedAt,
+		&u.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := u.SetReceived(received); err != nil {
+		return nil, fmt.Errorf("failed to decode received parts for upload (id=%s): %v", u.ID, err)
+	}
+	return u, nil
+}
+
+// get an upload session by its id. returns nil if not found.
+func (q *Query) GetUpload(uploadID string) (*svc.UploadSession, error) {
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	u, err := scanUpload(q.Conn.QueryRow(FindUploadQuery, uploadID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get upload: %v", err)
+	}
+	return u, nil
+}
+
+func (q *Query) getUploads(query string, args ...any) ([]*svc.UploadSession, error) {
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	uploads := make([]*svc.UploadSession, 0)
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, fmt.Errorf("unable to query for upload: %v", err)
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
+
+// get all upload sessions for a file, oldest first
+func (q *Query) GetFileUploads(fileID string) ([]*svc.UploadSession, error) {
+	return q.getUploads(FindFileUploadsQuery, fileID)
+}
+
+// get all upload sessions for files in a drive, oldest first
+func (q *Query) GetDriveUploads(driveID string) ([]*svc.UploadSession, error) {
+	return q.getUploads(FindDriveUploadsQuery, driveID)
+}
+
+// get all upload sessions, oldest first
+func (q *Query) GetUploads() ([]*svc.UploadSession, error) {
+	return q.getUploads(FindAllUploadsQuery)
+}
+
+// ------ tombstones & devices --------------------------------
+
+// get tombstones for all deleted files and directories in a drive
+func (q *Query) GetTombstones(driveID string) ([]*svc.Tombstone, error) {
+	files, err := q.getTombstones("files", FindFileTombstonesQuery, driveID, false)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := q.getTombstones("directories", FindDirTombstonesQuery, driveID, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, dirs...), nil
+}
+
+func (q *Query) getTombstones(dbName string, query string, driveID string, isDir bool) ([]*svc.Tombstone, error) {
+	q.WhichDB(dbName)
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(query, driveID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	tombstones := make([]*svc.Tombstone, 0)
+	for rows.Next() {
+		t := &svc.Tombstone{IsDir: isDir}
+		if err := rows.Scan(&t.ID, &t.DeviceID, &t.DeletedAt); err != nil {
+			return nil, fmt.Errorf("unable to query for tombstone: %v", err)
+		}
+		tombstones = append(tombstones, t)
+	}
+	return tombstones, nil
+}
+
+// get the last time each device synced with a drive.
+// key = device ID, value = last sync time
+func (q *Query) GetDeviceSyncs(driveID string) (map[string]time.Time, error) {
+	q.WhichDB("devices")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindDriveDevicesQuery, driveID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query: %v", err)
+	}
+	defer rows.Close()
+
+	devices := make(map[string]time.Time)
+	for rows.Next() {
+		var deviceID string
+		var lastSync time.Time
+		if err := rows.Scan(&deviceID, &lastSync); err != nil {
+			return nil, fmt.Errorf("unable to query for device: %v", err)
+		}
+		devices[deviceID] = lastSync
+	}
+	return devices, nil
+}
@@ -25,6 +25,10 @@ const (
 			endpoint VARCHAR(255),
 			checksum VARCHAR(255),
 			algorithm VARCHAR(50),
+			version TEXT DEFAULT '{}',
+			deleted_by VARCHAR(50) DEFAULT '',
+			deleted_at DATETIME DEFAULT '0001-01-01 00:00:00+00:00',
+			blob VARCHAR(255) DEFAULT '',
 			UNIQUE(id)
 		);`
 
@@ -46,6 +50,10 @@ const (
 			endpoint VARCHAR(255),
 			drive_root VARCHAR(255),
 			root_path VARCHAR(255),
+			deleted_by VARCHAR(50) DEFAULT '',
+			deleted_at DATETIME DEFAULT '0001-01-01 00:00:00+00:00',
+			parent_id VARCHAR(50) DEFAULT '',
+			version TEXT DEFAULT '{}',
 			UNIQUE(id)
 		);
 	`
@@ -66,9 +74,135 @@ const (
 			root_id VARCHAR(50),
 			registered BIT, 
 			recycle_bin VARCHAR(255),
+			max_versions INTEGER DEFAULT 0,
+			version_max_age INTEGER DEFAULT 0,
+			trash_retention INTEGER DEFAULT 0,
+			algorithm VARCHAR(50) DEFAULT 'sha256',
+			e2ee BIT DEFAULT 0,
+			snapshot_interval INTEGER DEFAULT 0,
+			max_snapshots INTEGER DEFAULT 0,
 			UNIQUE(id)
 		);`
 
+	CreateBlobTable string = `
+		CREATE TABLE IF NOT EXISTS Blobs (
+			checksum VARCHAR(255) PRIMARY KEY,
+			path VARCHAR(255),
+			size INTEGER,
+			refs INTEGER,
+			created_at DATETIME,
+			UNIQUE(checksum)
+		);`
+
+	CreateVersionTable string = `
+		CREATE TABLE IF NOT EXISTS Versions (
+			id VARCHAR(50) PRIMARY KEY,
+			file_id VARCHAR(50),
+			drive_id VARCHAR(50),
+			owner_id VARCHAR(50),
+			rev INTEGER,
+			name VARCHAR(255),
+			size INTEGER,
+			checksum VARCHAR(255),
+			path VARCHAR(255),
+			created_at DATETIME,
+			UNIQUE(id),
+			UNIQUE(file_id, rev)
+		);`
+
+	CreateRecycleBinTable string = `
+		CREATE TABLE IF NOT EXISTS RecycleBin (
+			id VARCHAR(50) PRIMARY KEY,
+			name VARCHAR(255),
+			is_dir BIT,
+			owner_id VARCHAR(50),
+			drive_id VARCHAR(50),
+			dir_id VARCHAR(50),
+			orig_path VARCHAR(255),
+			bin_path VARCHAR(255),
+			size INTEGER,
+			deleted_at DATETIME,
+			data TEXT,
+			UNIQUE(id)
+		);`
+
+	CreateSyncBaseTable string = `
+		CREATE TABLE IF NOT EXISTS SyncBases (
+			file_id VARCHAR(50) PRIMARY KEY,
+			checksum VARCHAR(255),
+			synced_at DATETIME,
+			UNIQUE(file_id)
+		);`
+
+	CreateConflictTable string = `
+		CREATE TABLE IF NOT EXISTS Conflicts (
+			id VARCHAR(50) PRIMARY KEY,
+			file_id VARCHAR(50),
+			name VARCHAR(255),
+			path VARCHAR(255),
+			copy_path VARCHAR(255),
+			device VARCHAR(255),
+			local_checksum VARCHAR(255),
+			remote_checksum VARCHAR(255),
+			base_checksum VARCHAR(255),
+			detected_at DATETIME,
+			is_dir BIT DEFAULT 0,
+			UNIQUE(id)
+		);`
+
+	CreateTransferTable string = `
+		CREATE TABLE IF NOT EXISTS Transfers (
+			id VARCHAR(50) PRIMARY KEY,
+			file_id VARCHAR(50),
+			drive_id VARCHAR(50),
+			name VARCHAR(255),
+			direction VARCHAR(50),
+			state VARCHAR(50),
+			attempts INTEGER,
+			last_error TEXT,
+			size INTEGER,
+			created_at DATETIME,
+			updated_at DATETIME,
+			data TEXT,
+			UNIQUE(id),
+			UNIQUE(file_id, direction)
+		);`
+
+	CreateUploadTable string = `
+		CREATE TABLE IF NOT EXISTS Uploads (
+			id VARCHAR(50) PRIMARY KEY,
+			file_id VARCHAR(50),
+			drive_id VARCHAR(50),
+			size INTEGER,
+			checksum VARCHAR(255),
+			received TEXT,
+			created_at DATETIME,
+			expires_at DATETIME,
+			UNIQUE(id)
+		);`
+
+	CreateSnapshotTable string = `
+		CREATE TABLE IF NOT EXISTS Snapshots (
+			id VARCHAR(50) PRIMARY KEY,
+			drive_id VARCHAR(50),
+			owner_id VARCHAR(50),
+			name VARCHAR(255),
+			scheduled BIT,
+			files INTEGER,
+			size INTEGER,
+			created_at DATETIME,
+			data TEXT,
+			UNIQUE(id)
+		);`
+
+	CreateDeviceTable string = `
+		CREATE TABLE IF NOT EXISTS Devices (
+			id VARCHAR(50),
+			drive_id VARCHAR(50),
+			last_sync DATETIME,
+			PRIMARY KEY (id, drive_id)
+		);`
+
 	CreateUserTable string = `
 		CREATE TABLE IF NOT EXISTS Users (
 			id VARCHAR(50) PRIMARY KEY,
@@ -106,9 +240,13 @@ const (
 			client_path,
 			endpoint,
 			checksum,
-			algorithm
+			algorithm,
+			version,
+			deleted_by,
+			deleted_at,
+			blob
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	AddDirQuery string = `
 		INSERT OR IGNORE INTO Directories (
@@ -127,9 +265,13 @@ const (
 			last_sync,
 			endpoint, 
 			drive_root, 
-			root_path
+			root_path,
+			deleted_by,
+			deleted_at,
+			parent_id,
+			version
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	AddDriveQuery string = `
 		INSERT OR IGNORE INTO Drives (
@@ -146,9 +288,135 @@ const (
 			root_path,
 			root_id,
 			registered, 
-			recycle_bin
+			recycle_bin,
+			max_versions,
+			version_max_age,
+			trash_retention,
+			algorithm,
+			e2ee,
+			snapshot_interval,
+			max_snapshots
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	AddVersionQuery string = `
+		INSERT OR IGNORE INTO Versions (
+			id,
+			file_id,
+			drive_id,
+			owner_id,
+			rev,
+			name,
+			size,
+			checksum,
+			path,
+			created_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	AddBlobQuery string = `
+		INSERT OR IGNORE INTO Blobs (
+			checksum,
+			path,
+			size,
+			refs,
+			created_at
+		)
+		VALUES (?, ?, ?, ?, ?)`
+
+	AddRecycledQuery string = `
+		INSERT OR IGNORE INTO RecycleBin (
+			id,
+			name,
+			is_dir,
+			owner_id,
+			drive_id,
+			dir_id,
+			orig_path,
+			bin_path,
+			size,
+			deleted_at,
+			data
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	AddSnapshotQuery string = `
+		INSERT OR IGNORE INTO Snapshots (
+			id,
+			drive_id,
+			owner_id,
+			name,
+			scheduled,
+			files,
+			size,
+			created_at,
+			data
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// replaces the existing base for a file, if any
+	SetSyncBaseQuery string = `
+		INSERT OR REPLACE INTO SyncBases (
+			file_id,
+			checksum,
+			synced_at
+		)
+		VALUES (?, ?, ?)`
+
+	AddConflictQuery string = `
+		INSERT OR IGNORE INTO Conflicts (
+			id,
+			file_id,
+			name,
+			path,
+			copy_path,
+			device,
+			local_checksum,
+			remote_checksum,
+			base_checksum,
+			detected_at,
+			is_dir
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// replaces anything already queued for the same file in the same direction
+	AddTransferQuery string = `
+		INSERT OR REPLACE INTO Transfers (
+			id,
+			file_id,
+			drive_id,
+			name,
+			direction,
+			state,
+			attempts,
+			last_error,
+			size,
+			created_at,
+			updated_at,
+			data
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	AddUploadQuery string = `
+		INSERT INTO Uploads (
+			id,
+			file_id,
+			drive_id,
+			size,
+			checksum,
+			received,
+			created_at,
+			expires_at
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	SetDeviceSyncQuery string = `
+		INSERT OR REPLACE INTO Devices (
+			id,
+			drive_id,
+			last_sync
+		)
+		VALUES (?, ?, ?)`
 
 	AddUserQuery string = `
 		INSERT OR IGNORE INTO Users (
@@ -169,6 +437,18 @@ const (
 
 	// ------- update file, user, directory, and drive entries -------
 
+	TombstoneFileQuery string = `
+		UPDATE Files
+		SET deleted_by = ?,
+				deleted_at = ?
+		WHERE id = ?;`
+
+	TombstoneDirQuery string = `
+		UPDATE Directories
+		SET deleted_by = ?,
+				deleted_at = ?
+		WHERE id = ?;`
+
 	UpdateFileQuery string = `
 		UPDATE Files
 		SET id = ?, 
@@ -187,7 +467,27 @@ const (
 				client_path = ?,
 				endpoint = ?,  
 				checksum = ?, 
-				algorithm = ?
+				algorithm = ?,
+				version = ?,
+				deleted_by = ?,
+				deleted_at = ?,
+				blob = ?
+		WHERE id = ?;`
+
+	UpdateBlobRefsQuery string = `UPDATE Blobs SET refs = refs + ? WHERE checksum = ?;`
+
+	UpdateUploadQuery string = `
+		UPDATE Uploads
+		SET received = ?,
+			expires_at = ?
+		WHERE id = ?;`
+
+	UpdateTransferQuery string = `
+		UPDATE Transfers
+		SET state = ?,
+			attempts = ?,
+			last_error = ?,
+			updated_at = ?
 		WHERE id = ?;`
 
 	UpdateDirQuery string = `
@@ -207,7 +507,11 @@ const (
 				last_sync = ?, 
 				endpoint = ?,
 				drive_root = ?, 
-				root_path = ?
+				root_path = ?,
+				deleted_by = ?,
+				deleted_at = ?,
+				parent_id = ?,
+				version = ?
 		WHERE id = ?;`
 
 	UpdateDriveQuery string = `
@@ -225,7 +529,14 @@ const (
 				root_path = ?,
 				root_id = ?,
 				registered = ?,
-				recycle_bin = ?
+				recycle_bin = ?,
+				max_versions = ?,
+				version_max_age = ?,
+				trash_retention = ?,
+				algorithm = ?,
+				e2ee = ?,
+				snapshot_interval = ?,
+				max_snapshots = ?
 		WHERE id = ?;`
 
 	UpdateUserQuery string = `
@@ -258,6 +569,44 @@ const (
 		DELETE FROM Drives WHERE id = ? 
 		AND EXISTS (SELECT 1 FROM Drives WHERE id = ?);`
 
+	RemoveVersionQuery string = `
+		DELETE FROM Versions WHERE id = ? 
+		AND EXISTS (SELECT 1 FROM Versions WHERE id = ?);`
+
+	RemoveBlobQuery string = `
+		DELETE FROM Blobs WHERE checksum = ? 
+		AND EXISTS (SELECT 1 FROM Blobs WHERE checksum = ?);`
+
+	RemoveRecycledQuery string = `
+		DELETE FROM RecycleBin WHERE id = ? 
+		AND EXISTS (SELECT 1 FROM RecycleBin WHERE id = ?);`
+
+	RemoveSnapshotQuery string = `
+		DELETE FROM Snapshots WHERE id = ? 
+		AND EXISTS (SELECT 1 FROM Snapshots WHERE id = ?);`
+
+	RemoveSyncBaseQuery string = `
+		DELETE FROM SyncBases WHERE file_id = ? 
+		AND EXISTS (SELECT 1 FROM SyncBases WHERE file_id = ?);`
+
+	RemoveConflictQuery string = `
+		DELETE FROM Conflicts WHERE id = ? 
+		AND EXISTS (SELECT 1 FROM Conflicts WHERE id = ?);`
+
+	RemoveTransferQuery string = `
+		DELETE FROM Transfers WHERE id = ? 
+		AND EXISTS (SELECT 1 FROM Transfers WHERE id = ?);`
+
+	RemoveDoneTransfersQuery string = `DELETE FROM Transfers WHERE state = 'done';`
+
+	RemoveUploadQuery string = `DELETE FROM Uploads WHERE id = ?;`
+
+	RemoveDeviceQuery string = `DELETE FROM Devices WHERE id = ? AND drive_id = ?;`
+
+	// clear any tombstone left behind for an item before (re)adding it
+	ClearFileTombstoneQuery string = `DELETE FROM Files WHERE id = ? AND deleted_by != '';`
+	ClearDirTombstoneQuery  string = `DELETE FROM Directories WHERE id = ? AND deleted_by != '';`
+
 	RemoveUserQuery string = `
 		DELETE FROM Users WHERE id = ? 
 		AND EXISTS (SELECT 1 FROM Users WHERE id=?);`
@@ -270,6 +619,24 @@ const (
 
 	DropFilesTableQuery string = `DROP TABLE IF EXISTS Files;`
 
+	DropVersionsTableQuery string = `DROP TABLE IF EXISTS Versions;`
+
+	DropBlobsTableQuery string = `DROP TABLE IF EXISTS Blobs;`
+
+	DropRecycleBinTableQuery string = `DROP TABLE IF EXISTS RecycleBin;`
+
+	DropSnapshotsTableQuery string = `DROP TABLE IF EXISTS Snapshots;`
+
+	DropSyncBasesTableQuery string = `DROP TABLE IF EXISTS SyncBases;`
+
+	DropConflictsTableQuery string = `DROP TABLE IF EXISTS Conflicts;`
+
+	DropTransfersTableQuery string = `DROP TABLE IF EXISTS Transfers;`
+
+	DropUploadsTableQuery string = `DROP TABLE IF EXISTS Uploads;`
+
+	DropDevicesTableQuery string = `DROP TABLE IF EXISTS Devices;`
+
 	// ---------- SELECT statements for searching -------------------------------
 
 	// general
@@ -280,28 +647,51 @@ const (
 	// find all
 	FindAllUsersQuery  string = `SELECT * FROM Users;`
 	FindAllDrivesQuery string = `SELECT * FROM Drives;`
-	FindAllDirsQuery   string = `SELECT * FROM Directories;`
-	FindAllFilesQuery  string = `SELECT * FROM Files;`
+	FindAllDirsQuery   string = `SELECT * FROM Directories WHERE deleted_by = '';`
+	FindAllFilesQuery  string = `SELECT * FROM Files WHERE deleted_by = '';`
 
 	// find specific
-	FindDirByNameQuery           string = `SELECT * FROM Directories WHERE name = ?;`
-	FindAllUsersFilesQuery       string = `SELECT * FROM Files WHERE owner_id = ?;`
-	FindFileIDWithPathQuery      string = `SELECT id FROM Files WHERE path = ?;`
-	FindFileQuery                string = `SELECT * FROM Files WHERE id = ?;`
-	FindFileByNameQuery          string = `SELECT * FROM Files WHERE name = ?;`
-	FindFileByPathQuery          string = `SELECT * FROM Files WHERE path = ?;`
-	FindFilesByDriveIDQuery      string = `SELECT * FROM Files WHERE drive_id = ?;`
-	FindAllBackedUpFilesQuery    string = `SELECT * FROM Files WHERE backup = 1;`
-	FindDirQuery                 string = `SELECT * FROM Directories WHERE id = ?;`
-	FindAllUsersDirectoriesQuery string = `SELECT * FROM Directories WHERE owner_id = ?;`
-	FindDirsByDriveIDQuery       string = `SELECT * FROM Directories WHERE drive_id = ?;`
-	FindDirByPathQuery           string = `SELECT * FROM Directories WHERE path = ?;`
-	FindDirIDByPathQuery         string = `SELECT id FROM Directories WHERE path = ?;`
+	FindDirByNameQuery           string = `SELECT * FROM Directories WHERE name = ? AND deleted_by = '';`
+	FindAllUsersFilesQuery       string = `SELECT * FROM Files WHERE owner_id = ? AND deleted_by = '';`
+	FindFileIDWithPathQuery      string = `SELECT id FROM Files WHERE path = ? AND deleted_by = '';`
+	FindFileQuery                string = `SELECT * FROM Files WHERE id = ? AND deleted_by = '';`
+	FindFileByNameQuery          string = `SELECT * FROM Files WHERE name = ? AND deleted_by = '';`
+	FindFileByPathQuery          string = `SELECT * FROM Files WHERE path = ? AND deleted_by = '';`
+	FindFilesByDriveIDQuery      string = `SELECT * FROM Files WHERE drive_id = ? AND deleted_by = '';`
+	FindAllBackedUpFilesQuery    string = `SELECT * FROM Files WHERE backup = 1 AND deleted_by = '';`
+	FindDirQuery                 string = `SELECT * FROM Directories WHERE id = ? AND deleted_by = '';`
+	FindAllUsersDirectoriesQuery string = `SELECT * FROM Directories WHERE owner_id = ? AND deleted_by = '';`
+	FindDirsByDriveIDQuery       string = `SELECT * FROM Directories WHERE drive_id = ? AND deleted_by = '';`
+	FindDirByPathQuery           string = `SELECT * FROM Directories WHERE path = ? AND deleted_by = '';`
+	FindDirIDByPathQuery         string = `SELECT id FROM Directories WHERE path = ? AND deleted_by = '';`
 	FindDriveQuery               string = `SELECT * FROM Drives WHERE id = ?;`
 	FindDriveByUserID            string = `SELECT * FROM Drives WHERE owner_id = ?;`
 	FindUserQuery                string = `SELECT * FROM Users WHERE id = ?;`
 	FindUsersDriveIDQuery        string = `SELECT drive_id FROM Users WHERE id = ?;`
 	FindUsersIDWithDriveIDQuery  string = `SELECT owner_id FROM Drives WHERE id = ?;`
+	FindFileVersionsQuery        string = `SELECT * FROM Versions WHERE file_id = ? ORDER BY rev DESC;`
+	FindVersionQuery             string = `SELECT * FROM Versions WHERE file_id = ? AND rev = ?;`
+	FindLatestRevQuery           string = `SELECT COALESCE(MAX(rev), 0) FROM Versions WHERE file_id = ?;`
+	FindBlobQuery                string = `SELECT * FROM Blobs WHERE checksum = ?;`
+	FindDriveBlobQuery           string = `SELECT EXISTS(SELECT 1 FROM Files WHERE drive_id = ? AND blob = ? AND deleted_by = '');`
+	FindUnlinkedFilesQuery       string = `SELECT * FROM Files WHERE blob = '' AND backup = 1 AND deleted_by = '';`
+	FindRecycledQuery            string = `SELECT * FROM RecycleBin WHERE id = ?;`
+	FindDriveRecycledQuery       string = `SELECT * FROM RecycleBin WHERE drive_id = ? ORDER BY deleted_at DESC;`
+	FindSnapshotQuery            string = `SELECT * FROM Snapshots WHERE id = ?;`
+	FindDriveSnapshotsQuery      string = `SELECT * FROM Snapshots WHERE drive_id = ? ORDER BY created_at DESC;`
+	FindSyncBaseQuery            string = `SELECT checksum FROM SyncBases WHERE file_id = ?;`
+	FindConflictQuery            string = `SELECT * FROM Conflicts WHERE id = ?;`
+	FindFileConflictQuery        string = `SELECT * FROM Conflicts WHERE file_id = ?;`
+	FindAllConflictsQuery        string = `SELECT * FROM Conflicts ORDER BY detected_at DESC;`
+	FindTransferQuery            string = `SELECT * FROM Transfers WHERE id = ?;`
+	FindAllTransfersQuery        string = `SELECT * FROM Transfers ORDER BY created_at;`
+	FindUploadQuery              string = `SELECT * FROM Uploads WHERE id = ?;`
+	FindFileUploadsQuery         string = `SELECT * FROM Uploads WHERE file_id = ? ORDER BY created_at;`
+	FindDriveUploadsQuery        string = `SELECT * FROM Uploads WHERE drive_id = ? ORDER BY created_at;`
+	FindAllUploadsQuery          string = `SELECT * FROM Uploads ORDER BY created_at;`
+	FindFileTombstonesQuery      string = `SELECT id, deleted_by, deleted_at FROM Files WHERE drive_id = ? AND deleted_by != '';`
+	FindDirTombstonesQuery       string = `SELECT id, deleted_by, deleted_at FROM Directories WHERE drive_id = ? AND deleted_by != '';`
+	FindDriveDevicesQuery        string = `SELECT id, last_sync FROM Devices WHERE drive_id = ?;`
 
 	// ---------- SELECT statements for confirming existance -------------------
 
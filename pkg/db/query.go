@@ -33,7 +33,7 @@ func NewQuery(dbPath string, isSingleton bool) *Query {
 		Debug:     false,
 		log:       logger.NewLogger("Database", "None"),
 		Singleton: isSingleton,
-		DBs:       []string{"users", "drives", "directories", "files"},
+		DBs:       []string{"users", "drives", "directories", "files", "versions", "recycled", "bases", "conflicts", "devices", "blobs", "snapshots", "transfers", "uploads"},
 	}
 }
 
@@ -24,6 +24,24 @@ func (q *Query) getTable(dbName string) string {
 		return "Directories"
 	case "files":
 		return "Files"
+	case "versions":
+		return "Versions"
+	case "blobs":
+		return "Blobs"
+	case "recycled":
+		return "RecycleBin"
+	case "snapshots":
+		return "Snapshots"
+	case "bases":
+		return "SyncBases"
+	case "conflicts":
+		return "Conflicts"
+	case "transfers":
+		return "Transfers"
+	case "uploads":
+		return "Uploads"
+	case "devices":
+		return "Devices"
 	}
 	return ""
 }
@@ -44,6 +62,33 @@ func (q *Query) getResetQueries(tableName string) (string, string) {
 	case "Files":
 		dropQuery = DropFilesTableQuery
 		createQuery = CreateFileTable
+	case "Versions":
+		dropQuery = DropVersionsTableQuery
+		createQuery = CreateVersionTable
+	case "Blobs":
+		dropQuery = DropBlobsTableQuery
+		createQuery = CreateBlobTable
+	case "RecycleBin":
+		dropQuery = DropRecycleBinTableQuery
+		createQuery = CreateRecycleBinTable
+	case "Snapshots":
+		dropQuery = DropSnapshotsTableQuery
+		createQuery = CreateSnapshotTable
+	case "SyncBases":
+		dropQuery = DropSyncBasesTableQuery
+		createQuery = CreateSyncBaseTable
+	case "Conflicts":
+		dropQuery = DropConflictsTableQuery
+		createQuery = CreateConflictTable
+	case "Transfers":
+		dropQuery = DropTransfersTableQuery
+		createQuery = CreateTransferTable
+	case "Uploads":
+		dropQuery = DropUploadsTableQuery
+		createQuery = CreateUploadTable
+	case "Devices":
+		dropQuery = DropDevicesTableQuery
+		createQuery = CreateDeviceTable
 	default:
 		log.Fatalf("unsupported table name: %s", tableName)
 	}
@@ -66,6 +111,24 @@ func (q *Query) DropTable(dbName string) error {
 		query = DropDirectoriesTableQuery
 	case "files":
 		query = DropFilesTableQuery
+	case "versions":
+		query = DropVersionsTableQuery
+	case "blobs":
+		query = DropBlobsTableQuery
+	case "recycled":
+		query = DropRecycleBinTableQuery
+	case "snapshots":
+		query = DropSnapshotsTableQuery
+	case "bases":
+		query = DropSyncBasesTableQuery
+	case "conflicts":
+		query = DropConflictsTableQuery
+	case "transfers":
+		query = DropTransfersTableQuery
+	case "uploads":
+		query = DropUploadsTableQuery
+	case "devices":
+		query = DropDevicesTableQuery
 	}
 	_, err := q.Conn.Exec(query)
 	if err != nil {
@@ -202,3 +265,122 @@ func (q *Query) ClearTable(dbName string) error {
 	}
 	return nil
 }
+
+func (q *Query) RemoveVersion(versionID string) error {
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveVersionQuery, versionID, versionID)
+	if err != nil {
+		return fmt.Errorf("failed to remove version (id=%s): %v", versionID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveBlob(checksum string) error {
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveBlobQuery, checksum, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to remove blob (checksum=%s): %v", checksum, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveRecycled(itemID string) error {
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveRecycledQuery, itemID, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to remove recycled item (id=%s): %v", itemID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveSnapshot(snapshotID string) error {
+	q.WhichDB("snapshots")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveSnapshotQuery, snapshotID, snapshotID)
+	if err != nil {
+		return fmt.Errorf("failed to remove snapshot (id=%s): %v", snapshotID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveSyncBase(fileID string) error {
+	q.WhichDB("bases")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveSyncBaseQuery, fileID, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to remove sync base for file (id=%s): %v", fileID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveConflict(conflictID string) error {
+	q.WhichDB("conflicts")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveConflictQuery, conflictID, conflictID)
+	if err != nil {
+		return fmt.Errorf("failed to remove conflict (id=%s): %v", conflictID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveTransfer(transferID string) error {
+	q.WhichDB("transfers")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveTransferQuery, transferID, transferID)
+	if err != nil {
+		return fmt.Errorf("failed to remove transfer (id=%s): %v", transferID, err)
+	}
+	return nil
+}
+
+// remove all finished transfers from the transfer queue
+func (q *Query) ClearDoneTransfers() error {
+	q.WhichDB("transfers")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(RemoveDoneTransfersQuery); err != nil {
+		return fmt.Errorf("failed to clear finished transfers: %v", err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveUpload(uploadID string) error {
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(RemoveUploadQuery, uploadID); err != nil {
+		return fmt.Errorf("failed to remove upload (id=%s): %v", uploadID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveDevice(driveID string, deviceID string) error {
+	q.WhichDB("devices")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveDeviceQuery, deviceID, driveID)
+	if err != nil {
+		return fmt.Errorf("failed to remove device (id=%s): %v", deviceID, err)
+	}
+	return nil
+}
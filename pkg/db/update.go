@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 	svc "github.com/sfs/pkg/service"
@@ -35,6 +36,10 @@ func (q *Query) UpdateFile(f *svc.File) error {
 		&f.Endpoint,
 		&f.CheckSum,
 		&f.Algorithm,
+		&f.Version,
+		&f.DeletedBy,
+		&f.DeletedAt,
+		&f.Blob,
 		&f.ID,
 	); err != nil {
 		return fmt.Errorf("failed to execute statement: %v", err)
@@ -69,6 +74,10 @@ func (q *Query) UpdateDir(d *svc.Directory) error {
 		&d.Endpoint,
 		&d.Root,
 		&d.RootPath,
+		&d.DeletedBy,
+		&d.DeletedAt,
+		&d.ParentID,
+		&d.Version,
 		&d.ID,
 	); err != nil {
 		return fmt.Errorf("failed to add directory: %v", err)
@@ -101,6 +110,13 @@ func (q *Query) UpdateDrive(drv *svc.Drive) error {
 		&drv.RootID,
 		&drv.Registered,
 		&drv.RecycleBin,
+		&drv.MaxVersions,
+		&drv.VersionMaxAge,
+		&drv.TrashRetention,
+		&drv.Algorithm,
+		&drv.E2EE,
+		&drv.SnapshotInterval,
+		&drv.MaxSnapshots,
 		&drv.ID,
 	); err != nil {
 		return fmt.Errorf("failed to execute query: %v", err)
@@ -137,3 +153,70 @@ func (q *Query) UpdateUser(u *auth.User) error {
 	}
 	return nil
 }
+
+// mark a file as deleted. the file's entry is kept as a tombstone
+// so the deletion can be synced with other devices.
+func (q *Query) TombstoneFile(fileID string, deviceID string, deletedAt time.Time) error {
+	q.WhichDB("files")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(TombstoneFileQuery, deviceID, deletedAt, fileID); err != nil {
+		return fmt.Errorf("failed to add tombstone for file (id=%s): %v", fileID, err)
+	}
+	return nil
+}
+
+// mark a directory as deleted. the directory's entry is kept as a tombstone
+// so the deletion can be synced with other devices.
+func (q *Query) TombstoneDir(dirID string, deviceID string, deletedAt time.Time) error {
+	q.WhichDB("directories")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(TombstoneDirQuery, deviceID, deletedAt, dirID); err != nil {
+		return fmt.Errorf("failed to add tombstone for directory (id=%s): %v", dirID, err)
+	}
+	return nil
+}
+
+// add n references to a blob. n can be negative to release references.
+func (q *Query) UpdateBlobRefs(checksum string, n int) error {
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(UpdateBlobRefsQuery, n, checksum); err != nil {
+		return fmt.Errorf("failed to update references for blob (checksum=%s): %v", checksum, err)
+	}
+	return nil
+}
+
+// record which parts of an upload have been received
+func (q *Query) UpdateUpload(u *svc.UploadSession) error {
+	received, err := u.ReceivedStr()
+	if err != nil {
+		return fmt.Errorf("failed to encode received parts: %v", err)
+	}
+
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(UpdateUploadQuery, received, u.ExpiresAt, u.ID); err != nil {
+		return fmt.Errorf("failed to update upload (id=%s): %v", u.ID, err)
+	}
+	return nil
+}
+
+// record a queued transfer's progress
+func (q *Query) UpdateTransfer(t *svc.TransferItem) error {
+	q.WhichDB("transfers")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(UpdateTransferQuery, t.State, t.Attempts, t.LastError, t.UpdatedAt, t.ID); err != nil {
+		return fmt.Errorf("failed to update transfer (id=%s): %v", t.ID, err)
+	}
+	return nil
+}
@@ -65,6 +65,8 @@ func TestCreateAndUpdateADirectory(t *testing.T) {
 
 	// update the directory
 	tmpDir.Name = "pron"
+	tmpDir.ParentID = "some-parent-id"
+	tmpDir.IncrementVersion("laptop")
 
 	if err := q.UpdateDir(tmpDir); err != nil {
 		Fatal(t, fmt.Errorf("failed to update directory: %v", err))
@@ -76,6 +78,8 @@ func TestCreateAndUpdateADirectory(t *testing.T) {
 	}
 
 	assert.Equal(t, tmpDir.Name, d.Name)
+	assert.Equal(t, tmpDir.ParentID, d.ParentID)
+	assert.Equal(t, tmpDir.Version, d.Version)
 
 	if err := Clean(t, GetTestingDir()); err != nil {
 		t.Fatal(err)
@@ -25,16 +25,20 @@ var BaseEnv = map[string]string{
 	"JWT_SECRET":        "default",
 	"NEW_SERVICE":       "true",
 	// client settings
-	"CLIENT":             "",
-	"CLIENT_ADDRESS":     "",
-	"CLIENT_EMAIL":       "",
-	"CLIENT_ID":          "",
-	"CLIENT_NEW_SERVICE": "true",
-	"CLIENT_PASSWORD":    "default",
-	"CLIENT_PORT":        "8080",
-	"CLIENT_ROOT":        "",
-	"CLIENT_TESTING":     "",
-	"CLIENT_USERNAME":    "",
+	"CLIENT":                  "",
+	"CLIENT_ADDRESS":          "",
+	"CLIENT_E2EE_PASSPHRASE":  "",
+	"CLIENT_EMAIL":            "",
+	"CLIENT_ID":               "",
+	"CLIENT_IGNORE":           ".git/;node_modules/;*.swp;*.swo;*~;.DS_Store",
+	"CLIENT_NEW_SERVICE":      "true",
+	"CLIENT_PASSWORD":         "default",
+	"CLIENT_PINNED":           "",
+	"CLIENT_PORT":             "8080",
+	"CLIENT_ROOT":             "",
+	"CLIENT_TESTING":          "",
+	"CLIENT_TRANSFER_WORKERS": "4",
+	"CLIENT_USERNAME":         "",
 	// server settings
 	"SERVER_ADDR":          "localhost:8080",
 	"SERVER_ADMIN":         "admin",
@@ -44,8 +48,15 @@ var BaseEnv = map[string]string{
 	"SERVER_TIMEOUT_READ":  "5s",
 	"SERVER_TIMEOUT_WRITE": "10s",
 	// service settings
-	"SERVICE_ROOT":      "",
-	"SERVICE_TEST_ROOT": "",
+	"SERVICE_MAX_DECODED_SIZE": "1073741824",
+	"SERVICE_ROOT":             "",
+	"SERVICE_S3_ACCESS_KEY":    "",
+	"SERVICE_S3_BUCKET":        "",
+	"SERVICE_S3_ENDPOINT":      "",
+	"SERVICE_S3_REGION":        "us-east-1",
+	"SERVICE_S3_SECRET_KEY":    "",
+	"SERVICE_STORAGE":          "local",
+	"SERVICE_TEST_ROOT":        "",
 }
 
 // new env object.
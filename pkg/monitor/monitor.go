@@ -53,6 +53,10 @@ type Monitor struct {
 	//
 	// key = item path, val is chan bool
 	OffSwitches map[string]chan bool
+
+	// optional filter for items that shouldn't be monitored.
+	// returns true if the item should be skipped.
+	Ignore func(path string, isDir bool) bool
 }
 
 func NewMonitor(drvRoot string) *Monitor {
@@ -65,6 +69,11 @@ func NewMonitor(drvRoot string) *Monitor {
 	}
 }
 
+// whether an item should be skipped
+func (m *Monitor) ignored(path string, isDir bool) bool {
+	return m.Ignore != nil && m.Ignore(path, isDir)
+}
+
 // see if an event channel exists for a given filepath.
 func (m *Monitor) IsMonitored(path string) bool {
 	if _, exists := m.Watchers[path]; exists {
@@ -120,7 +129,7 @@ func (m *Monitor) StartWatcher(path string, stop chan bool) {
 
 // add a file or directory to the events map and create a new monitoring
 // goroutine. will need a corresponding events handler on the client end.
-// will be a no-op if the given path is already being monitored.
+// will be a no-op if the given path is already being monitored or is ignored.
 func (m *Monitor) Watch(path string) error {
 	// make sure this item actually exists
 	if !m.Exists(path) {
@@ -131,6 +140,9 @@ func (m *Monitor) Watch(path string) error {
 		if err != nil {
 			return err
 		}
+		if m.ignored(path, isdir) {
+			return nil
+		}
 		// NOTE: monitoring directories is too expensive for the time being.
 		// os.ReadDir() took a lot of CPU, especially when
 		// called in a frequent operation loop. for now we're sticking
@@ -147,6 +159,34 @@ func (m *Monitor) Watch(path string) error {
 	return nil
 }
 
+// watch a directory for items being added or removed. directories aren't
+// picked up by Watch() since polling every directory under the root is too
+// expensive, so only directories explicitly registered with sfs are watched.
+// will be a no-op if the given path is already being monitored or is ignored.
+func (m *Monitor) WatchDir(path string) error {
+	if !m.Exists(path) {
+		return fmt.Errorf("%s does not exist", filepath.Base(path))
+	}
+	isdir, err := m.IsDir(path)
+	if err != nil {
+		return err
+	}
+	if !isdir {
+		return fmt.Errorf("%s is not a directory", filepath.Base(path))
+	}
+	if m.ignored(path, true) {
+		return nil
+	}
+	if !m.IsMonitored(path) {
+		stop := make(chan bool)
+		m.OffSwitches[path] = stop
+		m.AddWatcher(path, watchDir)
+		m.StartWatcher(path, stop)
+		m.log.Log("INFO", fmt.Sprintf("monitoring %s...", filepath.Base(path)))
+	}
+	return nil
+}
+
 // get an event listener channel for a given file
 func (m *Monitor) GetEventChan(path string) chan Event {
 	if evtChan, exists := m.Events[path]; exists {
@@ -330,8 +370,8 @@ func watchFile(filePath string, stop chan bool) chan Event {
 	return evt
 }
 
-// NOTE: no longer used, but kept for reference.
-// watch for changes in a directory
+// watch for items being added to or removed from a directory.
+// the directory is polled every WAIT interval.
 func watchDir(dirPath string, stop chan bool) chan Event {
 	var log = logger.NewLogger("DIR_WATCHER", auth.NewUUID())
 
@@ -363,13 +403,8 @@ func watchDir(dirPath string, stop chan bool) chan Event {
 				return
 			default:
 				currItems, err := os.ReadDir(dirPath)
-				if err != nil {
-					log.Error(fmt.Sprintf("failed to read directory: %v", err))
-					return
-				}
-				switch {
 				// directory was deleted
-				case err == os.ErrExist:
+				if errors.Is(err, os.ErrNotExist) {
 					log.Log("INFO", fmt.Sprintf("%s was deleted", dirName))
 					evt <- Event{
 						Kind: "Directory",
@@ -379,6 +414,11 @@ func watchDir(dirPath string, stop chan bool) chan Event {
 					}
 					close(evt)
 					return
+				} else if err != nil {
+					log.Error(fmt.Sprintf("failed to read directory: %v", err))
+					return
+				}
+				switch {
 				// item(s) were deleted
 				case len(currItems) < len(initialItems):
 					log.Log("INFO", fmt.Sprintf("%d items were deleted in %s", len(currItems)-len(initialItems), dirName))
@@ -405,6 +445,7 @@ func watchDir(dirPath string, stop chan bool) chan Event {
 					initialItems = currItems
 				}
 				// TODO: other directory changes?
+				time.Sleep(WAIT)
 			}
 		}
 	}
@@ -418,13 +459,20 @@ func watchDir(dirPath string, stop chan bool) chan Event {
 }
 
 // add all files and directories under the given path
-// (assumed to be a root directory) to the monitoring instance
+// (assumed to be a root directory) to the monitoring instance.
+// ignored directories are skipped along with everything under them.
 func watchAll(path string, m *Monitor) error {
 	m.log.Info(fmt.Sprintf("adding watchers for all files under %s ...", path))
 	err := filepath.Walk(path, func(itemPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if itemPath != path && m.ignored(itemPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if err := m.Watch(itemPath); err != nil {
 			return err
 		}
@@ -2,18 +2,21 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/storage"
 	"github.com/sfs/pkg/transfer"
 )
 
@@ -37,6 +40,13 @@ func NewAPI(newService bool, isAdmin bool) *API {
 	if err != nil {
 		log.Fatalf("failed to initialize new service instance: %v", err)
 	}
+	// clean out expired recycle bin items in the background
+	go svc.RunPurge(PurgeInterval)
+	// and take any scheduled snapshots
+	go svc.RunSnapshots(SnapshotCheckInterval)
+	// and remove abandoned upload sessions
+	go svc.RunUploadCleanup(UploadCheckInterval)
+
 	return &API{
 		StartTime: time.Now().UTC(),
 		Svc:       svc,
@@ -77,6 +87,63 @@ func (a *API) serverError(w http.ResponseWriter, err string) {
 	http.Error(w, err, http.StatusInternalServerError)
 }
 
+// sends a 413 if a file is too large to ever fit in a drive, or a 507 if the
+// drive doesn't have enough free space left for it. returns false if err isn't
+// a quota error so the caller can handle it.
+func (a *API) quotaError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, svc.ErrFileTooLarge):
+		a.log.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, svc.ErrQuotaExceeded):
+		a.log.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		return false
+	}
+	return true
+}
+
+// sends a 413 if a request body was cut off for being too large (see
+// DecodeContent). returns false for anything else.
+func (a *API) tooLargeError(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	msg := fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit)
+	a.log.Warn(msg)
+	http.Error(w, msg, http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// sends a 423 if a file is locked, or a 403 if the password sent for it
+// was wrong. returns false if err isn't either so the caller can handle it.
+func (a *API) lockError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, svc.ErrLocked):
+		a.log.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusLocked)
+	case errors.Is(err, svc.ErrWrongPassword):
+		a.log.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		return false
+	}
+	return true
+}
+
+// sends a 400 if a request needs the server to read or write the plaintext
+// of an end-to-end encrypted drive. returns false if err isn't an E2EE error.
+func (a *API) e2eeError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, ErrE2EE) {
+		return false
+	}
+	a.log.Warn(err.Error())
+	http.Error(w, err.Error(), http.StatusBadRequest)
+	return true
+}
+
 // -------- users (admin only) -----------------------------------------
 
 // add a new user and drive to sfs instance. user existance and
@@ -130,6 +197,22 @@ func (a *API) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	a.write(w, "user updated")
 }
 
+// set the storage quota for a user's drive.
+// expects a "size" query parameter with the new quota in bytes.
+func (a *API) SetQuota(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(User).(*auth.User)
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil || size <= 0 {
+		a.clientError(w, fmt.Sprintf("invalid quota size: %q", r.URL.Query().Get("size")))
+		return
+	}
+	if err := a.Svc.SetQuota(user.ID, size); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("quota for user (name=%s id=%s) set to %d bytes", user.Name, user.ID, size))
+}
+
 // remove a user from the server
 func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	user := r.Context().Value(User).(*auth.User)
@@ -153,19 +236,118 @@ func (a *API) GetFileInfo(w http.ResponseWriter, r *http.Request) {
 	a.write(w, string(data))
 }
 
-// retrieve a file from the server
+// retrieve a file from the server. supports Range requests, so clients can
+// pick up interrupted downloads. the file's checksum is sent as its ETag, so
+// If-Range only resumes a download if the contents haven't changed since.
 func (a *API) ServeFile(w http.ResponseWriter, r *http.Request) {
 	file := r.Context().Value(File).(*svc.File)
 
 	// set the response header for the download
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.Name))
 	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if file.CheckSum != "" && !svc.IsLegacyChecksum(file.CheckSum) {
+		w.Header().Set("ETag", strconv.Quote(file.CheckSum))
+	}
 
-	// send the file
-	http.ServeFile(w, r, file.ServerPath)
+	// send the file. locked files are decrypted if the client sent their password
+	f, err := a.Svc.OpenFile(file, r.Header.Get(PasswordHeader))
+	if errors.Is(err, storage.ErrNotExist) {
+		a.notFoundError(w, fmt.Sprintf("contents of %s (id=%s) not found", file.Name, file.ID))
+		return
+	} else if a.lockError(w, err) {
+		return
+	} else if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to open %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	defer f.Close()
+	if enc := a.contentEncoding(r, file, f); enc != "" {
+		a.serveEncoded(w, r, file, f, enc)
+		return
+	}
+	http.ServeContent(w, r, file.Name, file.LastSync, f)
 	a.log.Info(fmt.Sprintf("served file %s: %s", file.Name, file.ServerPath))
 }
 
+// pick an encoding to send a file's contents with, if the client accepts one
+// and they're worth compressing. ranges are always sent as they are, as are
+// the contents of end-to-end encrypted drives.
+func (a *API) contentEncoding(r *http.Request, file *svc.File, f storage.File) string {
+	if r.Header.Get("Range") != "" {
+		return ""
+	}
+	enc := transfer.NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if enc == "" {
+		return ""
+	}
+	if e2ee, err := a.Svc.isE2EE(file); err != nil || e2ee {
+		return ""
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if _, serr := f.Seek(0, io.SeekStart); err != nil || serr != nil || size < transfer.CompressMinSize {
+		return ""
+	}
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return ""
+	}
+	if !transfer.Compressible(file.Name, head[:n]) {
+		return ""
+	}
+	return enc
+}
+
+// send a file's contents compressed on the fly
+func (a *API) serveEncoded(w http.ResponseWriter, r *http.Request, file *svc.File, f storage.File, enc string) {
+	w.Header().Set("Content-Encoding", enc)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	cw := &transfer.CountingWriter{W: w}
+	zw, err := transfer.NewEncoder(cw, enc)
+	if err != nil {
+		a.log.Error(fmt.Sprintf("failed to compress %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	n, err := io.Copy(zw, f)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// too late to tell the client. they'll see a broken stream
+		a.log.Error(fmt.Sprintf("failed to send %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	a.log.Info(fmt.Sprintf("served file %s: %s (%s)", file.Name, file.ServerPath, transfer.Savings(enc, n, cw.N)))
+}
+
+// check whether a drive already has contents with a given checksum, so a
+// client can skip uploading them. expects the drive's id in ?drive=. sends
+// a 404 if none of the drive's files have them, whether or not other drives do.
+func (a *API) GetBlob(w http.ResponseWriter, r *http.Request) {
+	checksum := chi.URLParam(r, "checksum")
+	blob, err := a.Svc.GetDriveBlob(r.URL.Query().Get("drive"), checksum)
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to find blob %s: %v", checksum, err))
+		return
+	}
+	if blob == nil {
+		a.notFoundError(w, fmt.Sprintf("blob %s not found", checksum))
+		return
+	}
+	// where the blob lives and who refers to it are none of the client's business
+	data, err := json.Marshal(map[string]interface{}{"checksum": blob.CheckSum, "size": blob.Size})
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
 // get json blobs of all files available on the server for a user.
 // only sends metadata, not the actual files.
 func (a *API) GetAllFileInfo(w http.ResponseWriter, r *http.Request) {
@@ -180,44 +362,163 @@ func (a *API) GetAllFileInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// add initial file metadata to the server. creates an empty files,
-// does not create file contents, though svc.AddFile() does attempt
-// to write out the data. This will be remidied in a future version.
+// add a new file to the server. file contents are taken from the uploaded
+// form file, or from an existing blob if the client sent a checksum (?blob=)
+// instead. requests with neither create the file from its metadata alone.
 func (a *API) newFile(w http.ResponseWriter, r *http.Request, newFile *svc.File) {
+	if r.URL.Query().Get("blob") != "" || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, err := a.readUpload(r, newFile, true)
+		if err != nil {
+			a.uploadError(w, err)
+			return
+		}
+		newFile.Content = data
+	}
 	if err := a.Svc.AddFile(newFile.DirID, newFile); err != nil {
+		if a.quotaError(w, err) || a.e2eeError(w, err) {
+			return
+		}
 		a.serverError(w, fmt.Sprintf("failed to add %s to service: %v", newFile.Name, err))
 		return
 	}
 	a.write(w, fmt.Sprintf("file (%s) has been added to the server", newFile.Name))
 }
 
-// update the file on the server
-func (a *API) putFile(w http.ResponseWriter, r *http.Request, file *svc.File) {
-	f, _, err := r.FormFile("myFile")
+// get the contents of an uploaded file. clients that know the drive already
+// has the contents send their checksum (?blob=) instead of the data itself.
+//
+// the form file is read straight from the request body, and reading stops
+// with a quota error once it's larger than the file's drive has room for,
+// so uploads that won't fit are turned away without being buffered first.
+// isNew is set for files that aren't on the drive yet.
+func (a *API) readUpload(r *http.Request, file *svc.File, isNew bool) ([]byte, error) {
+	if checksum := r.URL.Query().Get("blob"); checksum != "" {
+		return a.Svc.ReadDriveBlob(file.DriveID, checksum)
+	}
+	mr, err := r.MultipartReader()
 	if err != nil {
-		a.serverError(w, "failed to retrieve form file: "+err.Error())
+		return nil, fmt.Errorf("failed to retrieve form file: %w", err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("failed to retrieve form file: %w", http.ErrMissingFile)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to retrieve form file: %w", err)
+		}
+		// parts aren't closed, since closing one reads the rest of it
+		if part.FormName() != "myFile" {
+			continue
+		}
+		f, err := a.Svc.limitUpload(file, isNew, part)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, f); err != nil {
+			return nil, fmt.Errorf("failed to copy file: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+}
+
+// sends a 404 if the blob a client asked for is gone, so it
+// can fall back to uploading the contents, a 413 or 507 if the
+// upload was too large, otherwise a 500.
+func (a *API) uploadError(w http.ResponseWriter, err error) {
+	if a.tooLargeError(w, err) || a.quotaError(w, err) {
 		return
 	}
+	if errors.Is(err, ErrBlobNotFound) {
+		a.notFoundError(w, err.Error())
+		return
+	}
+	a.serverError(w, err.Error())
+}
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, f)
+// update the file on the server
+func (a *API) putFile(w http.ResponseWriter, r *http.Request, file *svc.File) {
+	data, err := a.readUpload(r, file, false)
 	if err != nil {
-		a.serverError(w, "failed to copy file: "+err.Error())
+		a.uploadError(w, err)
 		return
 	}
-	if err := f.Close(); err != nil {
-		a.serverError(w, "failed to close form file: "+err.Error())
+
+	// make sure the client isn't overwriting changes it hasn't seen
+	if err := a.Svc.AcceptVersion(file, clientVersion(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
-	// update file
-	if err := a.Svc.UpdateFile(file, buf.Bytes()); err != nil {
+	// update file. clients of end-to-end encrypted drives
+	// send the checksum for the contents along with them.
+	var checksum string
+	if f := clientFile(r); f != nil {
+		checksum = f.CheckSum
+	}
+	if err := a.Svc.updateFile(file, data, checksum); err != nil {
+		if a.quotaError(w, err) || a.lockError(w, err) || a.e2eeError(w, err) {
+			return
+		}
 		a.serverError(w, fmt.Sprintf("failed to update %s (id=%s): %v", file.Name, file.ID, err))
 		return
 	}
 	a.write(w, fmt.Sprintf("file (%s) updated (owner id=%s)", file.Name, file.OwnerID))
 }
 
+// get the clients copy of a file from the request token.
+// returns nil if the token doesn't have one.
+func clientFile(r *http.Request) *svc.File {
+	fileInfo, err := auth.NewT().Validate(r)
+	if err != nil {
+		return nil
+	}
+	f, err := svc.UnmarshalFileStr(fileInfo)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// get the version vector of the clients copy of a file from the request token.
+// returns nil if the client didn't send one (i.e. older clients).
+func clientVersion(r *http.Request) svc.VersionVector {
+	if f := clientFile(r); f != nil {
+		return f.Version
+	}
+	return nil
+}
+
+// get the clients copy of a directory from the request token.
+// returns nil if the token doesn't have one.
+func clientDir(r *http.Request) *svc.Directory {
+	dirInfo, err := auth.NewT().Validate(r)
+	if err != nil {
+		return nil
+	}
+	d, err := svc.UnmarshalDirStr(dirInfo)
+	if err != nil || d.ID == "" || d.Name == "" {
+		return nil
+	}
+	return d
+}
+
+// get the device a file or directory was deleted on from the request token.
+// falls back to the server if the client didn't send one (i.e. older clients).
+func deletingDevice(r *http.Request) string {
+	info, err := auth.NewT().Validate(r)
+	if err != nil {
+		return ServerDeviceID
+	}
+	var item struct {
+		DeletedBy string `json:"deleted_by"`
+	}
+	if err := json.Unmarshal([]byte(info), &item); err != nil || item.DeletedBy == "" {
+		return ServerDeviceID
+	}
+	return item.DeletedBy
+}
+
 // upload or update a file on/to the server
 func (a *API) PutFile(w http.ResponseWriter, r *http.Request) {
 	f := r.Context().Value(File).(*svc.File)
@@ -231,13 +532,288 @@ func (a *API) PutFile(w http.ResponseWriter, r *http.Request) {
 // delete a file from the server
 func (a *API) DeleteFile(w http.ResponseWriter, r *http.Request) {
 	file := r.Context().Value(File).(*svc.File)
-	if err := a.Svc.DeleteFile(file); err != nil {
+	if err := a.Svc.DeleteFile(file, deletingDevice(r)); err != nil {
 		a.serverError(w, "failed to delete file: "+err.Error())
 		return
 	}
 	a.write(w, fmt.Sprintf("%s (id=%s) deleted from server", file.Name, file.ID))
 }
 
+// send a block signature of the servers copy of a file so the
+// client can build a delta against it.
+func (a *API) GetFileSignature(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	sig, err := a.Svc.FileSignature(file)
+	if a.lockError(w, err) || a.e2eeError(w, err) {
+		return
+	} else if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to generate signature for %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	data, err := sig.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// update a file on the server using a delta built against
+// the signature from GetFileSignature()
+func (a *API) PutFileDelta(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r.Body); err != nil {
+		if !a.tooLargeError(w, err) {
+			a.serverError(w, "failed to read request body: "+err.Error())
+		}
+		return
+	}
+	delta, err := transfer.UnmarshalDelta(buf.Bytes())
+	if err != nil {
+		a.clientError(w, "failed to decode delta: "+err.Error())
+		return
+	}
+	if delta.FileID != file.ID {
+		a.clientError(w, fmt.Sprintf("delta file id (%s) does not match file id (%s)", delta.FileID, file.ID))
+		return
+	}
+	if err := a.Svc.AcceptVersion(file, clientVersion(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err := a.Svc.ApplyFileDelta(file, delta); err != nil {
+		if a.quotaError(w, err) || a.lockError(w, err) || a.e2eeError(w, err) {
+			return
+		}
+		if errors.Is(err, transfer.ErrChecksumMismatch) || errors.Is(err, transfer.ErrBaseTooShort) {
+			// base version changed since the signature was generated
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to update %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	a.write(w, fmt.Sprintf("file (%s) updated (owner id=%s)", file.Name, file.OwnerID))
+}
+
+// build a delta of the servers copy of a file against a
+// signature of the clients copy and send it back.
+func (a *API) GetFileDelta(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r.Body); err != nil {
+		if !a.tooLargeError(w, err) {
+			a.serverError(w, "failed to read request body: "+err.Error())
+		}
+		return
+	}
+	sig, err := transfer.UnmarshalSignature(buf.Bytes())
+	if err != nil {
+		a.clientError(w, "failed to decode signature: "+err.Error())
+		return
+	}
+	delta, err := a.Svc.FileDelta(file, sig)
+	if errors.Is(err, storage.ErrNotExist) {
+		a.notFoundError(w, fmt.Sprintf("contents of %s (id=%s) not found", file.Name, file.ID))
+		return
+	} else if a.lockError(w, err) || a.e2eeError(w, err) {
+		return
+	} else if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to build delta for %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	delta.FileID = file.ID
+	data, err := delta.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	a.log.Info(fmt.Sprintf("sending delta for %s: %d of %d bytes changed", file.Name, delta.DataSize(), delta.Size))
+	w.Write(data)
+}
+
+// send a list of all saved versions of a file
+func (a *API) GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	versions, err := a.Svc.GetVersions(file)
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to get versions for %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// restore a file to a previous version
+func (a *API) RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	rev, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	if err != nil {
+		a.clientError(w, fmt.Sprintf("invalid revision number: %v", chi.URLParam(r, "rev")))
+		return
+	}
+	if err := a.Svc.RestoreVersion(file, rev); err != nil {
+		if errors.Is(err, ErrVersionNotFound) {
+			a.notFoundError(w, err.Error())
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to restore %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	a.write(w, fmt.Sprintf("%s (id=%s) restored to version %d", file.Name, file.ID, rev))
+}
+
+// -------- upload sessions -----------------------------------------
+
+// sends a 404 for missing or expired sessions, a 409 for sessions that
+// aren't ready to be committed, and a 422 for uploads that don't match
+// their checksum. returns false for anything else.
+func (a *API) uploadSessionError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		a.notFoundError(w, err.Error())
+	case errors.Is(err, ErrUploadIncomplete):
+		a.log.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrUploadChecksum):
+		a.log.Warn(err.Error())
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		return false
+	}
+	return true
+}
+
+func (a *API) writeUpload(w http.ResponseWriter, u *svc.UploadSession) {
+	data, err := u.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// open an upload session for a file. expects "size" and "checksum" query
+// parameters for everything that will be uploaded. sends the new session.
+func (a *API) NewUpload(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil {
+		a.clientError(w, fmt.Sprintf("invalid upload size: %q", r.URL.Query().Get("size")))
+		return
+	}
+	u, err := a.Svc.NewUpload(file, size, r.URL.Query().Get("checksum"))
+	if err != nil {
+		if a.quotaError(w, err) || a.lockError(w, err) {
+			return
+		}
+		if errors.Is(err, ErrUploadInvalid) {
+			a.clientError(w, err.Error())
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to open upload for %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	a.writeUpload(w, u)
+}
+
+// send the open upload sessions for a file
+func (a *API) GetUploads(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	uploads, err := a.Svc.GetUploads(file)
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to get uploads for %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	data, err := json.MarshalIndent(uploads, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// send an upload session, including which parts have been received
+func (a *API) GetUpload(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	u, err := a.Svc.GetUpload(file, chi.URLParam(r, "uploadID"))
+	if a.uploadSessionError(w, err) {
+		return
+	} else if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.writeUpload(w, u)
+}
+
+// write a part of an upload. the request body is written at the
+// "offset" query parameter. sends the updated session.
+func (a *API) PutUploadPart(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		a.clientError(w, fmt.Sprintf("invalid offset: %q", r.URL.Query().Get("offset")))
+		return
+	}
+	u, err := a.Svc.WriteUpload(file, chi.URLParam(r, "uploadID"), offset, r.Body)
+	if a.uploadSessionError(w, err) || a.tooLargeError(w, err) {
+		return
+	} else if err != nil {
+		if errors.Is(err, ErrUploadRange) {
+			a.clientError(w, err.Error())
+			return
+		}
+		a.serverError(w, err.Error())
+		return
+	}
+	a.writeUpload(w, u)
+}
+
+// replace a file's contents with a finished upload
+func (a *API) CommitUpload(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+
+	// make sure the client isn't overwriting changes it hasn't seen
+	if err := a.Svc.AcceptVersion(file, clientVersion(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	var checksum string
+	if f := clientFile(r); f != nil {
+		checksum = f.CheckSum
+	}
+	err := a.Svc.CommitUpload(file, chi.URLParam(r, "uploadID"), checksum)
+	if err != nil {
+		if a.uploadSessionError(w, err) || a.quotaError(w, err) || a.lockError(w, err) || a.e2eeError(w, err) {
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to update %s (id=%s): %v", file.Name, file.ID, err))
+		return
+	}
+	a.write(w, fmt.Sprintf("file (%s) updated (owner id=%s)", file.Name, file.OwnerID))
+}
+
+// cancel an upload session
+func (a *API) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	file := r.Context().Value(File).(*svc.File)
+	uploadID := chi.URLParam(r, "uploadID")
+	if err := a.Svc.AbortUpload(file, uploadID); err != nil {
+		if a.uploadSessionError(w, err) {
+			return
+		}
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("upload (id=%s) canceled", uploadID))
+}
+
 // ------- directories --------------------------------
 
 // temp for testing
@@ -304,29 +880,43 @@ func (a *API) GetManyDirsInfo(w http.ResponseWriter, r *http.Request) {
 	a.walkDir(w, dir)
 }
 
-// retrieve a zipfile of the directory (and all its children)
+// retrieve an archive of the directory (and all its children).
+// the format is set with ?format=zip|tar|tar.gz|tar.zst, and defaults to zip.
 func (a *API) GetDir(w http.ResponseWriter, r *http.Request) {
 	dir := r.Context().Value(Directory).(*svc.Directory)
-
-	// create a tmp .zip file so we can transfer the directory and its contents
-	archive := filepath.Join(dir.Path, dir.Name+".zip")
-	if err := transfer.Zip(dir.Path, archive); err != nil {
-		a.serverError(w, fmt.Sprintf("failed to compress directory: %v", err))
+	format, ok := a.archiveFormat(w, r)
+	if !ok {
 		return
 	}
-
-	// send archive file
-	http.ServeFile(w, r, archive)
-
-	// remove tmp archive file
-	if err := os.Remove(archive); err != nil {
-		a.log.Error(fmt.Sprintf("failed to remove temp archive %s: %v", archive, err))
+	arc, err := a.Svc.NewDirArchive(dir, r.Header.Get(PasswordHeader))
+	if err != nil {
+		if !a.archiveError(w, err) {
+			a.serverError(w, fmt.Sprintf("failed to archive %s (id=%s): %v", dir.Name, dir.ID, err))
+		}
+		return
 	}
+	a.serveArchive(w, arc, format)
 }
 
 // update the directory on the server
 func (a *API) PutDir(w http.ResponseWriter, r *http.Request) {
 	dir := r.Context().Value(Directory).(*svc.Directory)
+	// apply renames and moves sent by the client
+	if upd := clientDir(r); upd != nil {
+		// make sure the client isn't undoing changes it hasn't seen
+		if err := a.Svc.AcceptDirVersion(dir, upd.Version); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		dir.Name = upd.Name
+		// older clients don't send a parent id
+		if upd.ParentID != "" {
+			dir.ParentID = upd.ParentID
+		}
+		if upd.LastSync.After(dir.LastSync) {
+			dir.LastSync = upd.LastSync
+		}
+	}
 	if err := a.Svc.UpdateDir(dir.DriveID, dir); err != nil {
 		a.serverError(w, err.Error())
 		return
@@ -334,6 +924,79 @@ func (a *API) PutDir(w http.ResponseWriter, r *http.Request) {
 	a.write(w, fmt.Sprintf("directory (id=%s) has been updated", dir.ID))
 }
 
+// -------- archives -----------------------------------------
+
+// get the archive format from a request's ?format= parameter. sends
+// a 400 and returns false if the format isn't supported.
+func (a *API) archiveFormat(w http.ResponseWriter, r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return transfer.FormatZip, true
+	}
+	if !transfer.ValidArchiveFormat(format) {
+		a.clientError(w, fmt.Sprintf("unsupported archive format: %q", format))
+		return "", false
+	}
+	return format, true
+}
+
+// sends a 400 for invalid archive requests and end-to-end encrypted drives,
+// a 404 for missing items, and a 423 or 403 for locked files.
+// returns false for anything else.
+func (a *API) archiveError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, ErrArchiveRequest):
+		a.clientError(w, err.Error())
+	case errors.Is(err, ErrArchiveNotFound):
+		a.notFoundError(w, err.Error())
+	default:
+		return a.lockError(w, err) || a.e2eeError(w, err)
+	}
+	return true
+}
+
+// stream an archive to the client as it's written
+func (a *API) serveArchive(w http.ResponseWriter, arc *Archive, format string) {
+	aw, err := transfer.NewArchiveWriter(w, format)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	name := arc.Name + transfer.ArchiveExt(format)
+	w.Header().Set("Content-Type", transfer.ArchiveContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", name))
+	if err := a.Svc.WriteArchive(aw, arc); err != nil {
+		a.log.Error(fmt.Sprintf("failed to send archive %s: %v", name, err))
+		// the response has already started, so drop the connection
+		// rather than let the client mistake part of an archive for all of it
+		panic(http.ErrAbortHandler)
+	}
+	a.log.Info(fmt.Sprintf("served archive %s", name))
+}
+
+// stream an archive of several files and directories. expects an
+// ArchiveRequest with their ids. the format is set with ?format=,
+// and defaults to zip.
+func (a *API) NewArchive(w http.ResponseWriter, r *http.Request) {
+	format, ok := a.archiveFormat(w, r)
+	if !ok {
+		return
+	}
+	var req ArchiveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		a.clientError(w, fmt.Sprintf("failed to decode archive request: %v", err))
+		return
+	}
+	arc, err := a.Svc.NewItemsArchive(&req, r.Header.Get(PasswordHeader))
+	if err != nil {
+		if !a.archiveError(w, err) {
+			a.serverError(w, fmt.Sprintf("failed to create archive: %v", err))
+		}
+		return
+	}
+	a.serveArchive(w, arc, format)
+}
+
 // TODO:
 // create a new directory with supplied contents on the server.
 // should take a .zip file sent from the user, unpack it in the
@@ -347,7 +1010,7 @@ func (a *API) PutDir(w http.ResponseWriter, r *http.Request) {
 // create a new empty physical directory on the server for a user
 func (a *API) NewDir(w http.ResponseWriter, r *http.Request) {
 	newDir := r.Context().Value(Directory).(*svc.Directory)
-	if err := a.Svc.NewDir(newDir.DriveID, newDir.Parent.ID, newDir); err != nil {
+	if err := a.Svc.NewDir(newDir.DriveID, newDir.ParentID, newDir); err != nil {
 		a.serverError(w, fmt.Sprintf("failed to create directory: %v", err))
 		return
 	}
@@ -357,7 +1020,7 @@ func (a *API) NewDir(w http.ResponseWriter, r *http.Request) {
 // delete a physical file on the server for the user
 func (a *API) DeleteDir(w http.ResponseWriter, r *http.Request) {
 	dir := r.Context().Value(Directory).(*svc.Directory)
-	if err := a.Svc.RemoveDir(dir.DriveID, dir.ID); err != nil {
+	if err := a.Svc.RemoveDir(dir.DriveID, dir.ID, deletingDevice(r)); err != nil {
 		a.serverError(w, fmt.Sprintf("failed to remove directory: %v", err))
 		return
 	}
@@ -381,6 +1044,22 @@ func (a *API) GetDrive(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// send a drive's space usage, broken down by top-level directory.
+func (a *API) GetDriveUsage(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	usage, err := a.Svc.GetUsage(drive.ID)
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to get usage for drive (id=%s): %v", drive.ID, err))
+		return
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
 // add a new drive to the server. used as part of a separate registration process.
 func (a *API) NewDrive(w http.ResponseWriter, r *http.Request) {
 	drive := r.Context().Value(Drive).(*svc.Drive)
@@ -396,17 +1075,281 @@ func (a *API) NewDrive(w http.ResponseWriter, r *http.Request) {
 	a.write(w, fmt.Sprintf("drive (id=%s) added successfully", drive.ID))
 }
 
-// -------- sync ----------------------------------
+// update a drive's file version retention settings.
+// expects "versions" (max number of versions to keep per file) and "age"
+// (max age of a version, i.e. 720h) query parameters. 0 disables either limit.
+func (a *API) SetVersionRetention(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	maxVersions, err := strconv.Atoi(r.URL.Query().Get("versions"))
+	if err != nil || maxVersions < 0 {
+		a.clientError(w, fmt.Sprintf("invalid max versions: %q", r.URL.Query().Get("versions")))
+		return
+	}
+	maxAge, err := time.ParseDuration(r.URL.Query().Get("age"))
+	if err != nil || maxAge < 0 {
+		a.clientError(w, fmt.Sprintf("invalid max version age: %q", r.URL.Query().Get("age")))
+		return
+	}
+	if err := a.Svc.SetVersionRetention(drive.ID, maxVersions, maxAge); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("drive (id=%s) will keep %d versions up to %v old", drive.ID, maxVersions, maxAge))
+}
 
-// generate (or refresh) a sync index for a given drive
-func (a *API) GenIndex(w http.ResponseWriter, r *http.Request) {
-	driveID := r.Context().Value(Drive).(string)
-	idx, err := a.Svc.GenSyncIndex(driveID)
+// change the checksum algorithm used by a drive.
+// expects an "algo" query parameter (i.e. sha256, blake2b, xxh64).
+func (a *API) SetAlgorithm(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	algo := r.URL.Query().Get("algo")
+	if !svc.ValidAlgorithm(algo) {
+		a.clientError(w, fmt.Sprintf("unsupported checksum algorithm: %q. must be one of %v", algo, svc.ChecksumAlgorithms()))
+		return
+	}
+	if err := a.Svc.SetAlgorithm(drive.ID, algo); err != nil {
+		if a.e2eeError(w, err) {
+			return
+		}
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("drive (id=%s) now uses %s checksums", drive.ID, algo))
+}
+
+// turn on end-to-end encryption for a drive. see e2ee.go
+func (a *API) SetE2EE(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	if err := a.Svc.SetE2EE(drive.ID); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("drive (id=%s) is now end-to-end encrypted", drive.ID))
+}
+
+// -------- recycle bin ----------------------------------
+
+// send a list of everything in a drive's recycle bin
+func (a *API) GetRecycleBin(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	items, err := a.Svc.GetRecycled(drive.ID)
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to get recycle bin for drive (id=%s): %v", drive.ID, err))
+		return
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// restore a file or directory from a drive's recycle bin.
+// sends the restored item's recycle bin entry.
+func (a *API) RestoreRecycled(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	itemID := chi.URLParam(r, "itemID")
+	item, err := a.Svc.RestoreRecycled(drive.ID, itemID)
 	if err != nil {
+		if a.quotaError(w, err) {
+			return
+		}
+		if errors.Is(err, ErrRecycledNotFound) {
+			a.notFoundError(w, err.Error())
+		} else if errors.Is(err, ErrRestoreConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			a.serverError(w, fmt.Sprintf("failed to restore item (id=%s): %v", itemID, err))
+		}
+		return
+	}
+	data, err := item.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// permanently remove everything in a drive's recycle bin
+func (a *API) EmptyRecycleBin(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	if err := a.Svc.EmptyRecycleBin(drive.ID); err != nil {
+		a.serverError(w, fmt.Sprintf("failed to empty recycle bin for drive (id=%s): %v", drive.ID, err))
+		return
+	}
+	a.write(w, fmt.Sprintf("recycle bin emptied for drive (id=%s)", drive.ID))
+}
+
+// update how long deleted items are kept in a drive's recycle bin.
+// expects an "age" query parameter (i.e. 720h). 0 keeps items indefinitely.
+func (a *API) SetTrashRetention(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	retention, err := time.ParseDuration(r.URL.Query().Get("age"))
+	if err != nil || retention < 0 {
+		a.clientError(w, fmt.Sprintf("invalid recycle bin retention period: %q", r.URL.Query().Get("age")))
+		return
+	}
+	if err := a.Svc.SetTrashRetention(drive.ID, retention); err != nil {
 		a.serverError(w, err.Error())
 		return
 	}
-	data, err := idx.ToJSON()
+	a.write(w, fmt.Sprintf("drive (id=%s) will keep deleted items for %v", drive.ID, retention))
+}
+
+// -------- snapshots ----------------------------------
+
+// sends a 404 if a snapshot (or something in it) wasn't found.
+// returns false otherwise so the caller can handle it.
+func (a *API) snapshotError(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, ErrSnapshotNotFound) && !errors.Is(err, ErrSnapshotPathNotFound) {
+		return false
+	}
+	a.notFoundError(w, err.Error())
+	return true
+}
+
+// send a list of a drive's snapshots, newest first
+func (a *API) GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	snaps, err := a.Svc.GetSnapshots(drive.ID)
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to get snapshots for drive (id=%s): %v", drive.ID, err))
+		return
+	}
+	data, err := json.MarshalIndent(snaps, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// take a snapshot of a drive. accepts an optional "name" query
+// parameter to label it with. sends the new snapshot.
+func (a *API) NewSnapshot(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	snap, err := a.Svc.CreateSnapshot(drive.ID, r.URL.Query().Get("name"))
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to snapshot drive (id=%s): %v", drive.ID, err))
+		return
+	}
+	data, err := snap.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// update how often a drive is snapshotted on a schedule. expects an
+// "interval" (i.e. 24h) and a "keep" (number of scheduled snapshots to keep)
+// query parameter. an interval of 0 turns scheduled snapshots off, and
+// keeping 0 keeps all of them.
+func (a *API) SetSnapshotSchedule(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	interval, err := time.ParseDuration(r.URL.Query().Get("interval"))
+	if err != nil || interval < 0 {
+		a.clientError(w, fmt.Sprintf("invalid snapshot interval: %q", r.URL.Query().Get("interval")))
+		return
+	}
+	keep, err := strconv.Atoi(r.URL.Query().Get("keep"))
+	if err != nil || keep < 0 {
+		a.clientError(w, fmt.Sprintf("invalid number of snapshots to keep: %q", r.URL.Query().Get("keep")))
+		return
+	}
+	if err := a.Svc.SetSnapshotSchedule(drive.ID, interval, keep); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("drive (id=%s) will be snapshotted every %v. %d snapshots will be kept", drive.ID, interval, keep))
+}
+
+// send what changed in a drive since a snapshot was taken. accepts an
+// optional "to" query parameter with the id of a later snapshot to compare
+// against. otherwise the snapshot is compared with the drive as it is now.
+func (a *API) DiffSnapshot(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	snapshotID := chi.URLParam(r, "snapshotID")
+	changes, err := a.Svc.DiffSnapshots(drive.ID, snapshotID, r.URL.Query().Get("to"))
+	if err != nil {
+		if a.snapshotError(w, err) {
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to diff snapshot (id=%s): %v", snapshotID, err))
+		return
+	}
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// restore a drive from a snapshot. accepts an optional "path" query
+// parameter with the location of a file or directory in the snapshot
+// to restore by itself. otherwise the whole drive is restored.
+func (a *API) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	snapshotID := chi.URLParam(r, "snapshotID")
+	if err := a.Svc.RestoreSnapshot(drive.ID, snapshotID, r.URL.Query().Get("path")); err != nil {
+		if a.quotaError(w, err) || a.lockError(w, err) || a.snapshotError(w, err) {
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to restore snapshot (id=%s): %v", snapshotID, err))
+		return
+	}
+	a.write(w, fmt.Sprintf("drive (id=%s) restored from snapshot (id=%s)", drive.ID, snapshotID))
+}
+
+// remove one of a drive's snapshots
+func (a *API) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	drive := r.Context().Value(Drive).(*svc.Drive)
+	snapshotID := chi.URLParam(r, "snapshotID")
+	if err := a.Svc.RemoveSnapshot(drive.ID, snapshotID); err != nil {
+		if a.snapshotError(w, err) {
+			return
+		}
+		a.serverError(w, fmt.Sprintf("failed to remove snapshot (id=%s): %v", snapshotID, err))
+		return
+	}
+	a.write(w, fmt.Sprintf("snapshot (id=%s) removed", snapshotID))
+}
+
+// -------- sync ----------------------------------
+
+// get the sync index wire format requested by the client.
+// clients that don't ask for a format get the original one (SyncIndexV1).
+func syncFormat(r *http.Request) (int, error) {
+	f := r.URL.Query().Get("format")
+	if f == "" {
+		return svc.SyncIndexV1, nil
+	}
+	format, err := strconv.Atoi(f)
+	if err != nil || format < svc.SyncIndexV1 || format > svc.SyncIndexFormat {
+		return 0, fmt.Errorf("unsupported sync index format: %q", f)
+	}
+	return format, nil
+}
+
+// encode and send a sync index in the format requested by the client.
+// clients that send their device ID are recorded as having seen
+// the index's tombstones.
+func (a *API) writeIdx(w http.ResponseWriter, r *http.Request, idx *svc.SyncIndex) {
+	format, err := syncFormat(r)
+	if err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	if device := r.URL.Query().Get("device"); device != "" {
+		driveID := r.Context().Value(Drive).(string)
+		if err := a.Svc.DeviceSynced(driveID, device); err != nil {
+			a.serverError(w, fmt.Sprintf("failed to record device sync: %v", err))
+			return
+		}
+	}
+	data, err := idx.Encode(format)
 	if err != nil {
 		a.serverError(w, fmt.Sprintf("failed to encode sync index: %v", err))
 		return
@@ -414,6 +1357,17 @@ func (a *API) GenIndex(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// generate (or refresh) a sync index for a given drive
+func (a *API) GenIndex(w http.ResponseWriter, r *http.Request) {
+	driveID := r.Context().Value(Drive).(string)
+	idx, err := a.Svc.GenSyncIndex(driveID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.writeIdx(w, r, idx)
+}
+
 // retrieves (or generates) a sync index for a given drive.
 // sync operations are coordinated on the client side, so the server
 // only needs to manage indicies -- not coordinate operations.
@@ -428,12 +1382,7 @@ func (a *API) GetIdx(w http.ResponseWriter, r *http.Request) {
 		a.notFoundError(w, fmt.Sprintf("drive %s not found", driveID))
 		return
 	}
-	data, err := idx.ToJSON()
-	if err != nil {
-		a.serverError(w, fmt.Sprintf("failed to encode sync index: %v", err))
-		return
-	}
-	w.Write(data)
+	a.writeIdx(w, r, idx)
 }
 
 // refreshes the server side Update map for this drives sync index.
@@ -445,10 +1394,5 @@ func (a *API) GetUpdates(w http.ResponseWriter, r *http.Request) {
 		a.serverError(w, err.Error())
 		return
 	}
-	data, err := newIdx.ToJSON()
-	if err != nil {
-		a.serverError(w, err.Error())
-		return
-	}
-	w.Write(data)
+	a.writeIdx(w, r, newIdx)
 }
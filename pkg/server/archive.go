@@ -0,0 +1,255 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/storage"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+archives of a directory, or of several files and directories at once
+(see transfer/archive.go for the formats).
+
+archives are streamed to the client as they're written, so nothing is
+staged on the server's disk (or in the directory being archived), and
+the client starts receiving data right away. contents are read from the
+store, the same as when each file is downloaded on its own.
+
+everything that goes into an archive is looked up before anything is sent,
+so requests that can't be served (unknown items, locked files without their
+password, end-to-end encrypted drives) get an error response rather than a
+partial archive. files whose contents haven't been uploaded yet are left out.
+*/
+
+// most files and directories that can be asked for in one archive
+const MaxArchiveItems = 1000
+
+var (
+	ErrArchiveRequest  = errors.New("invalid archive request")
+	ErrArchiveNotFound = errors.New("archive item not found")
+)
+
+// files and directories to put in an archive together
+type ArchiveRequest struct {
+	Files []string `json:"files"` // file ids
+	Dirs  []string `json:"dirs"`  // directory ids
+}
+
+// the contents of an archive, looked up and ready to be written
+type Archive struct {
+	Name     string // file name for the archive, without an extension
+	entries  []archiveEntry
+	password string // for locked files
+}
+
+// a file or directory in an archive
+type archiveEntry struct {
+	name string // slash-separated path in the archive
+	file *svc.File
+	dir  *svc.Directory // set for directories
+}
+
+// add a file under a name
+func (arc *Archive) addFile(file *svc.File, name string) {
+	arc.entries = append(arc.entries, archiveEntry{name: name, file: file})
+}
+
+// add everything in a directory under prefix. prefix is
+// empty to add a directory's contents without the directory itself.
+func (arc *Archive) addDir(dir *svc.Directory, prefix string) {
+	if prefix != "" {
+		arc.entries = append(arc.entries, archiveEntry{name: prefix, dir: dir})
+	}
+	files := make([]*svc.File, 0, len(dir.Files))
+	for _, file := range dir.Files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	for _, file := range files {
+		arc.addFile(file, path.Join(prefix, file.Name))
+	}
+	dirs := make([]*svc.Directory, 0, len(dir.Dirs))
+	for _, subDir := range dir.Dirs {
+		dirs = append(dirs, subDir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name < dirs[j].Name })
+	for _, subDir := range dirs {
+		arc.addDir(subDir, path.Join(prefix, subDir.Name))
+	}
+}
+
+// make sure every locked file in the archive can be opened with its password
+func (arc *Archive) checkLocks() error {
+	for _, e := range arc.entries {
+		if e.file == nil || !e.file.Encrypted() {
+			continue
+		}
+		if arc.password == "" {
+			return errLocked(e.file)
+		}
+		if _, err := e.file.DataKey(arc.password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// make sure a drive's contents can be archived by the server
+func (s *Service) archivable(driveID string) error {
+	drive, err := s.Db.GetDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to get drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s): %w", driveID, ErrArchiveNotFound)
+	}
+	if drive.E2EE {
+		return fmt.Errorf("drive (id=%s) can't be archived by the server: %w", driveID, ErrE2EE)
+	}
+	return nil
+}
+
+// look up the contents of a directory to archive. entries are
+// relative to the directory. password is used for locked files.
+func (s *Service) NewDirArchive(dir *svc.Directory, password string) (*Archive, error) {
+	if err := s.archivable(dir.DriveID); err != nil {
+		return nil, err
+	}
+	arc := &Archive{Name: dir.Name, password: password}
+	arc.addDir(s.Populate(dir), "")
+	if err := arc.checkLocks(); err != nil {
+		return nil, err
+	}
+	return arc, nil
+}
+
+// look up files and directories to archive together. each one is at the top
+// of the archive, and they all have to be on the same drive. items with the
+// same name are numbered, i.e. "notes (2).txt". password is used for locked files.
+func (s *Service) NewItemsArchive(req *ArchiveRequest, password string) (*Archive, error) {
+	count := len(req.Files) + len(req.Dirs)
+	if count == 0 {
+		return nil, fmt.Errorf("%w: no files or directories", ErrArchiveRequest)
+	}
+	if count > MaxArchiveItems {
+		return nil, fmt.Errorf("%w: more than %d items", ErrArchiveRequest, MaxArchiveItems)
+	}
+
+	var (
+		driveID string
+		files   = make([]*svc.File, 0, len(req.Files))
+		dirs    = make([]*svc.Directory, 0, len(req.Dirs))
+	)
+	sameDrive := func(id string) error {
+		if driveID == "" {
+			driveID = id
+		} else if id != driveID {
+			return fmt.Errorf("%w: items are on more than one drive", ErrArchiveRequest)
+		}
+		return nil
+	}
+	for _, fileID := range req.Files {
+		file, err := s.Db.GetFileByID(fileID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get file: %v", err)
+		}
+		if file == nil {
+			return nil, fmt.Errorf("file (id=%s): %w", fileID, ErrArchiveNotFound)
+		}
+		if err := sameDrive(file.DriveID); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	for _, dirID := range req.Dirs {
+		dir, err := s.Db.GetDirectoryByID(dirID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get directory: %v", err)
+		}
+		if dir == nil {
+			return nil, fmt.Errorf("directory (id=%s): %w", dirID, ErrArchiveNotFound)
+		}
+		if err := sameDrive(dir.DriveID); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+	}
+	if err := s.archivable(driveID); err != nil {
+		return nil, err
+	}
+
+	arc := &Archive{Name: "sfs-archive", password: password}
+	used := make(map[string]bool, count)
+	for _, file := range files {
+		arc.addFile(file, uniqueName(used, file.Name, false))
+	}
+	for _, dir := range dirs {
+		arc.addDir(s.Populate(dir), uniqueName(used, dir.Name, true))
+	}
+	if count == 1 {
+		arc.Name = arc.entries[0].name
+	}
+	if err := arc.checkLocks(); err != nil {
+		return nil, err
+	}
+	return arc, nil
+}
+
+// number a name if it's already been used. files keep their extension.
+func uniqueName(used map[string]bool, name string, isDir bool) string {
+	base, ext := name, ""
+	if !isDir {
+		ext = path.Ext(name)
+		base = strings.TrimSuffix(name, ext)
+	}
+	unique := name
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[unique] = true
+	return unique
+}
+
+// write an archive's contents, then finish the archive.
+func (s *Service) WriteArchive(aw transfer.ArchiveWriter, arc *Archive) error {
+	for _, e := range arc.entries {
+		if e.dir != nil {
+			info := transfer.EntryInfo(e.name, 0, fs.ModeDir|0755, e.dir.LastSync)
+			if err := aw.Add(e.name, info, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.archiveFile(aw, e.file, e.name, arc.password); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+func (s *Service) archiveFile(aw transfer.ArchiveWriter, file *svc.File, name string, password string) error {
+	f, err := s.OpenFile(file, password)
+	if errors.Is(err, storage.ErrNotExist) {
+		s.log.Warn(fmt.Sprintf("contents of %s (id=%s) not found. leaving it out of the archive", file.Name, file.ID))
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return aw.Add(name, transfer.EntryInfo(name, size, 0644, file.LastSync), f)
+}
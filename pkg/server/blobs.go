@@ -0,0 +1,278 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+content-addressed blob store.
+
+file contents are stored once per unique checksum under
+<svc root>/blobs/<algo>/<first two digits>/<digest>, and each file's server
+path is a copy of the blob holding its contents. with the local store (see
+storage.go) copies are hard links, so identical files (in the same drive or
+across users) only take up space once. the blobs database keeps count of how
+many files refer to each blob, and a blob is removed once the last of them
+is changed or deleted.
+
+blobs are never modified. stores always replace an object when it's written
+rather than writing into it, so writing to a file's server path leaves its
+blob alone. once a file's new contents are written, commit them to the blob
+store (see commitBlob). on file systems without hard links files get their
+own copy of the blob instead, which keeps everything working without the
+space savings.
+*/
+// the blob store doesn't have contents with the requested checksum
+var ErrBlobNotFound = errors.New("blob not found")
+
+// path to a blob in the blob store
+func (s *Service) buildBlobPath(checksum string) (string, error) {
+	algo, digest, err := svc.ParseChecksum(checksum)
+	if err != nil {
+		return "", err
+	}
+	if !svc.ValidAlgorithm(algo) {
+		return "", fmt.Errorf("unsupported checksum algorithm: %q", algo)
+	}
+	return filepath.Join(s.svcCfgs.SvcRoot, "blobs", algo, digest[:2], digest), nil
+}
+
+// the algorithm used to find the blob for a file's contents
+func blobAlgorithm(file *svc.File) string {
+	if svc.ValidAlgorithm(file.Algorithm) {
+		return file.Algorithm
+	}
+	return svc.DefaultAlgorithm
+}
+
+// get a blob by its checksum. returns nil if the store doesn't have it.
+func (s *Service) GetBlob(checksum string) (*svc.Blob, error) {
+	blob, err := s.Db.GetBlob(checksum)
+	if err != nil || blob == nil {
+		return nil, err
+	}
+	if ok, err := s.objectExists(blob.Path); err != nil || !ok {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// read the contents of a blob. returns ErrBlobNotFound
+// if the store doesn't have it.
+func (s *Service) ReadBlob(checksum string) ([]byte, error) {
+	blob, err := s.GetBlob(checksum)
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, checksum)
+	}
+	return s.readObject(blob.Path)
+}
+
+// get a blob that one of a drive's files already has the contents of.
+// returns nil otherwise, even if the store has it, so clients can't find
+// out what other drives have or claim contents they can't prove they have.
+// identical uploads are still stored once (see commitBlob).
+func (s *Service) GetDriveBlob(driveID string, checksum string) (*svc.Blob, error) {
+	ok, err := s.Db.DriveHasBlob(driveID, checksum)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return s.GetBlob(checksum)
+}
+
+// read the contents of a blob one of a drive's files already has.
+// returns ErrBlobNotFound if there isn't one (see GetDriveBlob).
+func (s *Service) ReadDriveBlob(driveID string, checksum string) ([]byte, error) {
+	blob, err := s.GetDriveBlob(driveID, checksum)
+	if err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, checksum)
+	}
+	return s.readObject(blob.Path)
+}
+
+// checksum of the contents at a file's server path
+func (s *Service) blobChecksum(file *svc.File) (string, error) {
+	r, err := s.getObject(file.ServerPath)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	return svc.ChecksumOf(r, blobAlgorithm(file))
+}
+
+// move a file's current contents into the blob store and link the file to
+// its blob. if the store already has the same contents, the file's copy is
+// replaced with a link to the existing blob instead. releases the blob the
+// file referred to before, if it's changed.
+//
+// updates file.Blob, but not the file's database entry. locked files
+// are left out of the blob store (see crypt.go).
+func (s *Service) commitBlob(file *svc.File) error {
+	if file.Protected {
+		return nil
+	}
+	cs, err := s.blobChecksum(file)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+	}
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	blob, err := s.Db.GetBlob(cs)
+	if err != nil {
+		return err
+	}
+	if blob == nil {
+		blobPath, err := s.buildBlobPath(cs)
+		if err != nil {
+			return err
+		}
+		if err := s.copyObject(file.ServerPath, blobPath); err != nil {
+			return fmt.Errorf("failed to add %s to blob store: %v", file.Name, err)
+		}
+		obj, err := s.statObject(blobPath)
+		if err != nil {
+			return err
+		}
+		if err := s.Db.AddBlob(svc.NewBlob(cs, blobPath, obj.Size)); err != nil {
+			return fmt.Errorf("failed to add blob to database: %v", err)
+		}
+	} else {
+		// the contents went missing. this copy is as good as any
+		if ok, err := s.objectExists(blob.Path); err != nil {
+			return err
+		} else if !ok {
+			if err := s.copyObject(file.ServerPath, blob.Path); err != nil {
+				return fmt.Errorf("failed to add %s to blob store: %v", file.Name, err)
+			}
+		}
+		if err := s.copyObject(blob.Path, file.ServerPath); err != nil {
+			return fmt.Errorf("failed to link %s to blob: %v", file.Name, err)
+		}
+		if cs != file.Blob {
+			if err := s.Db.UpdateBlobRefs(cs, 1); err != nil {
+				return err
+			}
+		}
+	}
+	if file.Blob != "" && file.Blob != cs {
+		if err := s.release(file.Blob); err != nil {
+			return err
+		}
+	}
+	file.Blob = cs
+	return nil
+}
+
+// add a reference to the blob holding a file's current contents on behalf of
+// something other than the file (i.e. a snapshot). contents that aren't in
+// the blob store yet (i.e. those of locked files) are added to it. returns
+// the blob's checksum, or an empty string if the file has no contents on
+// the server. release it with releaseBlob once it's no longer needed.
+func (s *Service) retainBlob(file *svc.File) (string, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	if file.Blob != "" {
+		blob, err := s.Db.GetBlob(file.Blob)
+		if err != nil {
+			return "", err
+		}
+		if blob != nil {
+			return file.Blob, s.Db.UpdateBlobRefs(file.Blob, 1)
+		}
+	}
+	if ok, err := s.objectExists(file.ServerPath); err != nil || !ok {
+		return "", err
+	}
+	cs, err := s.blobChecksum(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+	}
+	blob, err := s.Db.GetBlob(cs)
+	if err != nil {
+		return "", err
+	}
+	if blob != nil {
+		return cs, s.Db.UpdateBlobRefs(cs, 1)
+	}
+	blobPath, err := s.buildBlobPath(cs)
+	if err != nil {
+		return "", err
+	}
+	if err := s.copyObject(file.ServerPath, blobPath); err != nil {
+		return "", fmt.Errorf("failed to add %s to blob store: %v", file.Name, err)
+	}
+	obj, err := s.statObject(blobPath)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Db.AddBlob(svc.NewBlob(cs, blobPath, obj.Size)); err != nil {
+		return "", fmt.Errorf("failed to add blob to database: %v", err)
+	}
+	return cs, nil
+}
+
+// drop a file's reference to a blob. the blob is
+// removed once nothing refers to it anymore.
+func (s *Service) releaseBlob(checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+	return s.release(checksum)
+}
+
+// must be called with blobMu held
+func (s *Service) release(checksum string) error {
+	if err := s.Db.UpdateBlobRefs(checksum, -1); err != nil {
+		return err
+	}
+	blob, err := s.Db.GetBlob(checksum)
+	if err != nil || blob == nil {
+		return err
+	}
+	if blob.Refs > 0 {
+		return nil
+	}
+	if err := s.deleteObject(blob.Path); err != nil {
+		return fmt.Errorf("failed to remove blob %s: %v", checksum, err)
+	}
+	return s.Db.RemoveBlob(checksum)
+}
+
+// move the contents of files stored by older versions of sfs into the
+// blob store. files are converted in place, so their server paths don't change.
+func (s *Service) migrateBlobs() error {
+	files, err := s.Db.GetUnlinkedFiles()
+	if err != nil {
+		return err
+	}
+	var moved int
+	for _, file := range files {
+		if ok, err := s.objectExists(file.ServerPath); err != nil || !ok {
+			continue // nothing to move
+		}
+		if err := s.commitBlob(file); err != nil {
+			return err
+		}
+		if err := s.Db.UpdateFile(file); err != nil {
+			return err
+		}
+		moved++
+	}
+	if moved > 0 {
+		s.log.Info(fmt.Sprintf("moved %d files into the blob store", moved))
+	}
+	return nil
+}
@@ -35,6 +35,17 @@ type SvcCfg struct {
 	SvcRoot    string `env:"SERVICE_ROOT,required"`
 	NewService bool   `env:"NEW_SERVICE,required"`
 	IsAdmin    bool   `env:"ADMIN_MODE,required"`
+
+	// where file contents are kept. see storage.go
+	Storage     string `env:"SERVICE_STORAGE,default=local"`
+	S3Endpoint  string `env:"SERVICE_S3_ENDPOINT"`
+	S3Bucket    string `env:"SERVICE_S3_BUCKET"`
+	S3Region    string `env:"SERVICE_S3_REGION,default=us-east-1"`
+	S3AccessKey string `env:"SERVICE_S3_ACCESS_KEY"`
+	S3SecretKey string `env:"SERVICE_S3_SECRET_KEY"`
+
+	// largest a compressed request body can be once it's decompressed, in bytes
+	MaxDecodedSize int64 `env:"SERVICE_MAX_DECODED_SIZE,default=1073741824"`
 }
 
 func ServiceConfig() *SvcCfg {
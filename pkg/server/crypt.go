@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/storage"
+)
+
+/*
+locked files on the server.
+
+the contents of a locked file are kept encrypted in the store (see
+service/crypt.go for the format and how keys work). the password a file is
+locked with is never kept by the server. clients send it with each request
+that needs to read the file's contents (see PasswordHeader), and it's only
+used to unwrap the file's data key for that request.
+
+locked files can't be changed until they're unlocked, and are taken out of
+the blob store, since their contents can't be shared with anything else.
+no plaintext copy of a file is kept once it's locked: its saved versions and
+any copy of it in the recycle bin are removed. files whose contents are shared
+with other files or snapshots can't be locked, since the shared copy would
+stay readable.
+*/
+
+// header clients use to send the password for a locked file
+const PasswordHeader = "X-Sfs-Password"
+
+var ErrSharedContents = errors.New("contents are shared with other files or snapshots")
+
+func errLocked(file *svc.File) error {
+	return fmt.Errorf("%s (id=%s) is %w", file.Name, file.ID, svc.ErrLocked)
+}
+
+// a file's decrypted contents
+type decryptedFile struct {
+	*svc.Decrypter
+	io.Closer
+}
+
+// open a locked file's contents, decrypting them with its data key
+func (s *Service) openDecrypted(file *svc.File, key []byte) (storage.File, error) {
+	f, err := s.openObject(file.ServerPath)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d, err := svc.NewDecrypter(f, size, key)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to decrypt %s: %w", file.Name, err)
+	}
+	return &decryptedFile{Decrypter: d, Closer: f}, nil
+}
+
+// lock a file with a password, and encrypt its contents on the server
+func (s *Service) LockFile(file *svc.File, password string) error {
+	encrypted := file.Encrypted()
+	if !encrypted && file.Blob != "" {
+		blob, err := s.Db.GetBlob(file.Blob)
+		if err != nil {
+			return err
+		}
+		if blob != nil && blob.Refs > 1 {
+			return fmt.Errorf("can't lock %s (id=%s): %w", file.Name, file.ID, ErrSharedContents)
+		}
+	}
+	key, err := file.DataKey(password)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		r, err := s.getObject(file.ServerPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file.Name, err)
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(svc.Encrypt(pw, r, key))
+			r.Close()
+		}()
+		err = s.putObject(file.ServerPath, pr)
+		pr.Close()
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", file.Name, err)
+		}
+		if err := s.releaseBlob(file.Blob); err != nil {
+			return err
+		}
+		file.Blob = ""
+		if err := s.removePlaintext(file); err != nil {
+			return err
+		}
+	}
+	file.Protected = true
+	return s.Db.UpdateFile(file)
+}
+
+// remove a file's saved versions and recycle bin copy,
+// which were saved before it was encrypted
+func (s *Service) removePlaintext(file *svc.File) error {
+	if err := s.removeVersions(file.ID); err != nil {
+		return err
+	}
+	item, err := s.Db.GetRecycled(file.ID)
+	if err != nil {
+		return err
+	}
+	if item != nil {
+		return s.purgeRecycled(item)
+	}
+	return nil
+}
+
+// unlock a file, and decrypt its contents on the server
+func (s *Service) UnlockFile(file *svc.File, password string) error {
+	if !file.Protected {
+		return nil
+	}
+	encrypted := file.Encrypted()
+	key, err := file.DataKey(password)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		f, err := s.openDecrypted(file, key)
+		if err != nil {
+			return err
+		}
+		err = s.putObject(file.ServerPath, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", file.Name, err)
+		}
+	}
+	file.Protected = false
+	if err := s.commitBlob(file); err != nil {
+		return err
+	}
+	return s.Db.UpdateFile(file)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"path/filepath"
+	"strings"
 
 	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/db"
@@ -10,9 +11,10 @@ import (
 
 // ----- db utils --------------------------------
 
-// get a one-off db connection to a given db
+// get a one-off db connection to a given db. db files are
+// lowercase (see db.InitDBs), so "Files" is the files db.
 func getDBConn(dbName string) *db.Query {
-	return db.NewQuery(filepath.Join(svcCfg.SvcRoot, "dbs", dbName), false)
+	return db.NewQuery(filepath.Join(svcCfg.SvcRoot, "dbs", strings.ToLower(dbName)), false)
 }
 
 // get file info from db. file will be nil if not found.
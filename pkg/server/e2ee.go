@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+end-to-end encrypted drives.
+
+clients encrypt everything for these drives before sending it, and the
+server never has the keys (see transfer/e2ee.go). all it can do is store
+and serve what it's given, so:
+
+  - checksums are keyed MACs made by the client. the server keeps the
+    ones clients send instead of calculating its own.
+  - uploads that weren't sealed by a client with the drive's keys
+    are rejected, as are delta transfers, which need the plaintext.
+  - the checksum algorithm can't be changed.
+
+turning E2EE on removes the saved versions of the drive's files, since
+they're plaintext. the current contents of each file are replaced as
+clients upload them again, and aren't saved as versions when they are.
+items already in the recycle bin are left as they are until it's emptied.
+E2EE can't be turned off again.
+*/
+
+// contents of an end-to-end encrypted drive can't be read by the server
+var ErrE2EE = errors.New("drive is end-to-end encrypted")
+
+func errE2EE(file *svc.File) error {
+	return fmt.Errorf("%s (id=%s) is on an end-to-end encrypted drive: %w", file.Name, file.ID, ErrE2EE)
+}
+
+// whether file's contents were sealed by a client with the drive's keys
+func sealedByClient(file *svc.File) bool {
+	return file.Algorithm == transfer.MACAlgorithm && svc.ChecksumAlgorithm(file.CheckSum) == transfer.MACAlgorithm
+}
+
+// whether the drive a file belongs to is end-to-end encrypted
+func (s *Service) isE2EE(file *svc.File) (bool, error) {
+	drive, err := s.Db.GetDrive(file.DriveID)
+	if err != nil {
+		return false, err
+	}
+	return drive != nil && drive.E2EE, nil
+}
+
+// turn on end-to-end encryption for a drive
+func (s *Service) SetE2EE(driveID string) error {
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	if drive.E2EE {
+		return nil
+	}
+	for _, file := range drive.GetFiles() {
+		if err := s.removeVersions(file.ID); err != nil {
+			return err
+		}
+	}
+	drive.E2EE = true
+	if err := s.UpdateDrive(drive); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("drive (id=%s) is now end-to-end encrypted", drive.ID))
+	return nil
+}
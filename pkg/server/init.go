@@ -160,6 +160,12 @@ func loadDrives(svc *Service) (*Service, error) {
 			if err := loadDrive(svc, drive); err != nil {
 				return svc, fmt.Errorf("failed to load drive: %v", err)
 			}
+			// usage is kept up to date as files change, but
+			// make sure it's accurate before we start enforcing quotas
+			drive.RecalculateUsage()
+			if err := svc.SaveDrive(drive); err != nil {
+				return svc, err
+			}
 			drive.BuildSyncIdx()
 			svc.Drives[drive.ID] = drive
 		}
@@ -177,6 +183,8 @@ the root sfs service directory should have the following structure:
 root/
 |---users/
 |   |---userDriveA/
+|   |   |---files/<first two characters of id>/<id>
+|   |   (see layout.go)
 |   |---userDriveB/
 |   (etc)
 |---state/
@@ -186,6 +194,9 @@ root/
 |   |---drives
 |   |---directories
 |   |---files
+|---blobs/
+|   |---<algo>/<first two digits of checksum>/<checksum>
+|   (created as files are added. see blobs.go)
 */
 
 // initialize a new service and corresponding databases
@@ -221,6 +232,11 @@ func SvcInit(svcRoot string) (*Service, error) {
 	// create new service instance and save initial state
 	initLogger.Info("initializing new service instance...")
 	svc := NewService(svcRoot)
+	store, err := newStore(svcCfg)
+	if err != nil {
+		return nil, err
+	}
+	svc.SetStore(store)
 	if err := svc.SaveState(); err != nil {
 		return nil, fmt.Errorf("failed to save service state: %v", err)
 	}
@@ -302,6 +318,33 @@ func SvcLoad(svcPath string) (*Service, error) {
 	// add configs to service instance
 	svc.svcCfgs = svcCfg
 
+	// connect to wherever file contents are kept
+	store, err := newStore(svcCfg)
+	if err != nil {
+		initLogger.Error(fmt.Sprintf("failed to set up storage: %v", err))
+		return nil, fmt.Errorf("failed to set up storage: %v", err)
+	}
+	svc.SetStore(store)
+
+	// bring databases created by older versions of sfs up to date.
+	// file contents are read back through the store to rehash them.
+	if err := db.MigrateDBs(svc.DbDir, svc.migrationReader); err != nil {
+		initLogger.Error(fmt.Sprintf("failed to migrate databases: %v", err))
+		return nil, fmt.Errorf("failed to migrate databases: %v", err)
+	}
+
+	// move files stored in the older tree layout to where they're kept now
+	if err := svc.migrateLayout(); err != nil {
+		initLogger.Error(fmt.Sprintf("failed to migrate files to new layout: %v", err))
+		return nil, fmt.Errorf("failed to migrate files to new layout: %v", err)
+	}
+
+	// move files stored by older versions of sfs into the blob store
+	if err := svc.migrateBlobs(); err != nil {
+		initLogger.Error(fmt.Sprintf("failed to migrate files to blob store: %v", err))
+		return nil, fmt.Errorf("failed to migrate files to blob store: %v", err)
+	}
+
 	// load users and drives
 	_, err = loadUsers(svc)
 	if err != nil {
@@ -343,11 +386,17 @@ func (s *Service) Reset(svcPath string) error {
 			}
 		}
 		// clear users directory of all roots and subdirectories
+		if err := s.deleteObjects(filepath.Join(s.svcCfgs.SvcRoot, "users")); err != nil {
+			return fmt.Errorf("failed to remove user files: %v", err)
+		}
 		for _, user := range s.Users {
 			if err := Clean(user.SvcRoot); err != nil {
 				return fmt.Errorf("failed to remove user files: %v", err)
 			}
 		}
+		if err := s.deleteObjects(filepath.Join(s.svcCfgs.SvcRoot, "blobs")); err != nil {
+			return fmt.Errorf("failed to remove blob store: %v", err)
+		}
 		// reset internal data structures
 		s.resetUserMap()
 		s.resetDrivesMap()
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+server-side file layout.
+
+the server doesn't mirror the client's file system tree. each file is stored
+under a path derived from its id, in directories sharded by the first two
+characters of the id:
+
+	users/<user>/files/3f/3f2a9c1e-...
+
+so two files with the same name in different client directories never
+collide, and a file can be found from its id alone. the directory tree only
+exists as metadata in the directories database, and moving or renaming a
+directory never touches the files in it. directories keep a server path for
+the place they'd have in the tree, but nothing is created there. file paths
+are relative to the service root in whichever store holds the contents
+(see storage.go).
+
+anything placed in a user's root directory on the server's local disk
+(users/<user>/root) is imported into the drive the next time it's
+refreshed, whichever store is used. see RefreshDrive()
+*/
+
+// path to a file in a user's file store
+func (s *Service) buildFilePath(user string, fileID string) string {
+	shard := fileID
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return filepath.Join(s.svcCfgs.SvcRoot, "users", user, "files", shard, fileID)
+}
+
+// path to the directory new items are imported from
+func (s *Service) buildImportPath(user string) string {
+	return filepath.Join(s.svcCfgs.SvcRoot, "users", user, "root")
+}
+
+// import everything under path into dir. directories are added to the
+// database as needed, and files are moved into the user's file store.
+// directories left empty are removed.
+func (s *Service) importDir(drive *svc.Drive, dir *svc.Directory, path string) error {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read directory: %v", err)
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if entry.IsDir() {
+			var subDir *svc.Directory
+			for _, d := range dir.Dirs {
+				if d.Name == entry.Name() {
+					subDir = d
+					break
+				}
+			}
+			// new directory
+			if subDir == nil {
+				subDir = svc.NewDirectory(entry.Name(), drive.OwnerID, drive.ID, s.dirServerPath(drive, dir, entry.Name()))
+				if err := drive.AddSubDir(dir.ID, subDir); err != nil {
+					return err
+				}
+				if err := s.Db.AddDir(subDir); err != nil {
+					return fmt.Errorf("failed to add directory (%s) to db: %v", entry.Name(), err)
+				}
+			}
+			if err := s.importDir(drive, subDir, entryPath); err != nil {
+				return err
+			}
+			os.Remove(entryPath) // only succeeds if it's empty now
+			continue
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := s.importFile(drive, dir, entryPath); err != nil {
+			return fmt.Errorf("failed to import %s: %v", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// move a file into the user's file store and add it to dir
+func (s *Service) importFile(drive *svc.Drive, dir *svc.Directory, path string) error {
+	file := svc.NewFile(filepath.Base(path), drive.ID, drive.OwnerID, path)
+	if err := file.SetAlgorithm(drive.GetAlgorithm()); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	file.ServerPath = s.buildFilePath(drive.OwnerName, file.ID)
+	file.MarkBackedUp()
+	err = s.putObject(file.ServerPath, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if err := s.commitBlob(file); err != nil {
+		return err
+	}
+	if err := drive.AddFile(dir.ID, file); err != nil {
+		return err
+	}
+	if err := s.Db.AddFile(file); err != nil {
+		return fmt.Errorf("failed to add file to db: %v", err)
+	}
+	s.log.Info(fmt.Sprintf("imported %s (id=%s) into drive (id=%s)", file.Name, file.ID, drive.ID))
+	return nil
+}
+
+// move files stored in the users directory tree by older versions
+// of sfs into their file stores.
+func (s *Service) migrateLayout() error {
+	drives, err := s.Db.GetDrives()
+	if err != nil {
+		return err
+	}
+	var moved int
+	for _, drive := range drives {
+		files, err := s.Db.GetFilesByDriveID(drive.ID)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			fp := s.buildFilePath(drive.OwnerName, file.ID)
+			if !file.Backup || file.ServerPath == fp {
+				continue
+			}
+			if ok, err := s.objectExists(file.ServerPath); err != nil || !ok {
+				continue // nothing to move
+			}
+			if err := s.copyObject(file.ServerPath, fp); err != nil {
+				return fmt.Errorf("failed to move %s (id=%s): %v", file.Name, file.ID, err)
+			}
+			if err := s.deleteObject(file.ServerPath); err != nil {
+				return fmt.Errorf("failed to move %s (id=%s): %v", file.Name, file.ID, err)
+			}
+			file.ServerPath = fp
+			if err := s.Db.UpdateFile(file); err != nil {
+				return err
+			}
+			moved++
+		}
+	}
+	if moved > 0 {
+		s.log.Info(fmt.Sprintf("moved %d files to the id-based file layout", moved))
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/sfs/pkg/auth"
 	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -21,6 +22,42 @@ func ContentTypeJson(h http.Handler) http.Handler {
 	})
 }
 
+// decompress request bodies sent with a content encoding we support, and let
+// clients know which ones those are, so they can compress their uploads (see
+// transfer/encoding.go). requests with any other encoding are rejected with a 415.
+//
+// decompressed bodies are cut off at SERVICE_MAX_DECODED_SIZE bytes so a small
+// request can't expand to fill the server's memory. handlers that read past
+// that get an *http.MaxBytesError, and send a 413 (see API.tooLargeError).
+func DecodeContent(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Encoding", transfer.AcceptEncoding)
+		enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if enc == "" || enc == "identity" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !transfer.SupportedEncoding(enc) {
+			http.Error(w, fmt.Sprintf("unsupported content encoding: %q", enc), http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := transfer.NewDecoder(r.Body, enc)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decompress request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+		r.Body = body
+		if svcCfg.MaxDecodedSize > 0 {
+			r.Body = http.MaxBytesReader(w, body, svcCfg.MaxDecodedSize)
+		}
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		h.ServeHTTP(w, r)
+	})
+}
+
 // -------- all item contexts ------------------------------------
 
 func AllUsersFilesCtx(h http.Handler) http.Handler {
@@ -299,10 +336,9 @@ func FileCtx(h http.Handler) http.Handler {
 			http.Error(w, fmt.Sprintf("file (id=%s) not found", fileID), http.StatusNotFound)
 			return
 		}
-		if !file.Exists() {
-			http.Error(w, "file was in database but physical file was not found", http.StatusInternalServerError)
-			return
-		}
+		// contents are in the service's store, which might not be on this
+		// machine, and files can be added before their contents are uploaded.
+		// handlers that read contents send a 404 if there aren't any.
 		ctx := context.WithValue(r.Context(), File, file)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
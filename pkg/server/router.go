@@ -14,6 +14,20 @@ ROUTES:
 // ----- meta
 
 GET     /v1/drive/{userID}        // "home". return a root directory listing
+GET     /v1/drive/{driveID}/usage     // get space usage, broken down by top-level directory
+PUT     /v1/drive/{driveID}/versions  // update file version retention settings
+PUT     /v1/drive/{driveID}/checksum  // change the checksum algorithm (?algo=sha256|blake2b|xxh64)
+PUT     /v1/drive/{driveID}/e2ee      // turn on end-to-end encryption (see e2ee.go)
+GET     /v1/drive/{driveID}/trash     // list items in the recycle bin
+PUT     /v1/drive/{driveID}/trash     // update how long deleted items are kept
+DELETE  /v1/drive/{driveID}/trash     // empty the recycle bin
+POST    /v1/drive/{driveID}/trash/{itemID}/restore  // restore a deleted item
+GET     /v1/drive/{driveID}/snapshots    // list snapshots of the drive
+POST    /v1/drive/{driveID}/snapshots    // take a snapshot (?name=<label>)
+PUT     /v1/drive/{driveID}/snapshots    // update the snapshot schedule (?interval=24h&keep=7)
+GET     /v1/drive/{driveID}/snapshots/{snapshotID}/diff     // what changed since a snapshot (?to=<later snapshot id>)
+POST    /v1/drive/{driveID}/snapshots/{snapshotID}/restore  // restore the drive (or ?path=<file or directory>) from a snapshot
+DELETE  /v1/drive/{driveID}/snapshots/{snapshotID}          // remove a snapshot
 
 // ----- users (admin only)
 
@@ -21,6 +35,7 @@ GET     /v1/users/{userID}       // get info about a user
 POST    /v1/users/new            // create a new user
 PUT     /v1/users/{userID}       // update a user
 DELETE  /v1/users/{userID}       // delete a user
+PUT     /v1/users/{userID}/quota // set the storage quota (in bytes) for a user's drive
 
 // ----- files
 
@@ -29,18 +44,68 @@ POST   /v1/files/new           // send a new file to the server
 GET    /v1/files/{fileID}      // download a file from the server
 PUT    /v1/files/{fileID}      // update a file on the server
 DELETE /v1/files/{fileID}      // delete a file on the server
+GET    /v1/files/{fileID}/sig    // get a block signature for the server's copy of a file
+PUT    /v1/files/{fileID}/delta  // update a file on the server using a delta
+POST   /v1/files/{fileID}/delta  // get a delta against a signature of the client's copy of a file
+GET    /v1/files/{fileID}/versions  // list saved versions of a file
+POST   /v1/files/{fileID}/versions/{rev}/restore  // restore a file to a previous version
+GET    /v1/files/{fileID}/uploads             // list open upload sessions for a file
+POST   /v1/files/{fileID}/uploads             // open an upload session (?size=<bytes>&checksum=<algo:hex>)
+GET    /v1/files/{fileID}/uploads/{uploadID}  // see which parts of an upload have been received
+PUT    /v1/files/{fileID}/uploads/{uploadID}  // send a part of an upload (?offset=<bytes>)
+POST   /v1/files/{fileID}/uploads/{uploadID}  // check an upload and replace the file's contents with it
+DELETE /v1/files/{fileID}/uploads/{uploadID}  // cancel an upload
+
+file downloads support Range and If-Range. a file's ETag is its checksum.
+
+request bodies can be compressed with any of the encodings listed in the
+Accept-Encoding header of every response (zstd, gzip), and are rejected with
+a 415 otherwise. downloads are compressed if the client asks for it with
+Accept-Encoding, unless they're already compressed or a Range was requested.
+
+file uploads (POST /v1/files/new, PUT /v1/files/{fileID}) can send ?blob=<checksum>
+instead of the file's contents if the server already has them (see below).
+
+uploads that would take a drive over its quota are rejected with a
+413 (file is larger than the whole quota) or 507 (not enough free space).
+
+the contents of locked files are encrypted on the server (see crypt.go).
+downloading one needs its password in the X-Sfs-Password header, and is
+rejected with a 423 without it, or a 403 if it's wrong. locked files can't
+be updated, and their signatures and deltas aren't available.
+
+files on end-to-end encrypted drives are encrypted by clients, and the server
+never sees their contents (see e2ee.go). uploads have to carry the keyed
+checksum the client made for them, and are rejected with a 400 otherwise.
+signatures and deltas aren't available for them either.
 
 // ---- directories
 
 GET    /v1/i/dirs/{dirID}    // get list of files and subdirectories for this directory
 POST   /v1/dirs/new          // create a directory on the server
 GET    /v1/dirs/{dirID}      // download a .zip (or other compressed format) file of this directory and its contents
+                             // pick the format with ?format=zip|tar|tar.gz|tar.zst (default zip).
 PUT    /v1/dirs/{dirID}      // update a directory on the server
 DELETE /v1/dirs/{dirID}      // delete a directory on the server
 
+// ----- archives
+
+POST   /v1/archive           // download several files and directories as one archive. expects their ids
+                             // as JSON: {"files": [...], "dirs": [...]}. accepts ?format= like /v1/dirs/{dirID}.
+
+// ----- blobs
+
+GET    /v1/blobs/{checksum}  // check whether a drive already has contents with this checksum (?drive=<drive id>)
+
 // ----- sync operations
 
 GET    /v1/sync/{driveID}    // fetch file last sync times from server
+GET    /v1/sync/{driveID}/index   // generate a new sync index for a drive
+GET    /v1/sync/{driveID}/update  // refresh a drive's sync index and fetch files to update
+                             // all sync index endpoints accept ?format=N (see service.SyncIndexFormat).
+                             // clients that don't send a format get the original (v1) index.
+                             // clients should also send ?device=<device id> so deletion tombstones
+                             // can be removed once every device has seen them.
 POST   /v1/sync/{driveID}    // send a last sync index object to the server
                              // generated from the local client directories to
 								             // initiate a client/server file sync.
@@ -68,6 +133,7 @@ func NewRouter() *chi.Mux {
 	// custom middleware
 	// r.Use(AuthUserHandler)
 	r.Use(ContentTypeJson) // will be overridden by streaming API endpoints
+	r.Use(DecodeContent)   // compressed uploads
 
 	// placeholder for sfs "homepage"
 	// this will eventually display a simple service index page
@@ -84,6 +150,7 @@ func NewRouter() *chi.Mux {
 				r.Get("/", api.GetUser)       // get info about a user
 				r.Put("/", api.UpdateUser)    // update a user
 				r.Delete("/", api.DeleteUser) // delete a user
+				r.Put("/quota", api.SetQuota) // set a user's storage quota
 			})
 			r.Route("/new", func(r chi.Router) {
 				r.Use(NewUserCtx)
@@ -100,9 +167,24 @@ func NewRouter() *chi.Mux {
 		r.Route("/files", func(r chi.Router) {
 			r.Route("/{fileID}", func(r chi.Router) {
 				r.Use(FileCtx)
-				r.Get("/", api.ServeFile)     // get a file from the server
-				r.Put("/", api.PutFile)       // update a file on the server
-				r.Delete("/", api.DeleteFile) // delete a file on the server
+				r.Get("/", api.ServeFile)                                 // get a file from the server
+				r.Put("/", api.PutFile)                                   // update a file on the server
+				r.Delete("/", api.DeleteFile)                             // delete a file on the server
+				r.Get("/sig", api.GetFileSignature)                       // get a block signature for a file
+				r.Put("/delta", api.PutFileDelta)                         // update a file using a delta
+				r.Post("/delta", api.GetFileDelta)                        // get a delta against a client's signature
+				r.Get("/versions", api.GetFileVersions)                   // list saved versions of a file
+				r.Post("/versions/{rev}/restore", api.RestoreFileVersion) // restore a previous version
+
+				// resumable uploads of large files, in parts
+				r.Route("/uploads", func(r chi.Router) {
+					r.Get("/", api.GetUploads)               // list open upload sessions
+					r.Post("/", api.NewUpload)               // open an upload session
+					r.Get("/{uploadID}", api.GetUpload)      // see which parts have been received
+					r.Put("/{uploadID}", api.PutUploadPart)  // send a part, by offset
+					r.Post("/{uploadID}", api.CommitUpload)  // replace the file's contents with the upload
+					r.Delete("/{uploadID}", api.AbortUpload) // cancel an upload
+				})
 			})
 			r.Route("/i/all/{userID}", func(r chi.Router) {
 				r.Use(AllUsersFilesCtx)
@@ -124,14 +206,12 @@ func NewRouter() *chi.Mux {
 		})
 
 		// directories
-		// NOTE: Directories are not supported at this time, but we'll keep these
-		// endpoints in place for future iterations.
 		r.Route("/dirs", func(r chi.Router) {
 			// specific directories
 			r.Route("/{dirID}", func(r chi.Router) {
 				r.Use(DirCtx)
-				r.Get("/", api.GetDir)       // get a directory as a zip file
-				r.Put("/", api.PutDir)       // update a directory on the server by sending a zip file and unpacking
+				r.Get("/", api.GetDir)       // get a directory as an archive
+				r.Put("/", api.PutDir)       // update a directory's metadata. renames or moves the directory if needed
 				r.Delete("/", api.DeleteDir) // delete a directory
 			})
 			// create a new directory
@@ -159,7 +239,30 @@ func NewRouter() *chi.Mux {
 		// drives
 		r.Route("/drive/{driveID}", func(r chi.Router) {
 			r.Use(DriveCtx)
-			r.Get("/", api.GetDrive) // "home" page data for all user's files, directories, etc.
+			r.Get("/", api.GetDrive)           // "home" page data for all user's files, directories, etc.
+			r.Get("/usage", api.GetDriveUsage) // space usage by top-level directory
+			// update file version retention settings
+			r.Put("/versions", api.SetVersionRetention)
+			// change the checksum algorithm used for the drive's files
+			r.Put("/checksum", api.SetAlgorithm)
+			// turn on end-to-end encryption for the drive's files
+			r.Put("/e2ee", api.SetE2EE)
+			// recycle bin
+			r.Route("/trash", func(r chi.Router) {
+				r.Get("/", api.GetRecycleBin)                    // list deleted items
+				r.Put("/", api.SetTrashRetention)                // update retention period
+				r.Delete("/", api.EmptyRecycleBin)               // permanently remove all deleted items
+				r.Post("/{itemID}/restore", api.RestoreRecycled) // restore a deleted item
+			})
+			// point-in-time snapshots of the drive. see snapshots.go
+			r.Route("/snapshots", func(r chi.Router) {
+				r.Get("/", api.GetSnapshots)                         // list snapshots
+				r.Post("/", api.NewSnapshot)                         // take a snapshot
+				r.Put("/", api.SetSnapshotSchedule)                  // update the snapshot schedule
+				r.Get("/{snapshotID}/diff", api.DiffSnapshot)        // what changed since a snapshot
+				r.Post("/{snapshotID}/restore", api.RestoreSnapshot) // restore from a snapshot
+				r.Delete("/{snapshotID}", api.DeleteSnapshot)        // remove a snapshot
+			})
 			// NOTE: new drives are created when a new user is added.
 		})
 		// add a new drive
@@ -168,6 +271,12 @@ func NewRouter() *chi.Mux {
 			r.Post("/", api.NewDrive)
 		})
 
+		// archives of several files and directories at once
+		r.Post("/archive", api.NewArchive)
+
+		// content-addressed file contents
+		r.Get("/blobs/{checksum}", api.GetBlob) // check whether a drive has contents with a given checksum
+
 		// sync operations
 		r.Route("/sync/{driveID}", func(r chi.Router) {
 			r.Use(DriveIdCtx)
@@ -217,6 +326,7 @@ func adminRouter() http.Handler {
 			r.Get("/", api.GetUser)        // get info about a user
 			r.Put("/", api.Placeholder)    // update a user
 			r.Delete("/", api.Placeholder) // delete a user)
+			r.Put("/quota", api.SetQuota)  // set a user's storage quota
 
 		})
 		r.Route("/new", func(r chi.Router) {
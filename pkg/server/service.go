@@ -1,11 +1,16 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/sfs/pkg/auth"
@@ -13,8 +18,14 @@ import (
 	"github.com/sfs/pkg/logger"
 	logs "github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/storage"
+	"github.com/sfs/pkg/transfer"
 )
 
+// device ID used in file version vectors for changes made
+// on the server itself, i.e. restoring a previous version of a file.
+const ServerDeviceID = "server"
+
 /*
 Server-side SFS service instance.
 */
@@ -60,6 +71,17 @@ type Service struct {
 	// map of populated drives.
 	// key == userID, val == *svc.Drive
 	Drives map[string]*svc.Drive `json:"drives"`
+
+	// guards blob reference counts. see blobs.go
+	blobMu sync.Mutex
+
+	// guards the parts received for upload sessions, and the space
+	// reserved for them when new ones are opened. see uploads.go
+	uploadMu sync.Mutex
+
+	// where file contents are kept. see storage.go
+	store   storage.Store
+	storeMu sync.Mutex
 }
 
 // intialize a new empty service struct
@@ -181,68 +203,33 @@ func (s *Service) DriveExists(driveID string) bool {
 	return true
 }
 
-// Populate() populates a drive's root directory with all the users
-// files and subdirectories by recursively traersing the users server-side file system
-// and searching the DB with the name of each file or directory Populate() discoveres
+// Populate() populates a directory with all of its files and subdirectories.
 //
-// Note that Populate() ignores files and subdirectories it doesn't find in the
-// database as its traversing the file system.
+// the server doesn't keep a physical copy of the users directory tree (see
+// layout.go), so the tree is rebuilt from the directory and file entries in
+// the database, using their parent directory ids.
 func (s *Service) Populate(root *svc.Directory) *svc.Directory {
-	if root.Path == "" {
-		s.log.Error("can't traverse directory without a path")
+	if root.DriveID == "" {
+		s.log.Error("can't populate directory without a drive id")
 		return root
 	}
-	return s.populate(root)
-}
-
-func (s *Service) populate(dir *svc.Directory) *svc.Directory {
-	entries, err := os.ReadDir(dir.ServerPath)
+	dirs, err := s.Db.GetDirsByDriveID(root.DriveID)
 	if err != nil {
-		s.log.Error("can't read directory: " + dir.ServerPath)
-		return dir
-	}
-	if len(entries) == 0 {
-		return dir
+		s.log.Error(fmt.Sprintf("could not get directories from db: %v", err))
+		return root
 	}
-	for _, entry := range entries {
-		entryPath := filepath.Join(dir.ServerPath, entry.Name())
-		item, err := os.Stat(entryPath)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("could not get stat for entry %s: %v", entryPath, err))
-			continue
-		}
-		// add directory then recurse
-		if item.IsDir() {
-			subDir, err := s.Db.GetDirectoryByPath(entryPath)
-			if err != nil {
-				s.log.Error(fmt.Sprintf("could not get directory (%s) from db: %s", item.Name(), err))
-				continue
-			}
-			if subDir == nil {
-				continue // not found
-			}
-			subDir = s.populate(subDir)
-			dir.AddSubDir(subDir)
-		} else { // add file
-			file, err := s.Db.GetFileByPath(entryPath)
-			if err != nil {
-				s.log.Error(fmt.Sprintf("could not get file (%s) from db: %v", item.Name(), err))
-				continue
-			}
-			if file == nil {
-				continue // not found
-			}
-			if err := dir.AddFile(file); err != nil {
-				s.log.Error(fmt.Sprintf("could not add file (%s) to db: %v", file.Name, err))
-			}
-		}
+	files, err := s.Db.GetFilesByDriveID(root.DriveID)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("could not get files from db: %v", err))
+		return root
 	}
-	return dir
+	root.AttachTree(dirs, files)
+	return root
 }
 
-// recursively descends the drive's directory tree and compares what it
-// finds to what is in the database, adding new items as it goes. generates
-// a new root directory object and attaches it to the drive.
+// rebuilds the drive's directory tree from the database, importing anything
+// that was placed in the drive's root directory on the server since the last
+// refresh. generates a new root directory object and attaches it to the drive.
 func (s *Service) RefreshDrive(driveID string) error {
 	if s.HasDrive(driveID) {
 		// get current full drive state
@@ -255,8 +242,15 @@ func (s *Service) RefreshDrive(driveID string) error {
 			}
 			drive.Root = root
 		}
-		// refresh root against the database and create a new root object
-		drive.Root = s.refreshDrive(drive.Root)
+		// add anything new to the database, then create a new root object
+		if err := s.importDir(drive, drive.Root, s.buildImportPath(drive.OwnerName)); err != nil {
+			return fmt.Errorf("failed to import new items: %v", err)
+		}
+		root, err := s.loadRoot(drive.RootID)
+		if err != nil {
+			return fmt.Errorf("failed to load root (id=%s): %v", drive.RootID, err)
+		}
+		drive.Root = root
 		// save to service instance
 		s.Drives[drive.ID] = drive
 		if err := s.SaveState(); err != nil {
@@ -268,62 +262,6 @@ func (s *Service) RefreshDrive(driveID string) error {
 	return nil
 }
 
-func (s *Service) refreshDrive(dir *svc.Directory) *svc.Directory {
-	entries, err := os.ReadDir(dir.ServerPath)
-	if err != nil {
-		s.log.Error(fmt.Sprintf("failed to read directory: %v", err))
-		return dir
-	}
-	if len(entries) == 0 {
-		return dir
-	}
-	for _, entry := range entries {
-		entryPath := filepath.Join(dir.ServerPath, entry.Name())
-		item, err := os.Stat(entryPath)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("failed to get stat for entry %s: %v", entryPath, err))
-			return dir
-		}
-		// add directory then recurse
-		if item.IsDir() {
-			subDir, err := s.Db.GetDirectoryByName(item.Name())
-			if err != nil {
-				s.log.Error(fmt.Sprintf("failed to get directory (%s) from db: %v", item.Name(), err))
-				continue
-			}
-			// new directory
-			if subDir == nil {
-				subDir = svc.NewDirectory(item.Name(), dir.OwnerID, dir.DriveID, entryPath)
-				if err := s.Db.AddDir(subDir); err != nil {
-					s.log.Error(fmt.Sprintf("failed to add directory (%s) to db: %v", item.Name(), err))
-					continue
-				}
-				subDir = s.refreshDrive(subDir)
-				dir.AddSubDir(subDir)
-			}
-		} else {
-			file, err := s.Db.GetFileByName(item.Name())
-			if err != nil {
-				s.log.Error(fmt.Sprintf("failed to get file (%s) from db: %v", item.Name(), err))
-				continue
-			}
-			// new file
-			if file == nil {
-				newFile := svc.NewFile(item.Name(), dir.DriveID, dir.OwnerID, entryPath)
-				newFile.DirID = dir.ID
-				if err := s.Db.AddFile(newFile); err != nil {
-					s.log.Error(fmt.Sprintf("failed to add file (%s) to db: %v", item.Name(), err))
-					continue // TEMP until there's a better way to handle this error
-				}
-				if err := dir.AddFile(newFile); err != nil {
-					s.log.Error(fmt.Sprintf("failed to add file (%s) to service: %v", item.Name(), err))
-				}
-			}
-		}
-	}
-	return dir
-}
-
 // attempts to retrieve a drive from the drive map.
 // populates the drive if found.
 func (s *Service) GetDrive(driveID string) *svc.Drive {
@@ -384,19 +322,22 @@ func (s *Service) LoadDrive(driveID string) (*svc.Drive, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to load users directories: %v", err)
 	}
-	drive.Root.AddSubDirs(dirs)
+	if err := drive.AttachDirs(dirs); err != nil {
+		return nil, fmt.Errorf("failed to attach users directories: %v", err)
+	}
 	s.log.Log(logger.INFO, fmt.Sprintf("added %d directories to drive id=%s", len(dirs), driveID))
 
-	// add all users files
+	// add all users files to their directories
 	files, err := s.Db.GetFilesByDriveID(driveID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load users files: %v", err)
 	}
-	drive.Root.AddFiles(files)
+	if err := drive.AttachFiles(files); err != nil {
+		return nil, fmt.Errorf("failed to attach users files: %v", err)
+	}
 	s.log.Log(logger.INFO, fmt.Sprintf("added %d files to drive id=%s", len(files), driveID))
 
-	// populate the root directory and generate a new sync index
-	drive.Root = s.Populate(root)
+	// generate a new sync index
 	drive.SyncIndex = svc.BuildRootSyncIndex(drive.Root)
 
 	// save to service instance
@@ -495,9 +436,22 @@ func (s *Service) RemoveDrive(driveID string) error {
 	if err := Clean(drv.Root.Path); err != nil {
 		return fmt.Errorf("failed to remove drives files and directories: %v", err)
 	}
+	// along with anything the store holds for it
+	if drv.OwnerName != "" {
+		if err := s.deleteObjects(filepath.Join(s.svcCfgs.SvcRoot, "users", drv.OwnerName)); err != nil {
+			return fmt.Errorf("failed to remove drives files: %v", err)
+		}
+	}
+	// and any snapshots of it
+	if err := s.removeSnapshots(driveID); err != nil {
+		return err
+	}
 	// remove all files and directories from the database
 	files := drv.GetFilesMap()
 	for _, f := range files {
+		if err := s.releaseBlob(f.Blob); err != nil {
+			return err
+		}
 		if err := s.Db.RemoveFile(f.ID); err != nil {
 			return err
 		}
@@ -661,19 +615,60 @@ func (s *Service) UpdateUser(user *auth.User) error {
 	return nil
 }
 
-// ---------- files --------------------------------
+// ---------- quotas --------------------------------
 
-// find a file in the drive instance and return.
-func (s *Service) GetFile(driveID string, fileID string) (*svc.File, error) {
+// set the storage quota (in bytes) for a user's drive.
+func (s *Service) SetQuota(userID string, size int64) error {
+	user, err := s.FindUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user (id=%s) not found", userID)
+	}
+	drive, err := s.LoadDrive(user.DriveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if err := drive.SetQuota(size); err != nil {
+		return err
+	}
+	if err := s.UpdateDrive(drive); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("quota for drive (id=%s) set to %d bytes", drive.ID, size))
+	return nil
+}
+
+// get a drive's space usage, broken down by top-level directory.
+func (s *Service) GetUsage(driveID string) (*svc.DriveUsage, error) {
 	drive, err := s.LoadDrive(driveID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load drive: %v", err)
 	}
+	return drive.Usage(), nil
+}
+
+// ---------- files --------------------------------
+
+// find a file in the drive and return. files are looked up by id
+// in the database, so the drive's directory tree isn't loaded or searched.
+func (s *Service) GetFile(driveID string, fileID string) (*svc.File, error) {
+	drive, err := s.Db.GetDrive(driveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get drive: %v", err)
+	}
 	if drive == nil {
 		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
 	}
-	file := drive.GetFile(fileID)
-	if file == nil {
+	if drive.Protected {
+		return nil, fmt.Errorf("drive (id=%s) is protected", driveID)
+	}
+	file, err := s.Db.GetFileByID(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || file.DriveID != driveID {
 		return nil, fmt.Errorf("file (id=%s) not found", fileID)
 	}
 	return file, nil
@@ -695,7 +690,8 @@ func (s *Service) GetAllFiles(driveID string) (map[string]*svc.File, error) {
 	return files, nil
 }
 
-// generate a server-side path for a file or directory.
+// generate a server-side path for a directory. directories only exist as
+// metadata on the server, so nothing is created at this path. see layout.go
 func (s *Service) buildServerPath(user string, itemName string) string {
 	return filepath.Join(s.svcCfgs.SvcRoot, "users", user, "root", itemName)
 }
@@ -710,6 +706,9 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
 	}
+	if drive.E2EE && !sealedByClient(file) {
+		return errE2EE(file)
+	}
 	// make sure the files parent directory exists on the server
 	// first. if not, add to server-side sfs root.
 	dir, err := s.Db.GetDirectoryByID(dirID)
@@ -721,29 +720,41 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 		// parent directory isn't registered server-side yet.
 		file.DirID = drive.Root.ID
 	}
-	// modify file.ServerPath to point to the server
-	// side users root directory (or subdirectory if managed by the
-	// server side root directory). whenever something gets
+	// modify file.ServerPath to point to the file's place in the users
+	// server-side file store, which is derived from the file's id rather than
+	// its place in the directory tree. whenever something gets
 	// uploaded to the server we need to set a unique server path so we
 	// can differentiate between client and server upload/download locations.
 	// NOTE: client makes an additional call to retrieve this new path
-	file.ServerPath = s.buildServerPath(drive.OwnerName, file.Name)
+	file.ServerPath = s.buildFilePath(drive.OwnerName, file.ID)
 
-	// create the (empty) physical file on the server side
-	_, err = os.Create(file.ServerPath)
-	if err != nil {
-		return fmt.Errorf("failed to create empty file on server: %v", err)
+	// make sure there's room for this file before writing anything
+	size := file.Size
+	if int64(len(file.Content)) > size {
+		size = int64(len(file.Content))
+	}
+	if err := drive.CheckQuota(size, size); err != nil {
+		return err
 	}
 
-	// add any file contents to the server side
-	if err := os.WriteFile(file.ServerPath, file.Content, svc.PERMS); err != nil {
-		s.log.Error("failed to write file on server: " + err.Error())
+	// write out the file's contents (if any). this replaces anything
+	// already at this path rather than writing through it, so any blob
+	// it was linked to is left alone. see blobs.go
+	if err := s.putObject(file.ServerPath, bytes.NewReader(file.Content)); err != nil {
+		return fmt.Errorf("failed to write file on server: %v", err)
 	}
 
 	// mark this as a back up so we can access it using the correct path on the
 	// server side
 	file.MarkBackedUp()
 
+	// move the contents into the blob store. a blob the client copy
+	// referred to belongs to some other file, so don't release it.
+	file.Blob = ""
+	if err := s.commitBlob(file); err != nil {
+		return err
+	}
+
 	// add file to drive service
 	if err := drive.AddFile(file.DirID, file); err != nil {
 		return fmt.Errorf("failed to add file to drive: %v", err)
@@ -752,6 +763,9 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 	if err := s.Db.AddFile(file); err != nil {
 		return fmt.Errorf("failed to add file to database: %v", err)
 	}
+	if err := s.SaveDrive(drive); err != nil {
+		return err
+	}
 	if err := s.SaveState(); err != nil {
 		return fmt.Errorf("failed to save state: %v", err)
 	}
@@ -760,6 +774,68 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 
 // update a file in the service.
 func (s *Service) UpdateFile(file *svc.File, data []byte) error {
+	return s.updateFile(file, data, "")
+}
+
+// update a file in the service. checksum is the client's checksum for
+// data, which is kept as the file's checksum for end-to-end encrypted
+// drives, since the server can't calculate one itself. see e2ee.go
+func (s *Service) updateFile(file *svc.File, data []byte, checksum string) error {
+	return s.replaceContents(file, bytes.NewReader(data), int64(len(data)), checksum)
+}
+
+// wrap an upload to a file so it stops with a quota error as soon as it's
+// larger than the file's drive has room for, rather than after it's been
+// read in full. isNew is set for files that aren't on the drive yet.
+func (s *Service) limitUpload(file *svc.File, isNew bool, r io.Reader) (io.Reader, error) {
+	drive, err := s.LoadDrive(file.DriveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", file.DriveID)
+	}
+	var limit int64
+	if isNew {
+		limit = drive.FileQuota(nil)
+	} else {
+		limit = drive.FileQuota(file)
+	}
+	err = fmt.Errorf("%w: %s is larger than the %d bytes free", svc.ErrQuotaExceeded, file.Name, limit)
+	if limit == drive.TotalSize {
+		err = fmt.Errorf("%w: %s is larger than %d bytes", svc.ErrFileTooLarge, file.Name, limit)
+	}
+	return &quotaReader{r: r, n: limit, err: err}, nil
+}
+
+// reads at most n bytes, then fails with err if there's anything left
+type quotaReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if q.n < 0 {
+		return 0, q.err
+	}
+	// read one byte past the limit to find out if there's more
+	if int64(len(p)) > q.n+1 {
+		p = p[:q.n+1]
+	}
+	n, err := q.r.Read(p)
+	if int64(n) <= q.n {
+		q.n -= int64(n)
+		return n, err
+	}
+	n = int(q.n)
+	q.n = -1
+	return n, q.err
+}
+
+// replace a file's contents with size bytes read from r. the contents are
+// streamed to the store rather than held in memory.
+func (s *Service) replaceContents(file *svc.File, r io.Reader, size int64, checksum string) error {
 	drive, err := s.LoadDrive(file.DriveID)
 	if err != nil {
 		return fmt.Errorf("failed to load drive: %v", err)
@@ -772,45 +848,100 @@ func (s *Service) UpdateFile(file *svc.File, data []byte) error {
 	if dir == nil {
 		return fmt.Errorf("file's directory not found")
 	}
-	if err := dir.ModifyFile(file, data); err != nil {
+	if file.Protected {
+		return errLocked(file)
+	}
+	if drive.E2EE && svc.ChecksumAlgorithm(checksum) != transfer.MACAlgorithm {
+		return errE2EE(file)
+	}
+	if err := drive.CheckFileQuota(file, size); err != nil {
+		return err
+	}
+	// keep a copy of the current contents before overwriting. contents
+	// from before a drive was end-to-end encrypted aren't kept.
+	if !drive.E2EE || sealedByClient(file) {
+		if err := s.saveVersion(drive, file); err != nil {
+			return err
+		}
+	}
+	var origSize = file.Size
+	h, err := svc.NewHash(blobAlgorithm(file))
+	if err != nil {
+		return err
+	}
+	if err := s.putObject(file.ServerPath, io.TeeReader(r, h)); err != nil {
+		return fmt.Errorf("failed to write file on server: %v", err)
+	}
+	if drive.E2EE {
+		file.CheckSum = checksum
+		file.Algorithm = transfer.MACAlgorithm
+	} else {
+		file.CheckSum = svc.FormatChecksum(blobAlgorithm(file), h.Sum(nil))
+	}
+	file.Size = size
+	file.LastSync = time.Now().UTC()
+	if err := s.commitBlob(file); err != nil {
+		return err
+	}
+	dir.Size += file.Size - origSize
+	drive.UpdateDriveSize(file.Size - origSize)
+	if err := dir.PutFile(file); err != nil {
 		return err
 	}
 	if err := s.Db.UpdateFile(file); err != nil {
 		return err
 	}
+	if err := s.SaveDrive(drive); err != nil {
+		return err
+	}
 	if err := s.SaveState(); err != nil {
 		return fmt.Errorf("failed to save state: %v", err)
 	}
 	return nil
 }
 
-// soft-deletes a file in the service. uses the users drive to
-// delete the original copy of the file, moves the copy to the recycle bin,
-// and updates database.
-func (s *Service) DeleteFile(file *svc.File) error {
-	drive, err := s.LoadDrive(file.DriveID)
-	if err != nil {
-		return fmt.Errorf("failed to load drive: %v", err)
-	}
-	if drive == nil {
-		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
+// check the version of a file sent by a client against the server's copy.
+// updates made without seeing the latest version on the server are rejected.
+// accepted versions are merged into the file's version vector, which is
+// saved along with the rest of the file's metadata by the update.
+//
+// clients that don't send a version (older clients) are always accepted.
+func (s *Service) AcceptVersion(file *svc.File, version svc.VersionVector) error {
+	if len(version) == 0 {
+		return nil
 	}
-	// remove physical file from original location on the server.
-	// NOTE: client side will have the original file moved to the client's recycle bin.
-	if err := drive.RemoveFile(file.DirID, file); err != nil {
-		return fmt.Errorf("failed to remove %s (id=%s)s from drive: %v", file.Name, file.ID, err)
+	switch version.Compare(file.Version) {
+	case svc.Before:
+		return fmt.Errorf("version conflict: %s (id=%s) has been updated since this version", file.Name, file.ID)
+	case svc.Concurrent:
+		return fmt.Errorf("version conflict: %s (id=%s) was modified on another device", file.Name, file.ID)
 	}
-	if err := s.Db.RemoveFile(file.ID); err != nil {
-		return fmt.Errorf("failed to remove %s (id=%s) from database: %v", file.Name, file.ID, err)
+	file.MergeVersion(version)
+	return nil
+}
+
+// check the version of a directory sent by a client against the server's copy.
+// renames and moves made without seeing the latest version on the server are
+// rejected. accepted versions are merged into the directory's version vector.
+//
+// clients that don't send a version (older clients) are always accepted.
+func (s *Service) AcceptDirVersion(dir *svc.Directory, version svc.VersionVector) error {
+	if len(version) == 0 {
+		return nil
 	}
-	if err := s.SaveState(); err != nil {
-		return fmt.Errorf("failed to save state: %v", err)
+	switch version.Compare(dir.Version) {
+	case svc.Before:
+		return fmt.Errorf("version conflict: %s (id=%s) has been renamed or moved since this version", dir.Name, dir.ID)
+	case svc.Concurrent:
+		return fmt.Errorf("version conflict: %s (id=%s) was renamed or moved on another device", dir.Name, dir.ID)
 	}
+	dir.MergeVersion(version)
 	return nil
 }
 
-// move a file from one directory to another.
-func (s *Service) CopyFile(destDirID string, file *svc.File, keepOrig bool) error {
+// update a file on the server by rebuilding it from the current server-side
+// copy and a delta sent by the client. only the changed blocks are sent over the wire.
+func (s *Service) ApplyFileDelta(file *svc.File, delta *transfer.Delta) error {
 	drive, err := s.LoadDrive(file.DriveID)
 	if err != nil {
 		return fmt.Errorf("failed to load drive: %v", err)
@@ -818,161 +949,887 @@ func (s *Service) CopyFile(destDirID string, file *svc.File, keepOrig bool) erro
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
 	}
-	// move file
-	origDir := drive.GetDir(file.DirID)
-	if origDir == nil {
-		return fmt.Errorf("original directory for file not found. dir id=%s", file.DirID)
+	dir := drive.GetDir(file.DirID)
+	if dir == nil {
+		return fmt.Errorf("file's directory not found")
 	}
-	destDir := drive.GetDir(destDirID)
-	if destDir == nil {
-		return fmt.Errorf("destination directory (id=%s) not found", destDirID)
+	if dir.Protected {
+		return fmt.Errorf("directory %s (id=%s) locked", dir.Name, dir.ID)
 	}
-	// copy physical file first. updating in destDir will change
-	// the file object's original path before we need move it.
-	if err := file.Copy(filepath.Join(destDir.Path, file.Name)); err != nil {
+	if file.Protected {
+		return errLocked(file)
+	}
+	if drive.E2EE {
+		return errE2EE(file)
+	}
+	if err := drive.CheckFileQuota(file, delta.Size); err != nil {
 		return err
 	}
-	// add file object to destination directory
-	if err := destDir.AddFile(file); err != nil {
-		return fmt.Errorf("failed to add file to destination directory: %v", err)
+	if err := s.saveVersion(drive, file); err != nil {
+		return err
 	}
-	if !keepOrig {
-		// remove physical file from original directory
-		// (also deletes original physical file)
-		if err := origDir.RemoveFile(file.ID); err != nil {
-			return err
-		}
+	var origSize = file.Size
+	if err := s.applyDelta(file, delta); err != nil {
+		return err
 	}
-	// update directory dbs
-	if err := s.Db.UpdateDir(origDir); err != nil {
+	if err := s.commitBlob(file); err != nil {
 		return err
 	}
-	if err := s.Db.UpdateDir(destDir); err != nil {
+	file.Size = delta.Size
+	dir.Size += file.Size - origSize
+	drive.UpdateDriveSize(file.Size - origSize)
+	if err := dir.PutFile(file); err != nil {
 		return err
 	}
 	if err := s.Db.UpdateFile(file); err != nil {
 		return err
 	}
-	return nil
-}
-
-// --------- directories --------------------------------
-
-// find a directory in the database. does not populate with files or subdirectories,
-// just returns metadata.
-func (s *Service) FindDir(driveID string, dirID string) (*svc.Directory, error) {
-	drive := s.GetDrive(driveID)
-	if drive == nil {
-		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	if err := s.SaveDrive(drive); err != nil {
+		return err
 	}
-	dir := drive.GetDir(dirID)
-	if dir == nil {
-		return nil, fmt.Errorf("directory (id=%s) not found", dirID)
+	if err := s.SaveState(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
 	}
-	return dir, nil
+	s.log.Info(fmt.Sprintf("%s updated with delta. %d of %d bytes sent", file.Name, delta.DataSize(), delta.Size))
+	return nil
 }
 
-// add a sub-directory to the given drive directory.
-// makes a physical directory for this new directory object
-// and updates the database.
-func (s *Service) NewDir(driveID string, destDirID string, newDir *svc.Directory) error {
-	drive := s.GetDrive(driveID)
-	if drive == nil {
-		return fmt.Errorf("drive (id=%s) not found", driveID)
+// rebuild a file's contents from its current contents and a delta. the new
+// contents are verified before they replace the old ones. updates the
+// file's checksum, but not its size or anything else.
+func (s *Service) applyDelta(file *svc.File, delta *transfer.Delta) error {
+	var base io.ReaderAt
+	if delta.HasCopyOps() {
+		f, err := s.openObject(file.ServerPath)
+		if err != nil {
+			return fmt.Errorf("failed to open base file: %v", err)
+		}
+		defer f.Close()
+		base = f
 	}
-	// check if the parent directory exists on the server. if not, add to root.
-	var id string
-	dir, err := s.Db.GetDirectoryByID(destDirID)
+	tmp, err := os.CreateTemp("", "sfs-delta-*")
 	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := transfer.ApplyDeltaTo(tmp, base, delta); err != nil {
 		return err
 	}
-	if dir != nil {
-		id = dir.ID
-		newDir.Parent = dir
-	} else {
-		id = drive.RootID
-		newDir.Parent = drive.Root
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
 	}
-	// add directory to service. creates physical directory.
-	if err := drive.AddSubDir(id, newDir); err != nil {
+	cs, err := svc.ChecksumOf(tmp, blobAlgorithm(file))
+	if err != nil {
 		return err
 	}
-	if err := s.Db.AddDir(newDir); err != nil {
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
+	if err := s.putObject(file.ServerPath, tmp); err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+	file.CheckSum = cs
+	file.LastSync = time.Now().UTC()
 	return nil
 }
 
-// remove a physical directory from a user's drive service.
-// use with caution! will remove all children of this subdirectory
-// as well.
-//
-// it's assumed dirID is a sub-directory within the drive, and not
-// the drives root directory itself.
-func (s *Service) RemoveDir(driveID string, dirID string) error {
-	drive := s.GetDrive(driveID)
-	if drive == nil {
-		return fmt.Errorf("drive (id=%s) not found", driveID)
-	}
-	// just to be sure
-	if dirID == drive.Root.ID {
-		return fmt.Errorf("dir (id=%s) is drive root. cant remove root", dirID)
-	}
-	// get the directory to be removed, as well as all potential children.
-	// we'll need to remove all of them from the DB.
-	dir := drive.GetDir(dirID)
-	if dir == nil {
-		return fmt.Errorf("dir (id=%s) not found", dirID)
+// generate a block signature of the server's copy of a file. returns an
+// empty signature if the server doesn't have any contents for it yet.
+func (s *Service) FileSignature(file *svc.File) (*transfer.Signature, error) {
+	if file.Encrypted() {
+		return nil, errLocked(file)
 	}
-	// remove all subdirs of this directory from the db
-	subDirs := dir.GetDirMap()
-	for _, subDir := range subDirs {
-		if err := drive.RemoveDir(subDir.ID); err != nil {
-			return err
-		}
-		if err := s.Db.RemoveDirectory(subDir.ID); err != nil {
-			return err
-		}
+	if e2ee, err := s.isE2EE(file); err != nil {
+		return nil, err
+	} else if e2ee {
+		return nil, errE2EE(file)
 	}
-	// remove all files from db
-	files := dir.GetFiles()
-	for _, file := range files {
-		if err := drive.RemoveFile(file.DirID, file); err != nil {
-			return err
-		}
-		if err := s.Db.RemoveFile(file.ID); err != nil {
-			return err
-		}
+	r, err := s.getObject(file.ServerPath)
+	if errors.Is(err, storage.ErrNotExist) {
+		return &transfer.Signature{FileID: file.ID, Blocks: make([]*transfer.Block, 0)}, nil
+	} else if err != nil {
+		return nil, err
 	}
-	// remove directory itself
-	if err := s.Db.RemoveDirectory(dirID); err != nil {
-		return fmt.Errorf("failed to remove directory from database: %v", err)
+	defer r.Close()
+	return transfer.SignatureFrom(file.ID, r)
+}
+
+// build a delta of the server's copy of a file against a signature
+func (s *Service) FileDelta(file *svc.File, sig *transfer.Signature) (*transfer.Delta, error) {
+	if file.Encrypted() {
+		return nil, errLocked(file)
 	}
-	if err := s.Db.UpdateDir(drive.Root); err != nil {
-		return fmt.Errorf("failed to update root directory: %v", err)
+	if e2ee, err := s.isE2EE(file); err != nil {
+		return nil, err
+	} else if e2ee {
+		return nil, errE2EE(file)
 	}
-	if err := drive.RemoveDir(dirID); err != nil {
-		return fmt.Errorf("failed to remove dir %s: %v", dirID, err)
+	r, err := s.getObject(file.ServerPath)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	defer r.Close()
+	return transfer.DeltaFrom(sig, r)
 }
 
-// update a directory within a drive.
-func (s *Service) UpdateDir(driveID string, dir *svc.Directory) error {
-	drive := s.GetDrive(driveID)
-	if drive == nil {
-		return fmt.Errorf("drive (id=%s) not found", driveID)
+// open the server's copy of a file's contents. locked files
+// are decrypted with password, and can't be opened without it.
+func (s *Service) OpenFile(file *svc.File, password string) (storage.File, error) {
+	if !file.Encrypted() {
+		return s.openObject(file.ServerPath)
 	}
-	if err := drive.UpdateDir(dir.ID, dir); err != nil {
-		return fmt.Errorf("failed to update dir %s (id=%s): %v", dir.Name, dir.ID, err)
+	if password == "" {
+		return nil, errLocked(file)
 	}
-	if err := s.Db.UpdateDir(dir); err != nil {
-		return fmt.Errorf("failed update dir %s (id=%s) in database: %v", dir.Name, dir.ID, err)
+	key, err := file.DataKey(password)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return s.openDecrypted(file, key)
 }
 
-// retrieves all directories available for user using the current drive state.
+// remove a file from a drive and its contents from the server.
+// doesn't release the file's blob.
+func (s *Service) removeFile(drive *svc.Drive, file *svc.File) error {
+	if err := drive.DetachFile(file.DirID, file); err != nil {
+		return err
+	}
+	return s.deleteObject(file.ServerPath)
+}
+
+// soft-deletes a file in the service. uses the users drive to
+// delete the original copy of the file, moves the copy to the recycle bin,
+// and leaves a tombstone in the database so other devices will delete it too.
+// deviceID is the device the file was deleted on.
+func (s *Service) DeleteFile(file *svc.File, deviceID string) error {
+	drive, err := s.LoadDrive(file.DriveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
+	}
+	if err := s.deleteFile(drive, file, deviceID); err != nil {
+		return err
+	}
+	if err := s.SaveDrive(drive); err != nil {
+		return err
+	}
+	if err := s.SaveState(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// soft-delete a file in a drive. doesn't save the drive.
+func (s *Service) deleteFile(drive *svc.Drive, file *svc.File, deviceID string) error {
+	// keep a copy in the recycle bin so it can be restored later.
+	// NOTE: client side will have the original file moved to the client's recycle bin.
+	if err := s.recycleFile(drive, file); err != nil {
+		return fmt.Errorf("failed to move %s (id=%s) to recycle bin: %v", file.Name, file.ID, err)
+	}
+	// remove the file from the drive, then its contents from the server.
+	if err := s.removeFile(drive, file); err != nil {
+		return fmt.Errorf("failed to remove %s (id=%s)s from drive: %v", file.Name, file.ID, err)
+	}
+	if err := s.releaseBlob(file.Blob); err != nil {
+		return err
+	}
+	if err := s.Db.TombstoneFile(file.ID, deviceID, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to remove %s (id=%s) from database: %v", file.Name, file.ID, err)
+	}
+	return nil
+}
+
+// move a file from one directory to another. if keepOrig is set, a copy
+// is made in the destination directory instead. copies get their own id,
+// and share the original's contents in the blob store.
+func (s *Service) CopyFile(destDirID string, file *svc.File, keepOrig bool) error {
+	drive, err := s.LoadDrive(file.DriveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
+	}
+	origDir := drive.GetDir(file.DirID)
+	if origDir == nil {
+		return fmt.Errorf("original directory for file not found. dir id=%s", file.DirID)
+	}
+	destDir := drive.GetDir(destDirID)
+	if destDir == nil {
+		return fmt.Errorf("destination directory (id=%s) not found", destDirID)
+	}
+	if keepOrig {
+		// copies take up additional space
+		if err := drive.CheckQuota(file.Size, file.Size); err != nil {
+			return err
+		}
+		cp := file.Duplicate()
+		cp.ClientPath = filepath.Join(destDir.ClientPath, file.Name)
+		cp.Path = cp.ClientPath
+		cp.ServerPath = s.buildFilePath(drive.OwnerName, cp.ID)
+		cp.MarkBackedUp()
+		if err := s.copyObject(file.ServerPath, cp.ServerPath); err != nil {
+			return err
+		}
+		// the copy holds its own reference to the original's blob
+		cp.Blob = ""
+		if err := s.commitBlob(cp); err != nil {
+			return err
+		}
+		if err := drive.AddFile(destDir.ID, cp); err != nil {
+			return fmt.Errorf("failed to add file to destination directory: %v", err)
+		}
+		if err := s.Db.AddFile(cp); err != nil {
+			return err
+		}
+	} else {
+		// files are stored by id, so moving one only changes its metadata
+		if err := origDir.DetachFile(file.ID); err != nil {
+			return err
+		}
+		if err := destDir.AddFile(file); err != nil {
+			return fmt.Errorf("failed to add file to destination directory: %v", err)
+		}
+		if err := s.Db.UpdateFile(file); err != nil {
+			return err
+		}
+	}
+	// update directory dbs
+	if err := s.Db.UpdateDir(origDir); err != nil {
+		return err
+	}
+	if err := s.Db.UpdateDir(destDir); err != nil {
+		return err
+	}
+	return s.SaveDrive(drive)
+}
+
+// --------- file versions --------------------------------
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// path to a file's version history in the drive's state directory
+func (s *Service) buildVersionPath(user string, fileID string, rev int) string {
+	return filepath.Join(s.svcCfgs.SvcRoot, "users", user, "state", "versions", fileID, strconv.Itoa(rev))
+}
+
+// copy the current server-side contents of a file to its version
+// history, then remove any versions that fall outside of the drive's
+// retention settings. no-op if there's no server-side copy yet.
+func (s *Service) saveVersion(drive *svc.Drive, file *svc.File) error {
+	if ok, err := s.objectExists(file.ServerPath); err != nil {
+		return err
+	} else if !ok {
+		return nil
+	}
+	latest, err := s.Db.GetLatestRev(file.ID)
+	if err != nil {
+		return err
+	}
+	verPath := s.buildVersionPath(drive.OwnerName, file.ID, latest+1)
+	if err := s.copyObject(file.ServerPath, verPath); err != nil {
+		return fmt.Errorf("failed to save version of %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	if err := s.Db.AddVersion(svc.NewVersion(file, latest+1, verPath)); err != nil {
+		return fmt.Errorf("failed to add version to database: %v", err)
+	}
+	return s.pruneVersions(drive, file.ID)
+}
+
+// remove any versions of a file that fall outside of the drive's retention settings
+func (s *Service) pruneVersions(drive *svc.Drive, fileID string) error {
+	versions, err := s.Db.GetVersions(fileID)
+	if err != nil {
+		return err
+	}
+	for _, v := range svc.PruneVersions(versions, drive.MaxVersions, drive.VersionMaxAge) {
+		if err := s.deleteObject(v.Path); err != nil {
+			return fmt.Errorf("failed to remove version %d of file (id=%s): %v", v.Rev, fileID, err)
+		}
+		if err := s.Db.RemoveVersion(v.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove all saved versions of a file
+func (s *Service) removeVersions(fileID string) error {
+	versions, err := s.Db.GetVersions(fileID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := s.deleteObject(v.Path); err != nil {
+			return fmt.Errorf("failed to remove version %d of file (id=%s): %v", v.Rev, fileID, err)
+		}
+		if err := s.Db.RemoveVersion(v.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get all saved versions of a file, newest first.
+func (s *Service) GetVersions(file *svc.File) ([]*svc.Version, error) {
+	return s.Db.GetVersions(file.ID)
+}
+
+// restore a file to a previous revision. the current contents are saved
+// as a new version first, so a restore can itself be undone.
+func (s *Service) RestoreVersion(file *svc.File, rev int) error {
+	v, err := s.Db.GetVersion(file.ID, rev)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return fmt.Errorf("version %d of %s (id=%s): %w", rev, file.Name, file.ID, ErrVersionNotFound)
+	}
+	data, err := s.readObject(v.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read version %d of %s: %v", rev, file.Name, err)
+	}
+	if err := s.updateFile(file, data, v.CheckSum); err != nil {
+		return err
+	}
+	// UpdateFile only writes out the contents, so make
+	// sure the file's metadata reflects the restored version
+	file.CheckSum = v.CheckSum
+	file.Size = v.Size
+	file.LastSync = time.Now().UTC()
+	file.IncrementVersion(ServerDeviceID)
+	if err := s.Db.UpdateFile(file); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("%s (id=%s) restored to version %d", file.Name, file.ID, rev))
+	return nil
+}
+
+// update a drive's file version retention settings and remove
+// any versions that no longer fall within them.
+func (s *Service) SetVersionRetention(driveID string, maxVersions int, maxAge time.Duration) error {
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	drive.MaxVersions = maxVersions
+	drive.VersionMaxAge = maxAge
+	if err := s.UpdateDrive(drive); err != nil {
+		return err
+	}
+	for _, file := range drive.GetFiles() {
+		if err := s.pruneVersions(drive, file.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --------- recycle bin --------------------------------
+
+// how often expired items are purged from recycle bins
+const PurgeInterval = time.Hour
+
+var (
+	ErrRecycledNotFound = errors.New("not found in recycle bin")
+	ErrRestoreConflict  = errors.New("already exists")
+)
+
+// path to an item in a user's recycle bin
+func (s *Service) buildRecyclePath(user string, itemID string) string {
+	return filepath.Join(s.svcCfgs.SvcRoot, "users", user, "recycled", itemID)
+}
+
+// copy a file to the drive's recycle bin and add it to the recycle bin database.
+// does not remove the original.
+func (s *Service) recycleFile(drive *svc.Drive, file *svc.File) error {
+	binPath := s.buildRecyclePath(drive.OwnerName, file.ID)
+	if err := s.copyObject(file.ServerPath, binPath); err != nil {
+		return err
+	}
+	item, err := svc.NewRecycledFile(file, binPath)
+	if err != nil {
+		return err
+	}
+	return s.Db.AddRecycled(item)
+}
+
+// copy all files in a directory (and its subdirectories) to the drive's recycle bin,
+// and add the directory to the recycle bin database. does not remove the original.
+func (s *Service) recycleDir(drive *svc.Drive, dir *svc.Directory) error {
+	binPath := s.buildRecyclePath(drive.OwnerName, dir.ID)
+	// files are stored by id since names aren't unique across directories
+	for _, file := range dir.GetFiles() {
+		if err := s.copyObject(file.ServerPath, filepath.Join(binPath, file.ID)); err != nil {
+			return err
+		}
+	}
+	parentID := drive.RootID
+	if dir.Parent != nil {
+		parentID = dir.Parent.ID
+	}
+	item, err := svc.NewRecycledDir(dir, parentID, binPath)
+	if err != nil {
+		return err
+	}
+	return s.Db.AddRecycled(item)
+}
+
+// remove an item's contents from the recycle bin
+func (s *Service) deleteRecycled(item *svc.RecycledItem) error {
+	if item.IsDir {
+		return s.deleteObjects(item.BinPath)
+	}
+	return s.deleteObject(item.BinPath)
+}
+
+// get all items in a drive's recycle bin, most recently deleted first.
+func (s *Service) GetRecycled(driveID string) ([]*svc.RecycledItem, error) {
+	return s.Db.GetRecycledItems(driveID)
+}
+
+// restore a file or directory from the recycle bin to the directory it
+// was deleted from. if that directory no longer exists, the item is
+// restored to the drive's root directory instead.
+func (s *Service) RestoreRecycled(driveID string, itemID string) (*svc.RecycledItem, error) {
+	item, err := s.Db.GetRecycled(itemID)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil || item.DriveID != driveID {
+		return nil, fmt.Errorf("item (id=%s) %w", itemID, ErrRecycledNotFound)
+	}
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	if err := drive.CheckQuota(item.Size, item.Size); err != nil {
+		return nil, err
+	}
+	parent := drive.GetDir(item.DirID)
+	if parent == nil {
+		s.log.Warn(fmt.Sprintf("original directory (id=%s) for %s not found. restoring to root", item.DirID, item.Name))
+		parent = drive.Root
+	}
+	if item.IsDir {
+		err = s.restoreDir(drive, parent, item)
+	} else {
+		err = s.restoreFile(drive, parent, item)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deleteRecycled(item); err != nil {
+		return nil, fmt.Errorf("failed to remove %s from recycle bin: %v", item.Name, err)
+	}
+	if err := s.Db.RemoveRecycled(item.ID); err != nil {
+		return nil, err
+	}
+	if err := s.SaveDrive(drive); err != nil {
+		return nil, err
+	}
+	if err := s.SaveState(); err != nil {
+		return nil, fmt.Errorf("failed to save state: %v", err)
+	}
+	s.log.Info(fmt.Sprintf("%s (id=%s) restored from recycle bin", item.Name, item.ID))
+	return item, nil
+}
+
+func (s *Service) restoreFile(drive *svc.Drive, parent *svc.Directory, item *svc.RecycledItem) error {
+	file, err := item.File()
+	if err != nil {
+		return err
+	}
+	if f, err := s.Db.GetFileByID(file.ID); err != nil {
+		return err
+	} else if f != nil {
+		return fmt.Errorf("file %s (id=%s) %w", file.Name, file.ID, ErrRestoreConflict)
+	}
+	// files deleted before the server switched to storing
+	// files by id are restored to the new layout
+	file.ServerPath = s.buildFilePath(drive.OwnerName, file.ID)
+	if ok, err := s.objectExists(file.ServerPath); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("can't restore %s. a file %w at %s", file.Name, ErrRestoreConflict, file.ServerPath)
+	}
+	if err := s.copyObject(item.BinPath, file.ServerPath); err != nil {
+		return err
+	}
+	// the blob the file referred to was released when it was deleted
+	file.Blob = ""
+	if err := s.commitBlob(file); err != nil {
+		return err
+	}
+	if err := drive.AddFile(parent.ID, file); err != nil {
+		return fmt.Errorf("failed to add file to drive: %v", err)
+	}
+	if err := s.Db.AddFile(file); err != nil {
+		return fmt.Errorf("failed to add file to database: %v", err)
+	}
+	return nil
+}
+
+func (s *Service) restoreDir(drive *svc.Drive, parent *svc.Directory, item *svc.RecycledItem) error {
+	dir, err := item.Dir()
+	if err != nil {
+		return err
+	}
+	if d, err := s.Db.GetDirectoryByID(dir.ID); err != nil {
+		return err
+	} else if d != nil {
+		return fmt.Errorf("directory %s (id=%s) %w", dir.Name, dir.ID, ErrRestoreConflict)
+	}
+	dirs := dir.WalkDs()
+	dirs[dir.ID] = dir
+	files := dir.GetFiles()
+	for _, file := range files {
+		file.ServerPath = s.buildFilePath(drive.OwnerName, file.ID)
+		if err := s.copyObject(filepath.Join(item.BinPath, file.ID), file.ServerPath); err != nil {
+			return err
+		}
+		file.Blob = ""
+		if err := s.commitBlob(file); err != nil {
+			return err
+		}
+	}
+	if err := drive.AddSubDir(parent.ID, dir); err != nil {
+		return fmt.Errorf("failed to add directory to drive: %v", err)
+	}
+	for _, d := range dirs {
+		if err := s.Db.AddDir(d); err != nil {
+			return fmt.Errorf("failed to add directory to database: %v", err)
+		}
+	}
+	if err := s.Db.AddFiles(files); err != nil {
+		return fmt.Errorf("failed to add files to database: %v", err)
+	}
+	return nil
+}
+
+// permanently remove an item from the recycle bin, along with
+// any saved versions of the file(s) it contains.
+func (s *Service) purgeRecycled(item *svc.RecycledItem) error {
+	var fileIDs []string
+	if item.IsDir {
+		dir, err := item.Dir()
+		if err != nil {
+			return err
+		}
+		for _, file := range dir.GetFiles() {
+			fileIDs = append(fileIDs, file.ID)
+		}
+	} else {
+		fileIDs = append(fileIDs, item.ID)
+	}
+	for _, fileID := range fileIDs {
+		if err := s.removeVersions(fileID); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteRecycled(item); err != nil {
+		return fmt.Errorf("failed to remove %s from recycle bin: %v", item.Name, err)
+	}
+	return s.Db.RemoveRecycled(item.ID)
+}
+
+// permanently remove everything in a drive's recycle bin.
+func (s *Service) EmptyRecycleBin(driveID string) error {
+	items, err := s.Db.GetRecycledItems(driveID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := s.purgeRecycled(item); err != nil {
+			return err
+		}
+	}
+	s.log.Info(fmt.Sprintf("recycle bin emptied for drive (id=%s). %d items removed", driveID, len(items)))
+	return nil
+}
+
+// permanently remove any items from a drive's recycle bin that are
+// older than the drive's retention period.
+func (s *Service) purgeExpired(drive *svc.Drive) error {
+	items, err := s.Db.GetRecycledItems(drive.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if !item.Expired(drive.TrashRetention) {
+			continue
+		}
+		if err := s.purgeRecycled(item); err != nil {
+			return err
+		}
+		s.log.Info(fmt.Sprintf("%s (id=%s) purged from recycle bin", item.Name, item.ID))
+	}
+	return nil
+}
+
+// permanently remove expired items from every drive's recycle bin,
+// along with any tombstones every device has seen.
+func (s *Service) PurgeRecycled() error {
+	drives, err := s.Db.GetDrives()
+	if err != nil {
+		return err
+	}
+	for _, drive := range drives {
+		if err := s.purgeExpired(drive); err != nil {
+			return fmt.Errorf("failed to purge recycle bin for drive (id=%s): %v", drive.ID, err)
+		}
+		if err := s.purgeTombstones(drive.ID); err != nil {
+			return fmt.Errorf("failed to purge tombstones for drive (id=%s): %v", drive.ID, err)
+		}
+	}
+	return nil
+}
+
+// periodically purge expired items from all recycle bins, and
+// tombstones that every device has seen.
+// should be run in its own goroutine.
+func (s *Service) RunPurge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.PurgeRecycled(); err != nil {
+			s.log.Error(err.Error())
+		}
+		<-ticker.C
+	}
+}
+
+// update how long deleted items are kept in a drive's recycle bin.
+// anything older than the new retention period is removed immediately.
+func (s *Service) SetTrashRetention(driveID string, retention time.Duration) error {
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	drive.TrashRetention = retention
+	if err := s.UpdateDrive(drive); err != nil {
+		return err
+	}
+	return s.purgeExpired(drive)
+}
+
+// --------- checksums --------------------------------
+
+// change the checksum algorithm used by a drive and
+// recalculate the checksums of all of its files.
+func (s *Service) SetAlgorithm(driveID string, algo string) error {
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	if drive.E2EE {
+		return fmt.Errorf("checksums for drive (id=%s) are keyed by its clients: %w", driveID, ErrE2EE)
+	}
+	updated, err := drive.SetAlgorithm(algo)
+	if err != nil {
+		return err
+	}
+	for _, file := range updated {
+		// files are stored by checksum, so move them to their new blobs
+		if err := s.commitBlob(file); err != nil {
+			return err
+		}
+		if err := s.Db.UpdateFile(file); err != nil {
+			return fmt.Errorf("failed to update file database: %v", err)
+		}
+	}
+	if err := s.UpdateDrive(drive); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("drive (id=%s) now uses %s checksums. %d files updated", drive.ID, algo, len(updated)))
+	return nil
+}
+
+// --------- directories --------------------------------
+
+// find a directory in the database. does not populate with files or subdirectories,
+// just returns metadata.
+func (s *Service) FindDir(driveID string, dirID string) (*svc.Directory, error) {
+	drive := s.GetDrive(driveID)
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	dir := drive.GetDir(dirID)
+	if dir == nil {
+		return nil, fmt.Errorf("directory (id=%s) not found", dirID)
+	}
+	return dir, nil
+}
+
+// add a sub-directory to the given drive directory and update the database.
+// directories only exist as metadata on the server, so no physical
+// directory is created.
+func (s *Service) NewDir(driveID string, destDirID string, newDir *svc.Directory) error {
+	drive := s.GetDrive(driveID)
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	// check if the parent directory exists on the server. if not, add to root.
+	parent := drive.GetDir(destDirID)
+	if parent == nil {
+		parent = drive.Root
+	}
+	// the path sent by the client only makes sense on the client
+	newDir.ServerPath = s.dirServerPath(drive, parent, newDir.Name)
+	newDir.Path = newDir.ServerPath
+	if newDir.Dirs == nil {
+		newDir.Dirs = make(map[string]*svc.Directory, 0)
+	}
+	if newDir.Files == nil {
+		newDir.Files = make(map[string]*svc.File, 0)
+	}
+	lastSync := newDir.LastSync
+	if err := drive.AddSubDir(parent.ID, newDir); err != nil {
+		return err
+	}
+	// keep the clients sync time so the directory isn't seen as changed
+	if !lastSync.IsZero() {
+		newDir.LastSync = lastSync
+	}
+	if err := s.Db.AddDir(newDir); err != nil {
+		return err
+	}
+	return nil
+}
+
+// server-side path for a directory under the given parent.
+// top-level directories live alongside the users files in their root directory.
+func (s *Service) dirServerPath(drive *svc.Drive, parent *svc.Directory, name string) string {
+	if parent.IsRoot() {
+		return s.buildServerPath(drive.OwnerName, name)
+	}
+	return filepath.Join(parent.ServerPath, name)
+}
+
+// remove a physical directory from a user's drive service.
+// use with caution! will remove all children of this subdirectory
+// as well.
+//
+// it's assumed dirID is a sub-directory within the drive, and not
+// the drives root directory itself. deviceID is the device the
+// directory was deleted on.
+func (s *Service) RemoveDir(driveID string, dirID string, deviceID string) error {
+	drive := s.GetDrive(driveID)
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	// just to be sure
+	if dirID == drive.Root.ID {
+		return fmt.Errorf("dir (id=%s) is drive root. cant remove root", dirID)
+	}
+	// get the directory to be removed, as well as all potential children.
+	// we'll need to remove all of them from the DB.
+	dir := drive.GetDir(dirID)
+	if dir == nil {
+		return fmt.Errorf("dir (id=%s) not found", dirID)
+	}
+	if err := s.removeDir(drive, dir, deviceID); err != nil {
+		return err
+	}
+	return s.SaveDrive(drive)
+}
+
+// soft-delete a directory and everything in it. doesn't save the drive.
+func (s *Service) removeDir(drive *svc.Drive, dir *svc.Directory, deviceID string) error {
+	dirID := dir.ID
+	// keep a copy of the directory and its contents in the recycle bin
+	if err := s.recycleDir(drive, dir); err != nil {
+		return fmt.Errorf("failed to move %s (id=%s) to recycle bin: %v", dir.Name, dir.ID, err)
+	}
+	// leave tombstones for the directory and everything in it
+	// so other devices will remove them too
+	now := time.Now().UTC()
+
+	// remove all files from db. files are removed before their
+	// directories so they can still be found in the drive.
+	files := dir.GetFiles()
+	for _, file := range files {
+		if err := s.removeFile(drive, file); err != nil {
+			return err
+		}
+		if err := s.releaseBlob(file.Blob); err != nil {
+			return err
+		}
+		if err := s.Db.TombstoneFile(file.ID, deviceID, now); err != nil {
+			return err
+		}
+	}
+	// remove all subdirs of this directory from the db
+	subDirs := dir.GetDirMap()
+	for _, subDir := range subDirs {
+		if err := drive.RemoveDir(subDir.ID); err != nil {
+			return err
+		}
+		if err := s.Db.TombstoneDir(subDir.ID, deviceID, now); err != nil {
+			return err
+		}
+	}
+	// remove directory itself
+	if err := s.Db.TombstoneDir(dirID, deviceID, now); err != nil {
+		return fmt.Errorf("failed to remove directory from database: %v", err)
+	}
+	if err := s.Db.UpdateDir(drive.Root); err != nil {
+		return fmt.Errorf("failed to update root directory: %v", err)
+	}
+	if err := drive.RemoveDir(dirID); err != nil {
+		return fmt.Errorf("failed to remove dir %s: %v", dirID, err)
+	}
+	return nil
+}
+
+// update a directory within a drive. if the directory's name or parent
+// changed, the directory (and everything in it) is renamed or moved.
+// parent ids that aren't known to the server (i.e. a client's root directory)
+// are treated as the drive's root directory.
+func (s *Service) UpdateDir(driveID string, dir *svc.Directory) error {
+	drive := s.GetDrive(driveID)
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	orig := drive.GetDir(dir.ID)
+	if orig == nil {
+		return fmt.Errorf("dir (id=%s) not found", dir.ID)
+	}
+	parent := drive.GetDir(dir.ParentID)
+	if parent == nil {
+		parent = drive.Root
+	}
+	if orig.Name != dir.Name || orig.Parent == nil || orig.Parent.ID != parent.ID {
+		orig.Version = dir.Version
+		return s.relocateDir(drive, orig, parent, dir.Name, dir.LastSync)
+	}
+	dir.ParentID = parent.ID
+	if err := drive.UpdateDir(dir.ID, dir); err != nil {
+		return fmt.Errorf("failed to update dir %s (id=%s): %v", dir.Name, dir.ID, err)
+	}
+	if err := s.Db.UpdateDir(dir); err != nil {
+		return fmt.Errorf("failed update dir %s (id=%s) in database: %v", dir.Name, dir.ID, err)
+	}
+	return nil
+}
+
+// retrieves all directories available for user using the current drive state.
 // returns nil if no directories are available.
 func (s *Service) GetAllDirs(driveID string) ([]*svc.Directory, error) {
 	drive := s.GetDrive(driveID)
@@ -991,30 +1848,73 @@ func (s *Service) GetAllDirs(driveID string) ([]*svc.Directory, error) {
 	return dirs, nil
 }
 
+// move a directory (and everything in it) under another directory.
 func (s *Service) MoveDir(driveID string, dirID string, destDirID string) error {
 	drive := s.GetDrive(driveID)
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", driveID)
 	}
-
 	// directory to move
 	dir := drive.GetDir(dirID)
 	if dir == nil {
 		return fmt.Errorf("dir (id=%s) not found", dirID)
 	}
-	// parent of this directory
-	// parent := dir.Parent
-	// parent.RemoveSubDir(dir.ID)
-
-	// TODO: directory.Copy(destPath)
-
 	// directory to move to
 	destDir := drive.GetDir(destDirID)
 	if destDir == nil {
 		return fmt.Errorf("dest dir (id=%s) not found", destDirID)
 	}
+	if dir.Parent != nil && dir.Parent.ID == destDir.ID {
+		return nil // already there
+	}
+	dir.IncrementVersion(ServerDeviceID)
+	return s.relocateDir(drive, dir, destDir, dir.Name, time.Time{})
+}
 
-	return nil
+// rename and/or move a directory under a new parent. updates the drive's
+// directory tree and the paths of everything under the directory in the
+// database. files are stored by id, so nothing is moved on disk. lastSync is used as the directory's new
+// sync time, or the current time if it's zero.
+func (s *Service) relocateDir(drive *svc.Drive, dir *svc.Directory, parent *svc.Directory, name string, lastSync time.Time) error {
+	if dir.IsRoot() {
+		return fmt.Errorf("can't move root directory")
+	}
+	if parent.ID == dir.ID || dir.WalkD(parent.ID) != nil {
+		return fmt.Errorf("can't move %s into itself", dir.Name)
+	}
+	for _, sibling := range parent.Dirs {
+		if sibling.ID != dir.ID && sibling.Name == name {
+			return fmt.Errorf("%s already exists in %s", name, parent.Name)
+		}
+	}
+	newPath := s.dirServerPath(drive, parent, name)
+	if lastSync.IsZero() {
+		lastSync = time.Now().UTC()
+	}
+	// server-side directories use their server path as their path
+	dir.Path = dir.ServerPath
+	dir.Relocate(newPath)
+	dir.Name = name
+	dir.ParentID = parent.ID
+	dir.LastSync = lastSync
+	if err := drive.UpdateDir(dir.ID, dir); err != nil {
+		return fmt.Errorf("failed to update dir %s (id=%s): %v", dir.Name, dir.ID, err)
+	}
+	// everything under this directory has a new path
+	dirs := dir.WalkDs()
+	dirs[dir.ID] = dir
+	for _, d := range dirs {
+		if err := s.Db.UpdateDir(d); err != nil {
+			return fmt.Errorf("failed update dir %s (id=%s) in database: %v", d.Name, d.ID, err)
+		}
+	}
+	for _, file := range dir.GetFiles() {
+		if err := s.Db.UpdateFile(file); err != nil {
+			return fmt.Errorf("failed to update file %s (id=%s) in database: %v", file.Name, file.ID, err)
+		}
+	}
+	s.log.Info(fmt.Sprintf("directory (id=%s) moved to %s", dir.ID, newPath))
+	return s.SaveState()
 }
 
 // --------- sync --------------------------------
@@ -1030,6 +1930,9 @@ func (s *Service) GenSyncIndex(driveID string) (*svc.SyncIndex, error) {
 		return nil, fmt.Errorf("drive (id=%s) root not found", drive.RootID)
 	}
 	drive.SyncIndex = svc.BuildRootSyncIndex(drive.Root)
+	if err := s.addTombstones(drive.SyncIndex, driveID); err != nil {
+		return nil, err
+	}
 	return drive.SyncIndex, nil
 }
 
@@ -1045,6 +1948,9 @@ func (s *Service) GetSyncIdx(driveID string) (*svc.SyncIndex, error) {
 	if !drive.IsIndexed() {
 		return nil, fmt.Errorf("drive (id=%s) is not indexed", driveID)
 	}
+	if err := s.addTombstones(drive.SyncIndex, driveID); err != nil {
+		return nil, err
+	}
 	return drive.SyncIndex, nil
 }
 
@@ -1059,5 +1965,55 @@ func (s *Service) RefreshUpdates(driveID string) (*svc.SyncIndex, error) {
 		return nil, fmt.Errorf("drive (id=%s) has not been indexed", driveID)
 	}
 	drive.SyncIndex = svc.BuildToUpdate(drive.Root, drive.SyncIndex)
+	if err := s.addTombstones(drive.SyncIndex, driveID); err != nil {
+		return nil, err
+	}
 	return drive.SyncIndex, nil
 }
+
+// add tombstones for a drive's deleted files and directories to a sync index
+func (s *Service) addTombstones(idx *svc.SyncIndex, driveID string) error {
+	tombstones, err := s.Db.GetTombstones(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to get tombstones: %v", err)
+	}
+	idx.AddTombstones(tombstones)
+	return nil
+}
+
+// record that a device has fetched a drive's sync index. tombstones are
+// kept until every device that syncs with the drive has seen them.
+func (s *Service) DeviceSynced(driveID string, deviceID string) error {
+	return s.Db.SetDeviceSync(driveID, deviceID, time.Now().UTC())
+}
+
+// permanently remove any tombstones every device has seen.
+func (s *Service) purgeTombstones(driveID string) error {
+	seen, err := s.Db.GetDeviceSyncs(driveID)
+	if err != nil {
+		return err
+	}
+	tombstones, err := s.Db.GetTombstones(driveID)
+	if err != nil {
+		return err
+	}
+	var purged int
+	for _, t := range tombstones {
+		if !t.Seen(seen) {
+			continue
+		}
+		if t.IsDir {
+			err = s.Db.RemoveDirectory(t.ID)
+		} else {
+			err = s.Db.RemoveFile(t.ID)
+		}
+		if err != nil {
+			return err
+		}
+		purged++
+	}
+	if purged > 0 {
+		s.log.Info(fmt.Sprintf("removed %d tombstones for drive (id=%s)", purged, driveID))
+	}
+	return nil
+}
@@ -1,15 +1,30 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/env"
+	"github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/storage"
+	"github.com/sfs/pkg/transfer"
 
 	"github.com/alecthomas/assert/v2"
 )
@@ -203,19 +218,17 @@ func TestMoveFile(t *testing.T) {
 		Fail(t, GetTestingDir(), err)
 	}
 
-	// verifications
-	entries, err := os.ReadDir(tmpDir.Path)
+	// verifications. files are stored by id, so only the
+	// file's directory should change.
+	moved, err := testSvc.Db.GetFileByID(file.ID)
 	if err != nil {
-		if err2 := os.Remove(tmpFolder); err2 != nil {
-			t.Error(err2)
-		}
 		Fail(t, GetTestingDir(), err)
 	}
-	if len(entries) != 1 {
-		if err2 := os.Remove(tmpFolder); err2 != nil {
-			t.Error(err2)
-		}
-		Fail(t, GetTestingDir(), fmt.Errorf("file was not copied"))
+	if moved == nil || moved.DirID != tmpDir.ID {
+		Fail(t, GetTestingDir(), fmt.Errorf("file was not moved"))
+	}
+	if _, err := os.Stat(moved.ServerPath); err != nil {
+		Fail(t, GetTestingDir(), err)
 	}
 
 	// clean up
@@ -486,6 +499,287 @@ func TestLoadDrive(t *testing.T) {
 	}
 }
 
+// -------- blob store tests --------------------------------
+
+func TestBlobStore(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+
+	// two files with the same contents
+	files := make([]*svc.File, 0, 2)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		f, err := MakeTmpTxtFile(filepath.Join(testSvc.SvcRoot, name), 10)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.MarkBackedUp()
+		if err := testSvc.commitBlob(f); err != nil {
+			Fatal(t, err)
+		}
+		files = append(files, f)
+	}
+	a, b := files[0], files[1]
+	assert.NotEqual(t, "", a.Blob)
+	assert.Equal(t, a.Blob, b.Blob)
+
+	// stored once, with a reference for each file
+	blob, err := testSvc.GetBlob(a.Blob)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotZero(t, blob)
+	assert.Equal(t, 2, blob.Refs)
+	blobInfo, err := os.Stat(blob.Path)
+	if err != nil {
+		Fatal(t, err)
+	}
+	for _, f := range files {
+		info, err := os.Stat(f.ServerPath)
+		if err != nil {
+			Fatal(t, err)
+		}
+		assert.True(t, os.SameFile(blobInfo, info), f.Name)
+	}
+
+	// changing one file leaves the blob (and the other file) alone
+	orig := a.Blob
+	if err := testSvc.putObject(a.ServerPath, strings.NewReader("something else")); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.commitBlob(a); err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, orig, a.Blob)
+	data, err := os.ReadFile(b.ServerPath)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, strings.Repeat(txtData, 10), string(data))
+	blob, err = testSvc.GetBlob(orig)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, blob.Refs)
+
+	// the client can ask for contents by checksum
+	data, err = testSvc.ReadBlob(a.Blob)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "something else", string(data))
+
+	// blobs are removed along with their last reference
+	if err := testSvc.releaseBlob(b.Blob); err != nil {
+		Fatal(t, err)
+	}
+	blob, err = testSvc.GetBlob(orig)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Zero(t, blob)
+	_, err = testSvc.ReadBlob(orig)
+	assert.True(t, errors.Is(err, ErrBlobNotFound))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestMigrateBlobs(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+
+	// files stored by an older version of sfs
+	files, err := MakeABunchOfTxtFiles(5, testSvc.SvcRoot)
+	if err != nil {
+		Fatal(t, err)
+	}
+	for _, f := range files {
+		f.MarkBackedUp()
+	}
+	if err := testSvc.Db.AddFiles(files); err != nil {
+		Fatal(t, err)
+	}
+
+	if err := testSvc.migrateBlobs(); err != nil {
+		Fatal(t, err)
+	}
+	unlinked, err := testSvc.Db.GetUnlinkedFiles()
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 0, len(unlinked))
+	for _, f := range files {
+		// converted in place
+		file, err := testSvc.Db.GetFileByID(f.ID)
+		if err != nil {
+			Fatal(t, err)
+		}
+		assert.Equal(t, f.ServerPath, file.ServerPath)
+		assert.NotEqual(t, "", file.Blob)
+		blob, err := testSvc.GetBlob(file.Blob)
+		if err != nil {
+			Fatal(t, err)
+		}
+		assert.NotZero(t, blob)
+	}
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+// -------- file layout tests --------------------------------
+
+func TestFileLayout(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+
+	// client-side drive with two directories
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	dirs := make([]*svc.Directory, 0, 2)
+	for _, name := range []string{"docs", "work"} {
+		dir := svc.NewDirectory(name, "me", testDrv.ID, filepath.Join(clientRoot, name))
+		if err := testSvc.NewDir(testDrv.ID, testDrv.RootID, dir); err != nil {
+			Fatal(t, err)
+		}
+		// directories are metadata only
+		_, err := os.Stat(dir.ServerPath)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+		dirs = append(dirs, dir)
+	}
+
+	// files with the same name in different directories don't collide
+	files := make([]*svc.File, 0, 2)
+	for i, dir := range dirs {
+		if err := os.MkdirAll(dir.ClientPath, 0755); err != nil {
+			Fatal(t, err)
+		}
+		f, err := MakeTmpTxtFile(filepath.Join(dir.ClientPath, "notes.txt"), i+1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = dir.ID
+		f.Content = []byte(strings.Repeat(txtData, i+1))
+		if err := testSvc.AddFile(dir.ID, f); err != nil {
+			Fatal(t, err)
+		}
+		assert.Equal(t, testSvc.buildFilePath(testDrv.OwnerName, f.ID), f.ServerPath)
+		files = append(files, f)
+	}
+	assert.NotEqual(t, files[0].ServerPath, files[1].ServerPath)
+	for i, f := range files {
+		file, err := testSvc.GetFile(testDrv.ID, f.ID)
+		if err != nil {
+			Fatal(t, err)
+		}
+		data, err := os.ReadFile(file.ServerPath)
+		if err != nil {
+			Fatal(t, err)
+		}
+		assert.Equal(t, strings.Repeat(txtData, i+1), string(data))
+	}
+	_, err := testSvc.GetFile("some-other-drive", files[0].ID)
+	assert.Error(t, err)
+
+	// the tree is rebuilt from the database
+	drive, err := testSvc.LoadDrive(testDrv.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	for i, dir := range dirs {
+		assert.True(t, drive.GetDir(dir.ID).HasFile(files[i].ID))
+	}
+
+	// moving a directory doesn't move its files
+	if err := testSvc.MoveDir(testDrv.ID, dirs[1].ID, dirs[0].ID); err != nil {
+		Fatal(t, err)
+	}
+	file, err := testSvc.GetFile(testDrv.ID, files[1].ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, files[1].ServerPath, file.ServerPath)
+
+	// files placed in the users root directory are imported on refresh
+	importPath := filepath.Join(testSvc.buildImportPath(testDrv.OwnerName), "docs", "new.txt")
+	if err := os.MkdirAll(filepath.Dir(importPath), 0755); err != nil {
+		Fatal(t, err)
+	}
+	if _, err := MakeTmpTxtFile(importPath, 1); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.RefreshDrive(testDrv.ID); err != nil {
+		Fatal(t, err)
+	}
+	_, err = os.Stat(importPath)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	var imported *svc.File
+	for _, f := range testSvc.Drives[testDrv.ID].Root.WalkD(dirs[0].ID).Files {
+		if f.Name == "new.txt" {
+			imported = f
+		}
+	}
+	assert.NotZero(t, imported)
+	assert.Equal(t, testSvc.buildFilePath(testDrv.OwnerName, imported.ID), imported.ServerPath)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+
+	// files stored in the users directory tree by an older version of sfs
+	treeRoot := filepath.Join(testSvc.SvcRoot, "users", "layout-user", "root")
+	if err := os.MkdirAll(treeRoot, 0755); err != nil {
+		Fatal(t, err)
+	}
+	root := svc.NewRootDirectory("root", "me", auth.NewUUID(), treeRoot)
+	testDrv := svc.NewDrive(root.DriveID, "layout-user", "me", treeRoot, root.ID, root)
+	if err := testSvc.Db.AddDrive(testDrv); err != nil {
+		Fatal(t, err)
+	}
+	files, err := MakeABunchOfTxtFiles(5, treeRoot)
+	if err != nil {
+		Fatal(t, err)
+	}
+	for _, f := range files {
+		f.DriveID = testDrv.ID
+		f.MarkBackedUp()
+	}
+	if err := testSvc.Db.AddFiles(files); err != nil {
+		Fatal(t, err)
+	}
+
+	if err := testSvc.migrateLayout(); err != nil {
+		Fatal(t, err)
+	}
+	for _, f := range files {
+		file, err := testSvc.Db.GetFileByID(f.ID)
+		if err != nil {
+			Fatal(t, err)
+		}
+		assert.Equal(t, testSvc.buildFilePath(testDrv.OwnerName, f.ID), file.ServerPath)
+		_, err = os.Stat(file.ServerPath)
+		assert.NoError(t, err)
+		_, err = os.Stat(f.ServerPath)
+		assert.True(t, errors.Is(err, os.ErrNotExist))
+	}
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
 // func TestRemoveDrive(t *testing.T) {}
 
 // func TestServiceReset(t *testing.T) {
@@ -519,3 +813,1370 @@ func TestLoadDrive(t *testing.T) {
 // 		t.Fatal(err)
 // 	}
 // }
+
+// -------- storage tests --------------------------------
+
+func TestMemoryStorage(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	read := func(file *svc.File) string {
+		f, err := testSvc.OpenFile(file, "")
+		if err != nil {
+			Fatal(t, err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			Fatal(t, err)
+		}
+		return string(data)
+	}
+
+	f, err := MakeTmpTxtFile(filepath.Join(clientRoot, "notes.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	f.DriveID = testDrv.ID
+	f.DirID = testDrv.RootID
+	f.Content = []byte("first")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "first", read(f))
+	assert.NotEqual(t, "", f.Blob)
+
+	// nothing is written to the local disk
+	_, err = os.Stat(f.ServerPath)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+
+	// updates keep the previous contents as a version
+	if err := testSvc.UpdateFile(f, []byte("second")); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "second", read(f))
+	assert.Equal(t, int64(6), f.Size)
+	versions, err := testSvc.GetVersions(f)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, len(versions))
+
+	// deltas are built against and applied to the stored contents
+	sig, err := testSvc.FileSignature(f)
+	if err != nil {
+		Fatal(t, err)
+	}
+	delta, err := transfer.DeltaFrom(sig, strings.NewReader("second, then third"))
+	if err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.ApplyFileDelta(f, delta); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "second, then third", read(f))
+
+	if err := testSvc.RestoreVersion(f, versions[0].Rev); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "first", read(f))
+	err = testSvc.RestoreVersion(f, versions[0].Rev+100)
+	assert.True(t, errors.Is(err, ErrVersionNotFound))
+
+	// copies share the original's blob
+	if err := testSvc.CopyFile(testDrv.RootID, f, true); err != nil {
+		Fatal(t, err)
+	}
+	drive, err := testSvc.LoadDrive(testDrv.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	var cp *svc.File
+	for _, file := range drive.GetFiles() {
+		if file.ID != f.ID {
+			cp = file
+		}
+	}
+	assert.NotZero(t, cp)
+	assert.Equal(t, "first", read(cp))
+	assert.Equal(t, f.Blob, cp.Blob)
+
+	// deleted files can be restored from the recycle bin
+	if err := testSvc.DeleteFile(f, "some-device"); err != nil {
+		Fatal(t, err)
+	}
+	ok, err := testSvc.objectExists(f.ServerPath)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	items, err := testSvc.GetRecycled(testDrv.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, len(items))
+	if _, err := testSvc.RestoreRecycled(testDrv.ID, items[0].ID); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "first", read(f))
+	_, err = testSvc.RestoreRecycled(testDrv.ID, items[0].ID)
+	assert.True(t, errors.Is(err, ErrRecycledNotFound))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestLockedFiles(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	store := storage.NewMemory()
+	testSvc.SetStore(store)
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	f, err := MakeTmpTxtFile(filepath.Join(clientRoot, "secrets.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	f.DriveID = testDrv.ID
+	f.DirID = testDrv.RootID
+	f.Content = []byte("the launch codes")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+	blob := f.Blob
+
+	// contents are encrypted in the store, and the file leaves the blob store
+	if err := testSvc.LockFile(f, "hunter2"); err != nil {
+		Fatal(t, err)
+	}
+	assert.True(t, f.Encrypted())
+	assert.Equal(t, "", f.Blob)
+	b, err := testSvc.GetBlob(blob)
+	assert.NoError(t, err)
+	assert.Zero(t, b)
+	stored, err := testSvc.readObject(f.ServerPath)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.False(t, strings.Contains(string(stored), "launch codes"))
+	saved, err := testSvc.Db.GetFileByID(f.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.True(t, saved.Protected)
+	assert.False(t, strings.Contains(saved.Key, "hunter2"))
+
+	// downloads need the password
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	serve := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/files/"+f.ID, nil)
+		req = req.WithContext(context.WithValue(req.Context(), File, saved))
+		if password != "" {
+			req.Header.Set(PasswordHeader, password)
+		}
+		w := httptest.NewRecorder()
+		api.ServeFile(w, req)
+		return w
+	}
+	assert.Equal(t, http.StatusLocked, serve("").Code)
+	assert.Equal(t, http.StatusForbidden, serve("hunter3").Code)
+	w := serve("hunter2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "the launch codes", w.Body.String())
+
+	// locked files can't be changed
+	err = testSvc.UpdateFile(f, []byte("new codes"))
+	assert.True(t, errors.Is(err, svc.ErrLocked))
+	_, err = testSvc.FileSignature(f)
+	assert.True(t, errors.Is(err, svc.ErrLocked))
+
+	// unlocking puts the plain contents back
+	assert.True(t, errors.Is(testSvc.UnlockFile(f, "hunter3"), svc.ErrWrongPassword))
+	if err := testSvc.UnlockFile(f, "hunter2"); err != nil {
+		Fatal(t, err)
+	}
+	assert.False(t, f.Protected)
+	assert.Equal(t, blob, f.Blob)
+	data, err := testSvc.readObject(f.ServerPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "the launch codes", string(data))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestLockFileRemovesPlaintext(t *testing.T) {
+	env.SetEnv(false)
+
+	// contents are kept on disk under the service root
+	testSvc := newTestService(t)
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	addFile := func(name string, contents string) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(clientRoot, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = testDrv.RootID
+		f.Content = []byte(contents)
+		if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+			Fatal(t, err)
+		}
+		return f
+	}
+	plaintextLeft := func(secrets ...string) []string {
+		var found []string
+		err := filepath.WalkDir(testSvc.SvcRoot, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			for _, secret := range secrets {
+				if strings.Contains(string(data), secret) {
+					found = append(found, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			Fatal(t, err)
+		}
+		return found
+	}
+
+	// the old contents are saved as a version when the file changes
+	f := addFile("diary.txt", "first diary entry")
+	if err := testSvc.UpdateFile(f, []byte("second diary entry")); err != nil {
+		Fatal(t, err)
+	}
+	versions, err := testSvc.GetVersions(f)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, 0, len(versions))
+	assert.NotEqual(t, 0, len(plaintextLeft("first diary entry", "second diary entry")))
+
+	// no copy of the old or current contents is left once the file is locked
+	if err := testSvc.LockFile(f, "hunter2"); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 0, len(plaintextLeft("first diary entry", "second diary entry")))
+	versions, err = testSvc.GetVersions(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(versions))
+
+	// files whose contents are shared with another file can't be locked
+	a := addFile("a.txt", "shared contents")
+	addFile("b.txt", "shared contents")
+	err = testSvc.LockFile(a, "hunter2")
+	assert.True(t, errors.Is(err, ErrSharedContents))
+	assert.False(t, a.Protected)
+	assert.Equal(t, "", a.Key)
+
+	// or with a snapshot
+	c := addFile("c.txt", "snapshotted contents")
+	if _, err := testSvc.CreateSnapshot(testDrv.ID, "before"); err != nil {
+		Fatal(t, err)
+	}
+	assert.True(t, errors.Is(testSvc.LockFile(c, "hunter2"), ErrSharedContents))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestE2EEDrive(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	newFile := func(name string) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(clientRoot, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = testDrv.RootID
+		return f
+	}
+
+	// a file from before the drive was encrypted, with a saved version
+	f := newFile("old.txt")
+	f.Content = []byte("plain old contents")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.UpdateFile(f, []byte("plain new contents")); err != nil {
+		Fatal(t, err)
+	}
+	versions, err := testSvc.GetVersions(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(versions))
+
+	// turning it on drops the plaintext versions
+	if err := testSvc.SetE2EE(testDrv.ID); err != nil {
+		Fatal(t, err)
+	}
+	drv, err := testSvc.Db.GetDrive(testDrv.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.True(t, drv.E2EE)
+	versions, err = testSvc.GetVersions(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(versions))
+
+	// plaintext uploads are rejected
+	plain := newFile("plain.txt")
+	plain.Content = []byte("not sealed")
+	assert.True(t, errors.Is(testSvc.AddFile(testDrv.RootID, plain), ErrE2EE))
+	assert.True(t, errors.Is(testSvc.UpdateFile(f, []byte("not sealed")), ErrE2EE))
+
+	// sealed uploads keep the client's checksum
+	keys, err := transfer.NewKeys(false)
+	if err != nil {
+		Fatal(t, err)
+	}
+	seal := func(data string) ([]byte, string) {
+		var buf strings.Builder
+		if err := keys.Encrypt(&buf, strings.NewReader(data)); err != nil {
+			Fatal(t, err)
+		}
+		cs, _ := keys.ChecksumOf(strings.NewReader(data))
+		return []byte(buf.String()), cs
+	}
+	g, err := keys.SealFile(newFile("sealed.txt"))
+	if err != nil {
+		Fatal(t, err)
+	}
+	g.Content, _ = seal("the family recipes")
+	if err := testSvc.AddFile(testDrv.RootID, g); err != nil {
+		Fatal(t, err)
+	}
+	stored, err := testSvc.readObject(g.ServerPath)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.False(t, strings.Contains(string(stored), "recipes"))
+	saved, err := testSvc.Db.GetFileByID(g.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, g.CheckSum, saved.CheckSum)
+
+	// the old file's plaintext isn't kept as a version once it's replaced
+	data, cs := seal("new encrypted contents")
+	if err := testSvc.updateFile(f, data, cs); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, cs, f.CheckSum)
+	assert.Equal(t, transfer.MACAlgorithm, f.Algorithm)
+	versions, _ = testSvc.GetVersions(f)
+	assert.Equal(t, 0, len(versions))
+	data, cs = seal("newer encrypted contents")
+	if err := testSvc.updateFile(f, data, cs); err != nil {
+		Fatal(t, err)
+	}
+	versions, _ = testSvc.GetVersions(f)
+	assert.Equal(t, 1, len(versions))
+
+	// nothing that needs the plaintext
+	_, err = testSvc.FileSignature(f)
+	assert.True(t, errors.Is(err, ErrE2EE))
+	assert.True(t, errors.Is(testSvc.SetAlgorithm(testDrv.ID, svc.BLAKE2b), ErrE2EE))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestDirVersions(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	docs := svc.NewDirectory("docs", "me", testDrv.ID, filepath.Join(clientRoot, "docs"))
+	if err := testSvc.NewDir(testDrv.ID, testDrv.RootID, docs); err != nil {
+		Fatal(t, err)
+	}
+	archive := svc.NewDirectory("archive", "me", testDrv.ID, filepath.Join(clientRoot, "archive"))
+	if err := testSvc.NewDir(testDrv.ID, testDrv.RootID, archive); err != nil {
+		Fatal(t, err)
+	}
+	// renames are sent the way PutDir applies them
+	rename := func(name string, version svc.VersionVector) error {
+		dir, err := testSvc.Db.GetDirectoryByID(docs.ID)
+		if err != nil {
+			Fatal(t, err)
+		}
+		if err := testSvc.AcceptDirVersion(dir, version); err != nil {
+			return err
+		}
+		dir.Name = name
+		return testSvc.UpdateDir(testDrv.ID, dir)
+	}
+
+	// a rename made after seeing the server's version is accepted and merged
+	laptop := svc.VersionVector{"laptop": 1}
+	if err := rename("notes", laptop); err != nil {
+		Fatal(t, err)
+	}
+	saved, err := testSvc.Db.GetDirectoryByID(docs.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "notes", saved.Name)
+	assert.Equal(t, laptop, saved.Version)
+
+	// and sent to clients in the sync index
+	idx, err := testSvc.GenSyncIndex(testDrv.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, laptop, idx.Dirs[docs.ID].Version)
+
+	// renames made without seeing another device's rename are rejected
+	assert.Error(t, rename("mine", svc.VersionVector{"desktop": 1}))
+
+	// as are renames made before the server moved the directory
+	if err := testSvc.MoveDir(testDrv.ID, docs.ID, archive.ID); err != nil {
+		Fatal(t, err)
+	}
+	assert.Error(t, rename("stale", laptop))
+	saved, err = testSvc.Db.GetDirectoryByID(docs.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "notes", saved.Name)
+	assert.Equal(t, svc.VersionVector{"laptop": 1, ServerDeviceID: 1}, saved.Version)
+
+	// older clients don't send a version
+	assert.NoError(t, rename("plain", nil))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestDriveSnapshots(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	docs := svc.NewDirectory("docs", "me", testDrv.ID, filepath.Join(clientRoot, "docs"))
+	if err := testSvc.NewDir(testDrv.ID, testDrv.RootID, docs); err != nil {
+		Fatal(t, err)
+	}
+	addFile := func(dirID string, name string, contents string) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(clientRoot, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = dirID
+		f.Content = []byte(contents)
+		if err := testSvc.AddFile(dirID, f); err != nil {
+			Fatal(t, err)
+		}
+		return f
+	}
+	a := addFile(testDrv.RootID, "a.txt", "original a")
+	b := addFile(docs.ID, "b.txt", "original b")
+
+	// contents are shared with the files rather than copied
+	snap, err := testSvc.CreateSnapshot(testDrv.ID, "before")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, snap.Files)
+	blob, err := testSvc.GetBlob(a.Blob)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, blob.Refs)
+
+	// change one file, delete another, and add a new one
+	if err := testSvc.UpdateFile(a, []byte("changed a")); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.DeleteFile(b, ServerDeviceID); err != nil {
+		Fatal(t, err)
+	}
+	c := addFile(testDrv.RootID, "c.txt", "new c")
+
+	changes, err := testSvc.DiffSnapshots(testDrv.ID, snap.ID, "")
+	if err != nil {
+		Fatal(t, err)
+	}
+	kinds := make(map[string]string)
+	for _, change := range changes {
+		kinds[change.ID] = change.Change
+	}
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, svc.SnapshotChanged, kinds[a.ID])
+	assert.Equal(t, svc.SnapshotRemoved, kinds[b.ID])
+	assert.Equal(t, svc.SnapshotAdded, kinds[c.ID])
+
+	// restore a single file
+	if err := testSvc.RestoreSnapshot(testDrv.ID, snap.ID, "docs/b.txt"); err != nil {
+		Fatal(t, err)
+	}
+	restored, err := testSvc.Db.GetFileByID(b.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotZero(t, restored)
+	assert.Equal(t, docs.ID, restored.DirID)
+	data, err := testSvc.readObject(restored.ServerPath)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "original b", string(data))
+
+	err = testSvc.RestoreSnapshot(testDrv.ID, snap.ID, "docs/missing.txt")
+	assert.True(t, errors.Is(err, ErrSnapshotPathNotFound))
+
+	// then the whole drive. anything added since goes to the recycle bin
+	if err := testSvc.RestoreSnapshot(testDrv.ID, snap.ID, ""); err != nil {
+		Fatal(t, err)
+	}
+	a, err = testSvc.Db.GetFileByID(a.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	data, err = testSvc.readObject(a.ServerPath)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "original a", string(data))
+	versions, err := testSvc.GetVersions(a)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(versions))
+	gone, err := testSvc.Db.GetFileByID(c.ID)
+	assert.NoError(t, err)
+	assert.Zero(t, gone)
+	items, err := testSvc.GetRecycled(testDrv.ID)
+	assert.NoError(t, err)
+	var recycled bool
+	for _, item := range items {
+		recycled = recycled || item.ID == c.ID
+	}
+	assert.True(t, recycled)
+	changes, err = testSvc.DiffSnapshots(testDrv.ID, snap.ID, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+
+	// each restore took a snapshot first, so it can be undone
+	snaps, err := testSvc.GetSnapshots(testDrv.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(snaps))
+	undo := snaps[0]
+	changes, err = testSvc.DiffSnapshots(testDrv.ID, snap.ID, undo.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(changes))
+
+	// removing snapshots releases their contents
+	for _, s := range snaps {
+		if err := testSvc.RemoveSnapshot(testDrv.ID, s.ID); err != nil {
+			Fatal(t, err)
+		}
+	}
+	blob, err = testSvc.GetBlob(a.Blob)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, blob.Refs)
+	_, err = testSvc.GetSnapshot(testDrv.ID, snap.ID)
+	assert.True(t, errors.Is(err, ErrSnapshotNotFound))
+
+	// scheduled snapshots are only taken when they're due,
+	// and only the latest are kept
+	if err := testSvc.SetSnapshotSchedule(testDrv.ID, time.Hour, 1); err != nil {
+		Fatal(t, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := testSvc.TakeScheduledSnapshots(); err != nil {
+			Fatal(t, err)
+		}
+	}
+	snaps, err = testSvc.GetSnapshots(testDrv.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(snaps))
+	assert.True(t, snaps[0].Scheduled)
+	if err := testSvc.SetSnapshotSchedule(testDrv.ID, time.Nanosecond, 1); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.TakeScheduledSnapshots(); err != nil {
+		Fatal(t, err)
+	}
+	latest, err := testSvc.GetSnapshots(testDrv.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(latest))
+	assert.NotEqual(t, snaps[0].ID, latest[0].ID)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestUploadSessions(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	f, err := MakeTmpTxtFile(filepath.Join(clientRoot, "big.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	f.DriveID = testDrv.ID
+	f.DirID = testDrv.RootID
+	f.Content = []byte("old contents")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+
+	data := []byte(strings.Repeat("all work and no play makes jack a dull boy\n", 100))
+	cs, err := svc.ChecksumOf(bytes.NewReader(data), svc.DefaultAlgorithm)
+	if err != nil {
+		Fatal(t, err)
+	}
+	_, err = testSvc.NewUpload(f, -1, cs)
+	assert.True(t, errors.Is(err, ErrUploadInvalid))
+	_, err = testSvc.NewUpload(f, int64(len(data)), "not a checksum")
+	assert.True(t, errors.Is(err, ErrUploadInvalid))
+	u, err := testSvc.NewUpload(f, int64(len(data)), cs)
+	if err != nil {
+		Fatal(t, err)
+	}
+
+	// parts can arrive out of order, and a dropped part
+	// keeps whatever made it through
+	half := int64(len(data) / 2)
+	_, err = testSvc.WriteUpload(f, u.ID, half, bytes.NewReader(data[half:]))
+	assert.NoError(t, err)
+	_, err = testSvc.WriteUpload(f, u.ID, 0, io.MultiReader(bytes.NewReader(data[:100]), iotest.ErrReader(errors.New("connection reset"))))
+	assert.Error(t, err)
+	got, err := testSvc.GetUpload(f, u.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), got.Acknowledged())
+	assert.Equal(t, []svc.ByteRange{{Start: 100, End: half}}, got.Missing())
+
+	// nothing's replaced until every part is there
+	assert.True(t, errors.Is(testSvc.CommitUpload(f, u.ID, ""), ErrUploadIncomplete))
+	_, err = testSvc.WriteUpload(f, u.ID, 100, bytes.NewReader(data[100:half]))
+	assert.NoError(t, err)
+
+	// and parts can't run past the end
+	_, err = testSvc.WriteUpload(f, u.ID, half, bytes.NewReader(append(data[half:], 'x')))
+	assert.True(t, errors.Is(err, ErrUploadRange))
+	_, err = testSvc.WriteUpload(f, u.ID, int64(len(data))+1, strings.NewReader("x"))
+	assert.True(t, errors.Is(err, ErrUploadRange))
+
+	assert.NoError(t, testSvc.CommitUpload(f, u.ID, ""))
+	stored, err := testSvc.readObject(f.ServerPath)
+	assert.NoError(t, err)
+	assert.Equal(t, data, stored)
+	assert.Equal(t, cs, f.CheckSum)
+	assert.Equal(t, int64(len(data)), f.Size)
+	versions, _ := testSvc.GetVersions(f)
+	assert.Equal(t, 1, len(versions))
+	_, err = os.Stat(testSvc.uploadPath(u.ID))
+	assert.True(t, os.IsNotExist(err))
+	_, err = testSvc.GetUpload(f, u.ID)
+	assert.True(t, errors.Is(err, ErrUploadNotFound))
+
+	// uploads that don't match their checksum are thrown out
+	u, err = testSvc.NewUpload(f, 3, cs)
+	if err != nil {
+		Fatal(t, err)
+	}
+	_, err = testSvc.WriteUpload(f, u.ID, 0, strings.NewReader("abc"))
+	assert.NoError(t, err)
+	assert.True(t, errors.Is(testSvc.CommitUpload(f, u.ID, ""), ErrUploadChecksum))
+	_, err = testSvc.GetUpload(f, u.ID)
+	assert.True(t, errors.Is(err, ErrUploadNotFound))
+
+	// abandoned sessions expire
+	u, err = testSvc.NewUpload(f, 3, cs)
+	if err != nil {
+		Fatal(t, err)
+	}
+	u.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+	assert.NoError(t, testSvc.Db.UpdateUpload(u))
+	uploads, err := testSvc.GetUploads(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(uploads))
+	assert.NoError(t, testSvc.ExpireUploads())
+	_, err = os.Stat(testSvc.uploadPath(u.ID))
+	assert.True(t, os.IsNotExist(err))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestUploadReservations(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	if err := testDrv.SetQuota(64 * 1024); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.UpdateDrive(testDrv); err != nil {
+		Fatal(t, err)
+	}
+	clientRoot := testDrv.RootPath
+	f, err := MakeTmpTxtFile(filepath.Join(clientRoot, "big.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	f.DriveID = testDrv.ID
+	f.DirID = testDrv.RootID
+	f.Content = []byte("old contents")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+	cs, err := svc.ChecksumOf(strings.NewReader(""), svc.DefaultAlgorithm)
+	if err != nil {
+		Fatal(t, err)
+	}
+
+	// each open session holds on to its space until it's done with,
+	// so they can't add up to more than the quota between them
+	first, err := testSvc.NewUpload(f, 40*1024, cs)
+	if err != nil {
+		Fatal(t, err)
+	}
+	_, err = testSvc.NewUpload(f, 40*1024, cs)
+	assert.True(t, errors.Is(err, svc.ErrQuotaExceeded))
+
+	// aborting a session frees up its space
+	assert.NoError(t, testSvc.AbortUpload(f, first.ID))
+	second, err := testSvc.NewUpload(f, 40*1024, cs)
+	assert.NoError(t, err)
+
+	// and so does letting it expire
+	second.ExpiresAt = time.Now().UTC().Add(-time.Minute)
+	assert.NoError(t, testSvc.Db.UpdateUpload(second))
+	_, err = testSvc.NewUpload(f, 40*1024, cs)
+	assert.NoError(t, err)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestServeFileRange(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	f, err := MakeTmpTxtFile(filepath.Join(clientRoot, "movie.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	f.DriveID = testDrv.ID
+	f.DirID = testDrv.RootID
+	f.Content = []byte("0123456789")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	serve := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/files/"+f.ID, nil)
+		req = req.WithContext(context.WithValue(req.Context(), File, f))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		api.ServeFile(w, req)
+		return w
+	}
+
+	// the checksum is the file's ETag
+	w := serve(nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+	assert.Equal(t, strconv.Quote(f.CheckSum), w.Header().Get("ETag"))
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+
+	// only the rest is sent if the contents haven't changed
+	w = serve(map[string]string{"Range": "bytes=4-", "If-Range": strconv.Quote(f.CheckSum)})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "456789", w.Body.String())
+	assert.Equal(t, "bytes 4-9/10", w.Header().Get("Content-Range"))
+
+	// otherwise everything is sent again
+	w = serve(map[string]string{"Range": "bytes=4-", "If-Range": strconv.Quote("sha256:stale")})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0123456789", w.Body.String())
+
+	// ranges of locked files are read from the decrypted contents
+	if err := testSvc.LockFile(f, "hunter2"); err != nil {
+		Fatal(t, err)
+	}
+	w = serve(map[string]string{"Range": "bytes=7-", PasswordHeader: "hunter2"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "789", w.Body.String())
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestServeFileCompressed(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	contents := []byte(strings.Repeat("timestamp,level,message\n", 200))
+	addFile := func(name string) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(clientRoot, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = testDrv.RootID
+		f.Content = contents
+		if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+			Fatal(t, err)
+		}
+		return f
+	}
+	logs, photo := addFile("logs.csv"), addFile("photo.jpg")
+
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	serve := func(f *svc.File, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/files/"+f.ID, nil)
+		req = req.WithContext(context.WithValue(req.Context(), File, f))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		api.ServeFile(w, req)
+		return w
+	}
+
+	// compressed with the encoding the client prefers
+	w := serve(logs, map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, transfer.EncodingGzip, w.Header().Get("Content-Encoding"))
+	assert.True(t, w.Body.Len() < len(contents))
+	zr, err := transfer.NewDecoder(w.Body, transfer.EncodingGzip)
+	assert.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, contents, got)
+
+	// but not if it didn't ask, it's already compressed, or it's a range
+	for _, w := range []*httptest.ResponseRecorder{
+		serve(logs, nil),
+		serve(photo, map[string]string{"Accept-Encoding": "zstd, gzip"}),
+		serve(logs, map[string]string{"Accept-Encoding": "zstd, gzip", "Range": "bytes=0-"}),
+	} {
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, contents, w.Body.Bytes())
+	}
+
+	// compressed uploads are decompressed before they're handled
+	var body []byte
+	h := DecodeContent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	var buf bytes.Buffer
+	zw, _ := transfer.NewEncoder(&buf, transfer.EncodingZstd)
+	zw.Write(contents)
+	zw.Close()
+	req := httptest.NewRequest(http.MethodPut, "/v1/files/"+logs.ID, &buf)
+	req.Header.Set("Content-Encoding", transfer.EncodingZstd)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, contents, body)
+	assert.Equal(t, transfer.AcceptEncoding, rec.Header().Get("Accept-Encoding"))
+
+	req = httptest.NewRequest(http.MethodPut, "/v1/files/"+logs.ID, strings.NewReader("??"))
+	req.Header.Set("Content-Encoding", "br")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestArchives(t *testing.T) {
+	env.SetEnv(false)
+
+	// contents are kept in the local store, under the service root
+	testSvc := newTestService(t)
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+
+	// everything is added to the drive through the API
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	newDir := func(parentID string, name string) *svc.Directory {
+		dir := svc.NewDirectory(name, "me", testDrv.ID, filepath.Join(clientRoot, name))
+		dir.ParentID = parentID
+		req := httptest.NewRequest(http.MethodPost, "/v1/dirs/"+dir.ID, nil)
+		req = req.WithContext(context.WithValue(req.Context(), Directory, dir))
+		w := httptest.NewRecorder()
+		api.NewDir(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return dir
+	}
+	upload := func(dirID string, name string, contents string) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(clientRoot, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = dirID
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		fw, err := mw.CreateFormFile("myFile", name)
+		if err != nil {
+			Fatal(t, err)
+		}
+		fw.Write([]byte(contents))
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/v1/files/"+f.ID, &form)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = req.WithContext(context.WithValue(req.Context(), File, f))
+		w := httptest.NewRecorder()
+		api.PutFile(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		// only the server's copy is left to archive
+		if err := os.Remove(f.ClientPath); err != nil {
+			Fatal(t, err)
+		}
+		return f
+	}
+	docs := newDir(testDrv.RootID, "docs")
+	newDir(docs.ID, "empty")
+	a := upload(testDrv.RootID, "a.txt", "contents of a")
+	b := upload(docs.ID, "b.txt", "contents of b")
+	stored, err := testSvc.Db.GetFileByID(a.ID)
+	if err != nil || stored == nil {
+		Fatal(t, fmt.Errorf("file (id=%s) not found: %v", a.ID, err))
+	}
+	_, err = os.Stat(stored.ServerPath)
+	assert.NoError(t, err)
+
+	getDir := func(dirID string, format string, header map[string]string) *httptest.ResponseRecorder {
+		dir, err := testSvc.Db.GetDirectoryByID(dirID)
+		if err != nil || dir == nil {
+			Fatal(t, fmt.Errorf("directory (id=%s) not found: %v", dirID, err))
+		}
+		req := httptest.NewRequest(http.MethodGet, "/v1/dirs/"+dirID+"?format="+format, nil)
+		req = req.WithContext(context.WithValue(req.Context(), Directory, dir))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		api.GetDir(w, req)
+		return w
+	}
+	newArchive := func(body string, format string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/archive?format="+format, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		api.NewArchive(w, req)
+		return w
+	}
+	// extract an archive from a response, and read back what's in it
+	extract := func(w *httptest.ResponseRecorder, format string) string {
+		dest := filepath.Join(GetTestingDir(), "extracted-"+auth.NewUUID())
+		archive := dest + transfer.ArchiveExt(format)
+		if err := os.WriteFile(archive, w.Body.Bytes(), 0644); err != nil {
+			Fatal(t, err)
+		}
+		if err := transfer.ExtractArchive(archive, dest, format, transfer.DefaultArchiveLimits); err != nil {
+			Fatal(t, err)
+		}
+		return dest
+	}
+	read := func(path string) string {
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	// directories are archived from the store, relative to the directory
+	for _, format := range transfer.ArchiveFormats {
+		w := getDir(testDrv.RootID, format, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, transfer.ArchiveContentType(format), w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "root"+transfer.ArchiveExt(format))
+		dest := extract(w, format)
+		assert.Equal(t, "contents of a", read(filepath.Join(dest, "a.txt")))
+		assert.Equal(t, "contents of b", read(filepath.Join(dest, "docs", "b.txt")))
+		info, err := os.Stat(filepath.Join(dest, "docs", "empty"))
+		assert.NoError(t, err)
+		assert.True(t, info.IsDir())
+	}
+	assert.Equal(t, http.StatusBadRequest, getDir(docs.ID, "rar", nil).Code)
+
+	// several items are archived together, each at the top of the archive
+	body := fmt.Sprintf(`{"files": [%q], "dirs": [%q]}`, a.ID, docs.ID)
+	w := newArchive(body, transfer.FormatTarGz)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "sfs-archive.tar.gz")
+	dest := extract(w, transfer.FormatTarGz)
+	assert.Equal(t, "contents of a", read(filepath.Join(dest, "a.txt")))
+	assert.Equal(t, "contents of b", read(filepath.Join(dest, "docs", "b.txt")))
+
+	// a single item is named after itself
+	w = newArchive(fmt.Sprintf(`{"dirs": [%q]}`, docs.ID), "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "docs.zip")
+
+	assert.Equal(t, http.StatusBadRequest, newArchive(`{}`, "").Code)
+	assert.Equal(t, http.StatusBadRequest, newArchive(`not json`, "").Code)
+	assert.Equal(t, http.StatusNotFound, newArchive(`{"files": ["nope"]}`, "").Code)
+
+	// locked files need their password
+	if err := testSvc.LockFile(b, "hunter2"); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, http.StatusLocked, getDir(docs.ID, "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, getDir(docs.ID, "", map[string]string{PasswordHeader: "wrong"}).Code)
+	w = getDir(docs.ID, "", map[string]string{PasswordHeader: "hunter2"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	dest = extract(w, transfer.FormatZip)
+	assert.Equal(t, "contents of b", read(filepath.Join(dest, "b.txt")))
+
+	// names at the top of an archive are unique
+	used := make(map[string]bool)
+	assert.Equal(t, "notes.txt", uniqueName(used, "notes.txt", false))
+	assert.Equal(t, "notes (2).txt", uniqueName(used, "notes.txt", false))
+	assert.Equal(t, "notes.txt (2)", uniqueName(used, "notes.txt", true))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestFileCtxStore(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	// FileCtx looks files up in the configured service's databases
+	defer func(root string) { svcCfg.SvcRoot = root }(svcCfg.SvcRoot)
+	svcCfg.SvcRoot = testSvc.SvcRoot
+
+	testDrv := newTestDrive(t, testSvc)
+	clientRoot := testDrv.RootPath
+	f, err := MakeTmpTxtFile(filepath.Join(clientRoot, "notes.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	f.DriveID = testDrv.ID
+	f.DirID = testDrv.RootID
+	f.Content = []byte("kept in the store")
+	if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+		Fatal(t, err)
+	}
+	// nothing is on the server's disk
+	_, err = os.Stat(f.ServerPath)
+	assert.True(t, os.IsNotExist(err))
+
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	r := chi.NewRouter()
+	r.Route("/v1/files/{fileID}", func(r chi.Router) {
+		r.Use(FileCtx)
+		r.Get("/", api.ServeFile)
+		r.Get("/sig", api.GetFileSignature)
+		r.Post("/delta", api.GetFileDelta)
+	})
+	get := func(method string, path string, body io.Reader) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, body))
+		return w
+	}
+
+	w := get(http.MethodGet, "/v1/files/"+f.ID, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "kept in the store", w.Body.String())
+	assert.Equal(t, http.StatusOK, get(http.MethodGet, "/v1/files/"+f.ID+"/sig", nil).Code)
+	assert.Equal(t, http.StatusNotFound, get(http.MethodGet, "/v1/files/"+auth.NewUUID(), nil).Code)
+
+	// files without contents are still found, but there's nothing to send
+	if err := testSvc.deleteObject(f.ServerPath); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, http.StatusNotFound, get(http.MethodGet, "/v1/files/"+f.ID, nil).Code)
+	sig, err := (&transfer.Signature{FileID: f.ID}).ToJSON()
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, http.StatusNotFound, get(http.MethodPost, "/v1/files/"+f.ID+"/delta", bytes.NewReader(sig)).Code)
+	assert.Equal(t, http.StatusOK, get(http.MethodGet, "/v1/files/"+f.ID+"/sig", nil).Code)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestDecodeContentLimit(t *testing.T) {
+	env.SetEnv(false)
+
+	defer func(max int64) { svcCfg.MaxDecodedSize = max }(svcCfg.MaxDecodedSize)
+	svcCfg.MaxDecodedSize = 1024 * 1024
+
+	// form uploads look up the drive's quota before they're read
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+	testDrv := newTestDrive(t, testSvc)
+
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	file := &svc.File{ID: auth.NewUUID(), Name: "bomb.txt", DriveID: testDrv.ID}
+	serve := func(h http.HandlerFunc, contentType string, body []byte) *httptest.ResponseRecorder {
+		// a few KB of zstd that expands to 64MB
+		var buf bytes.Buffer
+		zw, err := transfer.NewEncoder(&buf, transfer.EncodingZstd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(body)
+		zw.Close()
+		assert.True(t, buf.Len() < 64*1024)
+
+		req := httptest.NewRequest(http.MethodPut, "/v1/files/"+file.ID, &buf)
+		req.Header.Set("Content-Encoding", transfer.EncodingZstd)
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), File, file))
+		w := httptest.NewRecorder()
+		DecodeContent(h).ServeHTTP(w, req)
+		return w
+	}
+	bomb := make([]byte, 64*1024*1024)
+
+	// deltas and signatures are read whole
+	w := serve(api.PutFileDelta, "application/json", bomb)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	w = serve(api.GetFileDelta, "application/json", bomb)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// as are form uploads
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("myFile", file.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(bomb)
+	mw.Close()
+	w = serve(api.PutFile, mw.FormDataContentType(), form.Bytes())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+// counts how much of a request body was read
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestUploadQuota(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	testDrv := newTestDrive(t, testSvc)
+	if err := testDrv.SetQuota(64 * 1024); err != nil {
+		Fatal(t, err)
+	}
+	if err := testSvc.UpdateDrive(testDrv); err != nil {
+		Fatal(t, err)
+	}
+	clientRoot := testDrv.RootPath
+
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	upload := func(method string, file *svc.File, size int) (*httptest.ResponseRecorder, int64) {
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		fw, err := mw.CreateFormFile("myFile", file.Name)
+		if err != nil {
+			Fatal(t, err)
+		}
+		fw.Write(bytes.Repeat([]byte("a"), size))
+		mw.Close()
+
+		body := &countingReader{r: &form}
+		req := httptest.NewRequest(method, "/v1/files/"+file.ID, body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = req.WithContext(context.WithValue(req.Context(), File, file))
+		w := httptest.NewRecorder()
+		api.PutFile(w, req)
+		return w, body.n
+	}
+	addFile := func(name string, contents []byte) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(clientRoot, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = testDrv.ID
+		f.DirID = testDrv.RootID
+		f.Content = contents
+		f.Size = int64(len(contents))
+		if err := testSvc.AddFile(testDrv.RootID, f); err != nil {
+			Fatal(t, err)
+		}
+		// the server goes by the file's size, not the client's copy
+		if err := os.Remove(f.ClientPath); err != nil {
+			Fatal(t, err)
+		}
+		return f
+	}
+
+	// uploads larger than the whole quota are turned
+	// away without reading the rest of the body
+	newFile, err := MakeTmpTxtFile(filepath.Join(clientRoot, "new.txt"), 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	newFile.DriveID = testDrv.ID
+	newFile.DirID = testDrv.RootID
+	w, read := upload(http.MethodPost, newFile, 4*1024*1024)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.True(t, read < 256*1024)
+
+	// uploads that fit are accepted
+	f := addFile("notes.txt", []byte("notes"))
+	w, _ = upload(http.MethodPut, f, 1024)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// uploads larger than what's left are turned away too
+	addFile("other.txt", bytes.Repeat([]byte("b"), 32*1024))
+	drive, err := testSvc.LoadDrive(testDrv.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	limit := drive.FileQuota(f)
+	assert.True(t, limit < drive.TotalSize)
+	w, read = upload(http.MethodPut, f, int(limit)+16*1024)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	assert.True(t, read < limit+16*1024)
+	w, _ = upload(http.MethodPost, newFile, int(drive.FileQuota(nil))+1)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	w, _ = upload(http.MethodPut, f, int(limit))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestDriveBlobs(t *testing.T) {
+	env.SetEnv(false)
+
+	testSvc := newTestService(t)
+	testSvc.SetStore(storage.NewMemory())
+
+	// two users with their own drives
+	addFile := func(drv *svc.Drive, name string, contents string) *svc.File {
+		f, err := MakeTmpTxtFile(filepath.Join(drv.Root.Path, name), 1)
+		if err != nil {
+			Fatal(t, err)
+		}
+		f.DriveID = drv.ID
+		f.DirID = drv.RootID
+		f.Content = []byte(contents)
+		if err := testSvc.AddFile(drv.RootID, f); err != nil {
+			Fatal(t, err)
+		}
+		return f
+	}
+	alice, mallory := newTestDrive(t, testSvc), newTestDrive(t, testSvc)
+	secret := addFile(alice, "secret.txt", "alice's secret")
+	mine := addFile(mallory, "mine.txt", "mallory's file")
+	checksum := secret.Blob
+
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	r := chi.NewRouter()
+	r.Get("/v1/blobs/{checksum}", api.GetBlob)
+	hasBlob := func(driveID string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/blobs/"+checksum+"?drive="+driveID, nil))
+		return w.Code
+	}
+
+	// only drives that have the contents can find them
+	assert.Equal(t, http.StatusOK, hasBlob(alice.ID))
+	assert.Equal(t, http.StatusNotFound, hasBlob(mallory.ID))
+	assert.Equal(t, http.StatusNotFound, hasBlob(""))
+
+	// or claim them without uploading them
+	req := httptest.NewRequest(http.MethodPut, "/v1/files/"+mine.ID+"?blob="+checksum, nil)
+	req = req.WithContext(context.WithValue(req.Context(), File, mine))
+	w := httptest.NewRecorder()
+	api.PutFile(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	data, err := testSvc.readObject(mine.ServerPath)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "mallory's file", string(data))
+
+	// uploading the same contents still shares the blob
+	if err := testSvc.UpdateFile(mine, []byte("alice's secret")); err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, checksum, mine.Blob)
+	blob, err := testSvc.GetBlob(checksum)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, blob.Refs)
+	assert.Equal(t, http.StatusOK, hasBlob(mallory.ID))
+
+	if err := Clean(GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
@@ -0,0 +1,460 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+drive snapshots.
+
+a snapshot records a drive's whole directory tree at a point in time (see
+service/snapshot.go). file contents aren't copied. a snapshot holds a
+reference to the blob each of its files was in (see blobs.go), so those
+contents stay in the blob store for as long as the snapshot does, even after
+the files themselves are changed or deleted. the contents of locked files,
+which are otherwise kept out of the blob store, are added to it when they're
+snapshotted.
+
+restoring a snapshot (or part of one) puts everything back the way it was:
+
+  - files and directories removed since are added back with their original ids.
+  - files and directories moved or renamed since are moved back.
+  - files whose contents changed get their old contents back. the current
+    contents are saved as a version first, as with RestoreVersion.
+  - anything added since is moved to the recycle bin.
+
+a snapshot is taken before each restore, so restores can be undone.
+
+drives can also be snapshotted on a schedule (see Drive.SnapshotInterval).
+only the latest Drive.MaxSnapshots scheduled snapshots are kept. snapshots
+taken on demand are kept until they're removed.
+*/
+
+// how often drives are checked for scheduled snapshots that are due
+const SnapshotCheckInterval = time.Minute * 10
+
+var (
+	ErrSnapshotNotFound     = errors.New("snapshot not found")
+	ErrSnapshotPathNotFound = errors.New("not found in snapshot")
+)
+
+// take a snapshot of a drive. name is an optional label for it.
+func (s *Service) CreateSnapshot(driveID string, name string) (*svc.Snapshot, error) {
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	return s.createSnapshot(drive, name, false)
+}
+
+func (s *Service) createSnapshot(drive *svc.Drive, name string, scheduled bool) (*svc.Snapshot, error) {
+	entries := svc.SnapshotEntries(drive.Root)
+	for i, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		var err error
+		if e.Blob, err = s.retainBlob(e.File); err != nil {
+			s.releaseEntries(entries[:i])
+			return nil, fmt.Errorf("failed to snapshot %s (id=%s): %v", e.File.Name, e.File.ID, err)
+		}
+	}
+	snap, err := svc.NewSnapshot(drive, name, entries)
+	if err != nil {
+		s.releaseEntries(entries)
+		return nil, err
+	}
+	snap.Scheduled = scheduled
+	if err := s.Db.AddSnapshot(snap); err != nil {
+		s.releaseEntries(entries)
+		return nil, fmt.Errorf("failed to add snapshot to database: %v", err)
+	}
+	s.log.Info(fmt.Sprintf("snapshot (id=%s) taken of drive (id=%s). %d files, %d bytes", snap.ID, drive.ID, snap.Files, snap.Size))
+	return snap, nil
+}
+
+// release the blobs held by a snapshot's entries
+func (s *Service) releaseEntries(entries []*svc.SnapshotEntry) error {
+	for _, e := range entries {
+		if err := s.releaseBlob(e.Blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get all snapshots of a drive, newest first.
+func (s *Service) GetSnapshots(driveID string) ([]*svc.Snapshot, error) {
+	return s.Db.GetSnapshots(driveID)
+}
+
+// get one of a drive's snapshots
+func (s *Service) GetSnapshot(driveID string, snapshotID string) (*svc.Snapshot, error) {
+	snap, err := s.Db.GetSnapshot(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if snap == nil || snap.DriveID != driveID {
+		return nil, fmt.Errorf("snapshot (id=%s): %w", snapshotID, ErrSnapshotNotFound)
+	}
+	return snap, nil
+}
+
+// remove one of a drive's snapshots. contents nothing else
+// refers to are removed from the blob store.
+func (s *Service) RemoveSnapshot(driveID string, snapshotID string) error {
+	snap, err := s.GetSnapshot(driveID, snapshotID)
+	if err != nil {
+		return err
+	}
+	return s.removeSnapshot(snap)
+}
+
+func (s *Service) removeSnapshot(snap *svc.Snapshot) error {
+	entries, err := snap.Entries()
+	if err != nil {
+		return err
+	}
+	if err := s.releaseEntries(entries); err != nil {
+		return err
+	}
+	if err := s.Db.RemoveSnapshot(snap.ID); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("snapshot (id=%s) of drive (id=%s) removed", snap.ID, snap.DriveID))
+	return nil
+}
+
+// remove all snapshots of a drive
+func (s *Service) removeSnapshots(driveID string) error {
+	snaps, err := s.Db.GetSnapshots(driveID)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snaps {
+		if err := s.removeSnapshot(snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find what changed in a drive between two snapshots. if toID is
+// empty, the snapshot is compared with the drive as it is now.
+func (s *Service) DiffSnapshots(driveID string, fromID string, toID string) ([]*svc.SnapshotChange, error) {
+	from, err := s.GetSnapshot(driveID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	before, err := from.Entries()
+	if err != nil {
+		return nil, err
+	}
+	var after []*svc.SnapshotEntry
+	if toID == "" {
+		drive, err := s.LoadDrive(driveID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load drive: %v", err)
+		}
+		after = svc.SnapshotEntries(drive.Root)
+	} else {
+		to, err := s.GetSnapshot(driveID, toID)
+		if err != nil {
+			return nil, err
+		}
+		if after, err = to.Entries(); err != nil {
+			return nil, err
+		}
+	}
+	return svc.DiffSnapshots(before, after), nil
+}
+
+// restore a drive to the way it was when a snapshot was taken. path is the
+// location of a file or directory in the snapshot (relative to the drive's
+// root) to restore by itself. the whole drive is restored if it's empty.
+func (s *Service) RestoreSnapshot(driveID string, snapshotID string, path string) error {
+	snap, err := s.GetSnapshot(driveID, snapshotID)
+	if err != nil {
+		return err
+	}
+	entries, err := snap.Entries()
+	if err != nil {
+		return err
+	}
+	restore := svc.EntriesUnder(entries, path)
+	if len(restore) == 0 {
+		return fmt.Errorf("%s %w (id=%s)", path, ErrSnapshotPathNotFound, snapshotID)
+	}
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	// so this can be undone
+	if _, err := s.createSnapshot(drive, "before restoring "+snapshotName(snap), false); err != nil {
+		return err
+	}
+	for _, e := range restore {
+		if e.IsDir() {
+			err = s.restoreSnapshotDir(drive, e)
+		} else {
+			err = s.restoreSnapshotFile(drive, e)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore %s: %w", e.Path, err)
+		}
+	}
+	// anything that's been added since goes to the recycle bin. directories
+	// come before anything in them, so their contents go along with them.
+	keep := make(map[string]bool, len(restore))
+	for _, e := range restore {
+		keep[e.ID()] = true
+	}
+	for _, e := range svc.EntriesUnder(svc.SnapshotEntries(drive.Root), restore[0].Path) {
+		if keep[e.ID()] {
+			continue
+		}
+		if e.IsDir() {
+			if dir := drive.GetDir(e.ID()); dir != nil {
+				err = s.removeDir(drive, dir, ServerDeviceID)
+			}
+		} else if file := drive.GetFile(e.ID()); file != nil {
+			err = s.deleteFile(drive, file, ServerDeviceID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %v", e.Path, err)
+		}
+	}
+	if err := s.SaveDrive(drive); err != nil {
+		return err
+	}
+	if err := s.SaveState(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	s.log.Info(fmt.Sprintf("drive (id=%s) restored from snapshot (id=%s). %d items restored", driveID, snapshotID, len(restore)))
+	return nil
+}
+
+// name of a snapshot for logs and labels
+func snapshotName(snap *svc.Snapshot) string {
+	if snap.Name != "" {
+		return fmt.Sprintf("%q", snap.Name)
+	}
+	return snap.CreatedAt.Format(time.RFC3339)
+}
+
+// add a directory back to a drive, or move it back to where it was
+func (s *Service) restoreSnapshotDir(drive *svc.Drive, entry *svc.SnapshotEntry) error {
+	dir := entry.Dir
+	if dir.IsRoot() {
+		return nil
+	}
+	parent := drive.GetDir(dir.ParentID)
+	if parent == nil {
+		s.log.Warn(fmt.Sprintf("original directory (id=%s) for %s not found. restoring to root", dir.ParentID, dir.Name))
+		parent = drive.Root
+	}
+	live := drive.GetDir(dir.ID)
+	if live == nil {
+		dir.ServerPath = s.dirServerPath(drive, parent, dir.Name)
+		dir.Path = dir.ServerPath
+		dir.Files = make(map[string]*svc.File, 0)
+		dir.Dirs = make(map[string]*svc.Directory, 0)
+		if err := drive.AddSubDir(parent.ID, dir); err != nil {
+			return err
+		}
+		return s.Db.AddDir(dir)
+	}
+	if live.Name != dir.Name || live.Parent == nil || live.Parent.ID != parent.ID {
+		return s.relocateDir(drive, live, parent, dir.Name, time.Time{})
+	}
+	return nil
+}
+
+// add a file back to a drive, or restore its contents and location
+func (s *Service) restoreSnapshotFile(drive *svc.Drive, entry *svc.SnapshotEntry) error {
+	file := entry.File
+	parent := drive.GetDir(file.DirID)
+	if parent == nil {
+		s.log.Warn(fmt.Sprintf("original directory (id=%s) for %s not found. restoring to root", file.DirID, file.Name))
+		parent = drive.Root
+	}
+	live := drive.GetFile(file.ID)
+	if live != nil && live.CheckSum == file.CheckSum && live.DirID == parent.ID && live.Name == file.Name {
+		return nil // nothing to do
+	}
+	if live != nil {
+		// locked files can only be moved back
+		if live.Protected && (live.CheckSum != file.CheckSum || !file.Protected) {
+			return errLocked(live)
+		}
+		if err := drive.CheckFileQuota(live, file.Size); err != nil {
+			return err
+		}
+		// keep a copy of the current contents. contents from
+		// before a drive was end-to-end encrypted aren't kept.
+		if live.CheckSum != file.CheckSum && (!drive.E2EE || sealedByClient(live)) {
+			if err := s.saveVersion(drive, live); err != nil {
+				return err
+			}
+		}
+		origDir := drive.GetDir(live.DirID)
+		if err := drive.DetachFile(live.DirID, live); err != nil {
+			return err
+		}
+		if err := s.Db.UpdateDir(origDir); err != nil {
+			return err
+		}
+		// the file's current blob is released once it's replaced
+		file.Blob = live.Blob
+		file.Version = live.Version
+	} else {
+		if err := drive.CheckQuota(file.Size, file.Size); err != nil {
+			return err
+		}
+		file.Blob = ""
+	}
+	// put the snapshot's contents back in place
+	file.ServerPath = s.buildFilePath(drive.OwnerName, file.ID)
+	if entry.Blob != "" {
+		blob, err := s.GetBlob(entry.Blob)
+		if err != nil {
+			return err
+		}
+		if blob == nil {
+			return fmt.Errorf("%w: %s", ErrBlobNotFound, entry.Blob)
+		}
+		if err := s.copyObject(blob.Path, file.ServerPath); err != nil {
+			return err
+		}
+	} else if err := s.deleteObject(file.ServerPath); err != nil {
+		return err
+	}
+	if file.Protected {
+		// locked files aren't in the blob store
+		if err := s.releaseBlob(file.Blob); err != nil {
+			return err
+		}
+		file.Blob = ""
+	} else if err := s.commitBlob(file); err != nil {
+		return err
+	}
+	file.IncrementVersion(ServerDeviceID)
+	if err := drive.AddFile(parent.ID, file); err != nil {
+		return fmt.Errorf("failed to add file to drive: %v", err)
+	}
+	if err := s.Db.UpdateDir(parent); err != nil {
+		return err
+	}
+	if live != nil {
+		return s.Db.UpdateFile(file)
+	}
+	return s.Db.AddFile(file)
+}
+
+// --------- scheduled snapshots --------------------------------
+
+// take a snapshot of every drive that's due for a scheduled one, and
+// remove any scheduled snapshots past each drive's limit.
+func (s *Service) TakeScheduledSnapshots() error {
+	drives, err := s.Db.GetDrives()
+	if err != nil {
+		return err
+	}
+	for _, d := range drives {
+		if d.SnapshotInterval <= 0 {
+			continue
+		}
+		snaps, err := s.Db.GetSnapshots(d.ID)
+		if err != nil {
+			return err
+		}
+		due := true
+		for _, snap := range snaps {
+			if snap.Scheduled {
+				due = time.Since(snap.CreatedAt) >= d.SnapshotInterval
+				break
+			}
+		}
+		if due {
+			drive, err := s.LoadDrive(d.ID)
+			if err != nil {
+				return fmt.Errorf("failed to load drive (id=%s): %v", d.ID, err)
+			}
+			snap, err := s.createSnapshot(drive, "", true)
+			if err != nil {
+				return fmt.Errorf("failed to snapshot drive (id=%s): %v", d.ID, err)
+			}
+			snaps = append([]*svc.Snapshot{snap}, snaps...)
+		}
+		if err := s.pruneSnapshots(d, snaps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove a drive's oldest scheduled snapshots past its limit.
+// snaps should be newest first.
+func (s *Service) pruneSnapshots(drive *svc.Drive, snaps []*svc.Snapshot) error {
+	if drive.MaxSnapshots <= 0 {
+		return nil
+	}
+	var kept int
+	for _, snap := range snaps {
+		if !snap.Scheduled {
+			continue
+		}
+		if kept++; kept <= drive.MaxSnapshots {
+			continue
+		}
+		if err := s.removeSnapshot(snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// periodically take scheduled snapshots of every drive that's due for one.
+// should be run in its own goroutine.
+func (s *Service) RunSnapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.TakeScheduledSnapshots(); err != nil {
+			s.log.Error(err.Error())
+		}
+		<-ticker.C
+	}
+}
+
+// update how often a drive is snapshotted on a schedule, and how many
+// of those snapshots are kept. anything past the new limit is removed.
+func (s *Service) SetSnapshotSchedule(driveID string, interval time.Duration, maxSnapshots int) error {
+	drive, err := s.LoadDrive(driveID)
+	if err != nil {
+		return fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	drive.SnapshotInterval = interval
+	drive.MaxSnapshots = maxSnapshots
+	if err := s.UpdateDrive(drive); err != nil {
+		return err
+	}
+	snaps, err := s.Db.GetSnapshots(driveID)
+	if err != nil {
+		return err
+	}
+	return s.pruneSnapshots(drive, snaps)
+}
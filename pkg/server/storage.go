@@ -0,0 +1,158 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/sfs/pkg/storage"
+)
+
+/*
+file contents on the server are kept in a storage.Store (see pkg/storage),
+picked with SERVICE_STORAGE:
+
+	local  files under the service root. the default.
+	s3     objects in an S3-compatible bucket (SERVICE_S3_*).
+
+everything the server keeps for its users' files (contents, blobs, versions,
+and the recycle bin) goes through the store. objects are addressed by the
+same paths as before, i.e. file.ServerPath, with their path relative to the
+service root as their key. that way paths in the databases don't depend on
+where the contents are kept, and the local store keeps them where they've
+always been. databases and state files always stay on the local disk.
+*/
+
+// build the store configured for the service
+func newStore(cfg *SvcCfg) (storage.Store, error) {
+	switch strings.ToLower(cfg.Storage) {
+	case "", "local":
+		return storage.NewLocal(cfg.SvcRoot), nil
+	case "s3":
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+			return nil, fmt.Errorf("s3 storage needs an endpoint and bucket (SERVICE_S3_ENDPOINT, SERVICE_S3_BUCKET)")
+		}
+		return storage.NewS3(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %q", cfg.Storage)
+	}
+}
+
+// the store holding file contents for this service.
+// uses the local disk unless told otherwise.
+func (s *Service) Store() storage.Store {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	if s.store == nil {
+		s.store = storage.NewLocal(s.svcCfgs.SvcRoot)
+	}
+	return s.store
+}
+
+// use a different store for file contents. anything in the
+// current store is left where it is.
+func (s *Service) SetStore(store storage.Store) {
+	s.storeMu.Lock()
+	defer s.storeMu.Unlock()
+	s.store = store
+}
+
+// key for the object at the given server-side path
+func (s *Service) storeKey(path string) (string, error) {
+	rel, err := filepath.Rel(s.svcCfgs.SvcRoot, path)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.ToSlash(rel)
+	if key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("%w: %s is outside the service root", storage.ErrInvalidKey, path)
+	}
+	return key, nil
+}
+
+// write the object at path
+func (s *Service) putObject(path string, r io.Reader) error {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return err
+	}
+	return s.Store().Put(key, r)
+}
+
+// open the object at path for reading
+func (s *Service) getObject(path string) (io.ReadCloser, error) {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store().Get(key)
+}
+
+// open the object at path for random access
+func (s *Service) openObject(path string) (storage.File, error) {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Open(s.Store(), key)
+}
+
+// open the object at path for db.MigrateDBs
+func (s *Service) migrationReader(path string) (io.ReadCloser, error) {
+	return s.openObject(path)
+}
+
+// read the whole object at path into memory
+func (s *Service) readObject(path string) ([]byte, error) {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return storage.ReadAll(s.Store(), key)
+}
+
+func (s *Service) statObject(path string) (*storage.Object, error) {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.Store().Stat(key)
+}
+
+func (s *Service) objectExists(path string) (bool, error) {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return false, err
+	}
+	return storage.Exists(s.Store(), key)
+}
+
+func (s *Service) copyObject(src string, dst string) error {
+	srcKey, err := s.storeKey(src)
+	if err != nil {
+		return err
+	}
+	dstKey, err := s.storeKey(dst)
+	if err != nil {
+		return err
+	}
+	return storage.Copy(s.Store(), srcKey, dstKey)
+}
+
+func (s *Service) deleteObject(path string) error {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return err
+	}
+	return s.Store().Delete(key)
+}
+
+// remove every object under the directory at path
+func (s *Service) deleteObjects(path string) error {
+	key, err := s.storeKey(path)
+	if err != nil {
+		return err
+	}
+	return storage.DeleteAll(s.Store(), key+"/")
+}
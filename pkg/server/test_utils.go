@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/db"
 	svc "github.com/sfs/pkg/service"
 )
 
@@ -78,6 +79,39 @@ func Fatal(t *testing.T, err error) {
 	t.Fatalf("[ERROR] %v", err)
 }
 
+// make a stand-alone service for a test, with its own databases, rooted in
+// the testing directory. contents are kept in the local store under its root.
+func newTestService(t *testing.T) *Service {
+	svcRoot := filepath.Join(GetTestingDir(), t.Name()+"-svc")
+	for _, d := range []string{"dbs", "users", "state"} {
+		if err := os.MkdirAll(filepath.Join(svcRoot, d), 0755); err != nil {
+			Fatal(t, err)
+		}
+	}
+	if err := db.InitDBs(filepath.Join(svcRoot, "dbs")); err != nil {
+		Fatal(t, err)
+	}
+	s := NewService(svcRoot)
+	s.svcCfgs = &SvcCfg{SvcRoot: svcRoot}
+	return s
+}
+
+// add an empty drive for a new user to a test service. the drive's
+// client-side root is a new directory in the testing directory.
+func newTestDrive(t *testing.T, s *Service) *svc.Drive {
+	user := auth.NewUUID()
+	clientRoot := filepath.Join(GetTestingDir(), "client-"+user)
+	if err := os.MkdirAll(clientRoot, 0755); err != nil {
+		Fatal(t, err)
+	}
+	root := svc.NewRootDirectory("root", user, auth.NewUUID(), clientRoot)
+	drive := svc.NewDrive(root.DriveID, user, user, clientRoot, root.ID, root)
+	if err := s.AddDrive(drive); err != nil {
+		Fatal(t, err)
+	}
+	return drive
+}
+
 // make a temp .txt file of n size (in bytes).
 //
 // n is determined by textReps since that will be how
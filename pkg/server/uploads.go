@@ -0,0 +1,281 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+resumable uploads (see service/uploads.go).
+
+parts of an upload are written to a staging file on the server's local disk,
+at the offset they belong at, so they can arrive in any order and more than
+one can be written at once. the file's contents aren't touched until the
+session is committed. then the staging file is checked against the session's
+checksum and streamed to the store in its place, the same as any other update.
+
+the staging file and the session are removed once the upload is committed,
+aborted, or expires.
+
+since each staging file takes up the full size of its upload right away, the
+size of every open session counts against the drive's quota until then.
+*/
+
+// how often expired upload sessions are cleaned up
+const UploadCheckInterval = time.Minute * 30
+
+var (
+	ErrUploadNotFound   = errors.New("upload session not found")
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	ErrUploadChecksum   = errors.New("upload checksum mismatch")
+	ErrUploadInvalid    = errors.New("invalid upload")
+	ErrUploadRange      = errors.New("part is outside the upload")
+)
+
+// path to the staging file for an upload session
+func (s *Service) uploadPath(uploadID string) string {
+	return filepath.Join(s.SvcRoot, "uploads", uploadID)
+}
+
+// open a new upload session for size bytes of a file's contents.
+// checksum is the checksum of everything that will be sent.
+func (s *Service) NewUpload(file *svc.File, size int64, checksum string) (*svc.UploadSession, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("%w size: %d", ErrUploadInvalid, size)
+	}
+	if !svc.ValidAlgorithm(svc.ChecksumAlgorithm(checksum)) {
+		return nil, fmt.Errorf("%w checksum: %q", ErrUploadInvalid, checksum)
+	}
+	if file.Protected {
+		return nil, errLocked(file)
+	}
+	drive, err := s.LoadDrive(file.DriveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load drive: %v", err)
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", file.DriveID)
+	}
+
+	// hold the lock until the session is added so two new
+	// sessions can't both claim the same free space
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+	reserved, err := s.reservedUploads(drive.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := drive.CheckReservedQuota(file, size, reserved); err != nil {
+		return nil, err
+	}
+
+	u := svc.NewUploadSession(file, size, checksum)
+	path := s.uploadPath(u.ID)
+	if err := os.MkdirAll(filepath.Dir(path), svc.PERMS); err != nil {
+		return nil, err
+	}
+	staged, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %v", err)
+	}
+	defer staged.Close()
+	if err := staged.Truncate(size); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to create staging file: %v", err)
+	}
+	if err := s.Db.AddUpload(u); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to add upload to database: %v", err)
+	}
+	s.log.Info(fmt.Sprintf("upload (id=%s) of %d bytes opened for %s (id=%s)", u.ID, size, file.Name, file.ID))
+	return u, nil
+}
+
+// total size of the open upload sessions for files in a drive
+func (s *Service) reservedUploads(driveID string) (int64, error) {
+	uploads, err := s.Db.GetDriveUploads(driveID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, u := range uploads {
+		if !u.Expired() {
+			total += u.Size
+		}
+	}
+	return total, nil
+}
+
+// get an upload session for a file. returns ErrUploadNotFound
+// if there isn't one, or it has expired.
+func (s *Service) GetUpload(file *svc.File, uploadID string) (*svc.UploadSession, error) {
+	u, err := s.Db.GetUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil || u.FileID != file.ID || u.Expired() {
+		return nil, fmt.Errorf("%w: %s", ErrUploadNotFound, uploadID)
+	}
+	return u, nil
+}
+
+// get all open upload sessions for a file
+func (s *Service) GetUploads(file *svc.File) ([]*svc.UploadSession, error) {
+	uploads, err := s.Db.GetFileUploads(file.ID)
+	if err != nil {
+		return nil, err
+	}
+	open := make([]*svc.UploadSession, 0, len(uploads))
+	for _, u := range uploads {
+		if !u.Expired() {
+			open = append(open, u)
+		}
+	}
+	return open, nil
+}
+
+// write a part of an upload starting at offset. whatever was written is
+// recorded as received, even if r fails partway through, so the client
+// can pick up from there.
+func (s *Service) WriteUpload(file *svc.File, uploadID string, offset int64, r io.Reader) (*svc.UploadSession, error) {
+	u, err := s.GetUpload(file, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset > u.Size {
+		return nil, fmt.Errorf("%w: invalid offset %d for upload of %d bytes", ErrUploadRange, offset, u.Size)
+	}
+	staged, err := os.OpenFile(s.uploadPath(u.ID), os.O_WRONLY, svc.PERMS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open staging file: %v", err)
+	}
+	n, werr := io.Copy(io.NewOffsetWriter(staged, offset), io.LimitReader(r, u.Size-offset))
+	if err := staged.Close(); err != nil && werr == nil {
+		werr = err
+	}
+	// anything past the end of the upload doesn't belong to it
+	if werr == nil {
+		if extra, _ := io.Copy(io.Discard, io.LimitReader(r, 1)); extra > 0 {
+			werr = fmt.Errorf("%w: part runs past the end of the upload (%d bytes)", ErrUploadRange, u.Size)
+		}
+	}
+
+	// parts can be written at the same time, so record
+	// them against the latest copy of the session
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+	if u, err = s.GetUpload(file, uploadID); err != nil {
+		return nil, err
+	}
+	u.Add(svc.ByteRange{Start: offset, End: offset + n})
+	u.Touch()
+	if err := s.Db.UpdateUpload(u); err != nil {
+		return nil, err
+	}
+	if werr != nil {
+		return u, fmt.Errorf("failed to write part at offset %d: %w", offset, werr)
+	}
+	return u, nil
+}
+
+// check an upload against its checksum and replace the file's contents
+// with it. checksum is the client's checksum for the contents of files on
+// end-to-end encrypted drives, as with UpdateFile.
+func (s *Service) CommitUpload(file *svc.File, uploadID string, checksum string) error {
+	u, err := s.GetUpload(file, uploadID)
+	if err != nil {
+		return err
+	}
+	if !u.Complete() {
+		return fmt.Errorf("%w: %d of %d bytes received", ErrUploadIncomplete, u.Acknowledged(), u.Size)
+	}
+	path := s.uploadPath(u.ID)
+	cs, err := svc.CalculateChecksumWith(path, svc.ChecksumAlgorithm(u.CheckSum))
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum of upload: %v", err)
+	}
+	if cs != u.CheckSum {
+		// there's no telling which parts were damaged, so start over
+		if err := s.removeUpload(u.ID); err != nil {
+			s.log.Error(err.Error())
+		}
+		return fmt.Errorf("%w: expected %s, got %s", ErrUploadChecksum, u.CheckSum, cs)
+	}
+	e2ee, err := s.isE2EE(file)
+	if err != nil {
+		return err
+	}
+	if e2ee && svc.ChecksumAlgorithm(checksum) != transfer.MACAlgorithm {
+		return errE2EE(file)
+	}
+
+	staged, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open staging file: %v", err)
+	}
+	err = s.replaceContents(file, staged, u.Size, checksum)
+	staged.Close()
+	if err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("upload (id=%s) committed to %s (id=%s)", u.ID, file.Name, file.ID))
+	return s.removeUpload(u.ID)
+}
+
+// cancel an upload session
+func (s *Service) AbortUpload(file *svc.File, uploadID string) error {
+	u, err := s.GetUpload(file, uploadID)
+	if err != nil {
+		return err
+	}
+	return s.removeUpload(u.ID)
+}
+
+// remove an upload session and its staging file
+func (s *Service) removeUpload(uploadID string) error {
+	if err := os.Remove(s.uploadPath(uploadID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove staging file for upload (id=%s): %v", uploadID, err)
+	}
+	return s.Db.RemoveUpload(uploadID)
+}
+
+// remove upload sessions that haven't been touched in svc.UploadSessionTTL
+func (s *Service) ExpireUploads() error {
+	uploads, err := s.Db.GetUploads()
+	if err != nil {
+		return fmt.Errorf("failed to get upload sessions: %v", err)
+	}
+	var removed int
+	for _, u := range uploads {
+		if !u.Expired() {
+			continue
+		}
+		if err := s.removeUpload(u.ID); err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		s.log.Info(fmt.Sprintf("removed %d expired upload session(s)", removed))
+	}
+	return nil
+}
+
+// periodically remove expired upload sessions. blocks, so run it in its own goroutine.
+func (s *Service) RunUploadCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.ExpireUploads(); err != nil {
+			s.log.Error(err.Error())
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+)
+
+/*
+a unique piece of file contents in the server's blob store.
+
+the server stores the contents of each file once per unique checksum.
+files with identical contents, even across drives, all refer to the same
+blob, and Refs counts how many files do. a blob is removed once nothing
+refers to it anymore.
+*/
+type Blob struct {
+	CheckSum  string    `json:"checksum"`   // checksum of the contents. used as the blob's key
+	Path      string    `json:"path"`       // location of the contents in the blob store
+	Size      int64     `json:"size"`       // size of the contents in bytes
+	Refs      int       `json:"refs"`       // number of files referring to this blob
+	CreatedAt time.Time `json:"created_at"` // when the blob was first stored
+}
+
+// create a new blob entry with a single reference.
+// does not copy any contents to path.
+func NewBlob(checksum string, path string, size int64) *Blob {
+	return &Blob{
+		CheckSum:  checksum,
+		Path:      path,
+		Size:      size,
+		Refs:      1,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func (b *Blob) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
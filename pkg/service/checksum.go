@@ -0,0 +1,262 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+/*
+file checksums.
+
+checksums are stored as "algo:hex", i.e. "sha256:9f86d08...", so they're
+printable, can be checked with external tools, and say which algorithm they
+were made with. each drive picks the algorithm used for its files.
+
+	sha256   SHA-256. the default.
+	blake2b  BLAKE2b-256. cryptographic, and faster than SHA-256 on 64-bit machines.
+	xxh64    xxHash64. not cryptographic, but very fast. fine for change detection.
+
+checksums made with different algorithms can't be compared, so anything
+comparing a file against a known checksum should use the algorithm the
+checksum was made with (see CalculateChecksumLike).
+*/
+
+// supported checksum algorithms
+const (
+	SHA256  = "sha256"
+	BLAKE2b = "blake2b"
+	XXH64   = "xxh64"
+
+	DefaultAlgorithm = SHA256
+)
+
+var (
+	checksumMu sync.RWMutex
+
+	// key = algorithm name, value = hash constructor
+	checksums = map[string]func() hash.Hash{
+		SHA256: sha256.New,
+		BLAKE2b: func() hash.Hash {
+			h, _ := blake2b.New256(nil) // only fails with a key longer than 64 bytes
+			return h
+		},
+		XXH64: func() hash.Hash { return xxhash.New() },
+	}
+)
+
+// add a checksum algorithm, or replace an existing one
+func RegisterChecksum(algo string, newHash func() hash.Hash) {
+	checksumMu.Lock()
+	defer checksumMu.Unlock()
+	checksums[algo] = newHash
+}
+
+// names of all registered checksum algorithms
+func ChecksumAlgorithms() []string {
+	checksumMu.RLock()
+	defer checksumMu.RUnlock()
+	algos := make([]string, 0, len(checksums))
+	for algo := range checksums {
+		algos = append(algos, algo)
+	}
+	sort.Strings(algos)
+	return algos
+}
+
+// whether a checksum algorithm is registered
+func ValidAlgorithm(algo string) bool {
+	checksumMu.RLock()
+	defer checksumMu.RUnlock()
+	_, ok := checksums[algo]
+	return ok
+}
+
+// get a new hash for a checksum algorithm
+func NewHash(algo string) (hash.Hash, error) {
+	checksumMu.RLock()
+	newHash, ok := checksums[algo]
+	checksumMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm: %q", algo)
+	}
+	return newHash(), nil
+}
+
+// format a digest as "algo:hex"
+func FormatChecksum(algo string, sum []byte) string {
+	return algo + ":" + hex.EncodeToString(sum)
+}
+
+// split a checksum into its algorithm and hex digest.
+// returns an error for checksums not in "algo:hex" form.
+func ParseChecksum(checksum string) (string, string, error) {
+	algo, digest, found := strings.Cut(checksum, ":")
+	if !found || algo == "" || digest == "" {
+		return "", "", fmt.Errorf("invalid checksum format: %q", checksum)
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return "", "", fmt.Errorf("invalid checksum digest: %v", err)
+	}
+	return algo, digest, nil
+}
+
+// get the algorithm a checksum was made with.
+// returns an empty string for invalid or legacy checksums.
+func ChecksumAlgorithm(checksum string) string {
+	algo, _, err := ParseChecksum(checksum)
+	if err != nil {
+		return ""
+	}
+	return algo
+}
+
+// whether a checksum is in the raw sha256 format used by older versions of sfs
+func IsLegacyChecksum(checksum string) bool {
+	return checksum != "" && ChecksumAlgorithm(checksum) == ""
+}
+
+// convert a checksum from older versions of sfs, which stored raw sha256
+// digest bytes, to "sha256:hex". other checksums are returned as-is.
+func UpgradeChecksum(checksum string) string {
+	if !IsLegacyChecksum(checksum) || len(checksum) != sha256.Size {
+		return checksum
+	}
+	return FormatChecksum(SHA256, []byte(checksum))
+}
+
+// calculate a file's checksum with the default algorithm
+func CalculateChecksum(filePath string) (string, error) {
+	return CalculateChecksumWith(filePath, DefaultAlgorithm)
+}
+
+// calculate a file's checksum with the given algorithm
+func CalculateChecksumWith(filePath string, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return checksumOf(h, algo, file)
+}
+
+// calculate the checksum of everything read from r with the given algorithm
+func ChecksumOf(r io.Reader, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	return checksumOf(h, algo, r)
+}
+
+func checksumOf(h hash.Hash, algo string, r io.Reader) (string, error) {
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return FormatChecksum(algo, h.Sum(nil)), nil
+}
+
+// calculate a file's checksum with the same algorithm as another checksum,
+// so the two can be compared. uses fallback if the algorithm can't be
+// determined from the other checksum.
+func CalculateChecksumLike(filePath string, other string, fallback string) (string, error) {
+	algo := ChecksumAlgorithm(other)
+	if algo == "" || !ValidAlgorithm(algo) {
+		algo = fallback
+	}
+	return CalculateChecksumWith(filePath, algo)
+}
+
+// the algorithm used for this file's checksums
+func (f *File) algorithm() string {
+	if f.Algorithm == "" || !ValidAlgorithm(f.Algorithm) {
+		return DefaultAlgorithm
+	}
+	return f.Algorithm
+}
+
+// make sure the file's contents still match its checksum
+func (f *File) ValidateChecksum() error {
+	cs, err := CalculateChecksumLike(f.GetPath(), f.CheckSum, f.algorithm())
+	if err != nil {
+		return fmt.Errorf("unable to calculate checksum: %v", err)
+	}
+	if cs != f.CheckSum {
+		return fmt.Errorf("checksum mismatch! orig: %s, new: %s", cs, f.CheckSum)
+	}
+	return nil
+}
+
+// recalculate the file's checksum
+func (f *File) UpdateChecksum() error {
+	newCs, err := CalculateChecksumWith(f.GetPath(), f.algorithm())
+	if err != nil {
+		return fmt.Errorf("CalculateChecksum failed: %v", err)
+	}
+	f.CheckSum = newCs
+	f.LastSync = time.Now().UTC()
+	return nil
+}
+
+// switch the file to a different checksum algorithm and recalculate its checksum.
+// a no-op if the file already uses the algorithm and its checksum is up to date.
+func (f *File) SetAlgorithm(algo string) error {
+	if !ValidAlgorithm(algo) {
+		return fmt.Errorf("unsupported checksum algorithm: %q", algo)
+	}
+	if f.Algorithm == algo && ChecksumAlgorithm(f.CheckSum) == algo {
+		return nil
+	}
+	cs, err := CalculateChecksumWith(f.GetPath(), algo)
+	if err != nil {
+		return err
+	}
+	f.Algorithm = algo
+	f.CheckSum = cs
+	return nil
+}
+
+// the checksum algorithm used by this drive's files
+func (d *Drive) GetAlgorithm() string {
+	if d.Algorithm == "" {
+		return DefaultAlgorithm
+	}
+	return d.Algorithm
+}
+
+// change the checksum algorithm used by this drive and recalculate the
+// checksums of all of its files. returns the files that were updated.
+func (d *Drive) SetAlgorithm(algo string) ([]*File, error) {
+	if !ValidAlgorithm(algo) {
+		return nil, fmt.Errorf("unsupported checksum algorithm: %q", algo)
+	}
+	d.Algorithm = algo
+	if !d.HasRoot() {
+		return nil, nil
+	}
+	updated := make([]*File, 0)
+	for _, file := range d.GetFiles() {
+		if file.Algorithm == algo && ChecksumAlgorithm(file.CheckSum) == algo {
+			continue
+		}
+		if err := file.SetAlgorithm(algo); err != nil {
+			return updated, fmt.Errorf("failed to update checksum for %s: %v", file.Name, err)
+		}
+		updated = append(updated, file)
+	}
+	return updated, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestChecksumAlgorithms(t *testing.T) {
+	env.SetEnv(false)
+
+	fp := filepath.Join(GetTestingDir(), "checksum.txt")
+	if err := os.WriteFile(fp, []byte("hello"), PERMS); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp)
+
+	cs, err := CalculateChecksum(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", cs)
+
+	// digest sizes in hex characters
+	var sizes = map[string]int{SHA256: 64, BLAKE2b: 64, XXH64: 16}
+	for algo, size := range sizes {
+		cs, err := CalculateChecksumWith(fp, algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, digest, err := ParseChecksum(cs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, algo, a)
+		assert.Equal(t, size, len(digest))
+
+		// checksums can be recalculated to match another one
+		like, err := CalculateChecksumLike(fp, cs, SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, cs, like)
+	}
+
+	_, err = CalculateChecksumWith(fp, "md4")
+	assert.Error(t, err)
+
+	// raw sha256 digests from older versions can be converted
+	sum := sha256.Sum256([]byte("hello"))
+	legacy := string(sum[:])
+	assert.True(t, IsLegacyChecksum(legacy))
+	assert.False(t, IsLegacyChecksum(cs))
+	assert.Equal(t, cs, UpgradeChecksum(legacy))
+	assert.Equal(t, cs, UpgradeChecksum(cs))
+}
+
+func TestDriveSetAlgorithm(t *testing.T) {
+	env.SetEnv(false)
+
+	testDrv := MakeTmpDrive(t)
+	defer Clean(t, GetTestingDir())
+
+	files := testDrv.GetFiles()
+	assert.NotEqual(t, 0, len(files))
+	assert.Equal(t, SHA256, testDrv.GetAlgorithm())
+
+	updated, err := testDrv.SetAlgorithm(BLAKE2b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(files), len(updated))
+	for _, file := range testDrv.GetFiles() {
+		assert.Equal(t, BLAKE2b, file.Algorithm)
+		assert.True(t, strings.HasPrefix(file.CheckSum, "blake2b:"))
+		assert.NoError(t, file.ValidateChecksum())
+	}
+
+	// nothing to do the second time around
+	updated, err = testDrv.SetAlgorithm(BLAKE2b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(updated))
+
+	_, err = testDrv.SetAlgorithm("md4")
+	assert.Error(t, err)
+}
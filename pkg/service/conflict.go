@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+three-way conflict detection.
+
+the client keeps track of the last checksum both it and the server agreed on
+for each file (the "base"). during a sync, the current local and remote checksums
+are compared against the base to figure out which side changed. if both sides
+changed since the last sync then neither version is allowed to overwrite the other.
+*/
+
+// what should happen to a file during a sync operation
+type SyncOp int
+
+const (
+	SyncNone     SyncOp = iota // both sides are the same
+	SyncPush                   // only the local copy changed
+	SyncPull                   // only the remote copy changed
+	SyncConflict               // both copies changed since the last sync
+)
+
+// ways a conflict can be resolved
+const (
+	KeepLocal  string = "keep-local"  // overwrite the remote copy with the local copy
+	KeepRemote string = "keep-remote" // discard the local changes
+	KeepBoth   string = "keep-both"   // keep the conflict copy as a new file
+)
+
+// compare the local and remote checksums of a file against the
+// checksum from the last time both sides were in sync.
+func ThreeWay(base string, local string, remote string) SyncOp {
+	switch {
+	case local == remote:
+		return SyncNone
+	case local != base && remote == base:
+		return SyncPush
+	case local == base && remote != base:
+		return SyncPull
+	default:
+		return SyncConflict
+	}
+}
+
+/*
+a file that was modified on both the client and the server since the last sync.
+
+when a conflict is detected the local version is moved to a conflict copy
+next to the original, and the remote version is downloaded in its place.
+the conflict is kept until it's resolved by the user.
+
+directories can't have two names at once, so when a directory is renamed or
+moved on both sides the server's change is applied, and the local name and
+location are kept with the conflict so they can be restored instead.
+*/
+type Conflict struct {
+	ID             string    `json:"id"`              // conflict id
+	FileID         string    `json:"file_id"`         // id of the conflicted file or directory
+	Name           string    `json:"name"`            // name of the conflicted file
+	Path           string    `json:"path"`            // client path of the original file
+	CopyPath       string    `json:"copy_path"`       // client path of the conflict copy (local version)
+	Device         string    `json:"device"`          // device the local changes were made on
+	LocalCheckSum  string    `json:"local_checksum"`  // checksum of the local version
+	RemoteCheckSum string    `json:"remote_checksum"` // checksum of the remote version
+	BaseCheckSum   string    `json:"base_checksum"`   // checksum of the last synced version
+	DetectedAt     time.Time `json:"detected_at"`     // when the conflict was detected
+	IsDir          bool      `json:"is_dir"`          // whether the conflicted item is a directory
+}
+
+func NewConflict(file *File, device string, base string, local string, remote string) *Conflict {
+	now := time.Now().UTC()
+	return &Conflict{
+		ID:             auth.NewUUID(),
+		FileID:         file.ID,
+		Name:           file.Name,
+		Path:           file.ClientPath,
+		CopyPath:       filepath.Join(filepath.Dir(file.ClientPath), ConflictName(file.Name, device, now)),
+		Device:         device,
+		LocalCheckSum:  local,
+		RemoteCheckSum: remote,
+		BaseCheckSum:   base,
+		DetectedAt:     now,
+	}
+}
+
+// a directory that was renamed or moved on both the client and the server.
+// path is where the directory is now, and localPath is where it was moved to locally.
+func NewDirConflict(dir *Directory, device string, path string, localPath string) *Conflict {
+	return &Conflict{
+		ID:         auth.NewUUID(),
+		FileID:     dir.ID,
+		Name:       dir.Name,
+		Path:       path,
+		CopyPath:   localPath,
+		Device:     device,
+		DetectedAt: time.Now().UTC(),
+		IsDir:      true,
+	}
+}
+
+// build a name for a conflict copy of a file, i.e.
+// "report (conflict from laptop 2026-10-16).docx"
+func ConflictName(name string, device string, t time.Time) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	return fmt.Sprintf("%s (conflict from %s %s)%s", base, device, t.Local().Format("2006-01-02"), ext)
+}
+
+func (c *Conflict) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
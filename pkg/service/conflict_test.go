@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestThreeWay(t *testing.T) {
+	env.SetEnv(false)
+
+	assert.Equal(t, SyncNone, ThreeWay("a", "a", "a"))
+	assert.Equal(t, SyncNone, ThreeWay("a", "b", "b"))
+	assert.Equal(t, SyncPush, ThreeWay("a", "b", "a"))
+	assert.Equal(t, SyncPull, ThreeWay("a", "a", "b"))
+	assert.Equal(t, SyncConflict, ThreeWay("a", "b", "c"))
+}
+
+func TestConflictName(t *testing.T) {
+	env.SetEnv(false)
+
+	ts := time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)
+	assert.Equal(t, "report (conflict from laptop 2026-10-16).docx", ConflictName("report.docx", "laptop", ts))
+	assert.Equal(t, "notes (conflict from laptop 2026-10-16)", ConflictName("notes", "laptop", ts))
+}
@@ -0,0 +1,515 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/*
+encryption at rest for protected files.
+
+locking a file encrypts its contents with AES-256-GCM under a random data key
+belonging to that file. the data key is kept in the file's Key field, wrapped
+(encrypted) with a key derived from the file's password using Argon2id, so the
+password itself is never stored anywhere, and neither the data key nor the
+contents can be recovered without it. changing a password only re-wraps the
+data key, so contents are never encrypted again. locking a directory or drive
+locks every file in it with the same password.
+
+wrapped keys look like
+
+	argon2id$m=65536,t=3,p=4$<salt>$<nonce + sealed data key>
+
+(base64, without padding). the parameters are kept with each key so they
+can be raised later without breaking anything that's already locked.
+
+contents are split into chunks that are sealed separately, so files can be
+encrypted and decrypted as streams, and read from anywhere without
+decrypting everything before it:
+
+	"SFSE" | version (1 byte) | nonce prefix (7 bytes) | sealed chunks...
+
+each chunk holds up to 64KiB and is sealed with a nonce made of the prefix,
+the chunk's index, and a flag marking the last chunk, so chunks can't be
+reordered, dropped, or cut off without it being noticed.
+
+keys left over from older versions of sfs are plain text passwords. "default"
+(what everything started out with) is treated the same as an empty key, i.e.
+no password has been set yet, and the first one used to lock an item becomes
+its password. anything else has to match, and is replaced with a wrapped key
+the first time it's used.
+*/
+
+var (
+	// the password doesn't match the one an item was locked with
+	ErrWrongPassword = errors.New("wrong password")
+
+	// the item is locked and can't be read or changed
+	ErrLocked = errors.New("locked")
+
+	// contents weren't encrypted by sfs
+	ErrNotEncrypted = errors.New("contents are not encrypted")
+
+	// encrypted contents were modified or cut short
+	ErrCorrupted = errors.New("encrypted contents are corrupted")
+)
+
+const (
+	keySize          = 32 // AES-256
+	saltSize         = 16
+	chunkSize        = 64 * 1024
+	tagSize          = 16
+	sealedChunkSize  = chunkSize + tagSize
+	cryptMagic       = "SFSE"
+	cryptVersion     = 1
+	noncePrefixSize  = 7
+	cryptHeaderSize  = len(cryptMagic) + 1 + noncePrefixSize
+	wrappedKeyPrefix = "argon2id$"
+	legacyDefaultKey = "default"
+)
+
+// Argon2id parameters
+type kdfParams struct {
+	time    uint32
+	memory  uint32 // KiB
+	threads uint8
+}
+
+// parameters for newly wrapped keys (RFC 9106's second recommended option)
+var defaultKDF = kdfParams{time: 3, memory: 64 * 1024, threads: 4}
+
+// whether key (an item's Key field) is a wrapped data key
+func isWrappedKey(key string) bool {
+	return strings.HasPrefix(key, wrappedKeyPrefix)
+}
+
+// whether a password has been set for key (an item's Key field)
+func hasPassword(key string) bool {
+	return key != "" && key != legacyDefaultKey
+}
+
+type wrappedKey struct {
+	params kdfParams
+	salt   []byte
+	sealed []byte // nonce followed by the sealed data key
+}
+
+func (w *wrappedKey) String() string {
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("argon2id$m=%d,t=%d,p=%d$%s$%s",
+		w.params.memory, w.params.time, w.params.threads,
+		enc.EncodeToString(w.salt), enc.EncodeToString(w.sealed),
+	)
+}
+
+func parseWrappedKey(key string) (*wrappedKey, error) {
+	parts := strings.Split(key, "$")
+	if len(parts) != 4 || parts[0] != "argon2id" {
+		return nil, fmt.Errorf("malformed key")
+	}
+	w := new(wrappedKey)
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &w.params.memory, &w.params.time, &w.params.threads); err != nil {
+		return nil, fmt.Errorf("malformed key parameters: %v", err)
+	}
+	if w.params.memory == 0 || w.params.time == 0 || w.params.threads == 0 {
+		return nil, fmt.Errorf("malformed key parameters: %s", parts[1])
+	}
+	var err error
+	if w.salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("malformed key salt: %v", err)
+	}
+	if w.sealed, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return nil, fmt.Errorf("malformed key: %v", err)
+	}
+	return w, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wraps and unwraps data keys with keys derived from a password.
+// derived keys are kept by salt, so everything in a directory that's
+// locked together only has to pay for Argon2 once.
+type keyring struct {
+	password string
+	salt     []byte            // salt for keys wrapped by this keyring
+	keks     map[string][]byte // derived keys by parameters and salt
+}
+
+func newKeyring(password string) *keyring {
+	return &keyring{password: password, keks: make(map[string][]byte)}
+}
+
+func (k *keyring) derive(params kdfParams, salt []byte) []byte {
+	id := fmt.Sprintf("%d,%d,%d$%x", params.memory, params.time, params.threads, salt)
+	kek, ok := k.keks[id]
+	if !ok {
+		kek = argon2.IDKey([]byte(k.password), salt, params.time, params.memory, params.threads, keySize)
+		k.keks[id] = kek
+	}
+	return kek
+}
+
+func (k *keyring) wrap(dataKey []byte) (string, error) {
+	if k.salt == nil {
+		salt := make([]byte, saltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		k.salt = salt
+	}
+	aead, err := newGCM(k.derive(defaultKDF, k.salt))
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	w := &wrappedKey{
+		params: defaultKDF,
+		salt:   k.salt,
+		sealed: aead.Seal(nonce, nonce, dataKey, []byte(wrappedKeyPrefix)),
+	}
+	return w.String(), nil
+}
+
+func (k *keyring) unwrap(key string) ([]byte, error) {
+	w, err := parseWrappedKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(k.derive(w.params, w.salt))
+	if err != nil {
+		return nil, err
+	}
+	if len(w.sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("malformed key")
+	}
+	nonce, sealed := w.sealed[:aead.NonceSize()], w.sealed[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(wrappedKeyPrefix))
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return dataKey, nil
+}
+
+// check the password against key (an item's Key field)
+func (k *keyring) check(key string) error {
+	if isWrappedKey(key) {
+		_, err := k.unwrap(key)
+		return err
+	}
+	if hasPassword(key) && subtle.ConstantTimeCompare([]byte(key), []byte(k.password)) != 1 {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// get the data key for key (an item's Key field), along with the wrapped
+// key to keep in its place. sets up a new data key if it doesn't have one.
+func (k *keyring) open(key string) ([]byte, string, error) {
+	if isWrappedKey(key) {
+		dataKey, err := k.unwrap(key)
+		return dataKey, key, err
+	}
+	if err := k.check(key); err != nil {
+		return nil, "", err
+	}
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", err
+	}
+	wrapped, err := k.wrap(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrapped, nil
+}
+
+// re-wrap the data key for key with another password
+func (k *keyring) rekey(key string, to *keyring) ([]byte, string, error) {
+	dataKey, _, err := k.open(key)
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err := to.wrap(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrapped, nil
+}
+
+// wrap a key with a password, in the same form as an item's Key field.
+// for keys kept outside of sfs's databases, i.e. end-to-end encryption keys.
+func WrapKey(key []byte, password string) (string, error) {
+	return newKeyring(password).wrap(key)
+}
+
+// unwrap a key from WrapKey. returns ErrWrongPassword if
+// it wasn't wrapped with password.
+func UnwrapKey(wrapped string, password string) ([]byte, error) {
+	if !isWrappedKey(wrapped) {
+		return nil, fmt.Errorf("malformed key")
+	}
+	return newKeyring(password).unwrap(wrapped)
+}
+
+// ---------- contents
+
+func chunkNonce(prefix []byte, i uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], i)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// encrypt everything read from src with key, and write it to dst
+func Encrypt(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, cryptHeaderSize)
+	copy(header, cryptMagic)
+	header[len(cryptMagic)] = cryptVersion
+	prefix := header[len(cryptMagic)+1:]
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+
+	// read a chunk ahead so we know which one is the last
+	buf, next := make([]byte, chunkSize), make([]byte, chunkSize)
+	sealed := make([]byte, 0, sealedChunkSize)
+	n, err := io.ReadFull(src, buf)
+	for i := uint32(0); ; i++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		var m int
+		var nextErr error
+		if !last {
+			m, nextErr = io.ReadFull(src, next)
+			if nextErr == io.EOF {
+				last = true
+			}
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(prefix, i, last), buf[:n], nil)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if i == ^uint32(0) {
+			return fmt.Errorf("too much to encrypt")
+		}
+		buf, next = next, buf
+		n, err = m, nextErr
+	}
+}
+
+// reads the decrypted contents of something encrypted with Encrypt.
+// chunks are decrypted as they're read, and can be read in any order.
+// not safe for concurrent use.
+type Decrypter struct {
+	src     io.ReaderAt
+	aead    cipher.AEAD
+	prefix  []byte
+	srcSize int64 // size of the encrypted contents
+	size    int64 // size of the decrypted contents
+	chunks  int64
+	off     int64 // offset for Read and Seek
+
+	// the last chunk that was decrypted
+	cached int64
+	plain  []byte
+	sealed []byte
+}
+
+// decrypt size bytes of encrypted contents read from src
+func NewDecrypter(src io.ReaderAt, size int64, key []byte) (*Decrypter, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, cryptHeaderSize)
+	if n, err := src.ReadAt(header, 0); n < len(header) {
+		if err == io.EOF {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	if string(header[:len(cryptMagic)]) != cryptMagic || header[len(cryptMagic)] != cryptVersion {
+		return nil, ErrNotEncrypted
+	}
+	body := size - int64(cryptHeaderSize)
+	chunks := (body + sealedChunkSize - 1) / sealedChunkSize
+	if body < tagSize || body-(chunks-1)*sealedChunkSize < tagSize {
+		return nil, ErrCorrupted
+	}
+	d := &Decrypter{
+		src:     src,
+		aead:    aead,
+		prefix:  header[len(cryptMagic)+1:],
+		srcSize: size,
+		size:    body - chunks*tagSize,
+		chunks:  chunks,
+		cached:  -1,
+		sealed:  make([]byte, sealedChunkSize),
+	}
+	// the last chunk is always checked, so contents that were cut
+	// short (or a wrong key) are caught before anything is read
+	if _, err := d.chunk(chunks - 1); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// size of the decrypted contents
+func (d *Decrypter) Size() int64 { return d.size }
+
+func (d *Decrypter) chunk(i int64) ([]byte, error) {
+	if i == d.cached {
+		return d.plain, nil
+	}
+	off := int64(cryptHeaderSize) + i*sealedChunkSize
+	n := int64(sealedChunkSize)
+	if i == d.chunks-1 {
+		n = d.srcSize - off
+	}
+	if m, err := d.src.ReadAt(d.sealed[:n], off); int64(m) < n {
+		if err == io.EOF {
+			return nil, ErrCorrupted
+		}
+		return nil, err
+	}
+	plain, err := d.aead.Open(d.plain[:0], chunkNonce(d.prefix, uint32(i), i == d.chunks-1), d.sealed[:n], nil)
+	if err != nil {
+		d.cached = -1
+		return nil, ErrCorrupted
+	}
+	d.plain, d.cached = plain, i
+	return plain, nil
+}
+
+func (d *Decrypter) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	var n int
+	for n < len(p) {
+		if off >= d.size {
+			return n, io.EOF
+		}
+		plain, err := d.chunk(off / chunkSize)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], plain[off%chunkSize:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
+
+func (d *Decrypter) Read(p []byte) (int, error) {
+	n, err := d.ReadAt(p, d.off)
+	d.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (d *Decrypter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.off
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	d.off = offset
+	return offset, nil
+}
+
+// replace the contents of the file at path with whatever fn writes. the new
+// contents are written next to it first, so the file is never left half done.
+func rewriteFile(path string, fn func(dst io.Writer, src *os.File, size int64) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		src.Close()
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		src.Close()
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = fn(tmp, src, info.Size())
+	src.Close()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func encryptFile(path string, key []byte) error {
+	return rewriteFile(path, func(dst io.Writer, src *os.File, _ int64) error {
+		return Encrypt(dst, src, key)
+	})
+}
+
+func decryptFile(path string, key []byte) error {
+	return rewriteFile(path, func(dst io.Writer, src *os.File, size int64) error {
+		d, err := NewDecrypter(src, size, key)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(dst, d)
+		return err
+	})
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func encrypt(t *testing.T, data []byte, key []byte) []byte {
+	var buf bytes.Buffer
+	if err := Encrypt(&buf, bytes.NewReader(data), key); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(data []byte, key []byte) ([]byte, error) {
+	d, err := NewDecrypter(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(d)
+}
+
+func TestEncryption(t *testing.T) {
+	env.SetEnv(false)
+
+	key := make([]byte, keySize)
+	rand.Read(key)
+
+	// sizes around chunk boundaries
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		data := make([]byte, size)
+		rand.Read(data)
+		enc := encrypt(t, data, key)
+		if size > 16 {
+			assert.False(t, bytes.Contains(enc, data[:16]))
+		}
+
+		d, err := NewDecrypter(bytes.NewReader(enc), int64(len(enc)), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, int64(size), d.Size())
+		dec, err := io.ReadAll(d)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(data, dec), "size %d", size)
+	}
+
+	data := make([]byte, 3*chunkSize+5)
+	rand.Read(data)
+	enc := encrypt(t, data, key)
+
+	// random access, across chunks
+	d, err := NewDecrypter(bytes.NewReader(enc), int64(len(enc)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 100)
+	_, err = d.ReadAt(buf, chunkSize-50)
+	assert.NoError(t, err)
+	assert.Equal(t, data[chunkSize-50:chunkSize+50], buf)
+	_, err = d.Seek(-5, io.SeekEnd)
+	assert.NoError(t, err)
+	rest, err := io.ReadAll(d)
+	assert.NoError(t, err)
+	assert.Equal(t, data[len(data)-5:], rest)
+
+	// the same contents never encrypt the same way twice
+	assert.False(t, bytes.Equal(enc, encrypt(t, data, key)))
+
+	// wrong key
+	other := make([]byte, keySize)
+	rand.Read(other)
+	_, err = decrypt(enc, other)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	// modified, reordered, and truncated contents
+	modified := bytes.Clone(enc)
+	modified[cryptHeaderSize+10] ^= 1
+	_, err = decrypt(modified, key)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	swapped := bytes.Clone(enc)
+	first := swapped[cryptHeaderSize : cryptHeaderSize+sealedChunkSize]
+	second := bytes.Clone(swapped[cryptHeaderSize+sealedChunkSize : cryptHeaderSize+2*sealedChunkSize])
+	copy(swapped[cryptHeaderSize+sealedChunkSize:], first)
+	copy(swapped[cryptHeaderSize:], second)
+	_, err = decrypt(swapped, key)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+
+	for _, size := range []int{cryptHeaderSize + sealedChunkSize, cryptHeaderSize + tagSize, len(enc) - 1} {
+		_, err = decrypt(enc[:size], key)
+		assert.True(t, errors.Is(err, ErrCorrupted), "truncated to %d", size)
+	}
+
+	// plain contents
+	_, err = decrypt([]byte("not encrypted at all"), key)
+	assert.True(t, errors.Is(err, ErrNotEncrypted))
+}
+
+func TestKeyWrapping(t *testing.T) {
+	env.SetEnv(false)
+
+	kr := newKeyring("correct horse")
+
+	// new keys get a fresh data key, and never contain the password
+	dataKey, key, err := kr.open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, keySize, len(dataKey))
+	assert.True(t, isWrappedKey(key))
+	assert.False(t, strings.Contains(key, "correct horse"))
+
+	// only the same password unwraps it
+	again, err := newKeyring("correct horse").unwrap(key)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, again)
+	_, err = newKeyring("battery staple").unwrap(key)
+	assert.True(t, errors.Is(err, ErrWrongPassword))
+
+	// re-keying keeps the data key
+	rekeyed, wrapped, err := kr.rekey(key, newKeyring("battery staple"))
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, rekeyed)
+	again, err = newKeyring("battery staple").unwrap(wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, again)
+	assert.True(t, errors.Is(kr.check(wrapped), ErrWrongPassword))
+
+	// keys from older versions of sfs
+	assert.NoError(t, kr.check(legacyDefaultKey))
+	assert.NoError(t, kr.check("correct horse"))
+	assert.True(t, errors.Is(kr.check("something else"), ErrWrongPassword))
+	_, key, err = kr.open("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, isWrappedKey(key))
+
+	// garbage
+	for _, bad := range []string{"argon2id$", "argon2id$m=0,t=0,p=0$AA$AA", "argon2id$m=8,t=1,p=1$!!$AA"} {
+		_, err := kr.unwrap(bad)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrWrongPassword))
+	}
+}
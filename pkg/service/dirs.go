@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/sfs/pkg/auth"
@@ -59,6 +60,9 @@ type Directory struct {
 	// Last time this directory was modified
 	LastSync time.Time `json:"last_sync"`
 
+	// per-device version vector for renames and moves. see vclock.go
+	Version VersionVector `json:"version"`
+
 	// server API endpoint for this directory
 	Endpoint string `json:"endpoint"`
 
@@ -71,11 +75,18 @@ type Directory struct {
 	Dirs map[string]*Directory `json:"-"`
 
 	// pointer to parent directory (if not root).
-	Parent *Directory
+	Parent *Directory `json:"-"`
+
+	// id of the parent directory. empty for root directories.
+	ParentID string `json:"parent_id"`
 
 	// disignator for whether this directory is considerd the "root" directory
 	Root     bool   `json:"root"`
 	RootPath string `json:"root_path"`
+
+	// deletion tombstone. see tombstone.go
+	DeletedBy string    `json:"deleted_by,omitempty"` // device this directory was deleted on
+	DeletedAt time.Time `json:"deleted_at"`           // when this directory was deleted
 }
 
 // create a new root directory object. does not create physical directory.
@@ -89,9 +100,10 @@ func NewRootDirectory(dirName string, ownerID string, driveID string, rootPath s
 		OwnerID:    ownerID,
 		DriveID:    driveID,
 		Protected:  false,
-		Key:        "default",
+		Key:        "",
 		Overwrite:  false,
 		LastSync:   time.Now().UTC(),
+		Version:    NewVersionVector(),
 		Dirs:       make(map[string]*Directory, 0),
 		Files:      make(map[string]*File, 0),
 		Endpoint:   fmt.Sprint(Endpoint, ":", cfg.Port, "/v1/dirs/", uuid),
@@ -118,9 +130,10 @@ func NewDirectory(dirName string, ownerID string, driveID string, path string) *
 		OwnerID:    ownerID,
 		DriveID:    driveID,
 		Protected:  false,
-		Key:        "default",
+		Key:        "",
 		Overwrite:  false,
 		LastSync:   time.Now().UTC(),
+		Version:    NewVersionVector(),
 		Dirs:       make(map[string]*Directory, 0),
 		Files:      make(map[string]*File, 0),
 		Endpoint:   fmt.Sprint(Endpoint, ":", cfg.Port, "/v1/dirs/", uuid),
@@ -142,6 +155,22 @@ func UnmarshalDirStr(data string) (*Directory, error) {
 	return dir, nil
 }
 
+// record a rename or move of this directory made on the given device
+func (d *Directory) IncrementVersion(deviceID string) {
+	if d.Version == nil {
+		d.Version = NewVersionVector()
+	}
+	d.Version.Increment(deviceID)
+}
+
+// merge another version of this directory into this directory's version vector
+func (d *Directory) MergeVersion(other VersionVector) {
+	if d.Version == nil {
+		d.Version = NewVersionVector()
+	}
+	d.Version.Merge(other)
+}
+
 func (d *Directory) ToJSON() ([]byte, error) {
 	data, err := json.MarshalIndent(d, "", "  ")
 	if err != nil {
@@ -273,33 +302,129 @@ func (d *Directory) GetParent() *Directory {
 	return d.Parent
 }
 
-// -------- password protection and other simple security stuff
+// -------- password protection. see crypt.go
 
+// this directory and all of its subdirectories
+func (d *Directory) tree() []*Directory {
+	dirs := []*Directory{d}
+	for _, sd := range d.WalkDs() {
+		dirs = append(dirs, sd)
+	}
+	return dirs
+}
+
+// change this directory's password. everything in it that was locked
+// with the old password is re-keyed (not re-encrypted) to use the new one.
 func (d *Directory) SetPassword(password string, newPassword string) error {
-	if password == d.Key {
-		d.Key = newPassword
-		log.Printf("password updated")
-		return nil
+	old, kr := newKeyring(password), newKeyring(newPassword)
+	if err := old.check(d.Key); err != nil {
+		return err
 	}
-	return fmt.Errorf("wrong password")
+	if err := d.rekey(old, kr); err != nil {
+		return err
+	}
+	if !hasPassword(d.Key) {
+		_, key, err := kr.open(d.Key)
+		if err != nil {
+			return err
+		}
+		d.Key = key
+	}
+	log.Printf("password updated")
+	return nil
 }
 
-func (d *Directory) Lock(password string) bool {
-	if password == d.Key {
-		d.Protected = true
-		return true
+// re-key everything in this directory (and its subdirectories) locked with
+// old to use kr instead. anything with a different password is left alone.
+func (d *Directory) rekey(old *keyring, kr *keyring) error {
+	for _, dir := range d.tree() {
+		if !hasPassword(dir.Key) {
+			continue
+		}
+		_, key, err := old.rekey(dir.Key, kr)
+		if errors.Is(err, ErrWrongPassword) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		dir.Key = key
 	}
-	log.Printf("wrong password")
-	return false
+	for _, file := range d.WalkFs() {
+		if !hasPassword(file.Key) {
+			continue
+		}
+		if err := file.rekey(old, kr); err != nil && !errors.Is(err, ErrWrongPassword) {
+			return err
+		}
+	}
+	return nil
 }
 
-func (d *Directory) Unlock(password string) bool {
-	if password == d.Key {
-		d.Protected = false
-		return true
+// lock this directory with a password, and encrypt every file in it and
+// its subdirectories. nothing can be added to or removed from a locked
+// directory. files already locked with their own password stay locked with
+// it, but nothing is changed if the password is wrong for anything else.
+func (d *Directory) Lock(password string) error {
+	if err := d.lock(newKeyring(password)); err != nil {
+		log.Printf("failed to lock %s: %v", d.Name, err)
+		return err
 	}
-	log.Printf("wrong password")
-	return false
+	return nil
+}
+
+func (d *Directory) lock(kr *keyring) error {
+	dirs, files := d.tree(), d.WalkFs()
+	for _, dir := range dirs {
+		if err := kr.check(dir.Key); err != nil {
+			return fmt.Errorf("%s (id=%s): %w", dir.Name, dir.ID, err)
+		}
+	}
+	for _, file := range files {
+		if err := kr.check(file.Key); err != nil && !file.Protected {
+			return fmt.Errorf("%s (id=%s): %w", file.Name, file.ID, err)
+		}
+	}
+	for _, file := range files {
+		if err := file.lock(kr); err != nil && !errors.Is(err, ErrWrongPassword) {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		_, key, err := kr.open(dir.Key)
+		if err != nil {
+			return err
+		}
+		dir.Key = key
+		dir.Protected = true
+	}
+	return nil
+}
+
+// unlock this directory and decrypt everything in it that was
+// locked with this password.
+func (d *Directory) Unlock(password string) error {
+	if err := d.unlock(newKeyring(password)); err != nil {
+		log.Printf("failed to unlock %s: %v", d.Name, err)
+		return err
+	}
+	return nil
+}
+
+func (d *Directory) unlock(kr *keyring) error {
+	if err := kr.check(d.Key); err != nil {
+		return fmt.Errorf("%s (id=%s): %w", d.Name, d.ID, err)
+	}
+	for _, file := range d.WalkFs() {
+		if err := file.unlock(kr); err != nil && !errors.Is(err, ErrWrongPassword) {
+			return err
+		}
+	}
+	for _, dir := range d.tree() {
+		if kr.check(dir.Key) == nil {
+			dir.Protected = false
+		}
+	}
+	return nil
 }
 
 // --------- file management
@@ -309,7 +434,15 @@ func (d *Directory) addFile(file *File) {
 	file.DirID = d.ID
 	file.DriveID = d.DriveID
 	file.LastSync = time.Now().UTC()
-	d.Size += file.GetSize()
+	d.Size += sizeOf(file)
+	d.Files[file.ID] = file
+}
+
+// place a file loaded from the database in this directory. unlike addFile(),
+// leaves the file's sync time and the directory's size alone, since they were
+// saved along with everything else.
+func (d *Directory) attachFile(file *File) {
+	file.DirID = d.ID
 	d.Files[file.ID] = file
 }
 
@@ -387,14 +520,16 @@ func (d *Directory) PutFile(file *File) error {
 // remove physical file and update internal metadata.
 func (d *Directory) removeFile(fileID string) error {
 	if file, ok := d.Files[fileID]; ok {
+		// get the size before the physical file is gone
+		var size = sizeOf(file)
 		if err := os.Remove(file.ServerPath); err != nil {
 			return err
 		}
 		delete(d.Files, file.ID)
-		d.Size -= file.GetSize()
+		d.Size -= size
 		d.LastSync = time.Now().UTC()
 	} else {
-		return fmt.Errorf("file (id=%s) not found", file.ID)
+		return fmt.Errorf("file (id=%s) not found", fileID)
 	}
 	return nil
 }
@@ -412,6 +547,24 @@ func (d *Directory) RemoveFile(fileID string) error {
 	return nil
 }
 
+// removes file from internal file map, but leaves the physical file alone.
+// used when a file is moved to another directory on the server, where
+// files aren't stored under their directories.
+func (d *Directory) DetachFile(fileID string) error {
+	file, ok := d.Files[fileID]
+	if !ok {
+		return fmt.Errorf("file (id=%s) not found", fileID)
+	}
+	if !d.Protected {
+		delete(d.Files, file.ID)
+		d.Size -= sizeOf(file)
+		d.LastSync = time.Now().UTC()
+	} else {
+		log.Printf("directory protected. unlock before removing files")
+	}
+	return nil
+}
+
 // returns a file map containing all files starting at this directory.
 func (d *Directory) GetFileMap() map[string]*File {
 	return d.WalkFs()
@@ -437,6 +590,37 @@ func (d *Directory) FindFile(fileID string) *File {
 	return d.WalkF(fileID)
 }
 
+// update the paths of this directory and everything under it after
+// it was renamed or moved to newPath. paths that weren't under the old
+// location of this directory (i.e. files stored elsewhere on the server) are left alone.
+func (d *Directory) Relocate(newPath string) {
+	oldPath := d.Path
+	if oldPath == newPath {
+		return
+	}
+	move := func(path string) string {
+		if path == oldPath {
+			return newPath
+		}
+		if strings.HasPrefix(path, oldPath+string(filepath.Separator)) {
+			return newPath + strings.TrimPrefix(path, oldPath)
+		}
+		return path
+	}
+	dirs := d.WalkDs()
+	dirs[d.ID] = d
+	for _, dir := range dirs {
+		dir.Path = move(dir.Path)
+		dir.ClientPath = move(dir.ClientPath)
+		dir.ServerPath = move(dir.ServerPath)
+	}
+	for _, file := range d.WalkFs() {
+		file.Path = move(file.Path)
+		file.ClientPath = move(file.ClientPath)
+		file.ServerPath = move(file.ServerPath)
+	}
+}
+
 // -------- sub directory methods
 
 // update a subdirectory. must already exist as a subdirectory
@@ -445,6 +629,7 @@ func (d *Directory) FindFile(fileID string) *File {
 func (d *Directory) PutSubDir(subDir *Directory) error {
 	if d.HasDir(subDir.ID) {
 		subDir.Parent = d
+		subDir.ParentID = d.ID
 		d.Dirs[subDir.ID] = subDir
 	} else {
 		return fmt.Errorf("dir (id=%s) not found. need to add before updating", subDir.ID)
@@ -463,6 +648,7 @@ func (d *Directory) Mkdir(dirPath string) error {
 func (d *Directory) addSubDir(dir *Directory) error {
 	if _, exists := d.Dirs[dir.ID]; !exists {
 		dir.Parent = d
+		dir.ParentID = d.ID
 		dir.DriveID = d.DriveID
 		d.Dirs[dir.ID] = dir
 		d.Dirs[dir.ID].LastSync = time.Now().UTC()
@@ -501,6 +687,46 @@ func (d *Directory) AddSubDirs(dirs []*Directory) error {
 	return nil
 }
 
+// build this directory's tree from directories and files loaded from the
+// database, using their parent directory ids. anything that isn't under this
+// directory is skipped. items keep their original sync times.
+func (d *Directory) AttachTree(dirs []*Directory, files []*File) {
+	children := make(map[string][]*Directory)
+	for _, dir := range dirs {
+		if dir.ID == d.ID {
+			continue
+		}
+		children[dir.ParentID] = append(children[dir.ParentID], dir)
+	}
+	contents := make(map[string][]*File)
+	for _, file := range files {
+		contents[file.DirID] = append(contents[file.DirID], file)
+	}
+	var attach func(dir *Directory)
+	attach = func(dir *Directory) {
+		if dir.Files == nil {
+			dir.Files = make(map[string]*File, 0)
+		}
+		if dir.Dirs == nil {
+			dir.Dirs = make(map[string]*Directory, 0)
+		}
+		for _, file := range contents[dir.ID] {
+			if !dir.HasFile(file.ID) {
+				dir.attachFile(file)
+			}
+		}
+		for _, subDir := range children[dir.ID] {
+			if !dir.HasDir(subDir.ID) {
+				lastSync := subDir.LastSync
+				dir.addSubDir(subDir)
+				subDir.LastSync = lastSync
+			}
+			attach(dir.Dirs[subDir.ID])
+		}
+	}
+	attach(d)
+}
+
 func (d *Directory) removeDir(dirID string) error {
 	if dir, exists := d.Dirs[dirID]; exists {
 		if err := os.RemoveAll(dir.Path); err != nil {
@@ -589,12 +815,22 @@ func (d *Directory) Walk() *Directory {
 		log.Print("can't traverse directory without a path")
 		return d
 	}
-	return walk(d)
+	return walk(d, nil)
+}
+
+// same as Walk(), but skips any files or directories
+// the ignore function returns true for.
+func (d *Directory) WalkIgnoring(ignore IgnoreFunc) *Directory {
+	if d.Path == "" {
+		log.Print("can't traverse directory without a path")
+		return d
+	}
+	return walk(d, ignore)
 }
 
 // walk recursively descends the directory tree and populates all files
-// and subdirectory maps
-func walk(d *Directory) *Directory {
+// and subdirectory maps. ignore can be nil.
+func walk(d *Directory, ignore IgnoreFunc) *Directory {
 	entries, err := os.ReadDir(d.Path)
 	if err != nil {
 		log.Printf("could not read directory: %v", err)
@@ -610,9 +846,12 @@ func walk(d *Directory) *Directory {
 			log.Printf("could not get stat for %s - %v", entryPath, err)
 			return d
 		}
+		if ignore != nil && ignore(entryPath, item.IsDir()) {
+			continue
+		}
 		if item.IsDir() {
 			sd := NewDirectory(item.Name(), d.OwnerID, d.DriveID, entryPath)
-			sd = walk(sd)
+			sd = walk(sd, ignore)
 			if err := d.AddSubDir(sd); err != nil {
 				log.Print(err)
 			}
@@ -724,12 +963,14 @@ func buildSync(dir *Directory, idx *SyncIndex) *SyncIndex {
 	for _, file := range dir.Files {
 		if !idx.HasItem(file.ID) {
 			idx.LastSync[file.ID] = file.LastSync
+			idx.setCheckSum(file)
+			idx.setVersion(file)
 		}
 	}
-	// NOTE: monitoring directories is no longer supported.
-	// if !idx.HasItem(dir.ID) {
-	// 	idx.LastSync[dir.ID] = dir.LastSync
-	// }
+	if !dir.IsRoot() && !idx.HasItem(dir.ID) {
+		idx.LastSync[dir.ID] = dir.LastSync
+		idx.setDir(dir)
+	}
 	return idx
 }
 
@@ -756,17 +997,19 @@ func walkS(dir *Directory, idx *SyncIndex) *SyncIndex {
 func buildUpdate(dir *Directory, idx *SyncIndex) *SyncIndex {
 	for _, file := range dir.Files {
 		if idx.HasItem(file.ID) {
-			if file.LastSync.After(idx.LastSync[file.ID]) {
+			if idx.IsNewer(file.ID, file.Version, file.LastSync) {
 				idx.FilesToUpdate[file.ID] = file
 			}
 		}
 	}
-	// NOTE: monitoring Directories is no longer supported.
-	// if idx.HasItem(dir.ID) {
-	// 	if dir.LastSync.After(idx.LastSync[dir.ID]) {
-	// 		idx.DirsToUpdate[dir.ID] = dir
-	// 	}
-	// }
+	if !dir.IsRoot() && idx.HasItem(dir.ID) {
+		if dir.LastSync.After(idx.LastSync[dir.ID]) {
+			if idx.DirsToUpdate == nil {
+				idx.DirsToUpdate = make(map[string]*Directory, 0)
+			}
+			idx.DirsToUpdate[dir.ID] = dir
+		}
+	}
 	return idx
 }
 
@@ -3,6 +3,7 @@ package service
 import (
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -43,13 +44,31 @@ func TestSecurityFeatures(t *testing.T) {
 	assert.NotEqual(t, 0, len(td.Dirs))
 	assert.NotEqual(t, 0, len(td.Files))
 
+	// files in a locked directory are encrypted
+	assert.True(t, tf.Protected)
+	data, err := os.ReadFile(tf.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, txtData, string(data))
+
 	// attempt to change password, then re-lock and try to remove
-	td.SetPassword("wrongPassword", "newPassword")
-	assert.NotEqual(t, "wrongPassword", td.Key)
-	td.SetPassword("default", "newPassword")
-	assert.Equal(t, "newPassword", td.Key)
+	key := td.Key
+	assert.Error(t, td.SetPassword("wrongPassword", "newPassword"))
+	assert.Equal(t, key, td.Key)
+	assert.NoError(t, td.SetPassword("default", "newPassword"))
+	assert.NotEqual(t, key, td.Key)
+
+	// the directory's files were re-keyed along with it
+	assert.Error(t, td.Unlock("default"))
+	assert.NoError(t, td.Unlock("newPassword"))
+	assert.False(t, tf.Protected)
+	data, err = os.ReadFile(tf.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, txtData, string(data))
 
-	td.Unlock("newPassword")
 	td.AddFile(tf)
 	td.AddSubDir(td2)
 	td.Lock("newPassword")
@@ -279,7 +298,8 @@ func TestWalkS(t *testing.T) {
 
 	assert.NotEqual(t, nil, idx)
 	assert.NotEqual(t, 0, len(idx.LastSync))
-	assert.Equal(t, 20, len(idx.LastSync)) // this is how many test files were generated
+	// this is how many test files were generated, plus their (non-root) directories
+	assert.Equal(t, 20+len(idx.Dirs), len(idx.LastSync))
 
 	// make sure there's actual times and not uninstantiated time.Time objects
 	for _, lastSync := range idx.LastSync {
@@ -301,7 +321,8 @@ func TestWalkU(t *testing.T) {
 
 	assert.NotEqual(t, nil, idx)
 	assert.NotEqual(t, 0, len(idx.LastSync))
-	assert.Equal(t, 20, len(idx.LastSync)) // this is how many test files were generated
+	// this is how many test files were generated, plus their (non-root) directories
+	assert.Equal(t, 20+len(idx.Dirs), len(idx.LastSync))
 
 	// make sure there's actual times and not uninstantiated time.Time objects
 	for _, lastSync := range idx.LastSync {
@@ -359,3 +380,34 @@ func TestWalkFiles(t *testing.T) {
 		t.Errorf("[ERROR] unable to remove test directories: %v", err)
 	}
 }
+
+func TestRelocate(t *testing.T) {
+	env.SetEnv(false)
+
+	testingDir := GetTestingDir()
+	testDir := NewDirectory("testDir", "me", "some-rand-id", filepath.Join(testingDir, "testDir"))
+	subDir := NewDirectory("subDir", "me", "some-rand-id", filepath.Join(testDir.Path, "subDir"))
+	if err := os.MkdirAll(subDir.Path, PERMS); err != nil {
+		t.Fatal(err)
+	}
+	testFile, err := MakeTmpTxtFile(filepath.Join(subDir.Path, "test.txt"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subDir.AddFile(testFile)
+	testDir.AddSubDir(subDir)
+
+	// only metadata is updated. nothing is moved on disk.
+	newPath := filepath.Join(testingDir, "moved", "renamed")
+	testDir.Relocate(newPath)
+
+	assert.Equal(t, newPath, testDir.Path)
+	assert.Equal(t, filepath.Join(newPath, "subDir"), subDir.Path)
+	assert.Equal(t, filepath.Join(newPath, "subDir", "test.txt"), testFile.Path)
+	assert.Equal(t, testFile.Path, testFile.ClientPath)
+
+	// clean up after testing
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
@@ -1,56 +1,482 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 // --------- sync between hard drives ----------------
 
-// get paths for source and destination disks
-// create a sync index of the source disk, then
-// build a queue for physical *copying*, not tranferring
-// via HTTP.
-func SyncDisks(srcDir *Directory, destRootDir *Directory) error {
-	// build sync index
+/*
+local disk-to-disk mirroring.
+
+a mirror is a plain copy of a directory tree on another disk (a USB drive,
+a second hard drive, etc.), with the same layout as the source. what was
+mirrored last time is kept in a small index at the root of the destination
+(MirrorIndexName), so each run only copies what was added or changed since,
+moves anything that was moved or renamed in the source (when the source's
+ids are stable, i.e. a drive's root, or a directory mirrored by MirrorDisk),
+and removes anything that was deleted from the source.
+
+anything in the destination that wasn't put there by a mirror is left alone,
+and directories the mirror created are only removed once they're empty.
+*/
+
+const (
+	// name of the index kept at the root of a mirror
+	MirrorIndexName = ".sfs-mirror"
+
+	// how often DiskDaemon checks whether the destination is available
+	MirrorPollInterval = 5 * time.Second
+
+	// number of files copied at once
+	mirrorWorkers = 4
+)
+
+// what was mirrored to a destination the last time it was synced
+type MirrorIndex struct {
+	Src      string                 `json:"src"`       // source path
+	LastSync time.Time              `json:"last_sync"` // when the mirror was last synced
+	Items    map[string]*MirrorItem `json:"items"`     // key = path relative to the mirror's root, separated by /
+}
+
+// a file or directory in a mirror
+type MirrorItem struct {
+	ID       string `json:"id"` // id of the item in the source
+	IsDir    bool   `json:"is_dir"`
+	CheckSum string `json:"checksum,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+// load the index for a mirror. returns an empty index
+// if nothing has been mirrored to destPath yet.
+func LoadMirrorIndex(destPath string) (*MirrorIndex, error) {
+	idx := &MirrorIndex{Items: make(map[string]*MirrorItem)}
+	data, err := os.ReadFile(filepath.Join(destPath, MirrorIndexName))
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read mirror index: %v", err)
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("failed to decode mirror index: %v", err)
+	}
+	if idx.Items == nil {
+		idx.Items = make(map[string]*MirrorItem)
+	}
+	// paths in the index are moved and removed, so they
+	// can't point anywhere outside of the mirror
+	for path := range idx.Items {
+		if !mirrorPath(path) {
+			return nil, fmt.Errorf("invalid path in mirror index: %q", path)
+		}
+	}
+	return idx, nil
+}
+
+// whether path is somewhere below the root of a mirror
+func mirrorPath(path string) bool {
+	path = filepath.FromSlash(path)
+	return filepath.IsLocal(path) && filepath.Clean(path) != "."
+}
+
+// write the index to the root of the mirror
+func (m *MirrorIndex) save(destPath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(destPath, MirrorIndexName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write mirror index: %v", err)
+	}
+	return os.Rename(tmp, filepath.Join(destPath, MirrorIndexName))
+}
+
+// a file or directory in the source, along with where it goes in the mirror
+type mirrorEntry struct {
+	path string // relative to the mirror's root, separated by /
+	file *File
+	dir  *Directory
+}
+
+// list everything under root, with directories before anything in them
+func mirrorEntries(root *Directory) []*mirrorEntry {
+	return listMirrorDir(make([]*mirrorEntry, 0), root, "")
+}
+
+func listMirrorDir(entries []*mirrorEntry, dir *Directory, path string) []*mirrorEntry {
+	for _, f := range dir.Files {
+		entries = append(entries, &mirrorEntry{path: joinEntryPath(path, f.Name), file: f})
+	}
+	for _, sd := range dir.Dirs {
+		p := joinEntryPath(path, sd.Name)
+		entries = append(entries, &mirrorEntry{path: p, dir: sd})
+		entries = listMirrorDir(entries, sd, p)
+	}
+	return entries
+}
+
+/*
+mirror the contents of srcDir to destPath, keeping the same directory
+layout. destPath must already exist.
+
+a sync index is built from the source, and any file that's new, has a
+different checksum than what was mirrored last time, or is missing from
+the destination is added to its FilesToUpdate map and copied. files and
+directories that were moved or renamed are moved in the mirror instead of
+being copied again, and anything that was mirrored before but is no longer
+in the source is removed.
+*/
+func SyncDisks(srcDir *Directory, destPath string) error {
+	if info, err := os.Stat(destPath); err != nil {
+		return fmt.Errorf("destination unavailable: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("destination is not a directory: %s", destPath)
+	}
+	prev, err := LoadMirrorIndex(destPath)
+	if err != nil {
+		return err
+	}
 	srcIdx := BuildRootSyncIndex(srcDir)
 	if srcIdx == nil {
 		return fmt.Errorf("failed to create sync index from source disk")
 	}
-	srcIdx = BuildToUpdate(srcDir, srcIdx)
-	// build sync queue
-	queue := BuildQ(srcIdx)
-	if queue == nil || len(queue.Queue) == 0 {
-		log.Print("[INFO] no files matched for syncing. exiting sync.")
-		return nil
-	}
-	// copy files
-	for _, batch := range queue.Queue {
-		for _, file := range batch.Files {
-			go func() {
-				// TODO: maybe add separate disk field in file struct?
-				// NOTE: each file may have a different directory for their destination
-				// on the other disk. may have to find dest sub directory and treat the
-				// argument destDir as rootDir for the destinaton disc.
-				destPath := filepath.Join(destRootDir.Path, file.Name)
-				if err := file.Copy(destPath); err != nil {
-					log.Printf("[WARNING] failed to copy file (id=%s) \n%v", file.ID, err)
-				}
-				// update dir struct
-				if err := destRootDir.AddFile(file); err != nil {
-					log.Printf("[WARNING] failed to update dir: %v", err)
-				}
+
+	entries := mirrorEntries(srcDir)
+	current := make(map[string]*mirrorEntry, len(entries))
+	for _, e := range entries {
+		current[e.path] = e
+	}
+	// where each item was last time, so moves can be found
+	prevPaths := make(map[string]string, len(prev.Items))
+	for path, item := range prev.Items {
+		prevPaths[item.ID] = path
+	}
+	destOf := func(path string) string {
+		return filepath.Join(destPath, filepath.FromSlash(path))
+	}
+
+	// move directories first so whatever's in them goes along
+	for _, e := range entries {
+		if e.dir == nil {
+			continue
+		}
+		old, ok := prevPaths[e.dir.ID]
+		if !ok || old == e.path || !prev.Items[old].IsDir {
+			continue
+		}
+		if _, err := os.Stat(destOf(e.path)); err == nil {
+			continue
+		}
+		if _, err := os.Stat(destOf(old)); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(destOf(e.path)), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(destOf(old), destOf(e.path)); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %v", old, e.path, err)
+		}
+		movePrefix(prev, old, e.path)
+		prevPaths = make(map[string]string, len(prev.Items))
+		for path, item := range prev.Items {
+			prevPaths[item.ID] = path
+		}
+	}
+
+	// then files, if what's there from last time is still the same
+	for _, e := range entries {
+		if e.file == nil {
+			continue
+		}
+		old, ok := prevPaths[e.file.ID]
+		if !ok || old == e.path {
+			continue
+		}
+		item := prev.Items[old]
+		if item.IsDir || item.CheckSum != srcIdx.CheckSums[e.file.ID] || !mirrored(destOf(old), item) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(destOf(e.path)), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(destOf(old), destOf(e.path)); err != nil {
+			return fmt.Errorf("failed to move %s to %s: %v", old, e.path, err)
+		}
+		delete(prev.Items, old)
+		prev.Items[e.path] = item
+	}
+
+	// remove what's no longer in the source, deepest first. only what the
+	// mirror put there is removed, and directories only once they're empty,
+	// so anything else that was added to them is left alone.
+	stale := make([]string, 0)
+	for path, item := range prev.Items {
+		if e, ok := current[path]; !ok || (e.dir != nil) != item.IsDir {
+			stale = append(stale, path)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i] > stale[j] })
+	removed := 0
+	for _, path := range stale {
+		ok, err := removeFromMirror(destOf(path), prev.Items[path])
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+		if ok {
+			removed++
+		}
+		delete(prev.Items, path)
+	}
+
+	// create directories and find which files need copying
+	next := &MirrorIndex{
+		Src:   srcDir.Path,
+		Items: make(map[string]*MirrorItem, len(entries)),
+	}
+	for _, e := range entries {
+		if e.dir != nil {
+			if err := os.MkdirAll(destOf(e.path), 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %v", e.path, err)
+			}
+			next.Items[e.path] = &MirrorItem{ID: e.dir.ID, IsDir: true}
+			continue
+		}
+		cs := srcIdx.CheckSums[e.file.ID]
+		item, ok := prev.Items[e.path]
+		if ok && cs != "" && item.CheckSum == cs && mirrored(destOf(e.path), item) {
+			next.Items[e.path] = &MirrorItem{ID: e.file.ID, CheckSum: cs, Size: item.Size}
+			continue
+		}
+		srcIdx.FilesToUpdate[e.file.ID] = e.file
+	}
+
+	// copy files, and record the ones that made it
+	paths := make(map[string]string, len(srcIdx.FilesToUpdate))
+	for _, e := range entries {
+		if e.file != nil {
+			if _, ok := srcIdx.FilesToUpdate[e.file.ID]; ok {
+				paths[e.file.ID] = e.path
+			}
+		}
+	}
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make([]error, 0)
+		sem  = make(chan struct{}, mirrorWorkers)
+	)
+	for _, file := range srcIdx.FilesToUpdate {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(file *File, path string) {
+			defer func() {
+				<-sem
+				wg.Done()
 			}()
+			size, err := copyToMirror(file.GetPath(), destOf(path))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to copy %s: %v", path, err))
+				return
+			}
+			next.Items[path] = &MirrorItem{ID: file.ID, CheckSum: srcIdx.CheckSums[file.ID], Size: size}
+		}(file, paths[file.ID])
+	}
+	wg.Wait()
+
+	next.LastSync = time.Now().UTC()
+	if err := next.save(destPath); err != nil {
+		return err
+	}
+	if len(srcIdx.FilesToUpdate) > 0 || removed > 0 {
+		log.Printf("[INFO] mirrored %s to %s: %d copied, %d removed",
+			srcDir.Path, destPath, len(srcIdx.FilesToUpdate)-len(errs), removed)
+	}
+	return errors.Join(errs...)
+}
+
+// update the paths of everything under a moved directory
+func movePrefix(idx *MirrorIndex, old string, path string) {
+	for p, item := range idx.Items {
+		if p == old || strings.HasPrefix(p, old+"/") {
+			delete(idx.Items, p)
+			idx.Items[path+strings.TrimPrefix(p, old)] = item
+		}
+	}
+}
+
+// remove an item the mirror put at path. files are only removed if they're
+// still files, and directories only if they're empty. returns whether
+// anything was removed.
+func removeFromMirror(path string, item *MirrorItem) (bool, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if item.IsDir {
+		if !info.IsDir() {
+			return false, nil
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return false, err
+		}
+		if len(entries) > 0 {
+			return false, nil
+		}
+	} else if !info.Mode().IsRegular() {
+		return false, nil
+	}
+	return true, os.Remove(path)
+}
+
+// whether a file is still in the mirror as it was left
+func mirrored(path string, item *MirrorItem) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Size() == item.Size
+}
+
+// copy a file into the mirror. contents are written to a temporary
+// file first so a failed copy never leaves a partial file behind.
+// returns the number of bytes copied.
+func copyToMirror(srcPath string, destPath string) (int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+".*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), destPath)
+}
+
+// mirror the directory at srcPath to destPath. the source
+// is read from disk, and the mirror's index is never copied.
+// items are identified the same way on every run (see sourceID),
+// so anything moved or renamed since is moved in the mirror too.
+func MirrorDisk(srcPath string, destPath string) error {
+	if _, err := os.Stat(srcPath); err != nil {
+		return fmt.Errorf("source unavailable: %v", err)
+	}
+	if within(destPath, srcPath) || within(srcPath, destPath) {
+		return fmt.Errorf("can't mirror %s to %s: one is inside the other", srcPath, destPath)
+	}
+	root := NewRootDirectory(filepath.Base(srcPath), "", "", srcPath)
+	root = root.WalkIgnoring(func(path string, isDir bool) bool {
+		return filepath.Base(path) == MirrorIndexName
+	})
+	stableIDs(root, make(map[string]bool))
+	return SyncDisks(root, destPath)
+}
+
+// replace the ids everything under dir was given when it was walked with
+// ones from sourceID. items that would share an id (i.e. hard links) keep
+// their path as their id instead.
+func stableIDs(dir *Directory, used map[string]bool) {
+	idOf := func(path string) string {
+		id := sourceID(path)
+		if used[id] {
+			id = path
 		}
+		used[id] = true
+		return id
+	}
+	files := make(map[string]*File, len(dir.Files))
+	for _, f := range dir.Files {
+		f.ID = idOf(f.Path)
+		f.DirID = dir.ID
+		files[f.ID] = f
 	}
-	// TODO: verify that the files were copied correctly
-	// srcFiles := srcIdx.GetFiles()
-	return nil
+	dir.Files = files
+	dirs := make(map[string]*Directory, len(dir.Dirs))
+	for _, sd := range dir.Dirs {
+		sd.ID = idOf(sd.Path)
+		sd.ParentID = dir.ID
+		stableIDs(sd, used)
+		dirs[sd.ID] = sd
+	}
+	dir.Dirs = dirs
+}
+
+// whether path is dir or anything under it
+func within(path string, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
-// a local daemon that listens for file events and coordinates synchronization
-// operations between two hard disks. this is to implement a specific SFS mode that
-// doesn't depend on the client/server configuration, and instead uses the user's
-// local file systems/hard disks
-func DiskDaemon() error { return nil }
+// a local daemon that keeps a mirror of srcPath at destPath. it waits for
+// destPath to become available (i.e. the disk it's on is mounted), mirrors
+// the source as soon as it is, then again every interval for as long as it
+// stays available. this is a specific SFS mode that doesn't depend on the
+// client/server configuration, and instead uses the user's local file
+// systems/hard disks. runs until stop is closed or sent to.
+func DiskDaemon(srcPath string, destPath string, interval time.Duration, stop chan bool) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid mirror interval: %v", interval)
+	}
+	ticker := time.NewTicker(MirrorPollInterval)
+	defer ticker.Stop()
+
+	var (
+		mounted    bool
+		lastMirror time.Time
+	)
+	for {
+		info, err := os.Stat(destPath)
+		available := err == nil && info.IsDir()
+		switch {
+		case !available && mounted:
+			log.Printf("[INFO] %s is no longer available. waiting for it to come back", destPath)
+		case available && (!mounted || time.Since(lastMirror) >= interval):
+			if !mounted {
+				log.Printf("[INFO] %s is available. mirroring %s", destPath, srcPath)
+			}
+			if err := MirrorDisk(srcPath, destPath); err != nil {
+				log.Printf("[WARNING] failed to mirror %s to %s: %v", srcPath, destPath, err)
+			}
+			lastMirror = time.Now()
+		}
+		mounted = available
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package service
+
+// an id for a file or directory in a mirror's source. inodes aren't
+// available here, so items are identified by their path, and anything
+// that's moved or renamed is copied to its new location in the mirror.
+func sourceID(path string) string {
+	return path
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestSyncDisks(t *testing.T) {
+	env.SetEnv(false)
+
+	var (
+		src  = filepath.Join(GetTestingDir(), "tmp")
+		dest = filepath.Join(GetTestingDir(), "mirror")
+	)
+	for _, dir := range []string{src, filepath.Join(src, "sub"), dest} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			Fail(t, GetTestingDir(), err)
+		}
+	}
+	for _, path := range []string{"a.txt", "b.txt", filepath.Join("sub", "c.txt")} {
+		if _, err := MakeTmpTxtFile(filepath.Join(src, path), 10); err != nil {
+			Fail(t, GetTestingDir(), err)
+		}
+	}
+	// anything that wasn't mirrored is left alone
+	if err := os.WriteFile(filepath.Join(dest, "keep.txt"), []byte(txtData), 0644); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	root := NewRootDirectory("tmp", "me", "some-rand-id", src).Walk()
+
+	// first sync copies everything, keeping the layout
+	assert.NoError(t, SyncDisks(root, dest))
+	idx, err := LoadMirrorIndex(dest)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.Equal(t, 4, len(idx.Items))
+	for _, path := range []string{"a.txt", "b.txt", filepath.Join("sub", "c.txt")} {
+		want, err := os.ReadFile(filepath.Join(src, path))
+		assert.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(dest, path))
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	// renamed directories are moved, removed files are removed,
+	// and changed files are copied again
+	var sub *Directory
+	for _, d := range root.Dirs {
+		sub = d
+	}
+	sub.Name = "moved"
+	var a, b *File
+	for _, f := range root.Files {
+		switch f.Name {
+		case "a.txt":
+			a = f
+		case "b.txt":
+			b = f
+		}
+	}
+	assert.NoError(t, root.DetachFile(b.ID))
+	a = MutateFile(t, a)
+	a.CheckSum, err = CalculateChecksum(a.Path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, SyncDisks(root, dest))
+	_, err = os.Stat(filepath.Join(dest, "sub"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dest, "moved", "c.txt"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dest, "b.txt"))
+	assert.True(t, os.IsNotExist(err))
+	want, err := os.ReadFile(a.Path)
+	assert.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(dest, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+	_, err = os.Stat(filepath.Join(dest, "keep.txt"))
+	assert.NoError(t, err)
+
+	idx, err = LoadMirrorIndex(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(idx.Items))
+
+	// directories that were removed from the source are only removed
+	// from the mirror once nothing else is left in them
+	if err := os.WriteFile(filepath.Join(dest, "moved", "mine.txt"), []byte(txtData), 0644); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	delete(root.Dirs, sub.ID)
+	assert.NoError(t, SyncDisks(root, dest))
+	_, err = os.Stat(filepath.Join(dest, "moved", "c.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dest, "moved", "mine.txt"))
+	assert.NoError(t, err)
+	idx, err = LoadMirrorIndex(dest)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(idx.Items))
+
+	// nothing outside the mirror is touched, even if the index says so
+	outside := filepath.Join(GetTestingDir(), "outside.txt")
+	if err := os.WriteFile(outside, []byte(txtData), 0644); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	for _, path := range []string{"../outside.txt", "/outside.txt", ".", ""} {
+		idx.Items[path] = &MirrorItem{ID: "not-in-source"}
+		assert.NoError(t, idx.save(dest))
+		assert.Error(t, SyncDisks(root, dest))
+		delete(idx.Items, path)
+	}
+	_, err = os.Stat(outside)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dest, "a.txt"))
+	assert.NoError(t, err)
+
+	// a mirror can't be inside what it's a mirror of
+	assert.Error(t, MirrorDisk(src, filepath.Join(src, "sub")))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMirrorDiskMoves(t *testing.T) {
+	env.SetEnv(false)
+
+	var (
+		src  = filepath.Join(GetTestingDir(), "tmp")
+		dest = filepath.Join(GetTestingDir(), "mirror")
+	)
+	if sourceID(GetTestingDir()) == GetTestingDir() {
+		t.Skip("items can't be identified by inode on this platform")
+	}
+	for _, dir := range []string{src, filepath.Join(src, "sub"), dest} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			Fail(t, GetTestingDir(), err)
+		}
+	}
+	for _, path := range []string{"a.txt", filepath.Join("sub", "c.txt")} {
+		if _, err := MakeTmpTxtFile(filepath.Join(src, path), 10); err != nil {
+			Fail(t, GetTestingDir(), err)
+		}
+	}
+	assert.NoError(t, MirrorDisk(src, dest))
+	a, err := os.Stat(filepath.Join(dest, "a.txt"))
+	assert.NoError(t, err)
+	c, err := os.Stat(filepath.Join(dest, "sub", "c.txt"))
+	assert.NoError(t, err)
+
+	// renames between runs are moved in the mirror rather than copied again
+	if err := os.Rename(filepath.Join(src, "a.txt"), filepath.Join(src, "b.txt")); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if err := os.Rename(filepath.Join(src, "sub"), filepath.Join(src, "moved")); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.NoError(t, MirrorDisk(src, dest))
+	b, err := os.Stat(filepath.Join(dest, "b.txt"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(a, b))
+	moved, err := os.Stat(filepath.Join(dest, "moved", "c.txt"))
+	assert.NoError(t, err)
+	assert.True(t, os.SameFile(c, moved))
+	for _, path := range []string{"a.txt", "sub"} {
+		_, err := os.Stat(filepath.Join(dest, path))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
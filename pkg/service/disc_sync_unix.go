@@ -0,0 +1,24 @@
+//go:build unix
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// an id for a file or directory in a mirror's source that stays the same
+// when it's moved or renamed on the same file system, i.e. its inode.
+// falls back to its path if it can't be found.
+func sourceID(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return path
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
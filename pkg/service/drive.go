@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/sfs/pkg/logger"
 )
@@ -30,19 +31,20 @@ user/
 [server]
 users/
 |----userA/
-|    |----root/     <---- user files & directories
+|    |----root/     <---- files placed here are imported on the next refresh
+|    |----files/    <---- user files, stored by id
+|    |    |----3f/
+|    |    |    |----3f2a9c1e-...
 |    |----state/
 |    |    |----drive-state-d-m-y-hh-mm-ss.json
 |    |    recycled/     <---- "deleted" files & directories
 |----userB/
 (etc)
 
-TODO:
-
-	possibly store clients files on the server in a "flat" directory?
-	doesn't have to match the client's local file system tree -- only be a
-	repository of back ups. would make search time linear instead of whatever the walk()
-	implementations in dirs.go are at currently.
+the server doesn't mirror the client's file system tree. files are kept in a
+flat layout under files/, sharded by the first two characters of their id,
+so two files with the same name in different directories never collide.
+the directory tree only exists as metadata in the database.
 */
 func AllocateDrive(name string, svcRoot string) error {
 	// new user service file paths
@@ -51,6 +53,7 @@ func AllocateDrive(name string, svcRoot string) error {
 	serviceDirs := []string{
 		userRoot,
 		filepath.Join(userRoot, "root"),
+		filepath.Join(userRoot, "files"),
 		filepath.Join(userRoot, "state"),
 		filepath.Join(userRoot, "recycled"),
 	}
@@ -111,6 +114,32 @@ type Drive struct {
 
 	// folder for placing "deleted" files and directories
 	RecycleBin string `json:"recycle_bin"`
+
+	// file version history retention. the server keeps at most MaxVersions
+	// prior revisions of each file, and removes any older than VersionMaxAge.
+	// either is ignored if set to 0.
+	MaxVersions   int           `json:"max_versions"`
+	VersionMaxAge time.Duration `json:"version_max_age"`
+
+	// how long deleted items are kept in the recycle bin
+	// before being permanently removed. ignored if set to 0.
+	TrashRetention time.Duration `json:"trash_retention"`
+
+	// algorithm used for file checksums. see checksum.go
+	Algorithm string `json:"algorithm"`
+
+	// whether file contents are encrypted by clients before being sent to
+	// the server. the server only ever sees ciphertext for these drives,
+	// and file checksums are keyed MACs. see transfer/e2ee.go
+	E2EE bool `json:"e2ee"`
+
+	// how often snapshots of the drive are taken on the server, and how many
+	// of those are kept. snapshots taken on demand aren't counted. no
+	// snapshots are taken on a schedule if SnapshotInterval is 0, and
+	// scheduled ones are kept until they're removed if MaxSnapshots is 0.
+	// see snapshot.go
+	SnapshotInterval time.Duration `json:"snapshot_interval"`
+	MaxSnapshots     int           `json:"max_snapshots"`
 }
 
 var initLog = logger.NewLogger("DRIVE_INIT", "None")
@@ -136,12 +165,17 @@ func NewDrive(driveID string, ownerName string, ownerID string, rootPath string,
 		UsedSpace:  0,
 		FreeSpace:  MAX_SIZE,
 		Protected:  false,
-		Key:        "default",
+		Key:        "",
 		RootPath:   rootPath,
 		RootID:     rootID,
 		Root:       root,
 		RecycleBin: filepath.Join(root.Path, "recycle"),
 		log:        logger.NewLogger("DRIVE", driveID),
+
+		MaxVersions:    DefaultMaxVersions,
+		VersionMaxAge:  DefaultVersionMaxAge,
+		TrashRetention: DefaultTrashRetention,
+		Algorithm:      DefaultAlgorithm,
 	}
 }
 
@@ -183,6 +217,8 @@ func UnmarshalDriveString(data string) (*Drive, error) {
 	return drv, nil
 }
 
+// adjust the drive's used and free space by size bytes.
+// size is negative when space is freed up.
 func (d *Drive) UpdateDriveSize(size int64) {
 	d.UsedSpace += size
 	d.FreeSpace -= size
@@ -190,38 +226,73 @@ func (d *Drive) UpdateDriveSize(size int64) {
 
 // ------- security --------------------------------
 
-func (d *Drive) Lock(password string) {
-	if password != d.Key {
+// lock the drive and everything in it with a password. see crypt.go
+func (d *Drive) Lock(password string) error {
+	kr := newKeyring(password)
+	_, key, err := kr.open(d.Key)
+	if err != nil {
 		d.log.Info("wrong password")
-	} else {
-		d.Protected = true
+		return err
+	}
+	if d.HasRoot() {
+		if err := d.Root.lock(kr); err != nil {
+			return err
+		}
 	}
+	d.Key = key
+	d.Protected = true
+	return nil
 }
 
-func (d *Drive) Unlock(password string) {
-	if password != d.Key {
+// unlock the drive and decrypt everything in it locked with this password
+func (d *Drive) Unlock(password string) error {
+	kr := newKeyring(password)
+	if err := kr.check(d.Key); err != nil {
 		d.log.Info("wrong password")
-	} else {
-		d.Protected = false
+		return err
+	}
+	if d.HasRoot() {
+		if err := d.Root.unlock(kr); err != nil {
+			return err
+		}
 	}
+	d.Protected = false
+	return nil
 }
 
-func (d *Drive) SetNewPassword(password string, newPassword string, isAdmin bool) {
-	if !d.Protected {
-		if password == d.Key {
-			d.Key = newPassword
-			d.log.Info("password updated")
-		} else {
-			d.log.Info("wrong password")
+// change the drive's password. everything in it locked with the old password
+// is re-keyed to use the new one. admins can override the password of a locked
+// drive without knowing it, but anything encrypted with the old password can't
+// be re-keyed without it, and stays locked with the old password.
+func (d *Drive) SetNewPassword(password string, newPassword string, isAdmin bool) error {
+	kr := newKeyring(newPassword)
+	if d.Protected {
+		if !isAdmin {
+			d.log.Info(fmt.Sprintf("drive (id=%s) is protected. unlock with password.", d.ID))
+			return fmt.Errorf("drive (id=%s): %w", d.ID, ErrLocked)
 		}
-	} else {
-		if isAdmin {
-			d.log.Warn("admin password override!")
-			d.Key = newPassword
-			return
+		d.log.Warn("admin password override!")
+		_, key, err := kr.open("")
+		if err != nil {
+			return err
 		}
-		d.log.Info(fmt.Sprintf("drive (id=%s) is protected. unlock with password.", d.ID))
+		d.Key = key
+		return nil
+	}
+	old := newKeyring(password)
+	_, key, err := old.rekey(d.Key, kr)
+	if err != nil {
+		d.log.Info("wrong password")
+		return err
 	}
+	if d.HasRoot() {
+		if err := d.Root.rekey(old, kr); err != nil {
+			return err
+		}
+	}
+	d.Key = key
+	d.log.Info("password updated")
+	return nil
 }
 
 // ------- file management --------------------------------
@@ -245,7 +316,13 @@ func (d *Drive) AddFile(dirID string, file *File) error {
 				return err
 			}
 		}
-		d.UpdateDriveSize(file.GetSize())
+		// checksums for end-to-end encrypted drives are made by their clients
+		if !d.E2EE && file.Algorithm != d.GetAlgorithm() {
+			if err := file.SetAlgorithm(d.GetAlgorithm()); err != nil {
+				d.log.Warn(fmt.Sprintf("failed to update checksum for %s: %v", file.Name, err))
+			}
+		}
+		d.UpdateDriveSize(sizeOf(file))
 	} else {
 		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
 	}
@@ -304,6 +381,7 @@ func (d *Drive) ModifyFile(dirID string, file *File, data []byte) error {
 		if !d.HasRoot() {
 			return fmt.Errorf("no root directory")
 		}
+		var origSize = sizeOf(file)
 		if d.Root.ID == dirID {
 			if err := d.Root.ModifyFile(file, data); err != nil {
 				return fmt.Errorf("failed to update file %s: %v", file.ID, err)
@@ -316,9 +394,8 @@ func (d *Drive) ModifyFile(dirID string, file *File, data []byte) error {
 			if err := dir.ModifyFile(file, data); err != nil {
 				return err
 			}
-			// TODO: get the difference between old and new file sizes
-			// and adjust the drives used space value accordingly.
 		}
+		d.UpdateDriveSize(sizeOf(file) - origSize)
 	} else {
 		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
 	}
@@ -337,12 +414,9 @@ func (d *Drive) UpdateFile(dirID string, file *File) error {
 			if dir == nil {
 				return fmt.Errorf("dir (id=%s) not found", dirID)
 			}
-			var origSize = file.GetSize()
 			if err := dir.PutFile(file); err != nil {
 				return err
 			}
-			var newSize = file.GetSize()
-			d.UpdateDriveSize(origSize - newSize)
 		}
 	} else {
 		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
@@ -357,6 +431,7 @@ func (d *Drive) RemoveFile(dirID string, file *File) error {
 		if !d.HasRoot() {
 			return fmt.Errorf("no root directory")
 		}
+		var size = sizeOf(file)
 		// if the driveID is this drive's root directory
 		if dirID == d.Root.ID {
 			if err := d.Root.RemoveFile(file.ID); err != nil {
@@ -368,12 +443,35 @@ func (d *Drive) RemoveFile(dirID string, file *File) error {
 			if dir == nil {
 				return fmt.Errorf("dir (id=%s) not found", dirID)
 			}
-			d.UpdateDriveSize(-file.GetSize())
 			if err := dir.RemoveFile(file.ID); err != nil {
 				return err
 			}
-			return nil
 		}
+		d.UpdateDriveSize(-size)
+	} else {
+		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
+	}
+	return nil
+}
+
+// removes a file from the drive without touching its contents. used on the
+// server, where contents are kept in a separate store and removed from there.
+func (d *Drive) DetachFile(dirID string, file *File) error {
+	if !d.Protected {
+		if !d.HasRoot() {
+			return fmt.Errorf("no root directory")
+		}
+		var size = sizeOf(file)
+		dir := d.Root
+		if dirID != d.Root.ID {
+			if dir = d.GetDir(dirID); dir == nil {
+				return fmt.Errorf("dir (id=%s) not found", dirID)
+			}
+		}
+		if err := dir.DetachFile(file.ID); err != nil {
+			return err
+		}
+		d.UpdateDriveSize(-size)
 	} else {
 		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
 	}
@@ -412,11 +510,7 @@ func (d *Drive) AddSubDir(dirID string, dir *Directory) error {
 		if err := d.addSubDir(dirID, dir); err != nil {
 			return err
 		}
-		size, err := dir.GetSize()
-		if err != nil {
-			return err
-		}
-		d.UpdateDriveSize(size)
+		d.UpdateDriveSize(filesSize(dir))
 	} else {
 		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
 	}
@@ -434,11 +528,7 @@ func (d *Drive) AddDirs(dirs []*Directory) error {
 		}
 		var total int64
 		for _, dir := range dirs {
-			size, err := dir.GetSize()
-			if err != nil {
-				return err
-			}
-			total += size
+			total += filesSize(dir)
 		}
 		d.UpdateDriveSize(total)
 	} else {
@@ -501,9 +591,11 @@ func (d *Drive) GetDirsMap() map[string]*Directory {
 func (d *Drive) removeDir(dirID string) error {
 	dir := d.GetDir(dirID)
 	if dir != nil {
+		var size = filesSize(dir)
 		if err := os.RemoveAll(dir.Path); err != nil {
 			return err
 		}
+		d.UpdateDriveSize(-size)
 		// this should only apply to child directories
 		if dir.Parent != nil {
 			delete(dir.Parent.Dirs, dir.ID)
@@ -552,26 +644,126 @@ func (d *Drive) RemoveDirs(dirs []*Directory) error {
 	return nil
 }
 
-// update a directory within a drive.
+// update a directory within a drive. if the updated directory has a
+// different parent id than the original, it's moved (along with all its
+// contents) under the new parent. updatedDir keeps the original's files
+// and subdirectories if it doesn't have any of its own.
 func (d *Drive) UpdateDir(dirID string, updatedDir *Directory) error {
 	if !d.Protected {
 		if !d.HasRoot() {
 			return fmt.Errorf("no root directory")
 		}
-		// get the parent of the updated directory & add
-		parent := d.Root.WalkD(dirID)
-		if parent == nil {
+		if dirID == d.Root.ID {
+			return fmt.Errorf("can't update root directory")
+		}
+		dir := d.Root.WalkD(dirID)
+		if dir == nil {
 			return fmt.Errorf("dir %s not found", dirID)
 		}
-		if err := parent.PutSubDir(updatedDir); err != nil {
-			return fmt.Errorf("failed to update dir %s: %v", parent.Name, err)
+		parent := dir.Parent
+		if updatedDir.ParentID != "" && (parent == nil || updatedDir.ParentID != parent.ID) {
+			parent = d.GetDir(updatedDir.ParentID)
+			if parent == nil {
+				return fmt.Errorf("parent dir %s not found", updatedDir.ParentID)
+			}
+			if parent.ID == dirID || dir.WalkD(parent.ID) != nil {
+				return fmt.Errorf("can't move %s into itself", dir.Name)
+			}
+		}
+		if parent == nil {
+			parent = d.Root
 		}
+		if len(updatedDir.Files) == 0 && len(updatedDir.Dirs) == 0 {
+			updatedDir.Files = dir.Files
+			updatedDir.Dirs = dir.Dirs
+		}
+		for _, subDir := range updatedDir.Dirs {
+			subDir.Parent = updatedDir
+		}
+		if dir.Parent != nil {
+			delete(dir.Parent.Dirs, dirID)
+		}
+		updatedDir.Parent = parent
+		updatedDir.ParentID = parent.ID
+		parent.Dirs[dirID] = updatedDir
 	} else {
 		d.log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
 	}
 	return nil
 }
 
+// attach directories loaded from the database to their parent directories.
+// directories already in the drive's tree are skipped, and directories whose
+// parent can't be found are added to the root directory.
+func (d *Drive) AttachDirs(dirs []*Directory) error {
+	if !d.HasRoot() {
+		return fmt.Errorf("no root directory")
+	}
+	pending := make(map[string]*Directory, len(dirs))
+	for _, dir := range dirs {
+		if dir.ID == d.Root.ID || d.Root.WalkD(dir.ID) != nil {
+			continue
+		}
+		if dir.Dirs == nil {
+			dir.Dirs = make(map[string]*Directory, 0)
+		}
+		if dir.Files == nil {
+			dir.Files = make(map[string]*File, 0)
+		}
+		pending[dir.ID] = dir
+	}
+	attach := func(parent *Directory, dir *Directory) {
+		// keep the original sync time. addSubDir() resets it.
+		lastSync := dir.LastSync
+		parent.addSubDir(dir)
+		dir.LastSync = lastSync
+		delete(pending, dir.ID)
+	}
+	// parents need to be attached before their children
+	for len(pending) > 0 {
+		attached := false
+		for _, dir := range pending {
+			if _, waiting := pending[dir.ParentID]; waiting {
+				continue
+			}
+			parent := d.GetDir(dir.ParentID)
+			if parent == nil {
+				parent = d.Root
+			}
+			attach(parent, dir)
+			attached = true
+		}
+		// whatever's left refers to each other. put them under root.
+		if !attached {
+			for _, dir := range pending {
+				attach(d.Root, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// attach files loaded from the database to their directories.
+// files already in the drive's tree are skipped, and files whose
+// directory can't be found are added to the root directory.
+func (d *Drive) AttachFiles(files []*File) error {
+	if !d.HasRoot() {
+		return fmt.Errorf("no root directory")
+	}
+	dirs := d.Root.WalkDs()
+	dirs[d.Root.ID] = d.Root
+	for _, file := range files {
+		dir, ok := dirs[file.DirID]
+		if !ok {
+			dir = d.Root
+		}
+		if !dir.HasFile(file.ID) {
+			dir.attachFile(file)
+		}
+	}
+	return nil
+}
+
 // ----- cleanup --------------------------------
 
 // removes all users files and directories from their drive
@@ -594,7 +786,7 @@ func (d *Drive) ClearDrive() error {
 func (d *Drive) BuildSyncIdx() {
 	files := d.GetFiles()
 	d.SyncIndex = BuildRootSyncIndex(d.Root)
-	d.SyncIndex = BuildDistSyncIndex(files, nil, d.SyncIndex)
+	d.SyncIndex = BuildDistSyncIndex(files, d.GetDirs(), d.SyncIndex)
 }
 
 func (d *Drive) BuildToUpdate() error {
@@ -1,8 +1,11 @@
 package service
 
 import (
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alecthomas/assert/v2"
 	"github.com/sfs/pkg/auth"
@@ -52,22 +55,27 @@ func TestDriveSecurityFeatures(t *testing.T) {
 	testRoot := NewRootDirectory("testRoot", "some-rand-id", "some-rand-id", filepath.Join(tmpDir, "testRoot"))
 	testDrive := NewDrive(auth.NewUUID(), "test-drive", "me", tmpDir, auth.NewUUID(), testRoot)
 
-	testDrive.Lock("default")
+	assert.NoError(t, testDrive.Lock("default"))
 	assert.True(t, testDrive.Protected)
+	assert.True(t, testRoot.Protected)
 
-	testDrive.SetNewPassword("wrongPassword", "newPassword", false)
-	assert.NotEqual(t, "wrongPassword", testDrive.Key)
+	assert.Error(t, testDrive.SetNewPassword("wrongPassword", "newPassword", false))
+	assert.Error(t, testDrive.Unlock("wrongPassword"))
+	assert.True(t, testDrive.Protected)
 
-	testDrive.Unlock("default")
-	testDrive.SetNewPassword("default", "newPassword", false)
-	assert.Equal(t, "newPassword", testDrive.Key)
+	assert.NoError(t, testDrive.Unlock("default"))
+	assert.False(t, testRoot.Protected)
+	assert.Error(t, testDrive.SetNewPassword("wrongPassword", "newPassword", false))
+	assert.NoError(t, testDrive.SetNewPassword("default", "newPassword", false))
+	assert.False(t, strings.Contains(testDrive.Key, "newPassword"))
 
 	// test admin override
-	testDrive.Lock("newPassword")
+	assert.NoError(t, testDrive.Lock("newPassword"))
 	assert.True(t, testDrive.Protected)
 
-	testDrive.SetNewPassword("anotherPassword", "newPassword", true)
-	assert.Equal(t, "newPassword", testDrive.Key)
+	assert.NoError(t, testDrive.SetNewPassword("anotherPassword", "adminPassword", true))
+	assert.Error(t, testDrive.Unlock("newPassword"))
+	assert.True(t, testDrive.Protected)
 }
 
 func TestPopulateDrive(t *testing.T) {
@@ -85,3 +93,99 @@ func TestPopulateDrive(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDriveMoveDir(t *testing.T) {
+	env.SetEnv(false)
+
+	testDrv := MakeEmptyTmpDrive(t)
+	dir1 := NewDirectory("dir1", "me", testDrv.ID, filepath.Join(testDrv.Root.Path, "dir1"))
+	dir2 := NewDirectory("dir2", "me", testDrv.ID, filepath.Join(testDrv.Root.Path, "dir2"))
+	for _, dir := range []*Directory{dir1, dir2} {
+		if err := os.MkdirAll(dir.Path, PERMS); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testDrv.AddDirs([]*Directory{dir1, dir2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// move dir2 under dir1
+	moved := *dir2
+	moved.ParentID = dir1.ID
+	if err := testDrv.UpdateDir(dir2.ID, &moved); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, nil, dir1.WalkD(dir2.ID))
+	assert.Equal(t, dir1.ID, testDrv.GetDir(dir2.ID).ParentID)
+	_, ok := testDrv.Root.Dirs[dir2.ID]
+	assert.False(t, ok)
+
+	// dirs can't be moved into themselves
+	cycle := *dir1
+	cycle.ParentID = dir2.ID
+	assert.Error(t, testDrv.UpdateDir(dir1.ID, &cycle))
+	assert.Error(t, testDrv.UpdateDir(testDrv.Root.ID, &cycle))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttachDirs(t *testing.T) {
+	env.SetEnv(false)
+
+	testDrv := MakeEmptyTmpDrive(t)
+	parent := NewDirectory("parent", "me", testDrv.ID, filepath.Join(testDrv.Root.Path, "parent"))
+	child := NewDirectory("child", "me", testDrv.ID, filepath.Join(parent.Path, "child"))
+	orphan := NewDirectory("orphan", "me", testDrv.ID, filepath.Join(testDrv.Root.Path, "orphan"))
+	parent.ParentID = testDrv.Root.ID
+	child.ParentID = parent.ID
+	orphan.ParentID = "some-missing-dir"
+
+	// children listed before their parents should still be attached correctly
+	if err := testDrv.AttachDirs([]*Directory{child, orphan, parent}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, nil, parent.WalkD(child.ID))
+	_, ok := testDrv.Root.Dirs[orphan.ID]
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(testDrv.GetDirs()))
+}
+
+func TestAttachFiles(t *testing.T) {
+	env.SetEnv(false)
+
+	testDrv := MakeEmptyTmpDrive(t)
+	subDir := NewDirectory("sub", "me", testDrv.ID, filepath.Join(testDrv.Root.Path, "sub"))
+	subDir.ParentID = testDrv.Root.ID
+	if err := testDrv.AttachDirs([]*Directory{subDir}); err != nil {
+		t.Fatal(err)
+	}
+
+	// files with the same name in different directories
+	lastSync := time.Now().UTC().Add(-time.Hour)
+	inRoot := &File{ID: auth.NewUUID(), Name: "notes.txt", DirID: testDrv.Root.ID, LastSync: lastSync}
+	inSub := &File{ID: auth.NewUUID(), Name: "notes.txt", DirID: subDir.ID, LastSync: lastSync}
+	orphan := &File{ID: auth.NewUUID(), Name: "orphan.txt", DirID: "some-missing-dir", LastSync: lastSync}
+
+	if err := testDrv.AttachFiles([]*File{inRoot, inSub, orphan}); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, testDrv.Root.HasFile(inRoot.ID))
+	assert.True(t, subDir.HasFile(inSub.ID))
+	assert.False(t, testDrv.Root.HasFile(inSub.ID))
+	assert.True(t, testDrv.Root.HasFile(orphan.ID))
+	assert.Equal(t, testDrv.Root.ID, orphan.DirID)
+	assert.Equal(t, lastSync, inSub.LastSync)
+
+	// the same tree can be rebuilt from any directory
+	root := NewRootDirectory("root", "me", testDrv.ID, testDrv.Root.Path)
+	sub := NewDirectory("sub", "me", testDrv.ID, subDir.Path)
+	sub.ID = subDir.ID
+	sub.ParentID = root.ID
+	inSub.DirID = sub.ID
+	root.AttachTree([]*Directory{sub}, []*File{inSub})
+	assert.NotEqual(t, nil, root.WalkF(inSub.ID))
+	assert.Equal(t, 1, len(root.Dirs))
+	assert.Equal(t, 0, len(root.Files))
+}
@@ -1,7 +1,6 @@
 package service
 
 import (
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -42,9 +41,20 @@ type File struct {
 	Endpoint   string    `json:"endpoint"`    // unique server API endpoint
 	CheckSum   string    `json:"checksum"`    // file checksum
 	Algorithm  string    `json:"algorithm"`   // checksum algorithm
+	Blob       string    `json:"blob"`        // checksum of the server-side blob holding this file's contents. see blobs.go
+
+	// per-device change counters. see vclock.go
+	Version VersionVector `json:"version"`
+
+	// deletion tombstone. see tombstone.go
+	DeletedBy string    `json:"deleted_by,omitempty"` // device this file was deleted on
+	DeletedAt time.Time `json:"deleted_at"`           // when this file was deleted
 
 	// file content
 	Content []byte
+
+	// unwrapped data key for reading protected contents. see Authorize
+	dataKey []byte
 }
 
 // for logging any errors during new file object creation
@@ -79,14 +89,44 @@ func NewFile(fileName string, driveID string, ownerID string, filePath string) *
 		Size:       item.Size(),
 		Backup:     false,
 		Protected:  false,
-		Key:        "default",
+		Key:        "",
 		LastSync:   time.Now().UTC(),
 		Path:       filePath,
 		ServerPath: filePath,
 		ClientPath: filePath,
 		Endpoint:   Endpoint + ":" + cfg.Port + "/v1/files/" + uuid,
 		CheckSum:   cs,
-		Algorithm:  "sha256",
+		Algorithm:  DefaultAlgorithm,
+		Version:    NewVersionVector(),
+		Content:    make([]byte, 0),
+	}
+}
+
+// make a copy of this file's metadata with a new id.
+// contents are not copied.
+func (f *File) Duplicate() *File {
+	uuid := auth.NewUUID()
+	cfg := NewSvcCfg()
+	return &File{
+		Name:       f.Name,
+		ID:         uuid,
+		NMap:       newNameMap(f.Name, uuid),
+		OwnerID:    f.OwnerID,
+		DirID:      f.DirID,
+		DriveID:    f.DriveID,
+		Mode:       f.Mode,
+		Size:       f.Size,
+		Backup:     f.Backup,
+		Protected:  f.Protected,
+		Key:        f.Key,
+		LastSync:   time.Now().UTC(),
+		Path:       f.Path,
+		ServerPath: f.ServerPath,
+		ClientPath: f.ClientPath,
+		Endpoint:   Endpoint + ":" + cfg.Port + "/v1/files/" + uuid,
+		CheckSum:   f.CheckSum,
+		Algorithm:  f.Algorithm,
+		Version:    NewVersionVector(),
 		Content:    make([]byte, 0),
 	}
 }
@@ -108,13 +148,31 @@ func (f *File) ToString() string {
 	return string(data)
 }
 
+// record a change to this file made on the given device
+func (f *File) IncrementVersion(deviceID string) {
+	if f.Version == nil {
+		f.Version = NewVersionVector()
+	}
+	f.Version.Increment(deviceID)
+}
+
+// merge another version of this file into this file's version vector
+func (f *File) MergeVersion(other VersionVector) {
+	if f.Version == nil {
+		f.Version = NewVersionVector()
+	}
+	f.Version.Merge(other)
+}
+
 // returns file size in bytes
 //
 // uses os.Stat() - "length in bytes for regular files; system-dependent for others"
 func (f *File) GetSize() int64 {
 	info, err := os.Stat(f.GetPath())
 	if err != nil {
-		log.Fatalf("unable to determine file size: %v", err)
+		// not on disk (yet), i.e. a file that's about to be downloaded.
+		// use the last size we know of instead.
+		return f.Size
 	}
 	return info.Size()
 }
@@ -161,58 +219,166 @@ func (f *File) GetPath() string {
 	return path
 }
 
-// ----------- simple security features
+// ----------- security. see crypt.go
 
-func (f *File) Lock(password string) {
-	if password == f.Key {
-		f.Protected = true
-	} else {
-		log.Print("[INFO] wrong password")
+// lock this file with a password. its contents are encrypted on disk, and
+// can only be read again with the same password. if the file doesn't have a
+// password yet, this one becomes its password.
+func (f *File) Lock(password string) error {
+	return f.lock(newKeyring(password))
+}
+
+func (f *File) lock(kr *keyring) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	dataKey, key, err := kr.open(f.Key)
+	if err != nil {
+		return fmt.Errorf("%s (id=%s): %w", f.Name, f.ID, err)
 	}
+	// files locked by older versions of sfs were never encrypted
+	if !f.Protected || !isWrappedKey(f.Key) {
+		if err := encryptFile(f.GetPath(), dataKey); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", f.Name, err)
+		}
+	}
+	f.Key = key
+	f.Protected = true
+	f.dataKey = nil
+	return nil
 }
 
-func (f *File) Unlock(password string) {
-	if password == f.Key {
-		f.Protected = false
-	} else {
-		log.Print("[INFO] wrong password")
+// unlock this file, decrypting its contents on disk
+func (f *File) Unlock(password string) error {
+	return f.unlock(newKeyring(password))
+}
+
+func (f *File) unlock(kr *keyring) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if !f.Protected {
+		return nil
+	}
+	dataKey, key, err := kr.open(f.Key)
+	if err != nil {
+		return fmt.Errorf("%s (id=%s): %w", f.Name, f.ID, err)
+	}
+	if isWrappedKey(f.Key) {
+		if err := decryptFile(f.GetPath(), dataKey); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %v", f.Name, err)
+		}
+	}
+	f.Key = key
+	f.Protected = false
+	f.dataKey = nil
+	return nil
+}
+
+// change this file's password. its data key is wrapped
+// with the new password, so its contents aren't touched.
+func (f *File) ChangePassword(oldPassword string, newPassword string) error {
+	if err := f.rekey(newKeyring(oldPassword), newKeyring(newPassword)); err != nil {
+		return err
 	}
+	log.Printf("[INFO] %s (id=%s) password updated!", f.Name, f.ID)
+	return nil
 }
 
-func (f *File) ChangePassword(oldPassword string, newPassword string) {
-	if oldPassword == f.Key {
-		f.Key = newPassword
-		log.Printf("[INFO] %s (id=%s) password updated!", f.Name, f.ID)
-	} else {
-		log.Print("[INFO] wrong password")
+func (f *File) rekey(old *keyring, kr *keyring) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	dataKey, key, err := old.rekey(f.Key, kr)
+	if err != nil {
+		return fmt.Errorf("%s (id=%s): %w", f.Name, f.ID, err)
+	}
+	if f.Protected && !isWrappedKey(f.Key) {
+		if err := encryptFile(f.GetPath(), dataKey); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", f.Name, err)
+		}
+	}
+	f.Key = key
+	return nil
+}
+
+// whether this file's contents are encrypted. files locked
+// by older versions of sfs are protected, but not encrypted.
+func (f *File) Encrypted() bool {
+	return f.Protected && isWrappedKey(f.Key)
+}
+
+// get this file's data key for encrypting or decrypting contents kept
+// somewhere other than its path (i.e. on the server). if it doesn't have
+// one yet, a new one is set up with this password.
+func (f *File) DataKey(password string) ([]byte, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	dataKey, key, err := newKeyring(password).open(f.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%s (id=%s): %w", f.Name, f.ID, err)
 	}
+	f.Key = key
+	return dataKey, nil
+}
+
+// allow this file's contents to be read (see Load) without unlocking it.
+// the file's data key is only kept in memory.
+func (f *File) Authorize(password string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if !f.Protected || !isWrappedKey(f.Key) {
+		return nil
+	}
+	dataKey, err := newKeyring(password).unwrap(f.Key)
+	if err != nil {
+		return fmt.Errorf("%s (id=%s): %w", f.Name, f.ID, err)
+	}
+	f.dataKey = dataKey
+	return nil
 }
 
 // ----------- I/O
 
-// load file contents into memory
+// load file contents into memory. protected files are
+// decrypted if they've been authorized (see Authorize).
 func (f *File) Load() {
 	if f.GetPath() == "" {
 		log.Fatalf("no path specified")
 	}
-	if !f.Protected {
-		f.m.Lock()
-		defer f.m.Unlock()
+	f.m.Lock()
+	defer f.m.Unlock()
 
-		file, err := os.Open(f.GetPath())
-		if err != nil {
-			log.Fatalf("unable to open file %s: %v", f.GetPath(), err)
-		}
-		defer file.Close()
+	if f.Protected && f.dataKey == nil {
+		log.Printf("[INFO] %s is protected", f.Name)
+		return
+	}
+	file, err := os.Open(f.GetPath())
+	if err != nil {
+		log.Fatalf("unable to open file %s: %v", f.GetPath(), err)
+	}
+	defer file.Close()
 
-		data, err := os.ReadFile(file.Name())
+	var r io.Reader = file
+	if f.Protected {
+		info, err := file.Stat()
 		if err != nil {
 			log.Fatalf("unable to read file %s: %v", f.Name, err)
 		}
-		f.Content = data
-	} else {
-		log.Printf("[INFO] %s is protected", f.Name)
+		d, err := NewDecrypter(file, info.Size(), f.dataKey)
+		if err != nil {
+			log.Printf("[ERROR] unable to decrypt %s: %v", f.Name, err)
+			return
+		}
+		r = d
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Fatalf("unable to read file %s: %v", f.Name, err)
 	}
+	f.Content = data
 }
 
 // update (or create) a file. updates checksum and last sync time.
@@ -279,42 +445,3 @@ func (f *File) Copy(destPath string) error {
 	}
 	return nil
 }
-
-// ----------- File integrity
-
-func CalculateChecksum(filePath string) (string, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	var h = sha256.New()
-	if _, err := io.Copy(h, file); err != nil {
-		return "", err
-	}
-
-	checksum := string(h.Sum(nil))
-	return checksum, nil
-}
-
-func (f *File) ValidateChecksum() error {
-	cs, err := CalculateChecksum(f.GetPath())
-	if err != nil {
-		return fmt.Errorf("unable to calculate checksum: %v", err)
-	}
-	if cs != f.CheckSum {
-		return fmt.Errorf("checksum mismatch! orig: %s, new: %s", cs, f.CheckSum)
-	}
-	return nil
-}
-
-func (f *File) UpdateChecksum() error {
-	newCs, err := CalculateChecksum(f.GetPath())
-	if err != nil {
-		return fmt.Errorf("CalculateChecksum failed: %v", err)
-	}
-	f.CheckSum = newCs
-	f.LastSync = time.Now().UTC()
-	return nil
-}
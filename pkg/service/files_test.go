@@ -1,6 +1,9 @@
 package service
 
 import (
+	"errors"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/sfs/pkg/env"
@@ -97,20 +100,50 @@ func TestFileSecurityFeatures(t *testing.T) {
 	assert.NotEqual(t, 0, len(tf.Content))
 	assert.Equal(t, stuff, tf.Content)
 
+	// contents are encrypted on disk, and the password isn't stored anywhere
+	data, err := os.ReadFile(tf.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, stuff, data)
+	assert.False(t, strings.Contains(tf.Key, "default"))
+
+	// contents can only be read once authorized
+	tf.Content = make([]byte, 0)
+	tf.Load()
+	assert.Equal(t, 0, len(tf.Content))
+	assert.Error(t, tf.Authorize("wrongPassword"))
+	assert.NoError(t, tf.Authorize("default"))
+	tf.Load()
+	assert.Equal(t, stuff, tf.Content)
+
 	// attempt to change password
-	tf.ChangePassword("wrongPassword", "someOtherThing")
-	assert.NotEqual(t, tf.Key, "someOtherThing")
-	assert.Equal(t, "default", tf.Key)
+	key := tf.Key
+	assert.True(t, errors.Is(tf.ChangePassword("wrongPassword", "someOtherThing"), ErrWrongPassword))
+	assert.Equal(t, key, tf.Key)
 	assert.Equal(t, true, tf.Protected)
 
-	// actually change password
-	tf.ChangePassword("default", "someOtherThing")
-	assert.Equal(t, "someOtherThing", tf.Key)
+	// actually change password. contents aren't encrypted again
+	assert.NoError(t, tf.ChangePassword("default", "someOtherThing"))
+	assert.NotEqual(t, key, tf.Key)
+	assert.False(t, strings.Contains(tf.Key, "someOtherThing"))
 	assert.Equal(t, true, tf.Protected)
+	same, err := os.ReadFile(tf.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, same)
 
-	// unclock file
-	tf.Unlock("someOtherThing")
+	// unlock file
+	assert.Error(t, tf.Unlock("default"))
+	assert.Equal(t, true, tf.Protected)
+	assert.NoError(t, tf.Unlock("someOtherThing"))
 	assert.Equal(t, false, tf.Protected)
+	data, err = os.ReadFile(tf.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, stuff, data)
 
 	if err = RemoveTestFiles(t, 1); err != nil {
 		t.Fatalf("[ERROR] failed to remove test files: %v", err)
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+exclusion rules.
+
+items can be excluded from discovery, monitoring, and synchronization with
+gitignore-style patterns. patterns can be listed in a .sfsignore file in any
+directory, where they apply to that directory and everything under it, or set
+globally in the client's configuration.
+
+	# comments and blank lines are skipped
+	*.log        matches any item named *.log, at any depth
+	build/       trailing slash only matches directories
+	/todo.txt    leading (or middle) slash anchors the pattern to the
+	             directory the .sfsignore file is in
+	docs/**      ** matches any number of directories
+	!keep.log    negates a previous match
+
+the last rule that matches an item decides whether it's ignored, and rules in
+deeper .sfsignore files come after the ones above them. anything under an
+ignored directory is ignored too, and can't be brought back with a negated rule.
+*/
+
+// name of per-directory ignore files
+const IgnoreFile = ".sfsignore"
+
+// used by the walking and monitoring functions to
+// skip items. returns true if the item should be skipped.
+type IgnoreFunc func(path string, isDir bool) bool
+
+// a single exclusion pattern
+type IgnoreRule struct {
+	Pattern string `json:"pattern"` // the pattern as it was written
+	Source  string `json:"source"`  // path to the .sfsignore file this rule came from, or "global"
+	Line    int    `json:"line"`    // line number in the source file. 0 for global rules.
+
+	base     string   // directory the pattern is relative to. empty for unanchored global rules.
+	parts    []string // pattern split into path segments
+	negate   bool     // pattern started with a !
+	dirOnly  bool     // pattern ended with a /
+	anchored bool     // pattern is relative to base, rather than matching names at any depth
+}
+
+// parse a single line from an ignore file. base is the directory
+// the pattern is relative to. returns nil for blank lines and comments.
+func ParseIgnoreRule(line string, base string, source string, lineNum int) *IgnoreRule {
+	pattern := strings.TrimRight(line, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil
+	}
+	rule := &IgnoreRule{
+		Pattern: pattern,
+		Source:  source,
+		Line:    lineNum,
+		base:    filepath.Clean(base),
+	}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\`) {
+		pattern = pattern[1:] // escaped leading ! or #
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if strings.Contains(pattern, "/") {
+		rule.anchored = true
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	if pattern == "" {
+		return nil
+	}
+	rule.parts = strings.Split(pattern, "/")
+	return rule
+}
+
+// whether this rule matches the given item
+func (r *IgnoreRule) Matches(itemPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	// unanchored global rules match names anywhere, even outside the root
+	if r.base == "" {
+		return matchSegs(r.parts, []string{filepath.Base(itemPath)})
+	}
+	rel, err := filepath.Rel(r.base, itemPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	segs := strings.Split(filepath.ToSlash(rel), "/")
+	if !r.anchored {
+		return matchSegs(r.parts, segs[len(segs)-1:])
+	}
+	return matchSegs(r.parts, segs)
+}
+
+// whether this rule un-ignores the items it matches
+func (r *IgnoreRule) Negated() bool { return r.negate }
+
+func (r *IgnoreRule) String() string {
+	if r.Line == 0 {
+		return fmt.Sprintf("%s: %s", r.Source, r.Pattern)
+	}
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Pattern)
+}
+
+// match path segments against pattern segments. ** matches
+// zero or more segments, everything else uses path.Match.
+func matchSegs(pattern []string, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// a trailing ** matches everything inside a directory
+			if len(pattern) == 1 {
+				return len(segs) > 0
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegs(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], segs[0]); err != nil || !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// rules loaded from a single .sfsignore file
+type ignoreFile struct {
+	modTime time.Time
+	rules   []*IgnoreRule
+}
+
+// a set of exclusion rules for a directory tree.
+// .sfsignore files are read as they're needed and reloaded when they change.
+type Ignore struct {
+	Root string // root of the directory tree. anchored global patterns are relative to this.
+
+	mu     sync.Mutex
+	global []*IgnoreRule
+	files  map[string]*ignoreFile // key = directory path
+}
+
+// create a new set of exclusion rules for the directory tree under root.
+// patterns are global rules that apply everywhere, before any .sfsignore files.
+func NewIgnore(root string, patterns []string) *Ignore {
+	ig := &Ignore{
+		Root:   filepath.Clean(root),
+		global: make([]*IgnoreRule, 0, len(patterns)),
+		files:  make(map[string]*ignoreFile),
+	}
+	for _, p := range patterns {
+		if rule := ParseIgnoreRule(p, ig.Root, "global", 0); rule != nil {
+			if !rule.anchored {
+				rule.base = ""
+			}
+			ig.global = append(ig.global, rule)
+		}
+	}
+	return ig
+}
+
+// global rules
+func (ig *Ignore) Global() []*IgnoreRule {
+	return ig.global
+}
+
+// whether an item should be ignored
+func (ig *Ignore) Ignored(itemPath string, isDir bool) bool {
+	_, ignored := ig.Match(itemPath, isDir)
+	return ignored
+}
+
+// find the rule that decides whether an item is ignored. returns nil if no
+// rule matches. the rule may be a negated one, in which case the item isn't
+// ignored. if a parent directory is ignored, the rule for that directory is returned.
+func (ig *Ignore) Match(itemPath string, isDir bool) (*IgnoreRule, bool) {
+	itemPath = filepath.Clean(itemPath)
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	for _, dir := range ig.parents(itemPath) {
+		if rule, ignored := ig.match(dir, true); ignored {
+			return rule, true
+		}
+	}
+	return ig.match(itemPath, isDir)
+}
+
+// find the last rule matching an item, without checking its parents
+func (ig *Ignore) match(itemPath string, isDir bool) (*IgnoreRule, bool) {
+	var matched *IgnoreRule
+	for _, rule := range ig.rulesFor(filepath.Dir(itemPath)) {
+		if rule.Matches(itemPath, isDir) {
+			matched = rule
+		}
+	}
+	if matched == nil {
+		return nil, false
+	}
+	return matched, !matched.negate
+}
+
+// directories between the top of the tree and the item's parent.
+// items outside of the root use the top of the file system instead.
+func (ig *Ignore) parents(itemPath string) []string {
+	top := ig.top(itemPath)
+	dirs := make([]string, 0)
+	for dir := filepath.Dir(itemPath); dir != top && strings.HasPrefix(dir, top); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	// parents first
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+		dirs[i], dirs[j] = dirs[j], dirs[i]
+	}
+	return dirs
+}
+
+func (ig *Ignore) top(itemPath string) string {
+	if itemPath == ig.Root || strings.HasPrefix(itemPath, ig.Root+string(filepath.Separator)) {
+		return ig.Root
+	}
+	return filepath.VolumeName(itemPath) + string(filepath.Separator)
+}
+
+// all rules that apply to items in a directory, in order: global rules
+// first, then the .sfsignore files from the top of the tree down to dir.
+func (ig *Ignore) rulesFor(dir string) []*IgnoreRule {
+	rules := make([]*IgnoreRule, 0, len(ig.global))
+	rules = append(rules, ig.global...)
+	rules = append(rules, ig.load(ig.top(dir))...)
+	for _, d := range ig.parents(filepath.Join(dir, IgnoreFile)) {
+		rules = append(rules, ig.load(d)...)
+	}
+	return rules
+}
+
+// load the rules from a directory's .sfsignore file, if it has one.
+// rules are cached until the file is modified.
+func (ig *Ignore) load(dir string) []*IgnoreRule {
+	fp := filepath.Join(dir, IgnoreFile)
+	info, err := os.Stat(fp)
+	if err != nil {
+		delete(ig.files, dir)
+		return nil
+	}
+	if f, ok := ig.files[dir]; ok && f.modTime.Equal(info.ModTime()) {
+		return f.rules
+	}
+	rules, err := readIgnoreFile(fp, dir)
+	if err != nil {
+		return nil
+	}
+	ig.files[dir] = &ignoreFile{modTime: info.ModTime(), rules: rules}
+	return rules
+}
+
+// read the rules from an ignore file
+func readIgnoreFile(fp string, base string) ([]*IgnoreRule, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := make([]*IgnoreRule, 0)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		if rule := ParseIgnoreRule(scanner.Text(), base, fp, n); rule != nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
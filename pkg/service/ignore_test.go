@@ -0,0 +1,140 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	env.SetEnv(false)
+
+	root := filepath.Join(GetTestingDir(), "ignore-root")
+	defer os.RemoveAll(root)
+
+	ig := NewIgnore(root, []string{".git/", "*.swp", "/build/"})
+
+	var tests = []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"notes.txt", false, false},
+		{".git", true, true},
+		{".git", false, false}, // dir only
+		{".git/config", false, true},
+		{"src/.git/HEAD", false, true},
+		{"src/notes.txt.swp", false, true},
+		{"build", true, true},
+		{"src/build", true, false}, // anchored to root
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.ignored, ig.Ignored(filepath.Join(root, tt.path), tt.isDir), tt.path)
+	}
+
+	// unanchored global rules also apply outside the root
+	assert.True(t, ig.Ignored(filepath.Join(GetTestingDir(), "other", "a.swp"), false))
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	env.SetEnv(false)
+
+	root := filepath.Join(GetTestingDir(), "ignore-root")
+	sub := filepath.Join(root, "src")
+	if err := os.MkdirAll(sub, PERMS); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	rootRules := "# build output\n*.log\n!keep.log\ndocs/**/tmp\n"
+	if err := os.WriteFile(filepath.Join(root, IgnoreFile), []byte(rootRules), PERMS); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, IgnoreFile), []byte("/gen/\n!debug.log\n"), PERMS); err != nil {
+		t.Fatal(err)
+	}
+
+	ig := NewIgnore(root, nil)
+
+	var tests = []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"src/a.log", false, true},
+		{"src/debug.log", false, false}, // negated in src/.sfsignore
+		{"debug.log", false, true},
+		{"src/gen", true, true},
+		{"src/gen/main.go", false, true}, // parent is ignored
+		{"gen", true, false},             // anchored to src
+		{"docs/tmp", true, true},
+		{"docs/a/b/tmp", true, true},
+		{"tmp", true, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.ignored, ig.Ignored(filepath.Join(root, tt.path), tt.isDir), tt.path)
+	}
+
+	// the deciding rule is reported
+	rule, ignored := ig.Match(filepath.Join(sub, "debug.log"), false)
+	assert.False(t, ignored)
+	assert.NotZero(t, rule)
+	assert.Equal(t, "!debug.log", rule.Pattern)
+	assert.Equal(t, filepath.Join(sub, IgnoreFile), rule.Source)
+	assert.Equal(t, 2, rule.Line)
+
+	rule, ignored = ig.Match(filepath.Join(sub, "gen", "main.go"), false)
+	assert.True(t, ignored)
+	assert.Equal(t, "/gen/", rule.Pattern)
+
+	rule, _ = ig.Match(filepath.Join(root, "notes.txt"), false)
+	assert.Zero(t, rule)
+
+	// changes to .sfsignore files are picked up
+	if err := os.WriteFile(filepath.Join(root, IgnoreFile), []byte("*.txt\n"), PERMS); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, IgnoreFile), future, future); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, ig.Ignored(filepath.Join(root, "notes.txt"), false))
+	assert.False(t, ig.Ignored(filepath.Join(root, "a.log"), false))
+}
+
+func TestWalkIgnoring(t *testing.T) {
+	env.SetEnv(false)
+
+	root := filepath.Join(GetTestingDir(), "ignore-root")
+	for _, dir := range []string{"src", "node_modules/pkg"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), PERMS); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.RemoveAll(root)
+	for _, fn := range []string{"a.txt", "a.txt.swp", "src/b.txt", "node_modules/pkg/c.js"} {
+		if _, err := MakeTmpTxtFile(filepath.Join(root, fn), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ig := NewIgnore(root, []string{"node_modules/", "*.swp"})
+	dir := NewDirectory("ignore-root", "me", "some-drive", root)
+	dir.WalkIgnoring(ig.Ignored)
+
+	files := dir.GetFiles()
+	assert.Equal(t, 2, len(files))
+	for _, file := range files {
+		assert.True(t, file.Name == "a.txt" || file.Name == "b.txt", file.Name)
+	}
+	subDirs := dir.GetSubDirs()
+	assert.Equal(t, 1, len(subDirs))
+	assert.Equal(t, "src", subDirs[0].Name)
+}
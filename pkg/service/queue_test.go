@@ -126,3 +126,38 @@ func TestBuildQWithFilesLargerThanMAX(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBuildFileQ(t *testing.T) {
+	env.SetEnv(false)
+
+	// files that aren't on disk yet use their last known size
+	missing := func(name string, size int64) *File {
+		return &File{ID: name, Name: name, ClientPath: filepath.Join(GetTestingDir(), "missing", name), Size: size}
+	}
+	small := missing("small.txt", 10)
+	files := []*File{
+		missing("huge.bin", MAX+1),
+		small,
+		small, // duplicates are only queued once
+		missing("medium.txt", MAX/2),
+		missing("big.txt", MAX-1),
+	}
+
+	q := BuildFileQ(files)
+	seen := make(map[string]int)
+	var last *Batch
+	for b := q.Dequeue(); b != nil; b = q.Dequeue() {
+		for id := range b.Files {
+			seen[id]++
+		}
+		last = b
+	}
+	assert.Equal(t, map[string]int{"small.txt": 1, "medium.txt": 1, "big.txt": 1, "huge.bin": 1}, seen)
+
+	// files larger than MAX are in their own batch at the end
+	assert.Equal(t, 1, len(last.Files))
+	_, ok := last.Files["huge.bin"]
+	assert.True(t, ok)
+
+	assert.Equal(t, 0, len(BuildFileQ(nil).Queue))
+}
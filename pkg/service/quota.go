@@ -0,0 +1,162 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*
+storage quotas.
+
+each drive can hold at most TotalSize bytes. UsedSpace and FreeSpace are
+updated whenever a file is added, changed, or removed, and anything that
+would take a drive over its quota is rejected before it's written.
+only the current contents of a drive count towards its quota. saved file
+versions and the recycle bin don't.
+*/
+
+var (
+	// a change would take a drive over its quota
+	ErrQuotaExceeded = errors.New("drive quota exceeded")
+
+	// a single file is larger than the drive's entire quota
+	ErrFileTooLarge = errors.New("file is larger than the drive quota")
+)
+
+// size of a file's contents, as recorded in its metadata. the contents
+// themselves may not be on the local disk (see storage.Store), and files
+// encrypted at rest are larger there than what was uploaded.
+func sizeOf(file *File) int64 {
+	return file.Size
+}
+
+// total size of all files in a directory and its subdirectories
+func filesSize(dir *Directory) int64 {
+	var total int64
+	for _, file := range dir.WalkFs() {
+		total += sizeOf(file)
+	}
+	return total
+}
+
+// check whether a drive has room for a change of delta bytes.
+// size is the total size of the item being added or updated.
+// returns ErrFileTooLarge if the item could never fit, and ErrQuotaExceeded
+// if there isn't enough free space left for it.
+func (d *Drive) CheckQuota(size int64, delta int64) error {
+	if size > d.TotalSize {
+		return fmt.Errorf("%w: %d bytes (quota is %d bytes)", ErrFileTooLarge, size, d.TotalSize)
+	}
+	if delta > 0 && delta > d.RemainingSize() {
+		return fmt.Errorf("%w: %d bytes needed, %d bytes free", ErrQuotaExceeded, delta, d.RemainingSize())
+	}
+	return nil
+}
+
+// check whether a drive has room for a file's contents to be
+// replaced with newSize bytes.
+func (d *Drive) CheckFileQuota(file *File, newSize int64) error {
+	return d.CheckReservedQuota(file, newSize, 0)
+}
+
+// check whether a drive has room for a file's contents to be replaced
+// with newSize bytes when reserved bytes are already set aside for
+// changes that haven't been written yet, like open upload sessions.
+func (d *Drive) CheckReservedQuota(file *File, newSize int64, reserved int64) error {
+	return d.CheckQuota(newSize, newSize-sizeOf(file)+reserved)
+}
+
+// the largest a file's contents can be before CheckFileQuota would reject
+// them. file is nil for new files, which don't free up any space.
+func (d *Drive) FileQuota(file *File) int64 {
+	limit := d.RemainingSize()
+	if limit < 0 {
+		limit = 0
+	}
+	if file != nil {
+		limit += sizeOf(file)
+	}
+	if limit > d.TotalSize {
+		limit = d.TotalSize
+	}
+	return limit
+}
+
+// set the maximum size of the drive in bytes. the new quota can be
+// smaller than what's currently in use, in which case nothing new can
+// be added until enough space is freed up.
+func (d *Drive) SetQuota(size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid quota: %d", size)
+	}
+	d.TotalSize = size
+	d.FreeSpace = d.TotalSize - d.UsedSpace
+	return nil
+}
+
+// recalculate the space used by this drive from its files.
+// returns the new used space total.
+func (d *Drive) RecalculateUsage() int64 {
+	if d.HasRoot() {
+		d.UsedSpace = filesSize(d.Root)
+		d.FreeSpace = d.TotalSize - d.UsedSpace
+	}
+	return d.UsedSpace
+}
+
+// space used by a top-level directory in a drive
+type DirUsage struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+	Size  int64  `json:"size"`
+}
+
+// a summary of a drive's space usage
+type DriveUsage struct {
+	DriveID   string `json:"drive_id"`
+	TotalSize int64  `json:"total_size"`
+	UsedSpace int64  `json:"used_space"`
+	FreeSpace int64  `json:"free_space"`
+
+	// files directly under the root directory
+	RootFiles int   `json:"root_files"`
+	RootSize  int64 `json:"root_size"`
+
+	// top-level directories, largest first
+	Dirs []*DirUsage `json:"dirs"`
+}
+
+// get a drive's space usage broken down by top-level directory
+func (d *Drive) Usage() *DriveUsage {
+	usage := &DriveUsage{
+		DriveID:   d.ID,
+		TotalSize: d.TotalSize,
+		UsedSpace: d.UsedSpace,
+		FreeSpace: d.TotalSize - d.UsedSpace,
+		Dirs:      make([]*DirUsage, 0),
+	}
+	if !d.HasRoot() {
+		return usage
+	}
+	for _, file := range d.Root.Files {
+		usage.RootFiles++
+		usage.RootSize += sizeOf(file)
+	}
+	for _, dir := range d.Root.Dirs {
+		usage.Dirs = append(usage.Dirs, &DirUsage{
+			ID:    dir.ID,
+			Name:  dir.Name,
+			Files: len(dir.WalkFs()),
+			Size:  filesSize(dir),
+		})
+	}
+	sort.Slice(usage.Dirs, func(i, j int) bool {
+		if usage.Dirs[i].Size == usage.Dirs[j].Size {
+			return usage.Dirs[i].Name < usage.Dirs[j].Name
+		}
+		return usage.Dirs[i].Size > usage.Dirs[j].Size
+	})
+	return usage
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestCheckQuota(t *testing.T) {
+	env.SetEnv(false)
+
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testDrv.SetQuota(100); err != nil {
+		t.Fatal(err)
+	}
+	testDrv.UpdateDriveSize(60)
+	assert.Equal(t, int64(40), testDrv.FreeSpace)
+
+	assert.NoError(t, testDrv.CheckQuota(40, 40))
+	assert.True(t, errors.Is(testDrv.CheckQuota(50, 50), ErrQuotaExceeded))
+	assert.True(t, errors.Is(testDrv.CheckQuota(101, 101), ErrFileTooLarge))
+
+	// shrinking a file is always allowed
+	assert.NoError(t, testDrv.CheckQuota(10, -50))
+
+	// files can grow into the free space, but never past the whole quota
+	file := &File{Size: 30, ClientPath: filepath.Join(GetTestingDir(), "not-a-file.txt")}
+	assert.Equal(t, int64(40), testDrv.FileQuota(nil))
+	assert.Equal(t, int64(70), testDrv.FileQuota(file))
+	assert.NoError(t, testDrv.CheckFileQuota(file, testDrv.FileQuota(file)))
+	assert.Error(t, testDrv.CheckFileQuota(file, testDrv.FileQuota(file)+1))
+	file.Size = 90
+	assert.Equal(t, int64(100), testDrv.FileQuota(file))
+
+	// the recorded size is used, not the size of whatever is on disk
+	onDisk, err := MakeTmpTxtFile(filepath.Join(GetTestingDir(), "on-disk.txt"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDisk.Size = 5
+	assert.Equal(t, int64(45), testDrv.FileQuota(onDisk))
+
+	// quotas can be set below what's already in use
+	if err := testDrv.SetQuota(50); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(-10), testDrv.FreeSpace)
+	assert.True(t, errors.Is(testDrv.CheckQuota(1, 1), ErrQuotaExceeded))
+	assert.Equal(t, int64(0), testDrv.FileQuota(nil))
+	assert.Error(t, testDrv.SetQuota(0))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriveUsage(t *testing.T) {
+	env.SetEnv(false)
+
+	// root with one file, and two top-level directories of different sizes
+	testDrv := MakeEmptyTmpDrive(t)
+	rootFile, err := MakeTmpTxtFile(filepath.Join(testDrv.Root.Path, "root.txt"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testDrv.AddFile(testDrv.Root.ID, rootFile); err != nil {
+		t.Fatal(err)
+	}
+	for i, reps := range []int{10, 100} {
+		dir := NewDirectory(fmt.Sprintf("dir%d", i), "me", testDrv.ID, filepath.Join(testDrv.Root.Path, fmt.Sprintf("dir%d", i)))
+		if err := os.Mkdir(dir.Path, PERMS); err != nil {
+			t.Fatal(err)
+		}
+		if err := testDrv.AddSubDir(testDrv.Root.ID, dir); err != nil {
+			t.Fatal(err)
+		}
+		file, err := MakeTmpTxtFile(filepath.Join(dir.Path, "file.txt"), reps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := testDrv.AddFile(dir.ID, file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	used := testDrv.UsedSpace
+	assert.NotEqual(t, int64(0), used)
+	assert.Equal(t, used, testDrv.RecalculateUsage())
+
+	usage := testDrv.Usage()
+	assert.Equal(t, 1, usage.RootFiles)
+	assert.Equal(t, 2, len(usage.Dirs))
+	assert.Equal(t, "dir1", usage.Dirs[0].Name) // largest first
+	assert.Equal(t, used, usage.RootSize+usage.Dirs[0].Size+usage.Dirs[1].Size)
+
+	// removing a file frees up its space
+	size := sizeOf(rootFile)
+	if err := testDrv.RemoveFile(testDrv.Root.ID, rootFile); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, used-size, testDrv.UsedSpace)
+	assert.Equal(t, testDrv.UsedSpace, testDrv.RecalculateUsage())
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
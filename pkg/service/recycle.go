@@ -0,0 +1,180 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// default amount of time deleted items are kept in a drive's recycle bin
+// before being permanently removed
+const DefaultTrashRetention time.Duration = time.Hour * 24 * 30
+
+/*
+a file or directory that's been moved to a drive's recycle bin.
+
+the original item's metadata is kept in Data so it can be restored
+with its original ID to the directory it was deleted from (DirID).
+directories are stored along with all of their files and subdirectories.
+*/
+type RecycledItem struct {
+	ID        string    `json:"id"`         // id of the deleted file or directory
+	Name      string    `json:"name"`       // name of the deleted item
+	IsDir     bool      `json:"is_dir"`     // whether this is a directory
+	OwnerID   string    `json:"owner_id"`   // owner of the deleted item
+	DriveID   string    `json:"drive_id"`   // drive the item was deleted from
+	DirID     string    `json:"dir_id"`     // id of the directory the item was deleted from
+	OrigPath  string    `json:"orig_path"`  // original location of the item
+	BinPath   string    `json:"bin_path"`   // location of the item in the recycle bin
+	Size      int64     `json:"size"`       // size of the item (and all its children) in bytes
+	DeletedAt time.Time `json:"deleted_at"` // when this item was deleted
+	Data      string    `json:"-"`          // serialized item metadata. used for restoring.
+}
+
+// a deleted directory along with all of its contents
+type recycledDir struct {
+	Dir   *Directory     `json:"dir"`
+	Files []*File        `json:"files"`
+	Dirs  []*recycledDir `json:"dirs"`
+}
+
+// create a recycle bin entry for a deleted file.
+// does not move the file's contents to binPath.
+func NewRecycledFile(file *File, binPath string) (*RecycledItem, error) {
+	data, err := file.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode file metadata: %v", err)
+	}
+	return &RecycledItem{
+		ID:        file.ID,
+		Name:      file.Name,
+		IsDir:     false,
+		OwnerID:   file.OwnerID,
+		DriveID:   file.DriveID,
+		DirID:     file.DirID,
+		OrigPath:  file.ServerPath,
+		BinPath:   binPath,
+		Size:      file.Size,
+		DeletedAt: time.Now().UTC(),
+		Data:      string(data),
+	}, nil
+}
+
+// create a recycle bin entry for a deleted directory and all of its contents.
+// parentID is the id of the directory dir was deleted from.
+// does not move the directory's contents to binPath.
+func NewRecycledDir(dir *Directory, parentID string, binPath string) (*RecycledItem, error) {
+	data, err := json.Marshal(newRecycledDir(dir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode directory metadata: %v", err)
+	}
+	size, err := dir.GetSize()
+	if err != nil {
+		return nil, err
+	}
+	return &RecycledItem{
+		ID:        dir.ID,
+		Name:      dir.Name,
+		IsDir:     true,
+		OwnerID:   dir.OwnerID,
+		DriveID:   dir.DriveID,
+		DirID:     parentID,
+		OrigPath:  dir.Path,
+		BinPath:   binPath,
+		Size:      size,
+		DeletedAt: time.Now().UTC(),
+		Data:      string(data),
+	}, nil
+}
+
+func newRecycledDir(dir *Directory) *recycledDir {
+	rd := &recycledDir{
+		Dir:   copyDir(dir),
+		Files: make([]*File, 0, len(dir.Files)),
+		Dirs:  make([]*recycledDir, 0, len(dir.Dirs)),
+	}
+	for _, f := range dir.Files {
+		rd.Files = append(rd.Files, f)
+	}
+	for _, sd := range dir.Dirs {
+		rd.Dirs = append(rd.Dirs, newRecycledDir(sd))
+	}
+	return rd
+}
+
+// shallow copy of a directory without its parent, files, or subdirectories,
+// so we're not serializing the whole tree above it.
+func copyDir(dir *Directory) *Directory {
+	return &Directory{
+		ID:         dir.ID,
+		NMap:       dir.NMap,
+		Name:       dir.Name,
+		OwnerID:    dir.OwnerID,
+		DriveID:    dir.DriveID,
+		Size:       dir.Size,
+		Path:       dir.Path,
+		ClientPath: dir.ClientPath,
+		ServerPath: dir.ServerPath,
+		Protected:  dir.Protected,
+		AuthType:   dir.AuthType,
+		Key:        dir.Key,
+		Overwrite:  dir.Overwrite,
+		LastSync:   dir.LastSync,
+		Version:    dir.Version.Copy(),
+		Endpoint:   dir.Endpoint,
+		Root:       dir.Root,
+		RootPath:   dir.RootPath,
+		ParentID:   dir.ParentID,
+	}
+}
+
+// get the original file metadata for a recycled file
+func (r *RecycledItem) File() (*File, error) {
+	if r.IsDir {
+		return nil, fmt.Errorf("%s (id=%s) is a directory", r.Name, r.ID)
+	}
+	return UnmarshalFileStr(r.Data)
+}
+
+// rebuild the original directory tree for a recycled directory.
+// files and subdirectories are added back to their parent directories,
+// but the returned directory has no parent.
+func (r *RecycledItem) Dir() (*Directory, error) {
+	if !r.IsDir {
+		return nil, fmt.Errorf("%s (id=%s) is a file", r.Name, r.ID)
+	}
+	rd := new(recycledDir)
+	if err := json.Unmarshal([]byte(r.Data), rd); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal directory data: %v", err)
+	}
+	return rd.rebuild(), nil
+}
+
+func (rd *recycledDir) rebuild() *Directory {
+	dir := rd.Dir
+	dir.Files = make(map[string]*File, len(rd.Files))
+	dir.Dirs = make(map[string]*Directory, len(rd.Dirs))
+	for _, f := range rd.Files {
+		dir.Files[f.ID] = f
+	}
+	for _, sd := range rd.Dirs {
+		child := sd.rebuild()
+		child.Parent = dir
+		dir.Dirs[child.ID] = child
+	}
+	return dir
+}
+
+func (r *RecycledItem) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// whether this item has been in the recycle bin longer than the given retention period.
+// items are never expired if retention is <= 0.
+func (r *RecycledItem) Expired(retention time.Duration) bool {
+	return retention > 0 && time.Since(r.DeletedAt) > retention
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestRecycledFile(t *testing.T) {
+	env.SetEnv(false)
+
+	file, err := MakeTmpTxtFile(filepath.Join(GetTestingDir(), "tmp.txt"), 10)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	item, err := NewRecycledFile(file, filepath.Join(GetTestingDir(), "recycled", file.ID))
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.False(t, item.IsDir)
+	assert.Equal(t, file.DirID, item.DirID)
+
+	// original metadata should survive the round trip
+	f, err := item.File()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.Equal(t, file.ID, f.ID)
+	assert.Equal(t, file.ServerPath, f.ServerPath)
+
+	if _, err := item.Dir(); err == nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecycledDir(t *testing.T) {
+	env.SetEnv(false)
+
+	root := MakeTmpDirs(t)
+	var dir *Directory
+	for _, d := range root.Dirs {
+		dir = d
+	}
+	item, err := NewRecycledDir(dir, root.ID, filepath.Join(GetTestingDir(), "recycled", dir.ID))
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.True(t, item.IsDir)
+	assert.Equal(t, root.ID, item.DirID)
+
+	// rebuilt tree should have the same files and subdirectories
+	d, err := item.Dir()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.Equal(t, dir.ID, d.ID)
+	assert.Equal(t, len(dir.GetFiles()), len(d.GetFiles()))
+	assert.Equal(t, len(dir.WalkDs()), len(d.WalkDs()))
+	for _, sd := range d.Dirs {
+		assert.Equal(t, d, sd.Parent)
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecycledExpired(t *testing.T) {
+	item := &RecycledItem{DeletedAt: time.Now().UTC().Add(-time.Hour * 2)}
+	assert.True(t, item.Expired(time.Hour))
+	assert.False(t, item.Expired(time.Hour*3))
+	assert.False(t, item.Expired(0)) // never expires
+}
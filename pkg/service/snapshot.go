@@ -0,0 +1,212 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+// kinds of changes between two snapshots of a drive
+const (
+	SnapshotAdded   string = "added"
+	SnapshotRemoved string = "removed"
+	SnapshotChanged string = "changed"
+)
+
+/*
+a point-in-time copy of a drive's directory tree.
+
+snapshots keep the metadata of every directory and file in a drive,
+along with the blob each file's contents were in when the snapshot was
+taken. blobs are shared with the files (and other snapshots) that have
+the same contents, so nothing that hasn't changed since is stored twice.
+
+entries are kept in Data, serialized the same way recycled items are,
+and are ordered so every directory comes before anything in it.
+*/
+type Snapshot struct {
+	ID        string    `json:"id"`         // snapshot id
+	DriveID   string    `json:"drive_id"`   // drive this is a snapshot of
+	OwnerID   string    `json:"owner_id"`   // drive owner
+	Name      string    `json:"name"`       // optional label
+	Scheduled bool      `json:"scheduled"`  // whether this was taken on the drive's snapshot schedule
+	Files     int       `json:"files"`      // number of files in the snapshot
+	Size      int64     `json:"size"`       // total size of all files in bytes
+	CreatedAt time.Time `json:"created_at"` // when this snapshot was taken
+	Data      string    `json:"-"`          // serialized entries. used for diffs and restores.
+}
+
+// a directory or file in a snapshot
+type SnapshotEntry struct {
+	Path string     `json:"path"`           // location relative to the drive's root, separated by /
+	Blob string     `json:"blob,omitempty"` // checksum of the blob holding a file's contents
+	File *File      `json:"file,omitempty"`
+	Dir  *Directory `json:"dir,omitempty"`
+}
+
+// a difference between two snapshots
+type SnapshotChange struct {
+	Change  string `json:"change"`             // added, removed, or changed
+	ID      string `json:"id"`                 // id of the file or directory
+	Path    string `json:"path"`               // location of the item (or where it was, if removed)
+	OldPath string `json:"old_path,omitempty"` // previous location, if the item was moved or renamed
+	IsDir   bool   `json:"is_dir"`
+}
+
+// create a snapshot of a drive from its entries (see SnapshotEntries)
+func NewSnapshot(drive *Drive, name string, entries []*SnapshotEntry) (*Snapshot, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot entries: %v", err)
+	}
+	snap := &Snapshot{
+		ID:        auth.NewUUID(),
+		DriveID:   drive.ID,
+		OwnerID:   drive.OwnerID,
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+		Data:      string(data),
+	}
+	for _, e := range entries {
+		if e.File != nil {
+			snap.Files++
+			snap.Size += e.File.Size
+		}
+	}
+	return snap, nil
+}
+
+// entries for a directory tree, starting with root itself. root's path
+// is empty, and everything else is relative to it. blobs are taken from
+// each file's current blob.
+func SnapshotEntries(root *Directory) []*SnapshotEntry {
+	entries := make([]*SnapshotEntry, 0)
+	return snapshotDir(entries, root, "", "")
+}
+
+func snapshotDir(entries []*SnapshotEntry, dir *Directory, parentID string, path string) []*SnapshotEntry {
+	d := copyDir(dir)
+	d.ParentID = parentID
+	entries = append(entries, &SnapshotEntry{Path: path, Dir: d})
+	// sorted so the same tree always makes the same entries
+	files := make([]*File, 0, len(dir.Files))
+	for _, f := range dir.Files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ID < files[j].ID })
+	for _, f := range files {
+		entries = append(entries, &SnapshotEntry{
+			Path: joinEntryPath(path, f.Name),
+			Blob: f.Blob,
+			File: f,
+		})
+	}
+	dirs := make([]*Directory, 0, len(dir.Dirs))
+	for _, sd := range dir.Dirs {
+		dirs = append(dirs, sd)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].ID < dirs[j].ID })
+	for _, sd := range dirs {
+		entries = snapshotDir(entries, sd, dir.ID, joinEntryPath(path, sd.Name))
+	}
+	return entries
+}
+
+func joinEntryPath(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+// get the entries in this snapshot
+func (s *Snapshot) Entries() ([]*SnapshotEntry, error) {
+	entries := make([]*SnapshotEntry, 0)
+	if err := json.Unmarshal([]byte(s.Data), &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot entries: %v", err)
+	}
+	return entries, nil
+}
+
+func (s *Snapshot) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// id of a snapshot entry
+func (e *SnapshotEntry) ID() string {
+	if e.Dir != nil {
+		return e.Dir.ID
+	}
+	return e.File.ID
+}
+
+// whether a snapshot entry is a directory
+func (e *SnapshotEntry) IsDir() bool { return e.Dir != nil }
+
+// whether an item was moved or renamed between two entries for it
+func (e *SnapshotEntry) moved(prev *SnapshotEntry) bool {
+	if e.IsDir() {
+		return e.Dir.ParentID != prev.Dir.ParentID || e.Dir.Name != prev.Dir.Name
+	}
+	return e.File.DirID != prev.File.DirID || e.File.Name != prev.File.Name
+}
+
+// entries at path, and everything under it if it's a directory.
+// an empty path matches everything.
+func EntriesUnder(entries []*SnapshotEntry, path string) []*SnapshotEntry {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return entries
+	}
+	matched := make([]*SnapshotEntry, 0)
+	for _, e := range entries {
+		if e.Path == path || strings.HasPrefix(e.Path, path+"/") {
+			matched = append(matched, e)
+		}
+	}
+	return matched
+}
+
+// find what changed between two sets of entries. items are matched by id,
+// so anything moved or renamed is a change rather than a removal and an
+// addition. files are also changed if their contents are. whatever's in a
+// directory that was moved or renamed isn't changed itself, so renaming a
+// directory is a single change. changes are sorted by path.
+func DiffSnapshots(from []*SnapshotEntry, to []*SnapshotEntry) []*SnapshotChange {
+	before := make(map[string]*SnapshotEntry, len(from))
+	for _, e := range from {
+		before[e.ID()] = e
+	}
+	changes := make([]*SnapshotChange, 0)
+	for _, e := range to {
+		id := e.ID()
+		prev, ok := before[id]
+		delete(before, id)
+		switch {
+		case !ok:
+			changes = append(changes, &SnapshotChange{Change: SnapshotAdded, ID: id, Path: e.Path, IsDir: e.IsDir()})
+		case e.moved(prev):
+			changes = append(changes, &SnapshotChange{Change: SnapshotChanged, ID: id, Path: e.Path, OldPath: prev.Path, IsDir: e.IsDir()})
+		case !e.IsDir() && prev.File.CheckSum != e.File.CheckSum:
+			changes = append(changes, &SnapshotChange{Change: SnapshotChanged, ID: id, Path: e.Path})
+		}
+	}
+	for id, e := range before {
+		changes = append(changes, &SnapshotChange{Change: SnapshotRemoved, ID: id, Path: e.Path, IsDir: e.IsDir()})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Path != changes[j].Path {
+			return changes[i].Path < changes[j].Path
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	return changes
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestSnapshotEntries(t *testing.T) {
+	env.SetEnv(false)
+
+	drive := MakeTmpDrive(t)
+	entries := SnapshotEntries(drive.Root)
+	assert.Equal(t, "", entries[0].Path)
+	assert.Equal(t, drive.Root.ID, entries[0].ID())
+
+	// every directory comes before anything in it
+	seen := make(map[string]bool)
+	for _, e := range entries[1:] {
+		parent := e.Path[:strings.LastIndex(e.Path, "/")+1]
+		assert.True(t, parent == "" || seen[strings.TrimSuffix(parent, "/")], "%s listed before its directory", e.Path)
+		if e.IsDir() {
+			seen[e.Path] = true
+		}
+	}
+
+	snap, err := NewSnapshot(drive, "before", entries)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.Equal(t, len(drive.GetFiles()), snap.Files)
+	got, err := snap.Entries()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.Equal(t, len(entries), len(got))
+	assert.Equal(t, 0, len(DiffSnapshots(entries, got)))
+
+	// a subtree includes its directory and everything under it
+	var sub *Directory
+	for _, d := range drive.GetDirs() {
+		if d.Parent != nil && !d.Parent.IsRoot() {
+			sub = d
+		}
+	}
+	assert.Equal(t, len(sub.Files)+1, len(EntriesUnder(got, "tmp/tmp")))
+	assert.Equal(t, len(got), len(EntriesUnder(got, "")))
+	assert.Equal(t, 0, len(EntriesUnder(got, "tmp/tm")))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	env.SetEnv(false)
+
+	drive := MakeTmpDrive(t)
+	before, err := NewSnapshot(drive, "", SnapshotEntries(drive.Root))
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	from, err := before.Entries()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	var (
+		tmp     = drive.GetDir(drive.Root.Dirs[firstKey(drive.Root.Dirs)].ID)
+		sub     = tmp.Dirs[firstKey(tmp.Dirs)]
+		changed = drive.Root.Files[firstKey(drive.Root.Files)]
+		removed = sub.Files[firstKey(sub.Files)]
+	)
+	changed.CheckSum = "sha256:" + strings.Repeat("0", 64)
+	assert.NoError(t, sub.DetachFile(removed.ID))
+	added, err := MakeTmpTxtFile(filepath.Join(GetTestingDir(), "tmp", "new.txt"), 1)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	assert.NoError(t, drive.Root.AddFile(added))
+	sub.Name = "renamed"
+
+	changes := DiffSnapshots(from, SnapshotEntries(drive.Root))
+	kinds := make(map[string]*SnapshotChange)
+	for _, c := range changes {
+		kinds[c.ID] = c
+	}
+	// files in the renamed directory aren't changes themselves
+	assert.Equal(t, 4, len(changes))
+	assert.Equal(t, SnapshotChanged, kinds[changed.ID].Change)
+	assert.Equal(t, "", kinds[changed.ID].OldPath)
+	assert.Equal(t, SnapshotRemoved, kinds[removed.ID].Change)
+	assert.Equal(t, "tmp/tmp/"+removed.Name, kinds[removed.ID].Path)
+	assert.Equal(t, SnapshotAdded, kinds[added.ID].Change)
+	assert.Equal(t, SnapshotChanged, kinds[sub.ID].Change)
+	assert.Equal(t, "tmp/renamed", kinds[sub.ID].Path)
+	assert.Equal(t, "tmp/tmp", kinds[sub.ID].OldPath)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func firstKey[T any](m map[string]T) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
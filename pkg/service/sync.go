@@ -2,10 +2,25 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"time"
 )
 
+// sync index wire formats.
+//
+// clients request a format when fetching a sync index from the server so
+// older clients can still be served an index they know how to read.
+const (
+	SyncIndexV1 int = 1 // last sync times only
+	SyncIndexV2 int = 2 // adds checksums and per-device version vectors
+	SyncIndexV3 int = 3 // adds deletion tombstones
+	SyncIndexV4 int = 4 // adds directories
+
+	// format used by this version of sfs
+	SyncIndexFormat int = SyncIndexV4
+)
+
 /*
 A SyncIndex is a data structure usued to keep track of a user's
 files and directories within their SFS file system, and coordinate which files
@@ -13,6 +28,9 @@ should be updated, removed, or added between the client and server (or between h
 if this is the service mode that's active)
 */
 type SyncIndex struct {
+	// wire format of this index. see SyncIndexFormat
+	Format int `json:"format"`
+
 	// userID of of the user this sync index belongs to
 	UserID string `json:"user"`
 
@@ -25,24 +43,55 @@ type SyncIndex struct {
 	// key = file or directory UUID, value = last modified date
 	LastSync map[string]time.Time `json:"last_sync"`
 
+	// checksum of each file at the time the index was built.
+	// used to detect whether a file changed on both sides since the last sync.
+	//
+	// key = file UUID, value = file checksum
+	CheckSums map[string]string `json:"checksums"`
+
+	// version vector of each file at the time the index was built.
+	// used to decide whether one version of a file causally follows another,
+	// rather than relying on last sync times from clocks that may have drifted.
+	//
+	// key = file UUID, value = file version vector
+	Versions map[string]VersionVector `json:"versions"`
+
+	// files and directories that have been deleted. used to apply
+	// deletions on other devices rather than bringing the items back.
+	//
+	// key = file or dir UUID, value = tombstone
+	Tombstones map[string]*Tombstone `json:"tombstones,omitempty"`
+
 	// map of files to be queued for uploading or downloading.
 	// key = file UUID, value = file pointer
 	FilesToUpdate map[string]*File `json:"files_to_update"`
 
-	// NOTE: no longer used since directories aren't being monitored.
-	// map of directories to be queued for uploading or downloading
+	// metadata for every (non-root) directory at the time the index was built.
+	// used to create, rename, and move directories on the other side.
+	// parent ids are left empty for directories directly under the root, since
+	// the client and the server each have their own root directory.
+	//
+	// key = dir UUID, value = shallow copy of the directory
+	Dirs map[string]*Directory `json:"dirs,omitempty"`
+
+	// map of directories to be created, renamed, or moved
 	// key = dir UUID, value = dir pointer
-	// DirsToUpdate map[string]*Directory `json:"dirs_to_update"`
+	DirsToUpdate map[string]*Directory `json:"dirs_to_update,omitempty"`
 }
 
 // create a new sync-index object
 func NewSyncIndex(userID string) *SyncIndex {
 	return &SyncIndex{
+		Format:        SyncIndexFormat,
 		UserID:        userID,
 		Sync:          false,
 		LastSync:      make(map[string]time.Time, 0),
+		CheckSums:     make(map[string]string, 0),
+		Versions:      make(map[string]VersionVector, 0),
+		Tombstones:    make(map[string]*Tombstone, 0),
 		FilesToUpdate: make(map[string]*File, 0),
-		// DirsToUpdate:  make(map[string]*Directory, 0),
+		Dirs:          make(map[string]*Directory, 0),
+		DirsToUpdate:  make(map[string]*Directory, 0),
 	}
 }
 
@@ -50,10 +99,14 @@ func NewSyncIndex(userID string) *SyncIndex {
 func (s *SyncIndex) Reset() {
 	s.LastSync = nil
 	s.FilesToUpdate = nil
-	// s.DirsToUpdate = nil
+	s.DirsToUpdate = nil
 	s.LastSync = make(map[string]time.Time, 0)
+	s.CheckSums = make(map[string]string, 0)
+	s.Versions = make(map[string]VersionVector, 0)
+	s.Tombstones = make(map[string]*Tombstone, 0)
 	s.FilesToUpdate = make(map[string]*File, 0)
-	// s.DirsToUpdate = make(map[string]*Directory, 0)
+	s.Dirs = make(map[string]*Directory, 0)
+	s.DirsToUpdate = make(map[string]*Directory, 0)
 }
 
 // converts to json format for transfer
@@ -65,6 +118,82 @@ func (s *SyncIndex) ToJSON() ([]byte, error) {
 	return data, nil
 }
 
+// the original sync index format. kept so older clients can still be served.
+type syncIndexV1 struct {
+	UserID        string               `json:"user"`
+	Sync          bool                 `json:"sync"`
+	LastSync      map[string]time.Time `json:"last_sync"`
+	FilesToUpdate map[string]*File     `json:"files_to_update"`
+}
+
+// encode the index using the given wire format
+func (s *SyncIndex) Encode(format int) ([]byte, error) {
+	switch format {
+	case SyncIndexV1:
+		data, err := json.MarshalIndent(&syncIndexV1{
+			UserID:        s.UserID,
+			Sync:          s.Sync,
+			LastSync:      s.LastSync,
+			FilesToUpdate: s.FilesToUpdate,
+		}, "", " ")
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	case SyncIndexV2, SyncIndexV3:
+		old := *s
+		old.Format = format
+		old.Dirs = nil
+		old.DirsToUpdate = nil
+		if format == SyncIndexV2 {
+			old.Tombstones = nil
+		}
+		return old.ToJSON()
+	case SyncIndexV4:
+		s.Format = SyncIndexV4
+		return s.ToJSON()
+	default:
+		return nil, fmt.Errorf("unsupported sync index format: %d", format)
+	}
+}
+
+// decode a sync index sent in any supported wire format.
+// indexes without a format are treated as SyncIndexV1.
+func UnmarshalSyncIndex(data []byte) (*SyncIndex, error) {
+	idx := new(SyncIndex)
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, err
+	}
+	if idx.Format == 0 {
+		idx.Format = SyncIndexV1
+	}
+	if idx.Format > SyncIndexFormat {
+		return nil, fmt.Errorf("unsupported sync index format: %d", idx.Format)
+	}
+	if idx.LastSync == nil {
+		idx.LastSync = make(map[string]time.Time, 0)
+	}
+	if idx.CheckSums == nil {
+		idx.CheckSums = make(map[string]string, 0)
+	}
+	if idx.Versions == nil {
+		idx.Versions = make(map[string]VersionVector, 0)
+	}
+	if idx.Tombstones == nil {
+		idx.Tombstones = make(map[string]*Tombstone, 0)
+	}
+	if idx.FilesToUpdate == nil {
+		idx.FilesToUpdate = make(map[string]*File, 0)
+	}
+	if idx.Dirs == nil {
+		idx.Dirs = make(map[string]*Directory, 0)
+	}
+	if idx.DirsToUpdate == nil {
+		idx.DirsToUpdate = make(map[string]*Directory, 0)
+	}
+	return idx, nil
+}
+
 // checks last sync for file or directory.
 // won't be in toupdate if it's not in lastsync first.
 func (s *SyncIndex) HasItem(itemId string) bool {
@@ -74,6 +203,98 @@ func (s *SyncIndex) HasItem(itemId string) bool {
 	return false
 }
 
+// record a file's current checksum
+func (s *SyncIndex) setCheckSum(file *File) {
+	if s.CheckSums == nil {
+		s.CheckSums = make(map[string]string, 0)
+	}
+	s.CheckSums[file.ID] = file.CheckSum
+}
+
+// record a directory's current metadata
+func (s *SyncIndex) setDir(dir *Directory) {
+	if s.Dirs == nil {
+		s.Dirs = make(map[string]*Directory, 0)
+	}
+	d := copyDir(dir)
+	if dir.Parent != nil && dir.Parent.IsRoot() {
+		d.ParentID = ""
+	}
+	s.Dirs[dir.ID] = d
+}
+
+// whether this item is a directory
+func (s *SyncIndex) IsDir(itemID string) bool {
+	_, isDir := s.Dirs[itemID]
+	return isDir
+}
+
+// whether a directory was renamed or moved between two
+// versions of its metadata.
+func DirChanged(a *Directory, b *Directory) bool {
+	return a.Name != b.Name || a.ParentID != b.ParentID
+}
+
+// record a file's current version vector
+func (s *SyncIndex) setVersion(file *File) {
+	if s.Versions == nil {
+		s.Versions = make(map[string]VersionVector, 0)
+	}
+	if len(file.Version) > 0 {
+		s.Versions[file.ID] = file.Version.Copy()
+	}
+}
+
+// compare an item's version against what's recorded in this index.
+// uses version vectors when both sides have one, otherwise falls back
+// to comparing last sync times.
+func (s *SyncIndex) Order(itemID string, version VersionVector, lastSync time.Time) Ordering {
+	if rec, ok := s.Versions[itemID]; ok && len(rec) > 0 && len(version) > 0 {
+		return version.Compare(rec)
+	}
+	recSync := s.LastSync[itemID]
+	switch {
+	case lastSync.After(recSync):
+		return After
+	case lastSync.Before(recSync):
+		return Before
+	default:
+		return Equal
+	}
+}
+
+// add tombstones for deleted items to the index
+func (s *SyncIndex) AddTombstones(tombstones []*Tombstone) {
+	if s.Tombstones == nil {
+		s.Tombstones = make(map[string]*Tombstone, len(tombstones))
+	}
+	for _, t := range tombstones {
+		s.Tombstones[t.ID] = t
+	}
+}
+
+// remove an item from the index so it won't be synced.
+// tombstones are left alone.
+func (s *SyncIndex) Drop(itemID string) {
+	delete(s.LastSync, itemID)
+	delete(s.CheckSums, itemID)
+	delete(s.Versions, itemID)
+	delete(s.FilesToUpdate, itemID)
+	delete(s.Dirs, itemID)
+	delete(s.DirsToUpdate, itemID)
+}
+
+// whether an item has been deleted
+func (s *SyncIndex) IsDeleted(itemID string) bool {
+	_, deleted := s.Tombstones[itemID]
+	return deleted
+}
+
+// whether the given version of an item is newer than what's recorded in this index
+func (s *SyncIndex) IsNewer(itemID string, version VersionVector, lastSync time.Time) bool {
+	return s.Order(itemID, version, lastSync) == After
+}
+
 // make a json-formatted string representation of the sync-index object
 func (s *SyncIndex) ToString() string {
 	data, err := s.ToJSON()
@@ -129,45 +350,61 @@ func BuildToUpdate(root *Directory, idx *SyncIndex) *SyncIndex {
 
 /*
 compares a given syncindex against a newly generated one and returns the differnece
-between the two, favoring the newer one for any last sync times.
+between the two, favoring the newer one.
+
+items are compared with their version vectors when both indexes have them,
+otherwise by their last sync times. items that were changed independently in
+both indexes (concurrent versions) are neither newer nor older, and are left out.
 
 the map this returns will only contain the itemps that were matched and found to have a
-more recent time -- items that weren't matched will be ignored.
+more recent version -- items that weren't matched will be ignored.
 */
 func Compare(orig *SyncIndex, new *SyncIndex) *SyncIndex {
 	// index containing most recent items
 	diff := NewSyncIndex(orig.UserID)
 
-	// compare last sync times
+	// compare versions
 	for itemId, lastSync := range new.LastSync {
-		if origTime, exists := orig.LastSync[itemId]; exists {
-			if lastSync.After(origTime) {
+		if _, exists := orig.LastSync[itemId]; exists {
+			if orig.IsNewer(itemId, new.Versions[itemId], lastSync) {
 				diff.LastSync[itemId] = lastSync
+				if v, ok := new.Versions[itemId]; ok {
+					diff.Versions[itemId] = v.Copy()
+				}
 			}
 		}
 	}
 	// compare files marked for updating
 	for fileID, newFile := range new.FilesToUpdate {
 		if origFile, exists := orig.FilesToUpdate[fileID]; exists {
-			if newFile.LastSync.After(origFile.LastSync) {
+			if newerFile(newFile, origFile) {
 				diff.FilesToUpdate[fileID] = newFile
 			}
 		}
 	}
 	// compare directories marked for updating
-	// for dirID, newDir := range new.DirsToUpdate {
-	// 	if origDir, exists := orig.DirsToUpdate[dirID]; exists {
-	// 		if newDir.LastSync.After(origDir.LastSync) {
-	// 			diff.DirsToUpdate[dirID] = newDir
-	// 		}
-	// 	}
-	// }
+	for dirID, newDir := range new.DirsToUpdate {
+		if origDir, exists := orig.DirsToUpdate[dirID]; exists {
+			if newDir.LastSync.After(origDir.LastSync) {
+				diff.DirsToUpdate[dirID] = newDir
+			}
+		}
+	}
 	return diff
 }
 
+// whether a is a newer version of b. uses version vectors if
+// both files have them, otherwise compares last sync times.
+func newerFile(a *File, b *File) bool {
+	if len(a.Version) > 0 && len(b.Version) > 0 {
+		return a.Version.Compare(b.Version) == After
+	}
+	return a.LastSync.After(b.LastSync)
+}
+
 /*
-NOTE: the slice of directories argument is for future implementations.
-probably won't be used during this first iteration. dirs can be set to nil for the time being.
+add (or update) the given files and directories in a sync index.
+dirs can be nil if only files are being indexed.
 
 assumes the supplied index's LastSync map is instantiated and populated, otherwise
 will fail.
@@ -176,22 +413,24 @@ func BuildDistSyncIndex(files []*File, dirs []*Directory, idx *SyncIndex) *SyncI
 	for _, f := range files {
 		if !idx.HasItem(f.ID) {
 			idx.LastSync[f.ID] = f.LastSync
+			idx.setVersion(f)
 		} else {
-			if f.LastSync.After(idx.LastSync[f.ID]) {
+			if idx.IsNewer(f.ID, f.Version, f.LastSync) {
 				idx.LastSync[f.ID] = f.LastSync
+				idx.setVersion(f)
 			}
 		}
+		idx.setCheckSum(f)
+	}
+	for _, dir := range dirs {
+		if dir.IsRoot() {
+			continue
+		}
+		if !idx.HasItem(dir.ID) || dir.LastSync.After(idx.LastSync[dir.ID]) {
+			idx.LastSync[dir.ID] = dir.LastSync
+			idx.setDir(dir)
+		}
 	}
-	// NOTE: for future implementation iterations
-	// for _, dir := range dirs {
-	// 	if !idx.HasItem(dir.ID) {
-	// 		idx.LastSync[dir.ID] = dir.LastSync
-	// 	} else {
-	// 		if dir.LastSync.After(idx.LastSync[dir.ID]) {
-	// 			idx.LastSync[dir.ID] = dir.LastSync
-	// 		}
-	// 	}
-	// }
 	return idx
 }
 
@@ -203,21 +442,22 @@ will fail or give inaccurate results.
 func BuildToUpdateDist(files []*File, dirs []*Directory, idx *SyncIndex) *SyncIndex {
 	for _, f := range files {
 		if idx.HasItem(f.ID) {
-			if f.LastSync.After(idx.LastSync[f.ID]) {
+			if idx.IsNewer(f.ID, f.Version, f.LastSync) {
 				idx.FilesToUpdate[f.ID] = f
 			}
 		}
 	}
-	// NOTE: for future implementation iterations
-	// for _, d := range dirs {
-	// 	if !idx.HasItem(d.ID) {
-	// 		continue // ignore unknown directories
-	// 	} else {
-	// 		if d.LastSync.After(idx.LastSync[d.ID]) {
-	// 			idx.DirsToUpdate[d.ID] = d
-	// 		}
-	// 	}
-	// }
+	for _, d := range dirs {
+		if !idx.HasItem(d.ID) {
+			continue // ignore unknown directories
+		}
+		if d.LastSync.After(idx.LastSync[d.ID]) {
+			if idx.DirsToUpdate == nil {
+				idx.DirsToUpdate = make(map[string]*Directory, 0)
+			}
+			idx.DirsToUpdate[d.ID] = d
+		}
+	}
 	return idx
 }
 
@@ -321,3 +561,27 @@ func LargeFileQ(files []*File) *Queue {
 	q.Enqueue(b)
 	return q
 }
+
+// build a queue from a list of files, smallest first. files that
+// exceed MAX are put in their own batch at the end of the queue,
+// and files that appear more than once are only queued once.
+func BuildFileQ(files []*File) *Queue {
+	seen := make(map[string]bool, len(files))
+	unique := make([]*File, 0, len(files))
+	for _, f := range files {
+		if !seen[f.ID] {
+			seen[f.ID] = true
+			unique = append(unique, f)
+		}
+	}
+	q := NewQ()
+	if small := Prune(unique); len(small) > 0 {
+		q = buildQ(small, NewBatch(), q)
+	}
+	if large := GetLargeFiles(unique); len(large) > 0 {
+		b := NewBatch()
+		b.AddLgFiles(large)
+		q.Enqueue(b)
+	}
+	return q
+}
@@ -18,7 +18,7 @@ func TestToUpdate(t *testing.T) {
 	idx := d.WalkS(NewSyncIndex("me"))
 	assert.NotEqual(t, nil, idx)
 	assert.NotEqual(t, 0, len(idx.LastSync))
-	assert.Equal(t, 20, len(idx.LastSync))
+	assert.Equal(t, 20+len(idx.Dirs), len(idx.LastSync)) // test files plus their directories
 
 	// randomly update some of the files with additional content, causing their
 	// last sync times to be updated
@@ -73,3 +73,117 @@ func TestCompare(t *testing.T) {
 	// compare
 	assert.NotEqual(t, 0, len(diffs.LastSync))
 }
+
+func TestCompareVersions(t *testing.T) {
+	env.SetEnv(false)
+
+	tmpDrv := MakeTmpDrive(t)
+	files := tmpDrv.Root.GetFiles()
+	changed := files[0]
+	changed.IncrementVersion("laptop")
+	origIdx := BuildRootSyncIndex(tmpDrv.Root)
+
+	// record another change on one file from this device
+	changed.IncrementVersion("laptop")
+	newIdx := BuildRootSyncIndex(tmpDrv.Root)
+
+	// last sync times are left alone, so only the version vector
+	// should mark this file as newer
+	diff := Compare(origIdx, newIdx)
+	assert.Equal(t, 1, len(diff.LastSync))
+	assert.True(t, diff.Versions[changed.ID] != nil)
+
+	// a concurrent change on another device is neither newer nor older
+	other := newIdx.Versions[changed.ID].Copy()
+	other.Increment("desktop")
+	changed.IncrementVersion("laptop")
+	assert.Equal(t, Concurrent, BuildRootSyncIndex(tmpDrv.Root).Order(changed.ID, other, changed.LastSync))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncIndexFormats(t *testing.T) {
+	env.SetEnv(false)
+
+	tmpDrv := MakeTmpDrive(t)
+	files := tmpDrv.Root.GetFiles()
+	files[0].IncrementVersion("laptop")
+	idx := BuildRootSyncIndex(tmpDrv.Root)
+
+	// older clients get the original format without version vectors
+	data, err := idx.Encode(SyncIndexV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := UnmarshalSyncIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SyncIndexV1, v1.Format)
+	assert.Equal(t, len(idx.LastSync), len(v1.LastSync))
+	assert.Equal(t, 0, len(v1.Versions))
+
+	data, err = idx.Encode(SyncIndexV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := UnmarshalSyncIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SyncIndexV2, v2.Format)
+	assert.Equal(t, idx.Versions[files[0].ID], v2.Versions[files[0].ID])
+
+	// tombstones are only sent to clients that understand them
+	idx.AddTombstones([]*Tombstone{NewTombstone(files[1].ID, false, "laptop")})
+	data, err = idx.Encode(SyncIndexV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err = UnmarshalSyncIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, v2.IsDeleted(files[1].ID))
+	assert.True(t, idx.IsDeleted(files[1].ID))
+
+	data, err = idx.Encode(SyncIndexV3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v3, err := UnmarshalSyncIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SyncIndexV3, v3.Format)
+	assert.True(t, v3.IsDeleted(files[1].ID))
+	assert.Equal(t, "laptop", v3.Tombstones[files[1].ID].DeviceID)
+
+	// directories are only sent to clients that understand them
+	assert.Equal(t, 0, len(v3.Dirs))
+
+	dirs := tmpDrv.GetDirs()
+	idx = BuildRootSyncIndex(tmpDrv.Root)
+	data, err = idx.Encode(SyncIndexV4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4, err := UnmarshalSyncIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, SyncIndexV4, v4.Format)
+	assert.Equal(t, len(dirs), len(v4.Dirs))
+	for _, dir := range dirs {
+		assert.True(t, v4.IsDir(dir.ID))
+	}
+
+	_, err = idx.Encode(SyncIndexFormat + 1)
+	assert.Error(t, err)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
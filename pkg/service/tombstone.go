@@ -0,0 +1,63 @@
+package service
+
+import (
+	"time"
+)
+
+/*
+deletion tombstones.
+
+when a file or directory is deleted its database entry is kept and marked
+with the device it was deleted on and when. tombstones are sent along with
+sync indexes so the deletion can be applied on every other device instead of
+the item being pushed (or pulled) back. once every device has seen a tombstone
+it can be removed for good.
+*/
+type Tombstone struct {
+	ID        string    `json:"id"`         // id of the deleted file or directory
+	IsDir     bool      `json:"is_dir"`     // whether this is a directory
+	DeviceID  string    `json:"device_id"`  // device the item was deleted on
+	DeletedAt time.Time `json:"deleted_at"` // when the item was deleted
+}
+
+func NewTombstone(itemID string, isDir bool, deviceID string) *Tombstone {
+	return &Tombstone{
+		ID:        itemID,
+		IsDir:     isDir,
+		DeviceID:  deviceID,
+		DeletedAt: time.Now().UTC(),
+	}
+}
+
+// whether every device has synced since this item was deleted.
+// seen is the last time each device synced with the server.
+// tombstones are never collected if there aren't any devices to see them.
+func (t *Tombstone) Seen(seen map[string]time.Time) bool {
+	if len(seen) == 0 {
+		return false
+	}
+	for _, lastSync := range seen {
+		if !lastSync.After(t.DeletedAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// mark this file as deleted on the given device
+func (f *File) MarkDeleted(deviceID string) {
+	f.DeletedBy = deviceID
+	f.DeletedAt = time.Now().UTC()
+}
+
+// whether this file has been deleted
+func (f *File) IsDeleted() bool { return f.DeletedBy != "" }
+
+// mark this directory as deleted on the given device
+func (d *Directory) MarkDeleted(deviceID string) {
+	d.DeletedBy = deviceID
+	d.DeletedAt = time.Now().UTC()
+}
+
+// whether this directory has been deleted
+func (d *Directory) IsDeleted() bool { return d.DeletedBy != "" }
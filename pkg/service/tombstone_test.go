@@ -0,0 +1,44 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestTombstoneSeen(t *testing.T) {
+	env.SetEnv(false)
+
+	ts := NewTombstone("file-id", false, "laptop")
+
+	// nobody to see it
+	assert.False(t, ts.Seen(nil))
+
+	seen := map[string]time.Time{
+		"laptop":  ts.DeletedAt.Add(time.Second),
+		"desktop": ts.DeletedAt.Add(-time.Second),
+	}
+	assert.False(t, ts.Seen(seen))
+
+	seen["desktop"] = ts.DeletedAt.Add(time.Minute)
+	assert.True(t, ts.Seen(seen))
+}
+
+func TestMarkDeleted(t *testing.T) {
+	env.SetEnv(false)
+
+	file := &File{ID: "file-id"}
+	assert.False(t, file.IsDeleted())
+	file.MarkDeleted("laptop")
+	assert.True(t, file.IsDeleted())
+	assert.Equal(t, "laptop", file.DeletedBy)
+	assert.False(t, file.DeletedAt.IsZero())
+
+	dir := &Directory{ID: "dir-id"}
+	assert.False(t, dir.IsDeleted())
+	dir.MarkDeleted("laptop")
+	assert.True(t, dir.IsDeleted())
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+the transfer queue.
+
+files waiting to be uploaded or downloaded during a sync are kept in the
+client's database along with how far each one got, so a sync that's
+interrupted (the client is stopped, the laptop goes to sleep, etc.) can be
+picked up where it left off instead of starting over. anything that was
+in flight when the client stopped is tried again when it starts back up.
+*/
+
+// directions a file can be transferred in
+const (
+	Upload   string = "upload"
+	Download string = "download"
+)
+
+// states of a queued transfer
+const (
+	TransferPending  string = "pending"   // waiting to be sent
+	TransferInFlight string = "in-flight" // being sent
+	TransferDone     string = "done"      // sent successfully
+	TransferFailed   string = "failed"    // failed MaxTransferAttempts times
+)
+
+// number of times a transfer is tried before it's marked as failed
+const MaxTransferAttempts = 5
+
+// a file waiting to be uploaded or downloaded
+type TransferItem struct {
+	ID        string    `json:"id"`         // transfer id
+	FileID    string    `json:"file_id"`    // id of the file being transferred
+	DriveID   string    `json:"drive_id"`   // drive the file belongs to
+	Name      string    `json:"name"`       // name of the file
+	Direction string    `json:"direction"`  // upload or download
+	State     string    `json:"state"`      // see TransferPending, etc.
+	Attempts  int       `json:"attempts"`   // number of times this has been tried
+	LastError string    `json:"last_error"` // why the last attempt failed, if it did
+	Size      int64     `json:"size"`       // size of the file in bytes when it was queued
+	CreatedAt time.Time `json:"created_at"` // when this was queued
+	UpdatedAt time.Time `json:"updated_at"` // when this last changed state
+
+	// the file's metadata when it was queued
+	File *File `json:"file"`
+}
+
+// queue a file to be uploaded or downloaded
+func NewTransferItem(file *File, direction string) *TransferItem {
+	now := time.Now().UTC()
+	return &TransferItem{
+		ID:        auth.NewUUID(),
+		FileID:    file.ID,
+		DriveID:   file.DriveID,
+		Name:      file.Name,
+		Direction: direction,
+		State:     TransferPending,
+		Size:      file.Size,
+		CreatedAt: now,
+		UpdatedAt: now,
+		File:      file,
+	}
+}
+
+// mark a transfer as in flight
+func (t *TransferItem) Start() {
+	t.State = TransferInFlight
+	t.Attempts++
+	t.UpdatedAt = time.Now().UTC()
+}
+
+// mark a transfer as done
+func (t *TransferItem) Done() {
+	t.State = TransferDone
+	t.LastError = ""
+	t.UpdatedAt = time.Now().UTC()
+}
+
+// record a failed attempt. the transfer goes back to pending
+// unless it's been tried MaxTransferAttempts times.
+func (t *TransferItem) Fail(err error) {
+	t.State = TransferPending
+	if t.Attempts >= MaxTransferAttempts {
+		t.State = TransferFailed
+	}
+	t.LastError = err.Error()
+	t.UpdatedAt = time.Now().UTC()
+}
+
+// put a transfer back in the queue to be tried again from scratch
+func (t *TransferItem) Retry() {
+	t.State = TransferPending
+	t.Attempts = 0
+	t.UpdatedAt = time.Now().UTC()
+}
+
+// whether there's anything left to do for a transfer
+func (t *TransferItem) Finished() bool {
+	return t.State == TransferDone || t.State == TransferFailed
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestTransferItemAttempts(t *testing.T) {
+	env.SetEnv(false)
+
+	file, err := MakeTmpTxtFile(filepath.Join(GetTestingDir(), "tmp.txt"), 1)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	item := NewTransferItem(file, Upload)
+	assert.Equal(t, TransferPending, item.State)
+
+	// failed attempts go back in the queue until there's been too many
+	for i := 1; i < MaxTransferAttempts; i++ {
+		item.Start()
+		assert.Equal(t, TransferInFlight, item.State)
+		item.Fail(fmt.Errorf("attempt %d failed", i))
+		assert.Equal(t, TransferPending, item.State)
+		assert.False(t, item.Finished())
+	}
+	item.Start()
+	item.Fail(fmt.Errorf("last attempt failed"))
+	assert.Equal(t, TransferFailed, item.State)
+	assert.Equal(t, MaxTransferAttempts, item.Attempts)
+	assert.True(t, item.Finished())
+
+	item.Retry()
+	assert.Equal(t, TransferPending, item.State)
+	assert.Equal(t, 0, item.Attempts)
+
+	item.Start()
+	item.Done()
+	assert.Equal(t, TransferDone, item.State)
+	assert.Equal(t, "", item.LastError)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
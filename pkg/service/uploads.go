@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+upload sessions.
+
+large files are uploaded in parts instead of all at once. the client opens
+a session with the size and checksum of what it's about to send, sends the
+parts (in any order, and some at the same time) by their offset, then
+commits the session. the server checks the checksum of everything it
+received before the file's contents are replaced.
+
+if an upload is interrupted, the client asks which parts of the session the
+server already has and only sends the rest. sessions that aren't touched
+for UploadSessionTTL are removed.
+*/
+
+// how long an upload session is kept after it was last touched
+const UploadSessionTTL = time.Hour * 24
+
+// a range of bytes, from Start up to but not including End
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+func (r ByteRange) Len() int64 { return r.End - r.Start }
+
+// an upload of a file's contents in parts
+type UploadSession struct {
+	ID        string      `json:"id"`         // session id
+	FileID    string      `json:"file_id"`    // file being uploaded
+	DriveID   string      `json:"drive_id"`   // drive the file belongs to
+	Size      int64       `json:"size"`       // size of the whole upload, in bytes
+	CheckSum  string      `json:"checksum"`   // checksum of the whole upload
+	Received  []ByteRange `json:"received"`   // parts received so far. sorted and merged.
+	CreatedAt time.Time   `json:"created_at"` // when the session was opened
+	ExpiresAt time.Time   `json:"expires_at"` // when the session will be removed if it isn't touched again
+}
+
+// open a new upload session for size bytes of a file
+func NewUploadSession(file *File, size int64, checksum string) *UploadSession {
+	now := time.Now().UTC()
+	return &UploadSession{
+		ID:        auth.NewUUID(),
+		FileID:    file.ID,
+		DriveID:   file.DriveID,
+		Size:      size,
+		CheckSum:  checksum,
+		Received:  make([]ByteRange, 0),
+		CreatedAt: now,
+		ExpiresAt: now.Add(UploadSessionTTL),
+	}
+}
+
+// record that a part was received
+func (u *UploadSession) Add(r ByteRange) {
+	if r.Len() <= 0 {
+		return
+	}
+	ranges := append(u.Received, r)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := make([]ByteRange, 0, len(ranges))
+	for _, cur := range ranges {
+		if n := len(merged); n > 0 && cur.Start <= merged[n-1].End {
+			if cur.End > merged[n-1].End {
+				merged[n-1].End = cur.End
+			}
+			continue
+		}
+		merged = append(merged, cur)
+	}
+	u.Received = merged
+}
+
+// number of bytes received from the start of the upload without any
+// gaps. an interrupted upload can always be picked up from here.
+func (u *UploadSession) Acknowledged() int64 {
+	if len(u.Received) == 0 || u.Received[0].Start > 0 {
+		return 0
+	}
+	return u.Received[0].End
+}
+
+// parts of the upload that haven't been received yet
+func (u *UploadSession) Missing() []ByteRange {
+	missing := make([]ByteRange, 0)
+	var next int64
+	for _, r := range u.Received {
+		if r.Start > next {
+			missing = append(missing, ByteRange{Start: next, End: r.Start})
+		}
+		next = r.End
+	}
+	if next < u.Size {
+		missing = append(missing, ByteRange{Start: next, End: u.Size})
+	}
+	return missing
+}
+
+// whether every part of the upload has been received
+func (u *UploadSession) Complete() bool {
+	return len(u.Missing()) == 0
+}
+
+// push back when the session expires
+func (u *UploadSession) Touch() {
+	u.ExpiresAt = time.Now().UTC().Add(UploadSessionTTL)
+}
+
+// whether the session should be removed
+func (u *UploadSession) Expired() bool {
+	return time.Now().UTC().After(u.ExpiresAt)
+}
+
+// the received parts as a json string
+func (u *UploadSession) ReceivedStr() (string, error) {
+	data, err := json.Marshal(u.Received)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// set the received parts from a json string made with ReceivedStr
+func (u *UploadSession) SetReceived(received string) error {
+	ranges := make([]ByteRange, 0)
+	if err := json.Unmarshal([]byte(received), &ranges); err != nil {
+		return err
+	}
+	u.Received = ranges
+	return nil
+}
+
+func (u *UploadSession) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(u, "", "  ")
+}
+
+func UnmarshalUploadSession(data []byte) (*UploadSession, error) {
+	u := new(UploadSession)
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestUploadSessionRanges(t *testing.T) {
+	env.SetEnv(false)
+
+	u := NewUploadSession(&File{ID: "file-id", DriveID: "drive-id"}, 100, "sha256:00")
+	assert.Equal(t, []ByteRange{{0, 100}}, u.Missing())
+	assert.False(t, u.Complete())
+
+	// parts can arrive in any order
+	u.Add(ByteRange{Start: 50, End: 75})
+	assert.Equal(t, int64(0), u.Acknowledged())
+	u.Add(ByteRange{Start: 0, End: 25})
+	assert.Equal(t, int64(25), u.Acknowledged())
+	assert.Equal(t, []ByteRange{{25, 50}, {75, 100}}, u.Missing())
+
+	// overlapping and adjacent parts are merged
+	u.Add(ByteRange{Start: 20, End: 50})
+	assert.Equal(t, []ByteRange{{0, 75}}, u.Received)
+	assert.Equal(t, int64(75), u.Acknowledged())
+
+	u.Add(ByteRange{Start: 75, End: 100})
+	assert.True(t, u.Complete())
+	assert.Equal(t, int64(100), u.Acknowledged())
+	assert.False(t, u.Expired())
+
+	data, err := u.ToJSON()
+	assert.NoError(t, err)
+	got, err := UnmarshalUploadSession(data)
+	assert.NoError(t, err)
+	assert.Equal(t, u.Received, got.Received)
+}
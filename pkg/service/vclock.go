@@ -0,0 +1,140 @@
+package service
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+/*
+per-device version vectors.
+
+each file carries a counter for every device that has modified it. a device
+increments its own counter whenever it makes a local change, and the server
+merges in the client's vector whenever it accepts an update. comparing two
+vectors tells us whether one version causally follows the other, or whether
+both were modified independently -- without relying on anyone's clock.
+*/
+
+// key = device ID, value = number of changes made on that device
+type VersionVector map[string]uint64
+
+// the causal relationship between two versions of an item
+type Ordering int
+
+const (
+	Equal      Ordering = iota // both versions are the same
+	Before                     // this version is older than the other
+	After                      // this version is newer than the other
+	Concurrent                 // both versions were changed independently
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	case Concurrent:
+		return "concurrent"
+	default:
+		return "unknown"
+	}
+}
+
+func NewVersionVector() VersionVector {
+	return make(VersionVector)
+}
+
+// record a change made on the given device
+func (v VersionVector) Increment(deviceID string) {
+	v[deviceID]++
+}
+
+// compare this version against another
+func (v VersionVector) Compare(other VersionVector) Ordering {
+	var newer, older bool
+	for dev, n := range v {
+		if m := other[dev]; n > m {
+			newer = true
+		} else if n < m {
+			older = true
+		}
+	}
+	for dev, m := range other {
+		if _, ok := v[dev]; !ok && m > 0 {
+			older = true
+		}
+	}
+	switch {
+	case newer && older:
+		return Concurrent
+	case newer:
+		return After
+	case older:
+		return Before
+	default:
+		return Equal
+	}
+}
+
+// whether this version includes all the changes in other
+func (v VersionVector) Dominates(other VersionVector) bool {
+	o := v.Compare(other)
+	return o == After || o == Equal
+}
+
+// take the highest counter for each device from both vectors.
+// the result is newer than or equal to both.
+func (v VersionVector) Merge(other VersionVector) {
+	for dev, m := range other {
+		if m > v[dev] {
+			v[dev] = m
+		}
+	}
+}
+
+func (v VersionVector) Copy() VersionVector {
+	c := make(VersionVector, len(v))
+	for dev, n := range v {
+		c[dev] = n
+	}
+	return c
+}
+
+// stored as a json string in the database
+func (v VersionVector) Value() (driver.Value, error) {
+	if v == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (v *VersionVector) Scan(src interface{}) error {
+	var data []byte
+	switch s := src.(type) {
+	case nil:
+		*v = NewVersionVector()
+		return nil
+	case string:
+		data = []byte(s)
+	case []byte:
+		data = s
+	default:
+		return fmt.Errorf("unsupported version vector type: %T", src)
+	}
+	vv := NewVersionVector()
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &vv); err != nil {
+			return fmt.Errorf("failed to decode version vector: %v", err)
+		}
+	}
+	*v = vv
+	return nil
+}
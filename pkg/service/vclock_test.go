@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestVersionVectorCompare(t *testing.T) {
+	env.SetEnv(false)
+
+	a := NewVersionVector()
+	b := NewVersionVector()
+	assert.Equal(t, Equal, a.Compare(b))
+
+	a.Increment("laptop")
+	assert.Equal(t, After, a.Compare(b))
+	assert.Equal(t, Before, b.Compare(a))
+
+	b.Merge(a)
+	assert.Equal(t, Equal, a.Compare(b))
+
+	// both devices change the file without seeing each other's changes
+	a.Increment("laptop")
+	b.Increment("desktop")
+	assert.Equal(t, Concurrent, a.Compare(b))
+	assert.Equal(t, Concurrent, b.Compare(a))
+	assert.False(t, a.Dominates(b))
+
+	// merging both and making another change resolves it
+	c := a.Copy()
+	c.Merge(b)
+	c.Increment("laptop")
+	assert.Equal(t, After, c.Compare(a))
+	assert.Equal(t, After, c.Compare(b))
+	assert.True(t, c.Dominates(b))
+}
+
+func TestVersionVectorScan(t *testing.T) {
+	env.SetEnv(false)
+
+	v := NewVersionVector()
+	v.Increment("laptop")
+	v.Increment("laptop")
+	v.Increment("server")
+
+	val, err := v.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got VersionVector
+	if err := got.Scan(val); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, v, got)
+
+	var empty VersionVector
+	if err := empty.Scan(nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, len(empty))
+}
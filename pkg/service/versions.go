@@ -0,0 +1,83 @@
+package service
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+// default file version retention settings for new drives
+const (
+	DefaultMaxVersions   int           = 10
+	DefaultVersionMaxAge time.Duration = time.Hour * 24 * 30
+)
+
+/*
+a prior revision of a file.
+
+each time the server-side copy of a file is overwritten, the previous
+contents are copied to the drive's state/versions/<fileID>/ directory
+and a Version is added to the versions database so it can be restored later.
+*/
+type Version struct {
+	ID        string    `json:"id"`         // version id
+	FileID    string    `json:"file_id"`    // id of the file this is a revision of
+	DriveID   string    `json:"drive_id"`   // id of the drive the file belongs to
+	OwnerID   string    `json:"owner_id"`   // file owner
+	Rev       int       `json:"rev"`        // revision number. starts at 1 and increases with each new version
+	Name      string    `json:"name"`       // file name at the time this version was saved
+	Size      int64     `json:"size"`       // size of this revision in bytes
+	CheckSum  string    `json:"checksum"`   // checksum of this revision
+	Path      string    `json:"path"`       // location of this revision's contents
+	CreatedAt time.Time `json:"created_at"` // when this version was saved
+}
+
+// create a new version entry for the current state of a file.
+// does not copy the file's contents to path.
+func NewVersion(file *File, rev int, path string) *Version {
+	return &Version{
+		ID:        auth.NewUUID(),
+		FileID:    file.ID,
+		DriveID:   file.DriveID,
+		OwnerID:   file.OwnerID,
+		Rev:       rev,
+		Name:      file.Name,
+		Size:      file.Size,
+		CheckSum:  file.CheckSum,
+		Path:      path,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func (v *Version) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// figure out which versions should be removed according to a drive's retention settings.
+// the newest maxVersions versions are kept, and anything older than maxAge is removed.
+// either setting is ignored if it's <= 0.
+func PruneVersions(versions []*Version, maxVersions int, maxAge time.Duration) []*Version {
+	// newest first
+	sorted := make([]*Version, len(versions))
+	copy(sorted, versions)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Rev > sorted[j].Rev })
+
+	var (
+		toRemove = make([]*Version, 0)
+		now      = time.Now().UTC()
+	)
+	for i, v := range sorted {
+		if maxVersions > 0 && i >= maxVersions {
+			toRemove = append(toRemove, v)
+		} else if maxAge > 0 && now.Sub(v.CreatedAt) > maxAge {
+			toRemove = append(toRemove, v)
+		}
+	}
+	return toRemove
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/env"
+)
+
+func TestPruneVersions(t *testing.T) {
+	env.SetEnv(false)
+
+	file, err := MakeTmpTxtFile(filepath.Join(GetTestingDir(), "tmp.txt"), 10)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	versions := make([]*Version, 0)
+	for i := 1; i <= 5; i++ {
+		versions = append(versions, NewVersion(file, i, ""))
+	}
+
+	// keep the 3 newest
+	toRemove := PruneVersions(versions, 3, 0)
+	assert.Equal(t, 2, len(toRemove))
+	for _, v := range toRemove {
+		assert.True(t, v.Rev <= 2)
+	}
+
+	// anything older than an hour should be removed, regardless of count
+	versions[0].CreatedAt = time.Now().UTC().Add(-time.Hour * 2)
+	versions[1].CreatedAt = time.Now().UTC().Add(-time.Hour * 2)
+	toRemove = PruneVersions(versions, 0, time.Hour)
+	assert.Equal(t, 2, len(toRemove))
+
+	// no limits
+	toRemove = PruneVersions(versions, 0, 0)
+	assert.Equal(t, 0, len(toRemove))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// permissions for files written by the local store
+const filePerms = 0640
+
+// marks temp files used while writing objects.
+// they're skipped when listing.
+const tmpMarker = ".sfs-tmp-"
+
+// keeps objects as files in a directory on the local disk.
+// keys are paths relative to the root directory.
+type Local struct {
+	Root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{Root: filepath.Clean(root)}
+}
+
+func (l *Local) Path(key string) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(key)), nil
+}
+
+// objects are written to a temp file next to their
+// final location, then moved into place.
+func (l *Local) Put(key string, r io.Reader) error {
+	fp, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := l.tempFile(fp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), filePerms); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fp)
+}
+
+// returns an *os.File
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	fp, err := l.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, notExist(key)
+	} else if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		f.Close()
+		return nil, notExist(key)
+	}
+	return f, nil
+}
+
+func (l *Local) Stat(key string) (*Object, error) {
+	fp, err := l.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(fp)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, notExist(key)
+	} else if err != nil {
+		return nil, err
+	}
+	return &Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(key string) error {
+	fp, err := l.Path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// only the directory the prefix points into is walked
+func (l *Local) List(prefix string, fn func(*Object) error) error {
+	start := l.Root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		start = filepath.Join(l.Root, filepath.FromSlash(prefix[:i]))
+	}
+	err := filepath.WalkDir(start, func(fp string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.Contains(entry.Name(), tmpMarker) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Root, fp)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // removed while listing
+		} else if err != nil {
+			return err
+		}
+		return fn(&Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	})
+	return err
+}
+
+// copies are hard links where the file system supports them. objects are
+// never written in place, so changing one doesn't change the other.
+func (l *Local) Copy(src string, dst string) error {
+	srcPath, err := l.Path(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := l.Path(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(srcPath); errors.Is(err, os.ErrNotExist) {
+		return notExist(src)
+	}
+	if srcPath == dstPath {
+		return nil
+	}
+	tmp, err := l.tempFile(dstPath)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := os.Link(srcPath, tmp.Name()); err != nil {
+		r, err := l.Get(src)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return l.Put(dst, r)
+	}
+	return os.Rename(tmp.Name(), dstPath)
+}
+
+// create a temp file in the same directory as fp
+func (l *Local) tempFile(fp string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(fp), 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(filepath.Dir(fp), "."+filepath.Base(fp)+tmpMarker+"*")
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+// keeps objects in memory. nothing is saved anywhere,
+// so this is only really useful for tests.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]*memObject
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]*memObject)}
+}
+
+func (m *Memory) Put(key string, r io.Reader) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = &memObject{data: data, modTime: time.Now().UTC()}
+	return nil
+}
+
+// the returned reader also implements io.Seeker and io.ReaderAt
+func (m *Memory) Get(key string) (io.ReadCloser, error) {
+	obj, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return readSeekNopCloser{bytes.NewReader(obj.data)}, nil
+}
+
+func (m *Memory) Stat(key string) (*Object, error) {
+	obj, err := m.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime}, nil
+}
+
+func (m *Memory) Delete(key string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+// objects are listed in key order
+func (m *Memory) List(prefix string, fn func(*Object) error) error {
+	m.mu.RLock()
+	objs := make([]*Object, 0)
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objs = append(objs, &Object{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	for _, obj := range objs {
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// object contents are never modified, so copies can share them
+func (m *Memory) Copy(src string, dst string) error {
+	if err := ValidKey(dst); err != nil {
+		return err
+	}
+	obj, err := m.get(src)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[dst] = &memObject{data: obj.data, modTime: time.Now().UTC()}
+	return nil
+}
+
+func (m *Memory) get(key string) (*memObject, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, notExist(key)
+	}
+	return obj, nil
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
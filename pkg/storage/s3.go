@@ -0,0 +1,613 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// payload hash used for requests without a body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// payload hash used for streamed uploads, which aren't hashed before they're sent
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+const (
+	// default size of the parts large objects are uploaded and copied in.
+	// S3 needs parts (other than the last) to be at least 5 MiB.
+	DefaultS3PartSize int64 = 64 << 20
+
+	// most parts an object can be uploaded in
+	maxS3Parts = 10000
+)
+
+// keeps objects in a bucket on an S3-compatible service. requests use
+// path-style addressing (<endpoint>/<bucket>/<key>) and are signed with
+// AWS Signature Version 4, which works with AWS as well as MinIO and most
+// other S3-compatible services. the bucket must already exist.
+//
+// objects larger than PartSize are uploaded and copied in parts (multipart
+// uploads), since S3 won't take more than 5 GiB in a single request.
+type S3 struct {
+	Endpoint  string // i.e. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	PartSize  int64 // 0 uses DefaultS3PartSize
+
+	Client *http.Client
+}
+
+func NewS3(endpoint string, bucket string, region string, accessKey string, secretKey string) *S3 {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:  strings.TrimRight(endpoint, "/"),
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// error response from the service
+type S3Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %s (%d): %s", e.Code, e.Status, e.Message)
+}
+
+// uploads need a content length, so readers of unknown
+// size are spooled to a temp file first.
+func (s *S3) Put(key string, r io.Reader) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	body, size, cleanup, err := sizedBody(r)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if partSize := s.partSize(size); size > partSize {
+		return s.putParts(key, body, size, partSize)
+	}
+
+	req, err := s.newRequest(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := s.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptySHA256)
+	if err != nil {
+		return nil, s.mapErr(key, err)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(key string) (*Object, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, err
+	}
+	req, err := s.newRequest(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, emptySHA256)
+	if err != nil {
+		return nil, s.mapErr(key, err)
+	}
+	resp.Body.Close()
+	obj := &Object{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		obj.ModTime = t
+	}
+	return obj, nil
+}
+
+func (s *S3) Delete(key string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	req, err := s.newRequest(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptySHA256)
+	if err != nil {
+		if err := s.mapErr(key, err); !errors.Is(err, ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// results of a ListObjectsV2 request
+type listResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// objects are listed in key order, a page at a time
+func (s *S3) List(prefix string, fn func(*Object) error) error {
+	var token string
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(http.MethodGet, "", query, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(req, emptySHA256)
+		if err != nil {
+			return err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: failed to decode object list: %v", err)
+		}
+		for _, c := range result.Contents {
+			if err := fn(&Object{Key: c.Key, Size: c.Size, ModTime: c.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// copies are made by the service, without downloading the object
+func (s *S3) Copy(src string, dst string) error {
+	if err := ValidKey(src); err != nil {
+		return err
+	}
+	if err := ValidKey(dst); err != nil {
+		return err
+	}
+	obj, err := s.Stat(src)
+	if err != nil {
+		return err
+	}
+	if partSize := s.partSize(obj.Size); obj.Size > partSize {
+		return s.copyParts(src, dst, obj.Size, partSize)
+	}
+	req, err := s.newRequest(http.MethodPut, dst, nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-amz-copy-source", s.copySource(src))
+	resp, err := s.do(req, emptySHA256)
+	if err != nil {
+		return s.mapErr(src, err)
+	}
+	return s.mapErr(src, readResult(resp, nil))
+}
+
+func (s *S3) copySource(key string) string {
+	return "/" + s.Bucket + "/" + escapeKey(key)
+}
+
+// decode the XML body of a response into v, if it's set. copies and
+// completed uploads can fail after the service has already sent a 200,
+// so the body is checked for an error first.
+func readResult(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		e := &S3Error{Status: resp.StatusCode}
+		if err := xml.Unmarshal(data, e); err != nil {
+			return fmt.Errorf("s3: request failed: %s", data)
+		}
+		return e
+	}
+	if v != nil {
+		if err := xml.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("s3: failed to decode response: %v", err)
+		}
+	}
+	return nil
+}
+
+// ------- multipart uploads --------------------------------
+
+// size of the parts an object of size bytes is uploaded or copied in.
+// parts are made larger for objects that wouldn't fit in maxS3Parts.
+func (s *S3) partSize(size int64) int64 {
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = DefaultS3PartSize
+	}
+	if min := (size + maxS3Parts - 1) / maxS3Parts; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+// a part of a multipart upload
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// upload size bytes from body in parts
+func (s *S3) putParts(key string, body io.Reader, size int64, partSize int64) error {
+	return s.multipart(key, size, partSize, func(uploadID string, number int, offset int64, n int64) (string, error) {
+		req, err := s.newRequest(http.MethodPut, key, partQuery(uploadID, number), io.LimitReader(body, n))
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = n
+		resp, err := s.do(req, unsignedPayload)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		return resp.Header.Get("ETag"), nil
+	})
+}
+
+// copy an object of size bytes from src to dst in parts
+func (s *S3) copyParts(src string, dst string, size int64, partSize int64) error {
+	err := s.multipart(dst, size, partSize, func(uploadID string, number int, offset int64, n int64) (string, error) {
+		req, err := s.newRequest(http.MethodPut, dst, partQuery(uploadID, number), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("x-amz-copy-source", s.copySource(src))
+		req.Header.Set("x-amz-copy-source-range", fmt.Sprintf("bytes=%d-%d", offset, offset+n-1))
+		resp, err := s.do(req, emptySHA256)
+		if err != nil {
+			return "", err
+		}
+		var result struct {
+			ETag string `xml:"ETag"`
+		}
+		if err := readResult(resp, &result); err != nil {
+			return "", err
+		}
+		return result.ETag, nil
+	})
+	return s.mapErr(src, err)
+}
+
+func partQuery(uploadID string, number int) url.Values {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(number))
+	query.Set("uploadId", uploadID)
+	return query
+}
+
+// start a multipart upload to key, send each part of it with sendPart (which
+// returns the part's ETag), then put the parts together. the upload is
+// aborted if anything fails, so no parts are left behind.
+func (s *S3) multipart(key string, size int64, partSize int64, sendPart func(uploadID string, number int, offset int64, n int64) (string, error)) error {
+	req, err := s.newRequest(http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptySHA256)
+	if err != nil {
+		return err
+	}
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := readResult(resp, &upload); err != nil {
+		return err
+	}
+	if upload.UploadID == "" {
+		return fmt.Errorf("s3: no upload id for multipart upload of %s", key)
+	}
+
+	parts := make([]s3Part, 0, (size+partSize-1)/partSize)
+	for offset := int64(0); offset < size; offset += partSize {
+		n := partSize
+		if size-offset < n {
+			n = size - offset
+		}
+		etag, err := sendPart(upload.UploadID, len(parts)+1, offset, n)
+		if err != nil {
+			s.abortUpload(key, upload.UploadID)
+			return err
+		}
+		parts = append(parts, s3Part{PartNumber: len(parts) + 1, ETag: etag})
+	}
+
+	data, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err = s.newRequest(http.MethodPost, key, url.Values{"uploadId": {upload.UploadID}}, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err = s.do(req, hexSHA256(data))
+	if err == nil {
+		err = readResult(resp, nil)
+	}
+	if err != nil {
+		s.abortUpload(key, upload.UploadID)
+		return err
+	}
+	return nil
+}
+
+// abort a multipart upload, removing any parts already sent
+func (s *S3) abortUpload(key string, uploadID string) {
+	req, err := s.newRequest(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return
+	}
+	if resp, err := s.do(req, emptySHA256); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (s *S3) newRequest(method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint: %v", err)
+	}
+	base := strings.TrimRight(u.Path, "/")
+	rawBase := strings.TrimRight(u.EscapedPath(), "/")
+	u.Path = base + "/" + s.Bucket
+	u.RawPath = rawBase + "/" + escapeKey(s.Bucket)
+	if key != "" {
+		u.Path += "/" + key
+		u.RawPath += "/" + escapeKey(key)
+	}
+	if query != nil {
+		u.RawQuery = canonicalQuery(query)
+	}
+	return http.NewRequest(method, u.String(), body)
+}
+
+// sign and send a request. responses other than 2xx are returned as an *S3Error.
+func (s *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signV4(req, payloadHash, s.AccessKey, s.SecretKey, s.Region, time.Now().UTC())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %v", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	e := &S3Error{Status: resp.StatusCode}
+	if data, err := io.ReadAll(resp.Body); err == nil && len(data) > 0 {
+		xml.Unmarshal(data, e)
+	}
+	if e.Code == "" {
+		e.Code = http.StatusText(resp.StatusCode)
+	}
+	return nil, e
+}
+
+// convert missing object errors to ErrNotExist
+func (s *S3) mapErr(key string, err error) error {
+	if e, ok := err.(*S3Error); ok && (e.Status == http.StatusNotFound || e.Code == "NoSuchKey") {
+		return notExist(key)
+	}
+	return err
+}
+
+// ------- request signing --------------------------------
+
+// sign a request with AWS Signature Version 4. the host and every header
+// already set on the request are signed, along with the x-amz-date and
+// x-amz-content-sha256 headers added here.
+func signV4(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Del("Authorization")
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// query parameters sorted by name, with names and values uri-encoded
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape a key for use in a request path. slashes are left alone.
+func escapeKey(key string) string {
+	return uriEncode(key, false)
+}
+
+// uri-encode a string the way AWS expects. everything but unreserved
+// characters is percent-encoded, and slashes are too if encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// ------- uploads --------------------------------
+
+// get the size of an upload. readers that can't report how much is left
+// in them are copied to a temp file. cleanup removes the temp file, if any.
+func sizedBody(r io.Reader) (io.Reader, int64, func(), error) {
+	noop := func() {}
+	switch v := r.(type) {
+	case *bytes.Reader:
+		return v, int64(v.Len()), noop, nil
+	case *bytes.Buffer:
+		return v, int64(v.Len()), noop, nil
+	case *strings.Reader:
+		return v, int64(v.Len()), noop, nil
+	case *os.File:
+		if info, err := v.Stat(); err == nil && info.Mode().IsRegular() {
+			if pos, err := v.Seek(0, io.SeekCurrent); err == nil {
+				return v, info.Size() - pos, noop, nil
+			}
+		}
+	}
+	tmp, err := os.CreateTemp("", "sfs-upload-*")
+	if err != nil {
+		return nil, 0, noop, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+	return tmp, size, cleanup, nil
+}
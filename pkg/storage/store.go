@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+storage backends for file contents.
+
+a Store keeps objects under slash-separated keys, like
+"users/bill/files/3f/3f2a9c1e-...". objects are written and read as streams,
+so contents never need to be held in memory all at once (unless the backend
+itself keeps them there).
+
+	Local   files in a directory on the local disk. the default.
+	Memory  objects held in memory. meant for tests.
+	S3      objects in a bucket on an S3-compatible service (AWS, MinIO, etc.)
+
+keys can't start or end with a slash, or contain empty, "." or ".." segments.
+*/
+
+var (
+	// the store doesn't have an object with the requested key
+	ErrNotExist = errors.New("object does not exist")
+
+	// the key can't be used to store an object
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// information about a stored object
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+type Store interface {
+	// write an object, replacing any existing object with the same key.
+	// readers never see a partially written object.
+	Put(key string, r io.Reader) error
+
+	// open an object for reading. returns ErrNotExist if there's no such object.
+	Get(key string) (io.ReadCloser, error)
+
+	// get information about an object. returns ErrNotExist if there's no such object.
+	Stat(key string) (*Object, error)
+
+	// remove an object. removing an object that doesn't exist isn't an error.
+	Delete(key string) error
+
+	// call fn for each object whose key starts with prefix. listing
+	// stops at the first error returned by fn, which is passed back.
+	List(prefix string, fn func(*Object) error) error
+}
+
+// implemented by stores that can copy objects without
+// reading them back and writing them out again.
+type Copier interface {
+	Copy(src string, dst string) error
+}
+
+// implemented by stores that keep objects as files on the local disk.
+type Locator interface {
+	// path to the file holding an object. the file may not exist.
+	Path(key string) (string, error)
+}
+
+// copy an object to another key in the same store.
+// the copy doesn't change if the original does, or vice versa.
+func Copy(s Store, src string, dst string) error {
+	if c, ok := s.(Copier); ok {
+		return c.Copy(src, dst)
+	}
+	r, err := s.Get(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.Put(dst, r)
+}
+
+// an object opened for random access
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// open an object for random access. objects from stores that can only
+// stream them (i.e. S3) are copied to a temp file first, which is
+// removed when the object is closed.
+func Open(s Store, key string) (File, error) {
+	r, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := r.(File); ok {
+		return f, nil
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "sfs-object-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return spooled{tmp}, nil
+}
+
+type spooled struct {
+	*os.File
+}
+
+func (s spooled) Close() error {
+	err := s.File.Close()
+	os.Remove(s.Name())
+	return err
+}
+
+// read an entire object into memory
+func ReadAll(s Store, key string) ([]byte, error) {
+	r, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// whether an object exists
+func Exists(s Store, key string) (bool, error) {
+	if _, err := s.Stat(key); errors.Is(err, ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// remove every object whose key starts with prefix
+func DeleteAll(s Store, prefix string) error {
+	keys := make([]string, 0)
+	err := s.List(prefix, func(obj *Object) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// make sure a key can be used to store an object
+func ValidKey(key string) error {
+	if key == "" || strings.ContainsRune(key, '\\') {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+func notExist(key string) error {
+	return fmt.Errorf("%w: %s", ErrNotExist, key)
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// checks every store implementation is expected to pass
+func testStore(t *testing.T, s Store) {
+	put := func(key string, data string) {
+		if err := s.Put(key, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(key string) string {
+		data, err := ReadAll(s, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	list := func(prefix string) []string {
+		keys := make([]string, 0)
+		if err := s.List(prefix, func(obj *Object) error {
+			keys = append(keys, obj.Key)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	// write, read, and overwrite
+	put("a/b/one.txt", "hello")
+	assert.Equal(t, "hello", read("a/b/one.txt"))
+	obj, err := s.Stat("a/b/one.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "a/b/one.txt", obj.Key)
+	assert.Equal(t, int64(5), obj.Size)
+	put("a/b/one.txt", "hello again")
+	assert.Equal(t, "hello again", read("a/b/one.txt"))
+
+	// missing objects
+	_, err = s.Get("a/missing.txt")
+	assert.True(t, errors.Is(err, ErrNotExist))
+	_, err = s.Stat("a/missing.txt")
+	assert.True(t, errors.Is(err, ErrNotExist))
+	ok, err := Exists(s, "a/missing.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// bad keys
+	for _, key := range []string{"", "/a", "a/", "a//b", "a/../b", "./a"} {
+		err := s.Put(key, strings.NewReader("nope"))
+		assert.True(t, errors.Is(err, ErrInvalidKey), key)
+	}
+
+	// empty objects, and keys that need escaping
+	put("a/empty", "")
+	assert.Equal(t, "", read("a/empty"))
+	put("a/with space & more+chars!.txt", "escaped")
+	assert.Equal(t, "escaped", read("a/with space & more+chars!.txt"))
+
+	// readers of unknown size are streamed
+	big := make([]byte, 1<<20)
+	rand.Read(big)
+	if err := s.Put("big", io.LimitReader(bytes.NewReader(big), int64(len(big)))); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAll(s, "big")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(big, data))
+
+	// random access
+	f, err := Open(s, "big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	_, err = f.ReadAt(buf, 1000)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(big[1000:1016], buf))
+	assert.NoError(t, f.Close())
+
+	// listing
+	put("a/c.txt", "c")
+	put("z.txt", "z")
+	assert.Equal(t, []string{"a/b/one.txt", "a/c.txt", "a/empty", "a/with space & more+chars!.txt"}, list("a/"))
+	assert.Equal(t, []string{"a/b/one.txt"}, list("a/b"))
+	assert.Equal(t, 6, len(list("")))
+	stop := errors.New("stop")
+	var seen int
+	err = s.List("", func(*Object) error {
+		seen++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, seen)
+
+	// copies are independent of the original
+	if err := Copy(s, "a/c.txt", "copies/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	put("a/c.txt", "changed")
+	assert.Equal(t, "c", read("copies/c.txt"))
+	assert.True(t, errors.Is(Copy(s, "a/missing.txt", "copies/missing.txt"), ErrNotExist))
+
+	// removal
+	assert.NoError(t, s.Delete("z.txt"))
+	assert.NoError(t, s.Delete("z.txt"))
+	_, err = s.Stat("z.txt")
+	assert.True(t, errors.Is(err, ErrNotExist))
+	assert.NoError(t, DeleteAll(s, "a/"))
+	assert.Equal(t, 0, len(list("a/")))
+	assert.Equal(t, []string{"big", "copies/c.txt"}, list(""))
+}
+
+func TestLocalStore(t *testing.T) {
+	root := t.TempDir()
+	s := NewLocal(root)
+	testStore(t, s)
+
+	// objects are plain files under the root directory
+	if err := s.Put("x/y.txt", strings.NewReader("y")); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := s.Path("x/y.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "x", "y.txt"), fp)
+	_, err = s.Path("../outside")
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemory())
+}
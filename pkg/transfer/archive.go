@@ -0,0 +1,480 @@
+package transfer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+archives.
+
+directories are sent as a single archive in one of a few formats. entries
+use slash-separated paths relative to the archived directory, and keep their
+file modes and modification times.
+
+extracting an archive never writes outside of its destination (zip slip),
+skips anything that isn't a regular file or directory (i.e. symlinks), and
+stops once it's extracted more entries or bytes than its limits allow, so
+a small archive can't fill up the disk (decompression bombs).
+*/
+
+// archive formats
+const (
+	FormatZip    = "zip"
+	FormatTar    = "tar"
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+)
+
+// supported archive formats
+var ArchiveFormats = []string{FormatZip, FormatTar, FormatTarGz, FormatTarZst}
+
+var (
+	ErrArchiveFormat = errors.New("unsupported archive format")
+	ErrArchiveLimit  = errors.New("archive exceeds extraction limits")
+	ErrArchivePath   = errors.New("illegal path in archive")
+)
+
+// whether an archive format is supported
+func ValidArchiveFormat(format string) bool {
+	for _, f := range ArchiveFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// get an archive's format from its file name
+func ArchiveFormatOf(name string) (string, error) {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz, nil
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return FormatTarZst, nil
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar, nil
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrArchiveFormat, filepath.Base(name))
+}
+
+// file extension for an archive format, including the leading dot
+func ArchiveExt(format string) string {
+	return "." + format
+}
+
+// mime type for an archive format
+func ArchiveContentType(format string) string {
+	switch format {
+	case FormatZip:
+		return "application/zip"
+	case FormatTar:
+		return "application/x-tar"
+	case FormatTarGz:
+		return "application/gzip"
+	case FormatTarZst:
+		return "application/zstd"
+	}
+	return "application/octet-stream"
+}
+
+// ------- writing --------------------------------
+
+// writes entries to an archive
+type ArchiveWriter interface {
+	// add a file or directory. name is its slash-separated path in the
+	// archive. r is the file's contents, and is ignored for directories.
+	Add(name string, info fs.FileInfo, r io.Reader) error
+
+	// finish the archive. doesn't close the underlying writer.
+	Close() error
+}
+
+// create a new archive writer for a format
+func NewArchiveWriter(w io.Writer, format string) (ArchiveWriter, error) {
+	switch format {
+	case FormatZip:
+		return &zipWriter{w: zip.NewWriter(w)}, nil
+	case FormatTar:
+		return &tarWriter{w: tar.NewWriter(w)}, nil
+	case FormatTarGz:
+		zw := gzip.NewWriter(w)
+		return &tarWriter{w: tar.NewWriter(zw), c: zw}, nil
+	case FormatTarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarWriter{w: tar.NewWriter(zw), c: zw}, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrArchiveFormat, format)
+}
+
+type zipWriter struct {
+	w *zip.Writer
+}
+
+func (z *zipWriter) Add(name string, info fs.FileInfo, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+		_, err := z.w.CreateHeader(hdr)
+		return err
+	}
+	hdr.Method = zip.Deflate
+	fw, err := z.w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.w.Close()
+}
+
+type tarWriter struct {
+	w *tar.Writer
+	c io.Closer // compressor, if any
+}
+
+func (t *tarWriter) Add(name string, info fs.FileInfo, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// owners don't mean anything on another machine
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := t.w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+	_, err = io.Copy(t.w, r)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	err := t.w.Close()
+	if t.c != nil {
+		if cerr := t.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// info for an archive entry that isn't a file on disk (i.e. contents read
+// from a store). only the base of name is used.
+func EntryInfo(name string, size int64, mode fs.FileMode, modTime time.Time) fs.FileInfo {
+	return &entryInfo{name: path.Base(name), size: size, mode: mode, modTime: modTime}
+}
+
+type entryInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (e *entryInfo) Name() string       { return e.name }
+func (e *entryInfo) Size() int64        { return e.size }
+func (e *entryInfo) Mode() fs.FileMode  { return e.mode }
+func (e *entryInfo) ModTime() time.Time { return e.modTime }
+func (e *entryInfo) IsDir() bool        { return e.mode.IsDir() }
+func (e *entryInfo) Sys() any           { return nil }
+
+// add everything under a directory to an archive, with paths relative to
+// the directory. paths in skip (i.e. the archive itself) are left out.
+func AddDir(aw ArchiveWriter, dir string, skip ...string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, s := range skip {
+		if abs, err := filepath.Abs(s); err == nil {
+			skipped[abs] = true
+		}
+	}
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if skipped[p] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		switch {
+		case info.IsDir():
+			return aw.Add(name, info, nil)
+		case info.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return aw.Add(name, info, f)
+		default:
+			// symlinks, devices, etc. aren't archived
+			return nil
+		}
+	})
+}
+
+// create an archive of a directory at dest
+func ArchiveDir(srcDir string, dest string, format string) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	aw, err := NewArchiveWriter(f, format)
+	if err == nil {
+		if err = AddDir(aw, srcDir, dest); err == nil {
+			err = aw.Close()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to create %s archive of %s: %v", format, srcDir, err)
+	}
+	return nil
+}
+
+// ------- extracting --------------------------------
+
+// limits on what extracting an archive can create. zero means no limit.
+type ArchiveLimits struct {
+	MaxEntries int   // most files and directories
+	MaxSize    int64 // most bytes, across all files
+}
+
+// limits for archives we didn't make ourselves
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxEntries: 100_000,
+	MaxSize:    64 * 1024 * 1024 * 1024,
+}
+
+// an entry read from an archive
+type archiveEntry struct {
+	name    string
+	mode    fs.FileMode
+	modTime time.Time
+	isDir   bool
+	regular bool
+}
+
+// extract an archive into dest
+func ExtractArchive(src string, dest string, format string, limits ArchiveLimits) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	x := &extractor{dest: filepath.Clean(dest), limits: limits}
+	switch format {
+	case FormatZip:
+		var info fs.FileInfo
+		if info, err = f.Stat(); err == nil {
+			err = x.zip(f, info.Size())
+		}
+	case FormatTar:
+		err = x.tar(f)
+	case FormatTarGz:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(f); err == nil {
+			defer zr.Close()
+			err = x.tar(zr)
+		}
+	case FormatTarZst:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(f); err == nil {
+			defer zr.Close()
+			err = x.tar(zr)
+		}
+	default:
+		err = fmt.Errorf("%w: %q", ErrArchiveFormat, format)
+	}
+	if err != nil {
+		return err
+	}
+	return x.finish()
+}
+
+type extractor struct {
+	dest    string
+	limits  ArchiveLimits
+	entries int
+	size    int64
+	dirs    []archiveEntry // directory mtimes are set once everything's in them
+}
+
+func (x *extractor) zip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		info := f.FileInfo()
+		e := archiveEntry{
+			name:    f.Name,
+			mode:    info.Mode(),
+			modTime: f.Modified,
+			isDir:   info.IsDir(),
+			regular: info.Mode().IsRegular(),
+		}
+		if e.isDir || !e.regular {
+			if err := x.add(e, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = x.add(e, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := archiveEntry{
+			name:    hdr.Name,
+			mode:    hdr.FileInfo().Mode(),
+			modTime: hdr.ModTime,
+			isDir:   hdr.Typeflag == tar.TypeDir,
+			regular: hdr.Typeflag == tar.TypeReg,
+		}
+		if err := x.add(e, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extract a single entry
+func (x *extractor) add(e archiveEntry, r io.Reader) error {
+	if !e.isDir && !e.regular {
+		return nil
+	}
+	name := strings.ReplaceAll(e.name, "\\", "/")
+	clean := path.Clean(name)
+	if path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("%w: %s", ErrArchivePath, e.name)
+	}
+	if clean == "." {
+		return nil
+	}
+	target := filepath.Join(x.dest, filepath.FromSlash(clean))
+	if !ValidPath(target, x.dest) {
+		return fmt.Errorf("%w: %s", ErrArchivePath, e.name)
+	}
+	x.entries++
+	if x.limits.MaxEntries > 0 && x.entries > x.limits.MaxEntries {
+		return fmt.Errorf("%w: more than %d entries", ErrArchiveLimit, x.limits.MaxEntries)
+	}
+
+	if e.isDir {
+		if err := os.MkdirAll(target, e.mode.Perm()|0700); err != nil {
+			return err
+		}
+		e.name = target
+		x.dirs = append(x.dirs, e)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, e.mode.Perm())
+	if err != nil {
+		return err
+	}
+	// read one byte past what's left, so going over the limit can be told
+	// apart from landing right on it
+	src := r
+	if x.limits.MaxSize > 0 {
+		src = io.LimitReader(r, x.limits.MaxSize-x.size+1)
+	}
+	n, err := io.Copy(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	x.size += n
+	if x.limits.MaxSize > 0 && x.size > x.limits.MaxSize {
+		os.Remove(target)
+		return fmt.Errorf("%w: more than %d bytes", ErrArchiveLimit, x.limits.MaxSize)
+	}
+	return os.Chtimes(target, e.modTime, e.modTime)
+}
+
+// set directory modification times, deepest first
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		d := x.dirs[i]
+		if err := os.Chtimes(d.name, d.modTime, d.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
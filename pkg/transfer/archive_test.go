@@ -0,0 +1,146 @@
+package transfer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestArchiveFormats(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	src := filepath.Join(testDir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub", "deeper"), 0755); err != nil {
+		Fail(t, testDir, err)
+	}
+	mtime := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]os.FileMode{
+		"notes.txt":              0644,
+		"sub/script.sh":          0755,
+		"sub/deeper/private.txt": 0600,
+	}
+	for name, mode := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.WriteFile(p, []byte(strings.Repeat(name, 100)), mode); err != nil {
+			Fail(t, testDir, err)
+		}
+		if err := os.Chmod(p, mode); err != nil {
+			Fail(t, testDir, err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			Fail(t, testDir, err)
+		}
+	}
+	// symlinks are left out
+	if err := os.Symlink("/etc/passwd", filepath.Join(src, "link")); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	for _, format := range ArchiveFormats {
+		// the archive can live in the directory it's made of
+		archive := filepath.Join(src, "test"+ArchiveExt(format))
+		if err := ArchiveDir(src, archive, format); err != nil {
+			Fail(t, testDir, err)
+		}
+		got, err := ArchiveFormatOf(archive)
+		assert.NoError(t, err)
+		assert.Equal(t, format, got)
+
+		dest := filepath.Join(testDir, "dest-"+format)
+		if err := ExtractArchive(archive, dest, format, DefaultArchiveLimits); err != nil {
+			Fail(t, testDir, err)
+		}
+		for name, mode := range files {
+			p := filepath.Join(dest, filepath.FromSlash(name))
+			data, err := os.ReadFile(p)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Repeat(name, 100), string(data))
+			info, err := os.Stat(p)
+			assert.NoError(t, err)
+			assert.Equal(t, mode, info.Mode().Perm())
+			assert.True(t, info.ModTime().Equal(mtime))
+		}
+		_, err = os.Lstat(filepath.Join(dest, "link"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(dest, "test"+ArchiveExt(format)))
+		assert.True(t, os.IsNotExist(err))
+		if err := os.Remove(archive); err != nil {
+			Fail(t, testDir, err)
+		}
+	}
+
+	_, err := ArchiveFormatOf("photos.rar")
+	assert.True(t, errors.Is(err, ErrArchiveFormat))
+	_, err = NewArchiveWriter(&bytes.Buffer{}, "rar")
+	assert.True(t, errors.Is(err, ErrArchiveFormat))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(testDir, name)
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			Fail(t, testDir, err)
+		}
+		return p
+	}
+	tarOf := func(names ...string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, name := range names {
+			body := strings.Repeat("x", 1000)
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(body))
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+	dest := filepath.Join(testDir, "dest")
+
+	// nothing is written outside of the destination
+	for _, name := range []string{"../evil.txt", "a/../../evil.txt", "/etc/evil.txt"} {
+		archive := write("slip.tar", tarOf(name))
+		err := ExtractArchive(archive, dest, FormatTar, DefaultArchiveLimits)
+		assert.True(t, errors.Is(err, ErrArchivePath), name)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("../evil.txt")
+	fw.Write([]byte("gotcha"))
+	zw.Close()
+	archive := write("slip.zip", buf.Bytes())
+	assert.True(t, errors.Is(ExtractArchive(archive, dest, FormatZip, DefaultArchiveLimits), ErrArchivePath))
+	_, err := os.Stat(filepath.Join(testDir, "evil.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// or past the limits
+	archive = write("many.tar", tarOf("1.txt", "2.txt", "3.txt"))
+	err = ExtractArchive(archive, dest, FormatTar, ArchiveLimits{MaxEntries: 2})
+	assert.True(t, errors.Is(err, ErrArchiveLimit))
+	err = ExtractArchive(archive, dest, FormatTar, ArchiveLimits{MaxSize: 2500})
+	assert.True(t, errors.Is(err, ErrArchiveLimit))
+	_, err = os.Stat(filepath.Join(dest, "3.txt"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, ExtractArchive(archive, dest, FormatTar, ArchiveLimits{MaxEntries: 3, MaxSize: 3000}))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,393 @@
+package transfer
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+block-level delta transfers.
+
+files are split into content-defined chunks using a rolling (gear) hash, so
+inserting or removing bytes in the middle of a file only changes the chunks
+surrounding the edit rather than every block after it.
+
+the side that has the *old* version of a file sends a Signature (a list of chunk
+hashes) to the side with the *new* version, which then builds a Delta made up of
+copy operations (reuse a block from the old version) and data operations
+(literal bytes that weren't found in the old version). the old version is then
+used as a base to rebuild the new version with ApplyDelta().
+*/
+
+const (
+	MinChunkSize int = 2 * 1024  // minimum chunk size in bytes
+	MaxChunkSize int = 64 * 1024 // maximum chunk size in bytes
+
+	// average chunk size is ~8KB. a boundary is found when the lower
+	// 13 bits of the rolling hash are all zero (2^13 = 8192)
+	chunkMask uint64 = (1 << 13) - 1
+
+	// files smaller than this aren't worth the extra round trip,
+	// so they're always sent in full.
+	DeltaMinSize int64 = 64 * 1024
+)
+
+// a delta copies a block from past the end of its base file, i.e. the
+// base changed since the signature the delta was built against
+var ErrBaseTooShort = errors.New("base file is too short")
+
+type OpType string
+
+const (
+	OpCopy OpType = "copy" // copy a block from the base file
+	OpData OpType = "data" // write literal data
+)
+
+// gear table used by the rolling hash. this needs to be identical on the client
+// and the server so chunk boundaries line up, so it's generated from a fixed seed
+// rather than using math/rand.
+var gear = func() [256]uint64 {
+	var (
+		g    [256]uint64
+		seed uint64 = 0x5f3759df
+	)
+	for i := range g {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		g[i] = z ^ (z >> 31)
+	}
+	return g
+}()
+
+// a single content-defined chunk of a file
+type Block struct {
+	Offset int64  `json:"offset"` // offset of the block in the file
+	Size   int64  `json:"size"`   // size of the block in bytes
+	Hash   string `json:"hash"`   // sha256 hash of the block contents
+}
+
+// list of chunk hashes for a given version of a file
+type Signature struct {
+	FileID string   `json:"file_id"`
+	Size   int64    `json:"size"` // total size of the file this signature was generated from
+	Blocks []*Block `json:"blocks"`
+}
+
+// a single instruction used to rebuild a file
+type Op struct {
+	Type   OpType `json:"type"`
+	Offset int64  `json:"offset,omitempty"` // offset in the base file (copy ops only)
+	Size   int64  `json:"size"`             // number of bytes to copy or write
+	Data   []byte `json:"data,omitempty"`   // literal data (data ops only)
+}
+
+// set of instructions to rebuild the new version of a file from
+// the old version (base) plus whatever data wasn't found in the base.
+type Delta struct {
+	FileID   string `json:"file_id"`
+	Size     int64  `json:"size"`     // size of the new file
+	CheckSum string `json:"checksum"` // hex encoded sha256 checksum of the new file
+	Ops      []*Op  `json:"ops"`
+}
+
+// ------- chunking --------------------------------
+
+// split the contents of r into content-defined chunks, calling fn
+// with each chunk's offset and contents as they are found.
+//
+// NOTE: the data slice passed to fn is reused between calls,
+// so it needs to be copied if fn wants to keep it.
+func chunk(r io.Reader, fn func(offset int64, data []byte) error) error {
+	var (
+		br     = bufio.NewReaderSize(r, MaxChunkSize)
+		buf    = make([]byte, 0, MaxChunkSize)
+		offset int64
+		h      uint64
+	)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		buf = append(buf, b)
+		h = (h << 1) + gear[b]
+		if (len(buf) >= MinChunkSize && h&chunkMask == 0) || len(buf) >= MaxChunkSize {
+			if err := fn(offset, buf); err != nil {
+				return err
+			}
+			offset += int64(len(buf))
+			buf = buf[:0]
+			h = 0
+		}
+	}
+	if len(buf) > 0 {
+		return fn(offset, buf)
+	}
+	return nil
+}
+
+func hashBlock(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ------- signatures --------------------------------
+
+// generate a signature for the file at the given path.
+// if the file doesn't exist, an empty signature is returned.
+func NewSignature(fileID string, path string) (*Signature, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Signature{FileID: fileID, Blocks: make([]*Block, 0)}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+	return SignatureFrom(fileID, file)
+}
+
+// generate a signature for the contents read from r
+func SignatureFrom(fileID string, r io.Reader) (*Signature, error) {
+	var sig = &Signature{
+		FileID: fileID,
+		Blocks: make([]*Block, 0),
+	}
+	err := chunk(r, func(offset int64, data []byte) error {
+		sig.Blocks = append(sig.Blocks, &Block{
+			Offset: offset,
+			Size:   int64(len(data)),
+			Hash:   hashBlock(data),
+		})
+		sig.Size += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signature: %v", err)
+	}
+	return sig, nil
+}
+
+// whether there's anything in this signature to build a delta against
+func (s *Signature) IsEmpty() bool { return len(s.Blocks) == 0 }
+
+func (s *Signature) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func UnmarshalSignature(data []byte) (*Signature, error) {
+	sig := new(Signature)
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// ------- deltas --------------------------------
+
+// build a delta for the file at the given path against a signature
+// generated from the base version of the file.
+func NewDelta(sig *Signature, path string) (*Delta, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+	return DeltaFrom(sig, file)
+}
+
+// build a delta for the contents read from r against a signature
+// generated from the base version of the file.
+func DeltaFrom(sig *Signature, r io.Reader) (*Delta, error) {
+	// index base blocks by hash. if there are duplicate blocks
+	// then we only need to keep track of one of them.
+	var blocks = make(map[string]*Block, len(sig.Blocks))
+	for _, b := range sig.Blocks {
+		if _, exists := blocks[b.Hash]; !exists {
+			blocks[b.Hash] = b
+		}
+	}
+
+	var (
+		delta = &Delta{FileID: sig.FileID, Ops: make([]*Op, 0)}
+		h     = sha256.New()
+	)
+	err := chunk(r, func(offset int64, data []byte) error {
+		h.Write(data)
+		delta.Size += int64(len(data))
+		if b, found := blocks[hashBlock(data)]; found && b.Size == int64(len(data)) {
+			delta.addCopy(b.Offset, b.Size)
+		} else {
+			delta.addData(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build delta: %v", err)
+	}
+	delta.CheckSum = hex.EncodeToString(h.Sum(nil))
+	return delta, nil
+}
+
+// add a copy operation, merging with the previous op if the blocks are contiguous
+func (d *Delta) addCopy(offset int64, size int64) {
+	if len(d.Ops) > 0 {
+		last := d.Ops[len(d.Ops)-1]
+		if last.Type == OpCopy && last.Offset+last.Size == offset {
+			last.Size += size
+			return
+		}
+	}
+	d.Ops = append(d.Ops, &Op{Type: OpCopy, Offset: offset, Size: size})
+}
+
+// add a data operation, merging with the previous op if it was also data
+func (d *Delta) addData(data []byte) {
+	if len(d.Ops) > 0 {
+		last := d.Ops[len(d.Ops)-1]
+		if last.Type == OpData {
+			last.Data = append(last.Data, data...)
+			last.Size += int64(len(data))
+			return
+		}
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	d.Ops = append(d.Ops, &Op{Type: OpData, Size: int64(len(data)), Data: buf})
+}
+
+// total number of literal bytes in this delta, i.e. how much
+// data actually needs to be sent over the wire.
+func (d *Delta) DataSize() int64 {
+	var total int64
+	for _, op := range d.Ops {
+		if op.Type == OpData {
+			total += op.Size
+		}
+	}
+	return total
+}
+
+func (d *Delta) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func UnmarshalDelta(data []byte) (*Delta, error) {
+	delta := new(Delta)
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return nil, err
+	}
+	return delta, nil
+}
+
+// rebuild a file using a base file and a delta. the new version is written to
+// a temp file in the same directory as destPath, verified against the delta's
+// checksum, then moved into place, so basePath and destPath can be the same file.
+func ApplyDelta(basePath string, destPath string, delta *Delta) error {
+	var base io.ReaderAt
+	if delta.HasCopyOps() {
+		b, err := os.Open(basePath)
+		if err != nil {
+			return fmt.Errorf("failed to open base file: %v", err)
+		}
+		defer b.Close()
+		base = b
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), filepath.Base(destPath)+".delta-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := ApplyDeltaTo(tmp, base, delta); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %v", err)
+	}
+	if info, err := os.Stat(destPath); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+			return fmt.Errorf("failed to set file permissions: %v", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), destPath); err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+	return nil
+}
+
+// write the version of a file rebuilt from base and a delta to w. base
+// can be nil if the delta doesn't copy anything from it. the result is
+// checked against the delta's checksum once it's all been written, so
+// callers should discard what was written if an error is returned.
+func ApplyDeltaTo(w io.Writer, base io.ReaderAt, delta *Delta) error {
+	if base == nil && delta.HasCopyOps() {
+		return fmt.Errorf("delta copies from a base file, but none was given")
+	}
+	h := sha256.New()
+	if err := applyOps(io.MultiWriter(w, h), base, delta.Ops); err != nil {
+		return err
+	}
+	return verify(h, delta)
+}
+
+func applyOps(w io.Writer, base io.ReaderAt, ops []*Op) error {
+	for _, op := range ops {
+		switch op.Type {
+		case OpCopy:
+			src := io.NewSectionReader(base, op.Offset, op.Size)
+			if n, err := io.Copy(w, src); err != nil {
+				return fmt.Errorf("failed to copy block from base file: %v", err)
+			} else if n != op.Size {
+				return fmt.Errorf("%w. expected %d bytes at offset %d, got %d", ErrBaseTooShort, op.Size, op.Offset, n)
+			}
+		case OpData:
+			if _, err := w.Write(op.Data); err != nil {
+				return fmt.Errorf("failed to write data: %v", err)
+			}
+		default:
+			return fmt.Errorf("unknown delta operation: %q", op.Type)
+		}
+	}
+	return nil
+}
+
+func verify(h hash.Hash, delta *Delta) error {
+	cs := hex.EncodeToString(h.Sum(nil))
+	if cs != delta.CheckSum {
+		return fmt.Errorf("%w after applying delta. expected: %s, got: %s", ErrChecksumMismatch, delta.CheckSum, cs)
+	}
+	return nil
+}
+
+// whether any part of the new version is copied from the base version
+func (d *Delta) HasCopyOps() bool {
+	for _, op := range d.Ops {
+		if op.Type == OpCopy {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sfs/pkg/env"
+)
+
+// make a file with n bytes of random (but reproducible) data
+func makeRandFile(path string, n int, seed int64) ([]byte, error) {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func TestChunkBoundaries(t *testing.T) {
+	env.SetEnv(false)
+
+	testFile := filepath.Join(GetTestingDir(), "chunks.bin")
+	if _, err := makeRandFile(testFile, 1024*1024, 1); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	sig, err := NewSignature("some-file-id", testFile)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if sig.Size != 1024*1024 {
+		Fail(t, GetTestingDir(), fmt.Errorf("signature size mismatch. got %d", sig.Size))
+	}
+	var offset int64
+	for i, b := range sig.Blocks {
+		if b.Offset != offset {
+			Fail(t, GetTestingDir(), fmt.Errorf("block %d offset mismatch. expected %d, got %d", i, offset, b.Offset))
+		}
+		if b.Size > int64(MaxChunkSize) {
+			Fail(t, GetTestingDir(), fmt.Errorf("block %d exceeds max chunk size: %d", i, b.Size))
+		}
+		// last block is allowed to be smaller than the minimum
+		if i < len(sig.Blocks)-1 && b.Size < int64(MinChunkSize) {
+			Fail(t, GetTestingDir(), fmt.Errorf("block %d is below min chunk size: %d", i, b.Size))
+		}
+		offset += b.Size
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	env.SetEnv(false)
+
+	basePath := filepath.Join(GetTestingDir(), "base.bin")
+	newPath := filepath.Join(GetTestingDir(), "new.bin")
+
+	orig, err := makeRandFile(basePath, 1024*1024, 2)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	// insert some data in the middle of the file and modify some near the end
+	var modified []byte
+	modified = append(modified, orig[:300*1024]...)
+	modified = append(modified, []byte(strings.Repeat(txtData, 10))...)
+	modified = append(modified, orig[300*1024:]...)
+	copy(modified[900*1024:], []byte("some new data"))
+	if err := os.WriteFile(newPath, modified, 0644); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	sig, err := NewSignature("some-file-id", basePath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	delta, err := NewDelta(sig, newPath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if delta.Size != int64(len(modified)) {
+		Fail(t, GetTestingDir(), fmt.Errorf("delta size mismatch. expected %d, got %d", len(modified), delta.Size))
+	}
+	// only the chunks around the edits should need to be sent
+	if delta.DataSize() >= delta.Size/4 {
+		Fail(t, GetTestingDir(), fmt.Errorf("delta is too large: %d of %d bytes", delta.DataSize(), delta.Size))
+	}
+
+	// make sure the delta survives encoding
+	data, err := delta.ToJSON()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	delta, err = UnmarshalDelta(data)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	// rebuild in place
+	if err := ApplyDelta(basePath, basePath, delta); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	rebuilt, err := os.ReadFile(basePath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if !bytes.Equal(rebuilt, modified) {
+		Fail(t, GetTestingDir(), fmt.Errorf("rebuilt file does not match modified file"))
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deltas can be built and applied without anything on disk
+func TestDeltaFromReaders(t *testing.T) {
+	env.SetEnv(false)
+
+	orig := make([]byte, 512*1024)
+	rand.New(rand.NewSource(3)).Read(orig)
+	modified := append([]byte(strings.Repeat(txtData, 5)), orig...)
+
+	sig, err := SignatureFrom("some-file-id", bytes.NewReader(orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := DeltaFrom(sig, bytes.NewReader(modified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ApplyDeltaTo(&buf, bytes.NewReader(orig), delta); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), modified) {
+		t.Fatal("rebuilt contents do not match modified contents")
+	}
+	// copies need a base to copy from
+	if err := ApplyDeltaTo(&bytes.Buffer{}, nil, delta); err == nil {
+		t.Fatal("expected an error applying a delta without its base")
+	}
+}
+
+func TestDeltaWithNoBase(t *testing.T) {
+	env.SetEnv(false)
+
+	newPath := filepath.Join(GetTestingDir(), "new.bin")
+	destPath := filepath.Join(GetTestingDir(), "dest.bin")
+	orig, err := makeRandFile(newPath, 256*1024, 3)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	// signature of a file that doesn't exist should be empty,
+	// and the delta should be all data
+	sig, err := NewSignature("some-file-id", destPath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if !sig.IsEmpty() {
+		Fail(t, GetTestingDir(), fmt.Errorf("signature should be empty"))
+	}
+	delta, err := NewDelta(sig, newPath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if delta.DataSize() != delta.Size {
+		Fail(t, GetTestingDir(), fmt.Errorf("expected all data. got %d of %d bytes", delta.DataSize(), delta.Size))
+	}
+	if err := ApplyDelta(destPath, destPath, delta); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	rebuilt, err := os.ReadFile(destPath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if !bytes.Equal(rebuilt, orig) {
+		Fail(t, GetTestingDir(), fmt.Errorf("rebuilt file does not match original"))
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyDeltaChecksumMismatch(t *testing.T) {
+	env.SetEnv(false)
+
+	basePath := filepath.Join(GetTestingDir(), "base.bin")
+	newPath := filepath.Join(GetTestingDir(), "new.bin")
+	if _, err := makeRandFile(basePath, 256*1024, 4); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if _, err := makeRandFile(newPath, 256*1024, 5); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	sig, err := NewSignature("some-file-id", basePath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	delta, err := NewDelta(sig, newPath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	before, err := os.ReadFile(basePath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	// a base that's shorter than the one the delta was built against
+	same, err := NewDelta(sig, basePath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	err = ApplyDeltaTo(&bytes.Buffer{}, bytes.NewReader(before[:1024]), same)
+	if !errors.Is(err, ErrBaseTooShort) {
+		Fail(t, GetTestingDir(), fmt.Errorf("expected base too short error, got: %v", err))
+	}
+
+	delta.CheckSum = "not-a-real-checksum"
+	if err := ApplyDelta(basePath, basePath, delta); !errors.Is(err, ErrChecksumMismatch) {
+		Fail(t, GetTestingDir(), fmt.Errorf("expected checksum mismatch error, got: %v", err))
+	}
+	// base file should be left untouched
+	after, err := os.ReadFile(basePath)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if !bytes.Equal(before, after) {
+		Fail(t, GetTestingDir(), fmt.Errorf("base file was modified after a failed delta"))
+	}
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
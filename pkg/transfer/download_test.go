@@ -0,0 +1,126 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDownloadResume(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	data := []byte(strings.Repeat(txtData, 100))
+	src := filepath.Join(testDir, "src.txt")
+	if err := os.WriteFile(src, data, svc.PERMS); err != nil {
+		Fail(t, testDir, err)
+	}
+	checksum, err := svc.CalculateChecksum(src)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	var (
+		ranges []string
+		cut    bool // drop the connection halfway through the next response
+		fail   bool // send a 500 instead of the file
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if fail {
+			http.Error(w, "server is having a bad day", http.StatusInternalServerError)
+			return
+		}
+		if cut {
+			cut = false
+			w.Header().Set("ETag", strconv.Quote(checksum))
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			return
+		}
+		w.Header().Set("ETag", strconv.Quote(checksum))
+		http.ServeContent(w, r, "src.txt", time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	tr := NewTransfer()
+	dest := filepath.Join(testDir, "dest.txt")
+
+	// failed downloads return an error and leave nothing behind
+	fail = true
+	err = tr.Download(dest, srv.URL, checksum)
+	assert.Error(t, err)
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+	fail = false
+
+	// an interrupted download keeps what it got,
+	// and picks up where it left off next time
+	cut = true
+	assert.Error(t, tr.Download(dest, srv.URL, checksum))
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+	info, err := os.Stat(partialPath(dest))
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, int64(len(data)/2), info.Size())
+
+	ranges = nil
+	if err := tr.Download(dest, srv.URL, checksum); err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, []string{"bytes=" + strconv.Itoa(len(data)/2) + "-"}, ranges)
+	got, err := os.ReadFile(dest)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, data, got)
+	_, err = os.Stat(partialPath(dest))
+	assert.True(t, os.IsNotExist(err))
+
+	// a partial file for other contents is thrown away
+	if err := os.WriteFile(partialPath(dest), []byte("something else entirely"), svc.PERMS); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := tr.Download(dest, srv.URL, checksum); err != nil {
+		Fail(t, testDir, err)
+	}
+	got, err = os.ReadFile(dest)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, data, got)
+
+	// contents that don't match their checksum aren't moved into place
+	if err := os.Remove(dest); err != nil {
+		Fail(t, testDir, err)
+	}
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", strconv.Quote(checksum))
+		w.Write([]byte("corrupted"))
+	}))
+	defer bad.Close()
+	err = tr.Download(dest, bad.URL, checksum)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(partialPath(dest))
+	assert.True(t, os.IsNotExist(err))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,362 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	svc "github.com/sfs/pkg/service"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+/*
+end-to-end encrypted drives.
+
+for drives with E2EE set, file contents are encrypted by the client before
+they're uploaded and decrypted after they're downloaded, so the server only
+ever holds ciphertext. the keys never leave the client unless they're
+exported.
+
+every key is derived from a random 256-bit master key with HKDF-SHA256:
+
+	content  each upload starts with a random salt, which is used to derive
+	         a key for that upload alone. the rest is encrypted the same way
+	         as locked files (see service/crypt.go).
+	mac      checksums are HMAC-SHA256s of the plaintext, written as
+	         "hmac-sha256:<hex>". the server keeps these in place of its own
+	         checksums, so clients can still tell which files have changed
+	         without the server learning anything about the contents.
+	names    file and directory names, if enabled. each part of a path is
+	         sealed separately, with a nonce derived from the name itself,
+	         so the same name always seals to the same thing and paths
+	         still line up on the server.
+
+clients keep the master key in their state file, wrapped with a key derived
+from a passphrase (see service.WrapKey). exported keys are wrapped the same
+way, with a passphrase of their own, so they can be imported on another
+device.
+
+delta transfers and blob lookups are never used for these drives, since
+both need the server to read the contents.
+*/
+
+// algorithm name for keyed checksums
+const MACAlgorithm = "hmac-sha256"
+
+const (
+	masterKeySize = 32
+	e2eeSaltSize  = 16
+	exportPrefix  = "sfs-e2ee:"
+	sealNames     = 1 // key flag for whether names are sealed
+)
+
+// keys for an end-to-end encrypted drive
+type Keys struct {
+	master  []byte
+	content []byte
+	mac     []byte
+	names   []byte
+	nonces  []byte // for deriving name nonces
+
+	// whether file and directory names are sealed
+	Names bool
+}
+
+// generate new keys
+func NewKeys(names bool) (*Keys, error) {
+	master := make([]byte, masterKeySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, err
+	}
+	return newKeys(master, names)
+}
+
+func newKeys(master []byte, names bool) (*Keys, error) {
+	if len(master) != masterKeySize {
+		return nil, fmt.Errorf("malformed master key")
+	}
+	k := &Keys{master: master, Names: names}
+	for _, sub := range []struct {
+		key  *[]byte
+		info string
+	}{
+		{&k.content, "content"},
+		{&k.mac, "mac"},
+		{&k.names, "names"},
+		{&k.nonces, "name nonces"},
+	} {
+		key, err := deriveKey(master, nil, sub.info)
+		if err != nil {
+			return nil, err
+		}
+		*sub.key = key
+	}
+	return k, nil
+}
+
+func deriveKey(secret []byte, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("sfs e2ee "+info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// seal the keys with a passphrase, for keeping in the client's state file
+func (k *Keys) Seal(passphrase string) (string, error) {
+	var flags byte
+	if k.Names {
+		flags |= sealNames
+	}
+	return svc.WrapKey(append(bytes.Clone(k.master), flags), passphrase)
+}
+
+// open keys from Seal. returns service.ErrWrongPassword
+// if passphrase isn't the one they were sealed with.
+func OpenKeys(sealed string, passphrase string) (*Keys, error) {
+	data, err := svc.UnwrapKey(sealed, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != masterKeySize+1 {
+		return nil, fmt.Errorf("malformed keys")
+	}
+	return newKeys(data[:masterKeySize], data[masterKeySize]&sealNames != 0)
+}
+
+// export the keys so they can be imported on another device
+func (k *Keys) Export(passphrase string) (string, error) {
+	sealed, err := k.Seal(passphrase)
+	if err != nil {
+		return "", err
+	}
+	return exportPrefix + sealed, nil
+}
+
+// import keys from Export
+func ImportKeys(exported string, passphrase string) (*Keys, error) {
+	sealed, ok := strings.CutPrefix(strings.TrimSpace(exported), exportPrefix)
+	if !ok {
+		return nil, fmt.Errorf("not an exported sfs key")
+	}
+	return OpenKeys(sealed, passphrase)
+}
+
+// ------- contents --------------------------------
+
+// encrypt everything read from src and write it to dst
+func (k *Keys) Encrypt(dst io.Writer, src io.Reader) error {
+	salt := make([]byte, e2eeSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := deriveKey(k.content, salt, "file")
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(salt); err != nil {
+		return err
+	}
+	return svc.Encrypt(dst, src, key)
+}
+
+// decrypt contents from Encrypt
+func (k *Keys) Decrypt(src io.ReaderAt, size int64) (*svc.Decrypter, error) {
+	if size < e2eeSaltSize {
+		return nil, svc.ErrNotEncrypted
+	}
+	salt := make([]byte, e2eeSaltSize)
+	if _, err := src.ReadAt(salt, 0); err != nil {
+		return nil, err
+	}
+	key, err := deriveKey(k.content, salt, "file")
+	if err != nil {
+		return nil, err
+	}
+	size -= e2eeSaltSize
+	return svc.NewDecrypter(io.NewSectionReader(src, e2eeSaltSize, size), size, key)
+}
+
+// ------- checksums --------------------------------
+
+// keyed checksum of everything read from r
+func (k *Keys) ChecksumOf(r io.Reader) (string, error) {
+	h := hmac.New(sha256.New, k.mac)
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return svc.FormatChecksum(MACAlgorithm, h.Sum(nil)), nil
+}
+
+// keyed checksum of a file's contents
+func (k *Keys) Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return k.ChecksumOf(f)
+}
+
+// ------- names --------------------------------
+
+func (k *Keys) nameCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.names)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal a file or directory name. the same name always seals the same way.
+func (k *Keys) SealName(name string) (string, error) {
+	aead, err := k.nameCipher()
+	if err != nil {
+		return "", err
+	}
+	m := hmac.New(sha256.New, k.nonces)
+	m.Write([]byte(name))
+	nonce := m.Sum(nil)[:aead.NonceSize()]
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(name), nil)), nil
+}
+
+// open a name from SealName
+func (k *Keys) OpenName(sealed string) (string, error) {
+	aead, err := k.nameCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("malformed name: %q", sealed)
+	}
+	name, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to open name %q: %w", sealed, svc.ErrCorrupted)
+	}
+	return string(name), nil
+}
+
+// seal each part of a path
+func (k *Keys) SealPath(path string) (string, error) {
+	return k.mapPath(path, k.SealName)
+}
+
+// open a path from SealPath
+func (k *Keys) OpenPath(path string) (string, error) {
+	return k.mapPath(path, k.OpenName)
+}
+
+func (k *Keys) mapPath(path string, fn func(string) (string, error)) (string, error) {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		p, err := fn(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = p
+	}
+	return filepath.FromSlash(strings.Join(parts, "/")), nil
+}
+
+// ------- metadata --------------------------------
+
+// copy of a file's metadata to send to the server. its checksum is replaced
+// with a keyed one (left empty if the file can't be read), and its names
+// are sealed if enabled.
+func (k *Keys) SealFile(file *svc.File) (*svc.File, error) {
+	f, err := copyFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f.CheckSum = ""
+	f.Algorithm = MACAlgorithm
+	if file.ClientPath != "" {
+		if cs, err := k.Checksum(file.ClientPath); err == nil {
+			f.CheckSum = cs
+		}
+	}
+	if !k.Names {
+		return f, nil
+	}
+	if err := k.mapNames(k.SealName, &f.Name, f.NMap, &f.Path, &f.ClientPath); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open the names of a file from the server. names that weren't sealed
+// (i.e. added before names were) are left as they are.
+func (k *Keys) OpenFile(file *svc.File) *svc.File {
+	if !k.Names {
+		return file
+	}
+	open := func(name string) (string, error) {
+		if opened, err := k.OpenName(name); err == nil {
+			return opened, nil
+		}
+		return name, nil
+	}
+	k.mapNames(open, &file.Name, file.NMap, &file.Path, &file.ClientPath)
+	return file
+}
+
+// copy of a directory's metadata to send to the server,
+// with its names sealed if enabled.
+func (k *Keys) SealDir(dir *svc.Directory) (*svc.Directory, error) {
+	data, err := dir.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	d, err := svc.UnmarshalDirStr(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if !k.Names {
+		return d, nil
+	}
+	if err := k.mapNames(k.SealName, &d.Name, d.NMap, &d.Path, &d.ClientPath); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func copyFile(file *svc.File) (*svc.File, error) {
+	data, err := file.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	return svc.UnmarshalFileStr(string(data))
+}
+
+// apply fn to an item's name, its name map, and each part of its paths
+func (k *Keys) mapNames(fn func(string) (string, error), name *string, nmap svc.NameMap, paths ...*string) error {
+	var err error
+	if *name, err = fn(*name); err != nil {
+		return err
+	}
+	for id, n := range nmap {
+		if nmap[id], err = fn(n); err != nil {
+			return err
+		}
+	}
+	for _, path := range paths {
+		if *path, err = k.mapPath(*path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestE2EEKeys(t *testing.T) {
+	env.SetEnv(false)
+
+	keys, err := NewKeys(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sealed keys only open with the same passphrase
+	sealed, err := keys.Seal("family photos")
+	assert.NoError(t, err)
+	opened, err := OpenKeys(sealed, "family photos")
+	assert.NoError(t, err)
+	assert.Equal(t, keys.master, opened.master)
+	assert.True(t, opened.Names)
+	_, err = OpenKeys(sealed, "something else")
+	assert.True(t, errors.Is(err, svc.ErrWrongPassword))
+
+	// exported keys work the same on another device
+	exported, err := keys.Export("second device")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(exported, exportPrefix))
+	imported, err := ImportKeys(exported+"\n", "second device")
+	assert.NoError(t, err)
+	assert.Equal(t, keys.mac, imported.mac)
+	_, err = ImportKeys(sealed, "family photos")
+	assert.Error(t, err)
+}
+
+func TestE2EEContents(t *testing.T) {
+	env.SetEnv(false)
+
+	keys, err := NewKeys(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(strings.Repeat(txtData, 5000))
+
+	var enc bytes.Buffer
+	assert.NoError(t, keys.Encrypt(&enc, bytes.NewReader(data)))
+	assert.False(t, bytes.Contains(enc.Bytes(), []byte(txtData)))
+
+	d, err := keys.Decrypt(bytes.NewReader(enc.Bytes()), int64(enc.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := io.ReadAll(d)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, dec))
+
+	// other keys can't open it
+	other, _ := NewKeys(false)
+	_, err = other.Decrypt(bytes.NewReader(enc.Bytes()), int64(enc.Len()))
+	assert.True(t, errors.Is(err, svc.ErrCorrupted))
+
+	// checksums are keyed
+	cs, err := keys.ChecksumOf(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, MACAlgorithm, svc.ChecksumAlgorithm(cs))
+	again, _ := keys.ChecksumOf(bytes.NewReader(data))
+	assert.Equal(t, cs, again)
+	theirs, _ := other.ChecksumOf(bytes.NewReader(data))
+	assert.NotEqual(t, cs, theirs)
+	plain, _ := svc.ChecksumOf(bytes.NewReader(data), svc.SHA256)
+	assert.NotEqual(t, svc.ChecksumAlgorithm(plain), svc.ChecksumAlgorithm(cs))
+}
+
+func TestE2EENames(t *testing.T) {
+	env.SetEnv(false)
+
+	keys, err := NewKeys(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := keys.SealPath("/home/me/sfs/taxes/2024.pdf")
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(sealed, "taxes"))
+	assert.True(t, strings.HasPrefix(sealed, string(filepath.Separator)))
+	opened, err := keys.OpenPath(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.FromSlash("/home/me/sfs/taxes/2024.pdf"), opened)
+
+	// the same name always seals the same way, so paths line up
+	other, _ := keys.SealPath("/home/me/sfs/taxes/2023.pdf")
+	assert.Equal(t, filepath.Dir(sealed), filepath.Dir(other))
+
+	_, err = keys.OpenName("bm90IHNlYWxlZA")
+	assert.Error(t, err)
+}
+
+func TestE2EETransfer(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "secret.txt"), 1000)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	orig, err := os.ReadFile(file.ClientPath)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	var (
+		stored []byte
+		sent   *svc.File
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			info, err := auth.NewT().Validate(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if sent, err = svc.UnmarshalFileStr(info); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			f, _, err := r.FormFile("myFile")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			stored, _ = io.ReadAll(f)
+		case http.MethodGet:
+			w.Write(stored)
+		}
+	}))
+	defer srv.Close()
+
+	tr := NewTransfer()
+	if tr.Keys, err = NewKeys(true); err != nil {
+		Fail(t, testDir, err)
+	}
+
+	// the server only sees ciphertext, a keyed checksum, and sealed names
+	if err := tr.Upload(http.MethodPost, file, srv.URL); err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.False(t, bytes.Contains(stored, []byte(txtData)))
+	mac, _ := tr.Keys.Checksum(file.ClientPath)
+	assert.Equal(t, mac, sent.CheckSum)
+	assert.Equal(t, MACAlgorithm, sent.Algorithm)
+	assert.False(t, strings.Contains(sent.Name, "secret"))
+	assert.False(t, strings.Contains(sent.ClientPath, "secret"))
+	assert.Equal(t, file.ID, sent.ID)
+	assert.Equal(t, file.ClientPath, tr.Keys.OpenFile(sent).ClientPath)
+
+	// deltas aren't used, and downloads are decrypted
+	if err := os.Remove(file.ClientPath); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := tr.DownloadDelta(file, srv.URL); err != nil {
+		Fail(t, testDir, err)
+	}
+	got, err := os.ReadFile(file.ClientPath)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.True(t, bytes.Equal(orig, got))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
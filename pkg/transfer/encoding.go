@@ -0,0 +1,286 @@
+package transfer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+content encodings.
+
+file contents are compressed on the fly while they're sent, if the other side
+supports it and they're likely to get any smaller. downloads ask for an
+encoding with Accept-Encoding as usual. the server lists the encodings it
+accepts for uploads in an Accept-Encoding header on every response (RFC 7694),
+so clients only compress uploads once they've seen one, and servers that don't
+know about compression are never sent compressed contents.
+
+contents that are already compressed (images, video, archives, etc.) are sent
+as they are, and so are the contents of end-to-end encrypted drives, since
+ciphertext doesn't compress.
+*/
+
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// supported encodings, most preferred first
+var Encodings = []string{EncodingZstd, EncodingGzip}
+
+// Accept-Encoding header value listing the supported encodings
+var AcceptEncoding = strings.Join(Encodings, ", ")
+
+// contents smaller than this aren't worth compressing
+var CompressMinSize int64 = 1024
+
+// whether an encoding is supported
+func SupportedEncoding(enc string) bool {
+	for _, e := range Encodings {
+		if e == enc {
+			return true
+		}
+	}
+	return false
+}
+
+// pick the most preferred supported encoding from an Accept-Encoding header.
+// returns an empty string if none of them are acceptable.
+func NegotiateEncoding(accept string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if w, err := strconv.ParseFloat(q, 64); err == nil {
+				weight = w
+			}
+		}
+		weights[name] = weight
+	}
+	anyQ, hasAny := weights["*"]
+	var (
+		best  string
+		bestQ float64
+	)
+	for _, enc := range Encodings {
+		w, ok := weights[enc]
+		if !ok && hasAny {
+			w, ok = anyQ, true
+		}
+		if ok && w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
+
+// extensions of formats that are already compressed
+var compressedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".mov": true, ".mkv": true, ".avi": true, ".webm": true, ".m4v": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".epub": true, ".jar": true, ".apk": true,
+	".pdf": true, ".woff": true, ".woff2": true,
+}
+
+// mime types (or prefixes, ending in /) of formats that are already compressed
+var compressedTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2",
+	"application/x-xz", "application/zstd", "application/x-7z-compressed",
+	"application/x-rar-compressed", "application/vnd.rar", "application/pdf", "application/wasm",
+}
+
+// image formats that aren't compressed
+var uncompressedImages = []string{"image/svg+xml", "image/bmp", "image/x-icon", "image/vnd.microsoft.icon", "image/tiff"}
+
+func compressedType(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	for _, t := range uncompressedImages {
+		if mimeType == t {
+			return false
+		}
+	}
+	for _, t := range compressedTypes {
+		if mimeType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mimeType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// whether contents are worth compressing, going by their name and
+// their first few bytes (see http.DetectContentType)
+func Compressible(name string, head []byte) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if compressedExts[ext] || compressedType(mime.TypeByExtension(ext)) {
+		return false
+	}
+	return !compressedType(http.DetectContentType(head))
+}
+
+// whether a file's contents are worth compressing
+func CompressibleFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() < CompressMinSize {
+		return false
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	return Compressible(path, head[:n])
+}
+
+// compress everything written to w with an encoding.
+// Close has to be called to finish the stream.
+func NewEncoder(w io.Writer, enc string) (io.WriteCloser, error) {
+	switch enc {
+	case EncodingZstd:
+		return zstd.NewWriter(w)
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", enc)
+	}
+}
+
+// decompress everything read from r with an encoding
+func NewDecoder(r io.Reader, enc string) (io.ReadCloser, error) {
+	switch enc {
+	case EncodingZstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", enc)
+	}
+}
+
+// compress the contents of a buffer
+func encodeBuffer(buf *bytes.Buffer, enc string) (*bytes.Buffer, error) {
+	out := new(bytes.Buffer)
+	zw, err := NewEncoder(out, enc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := buf.WriteTo(zw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// counts the bytes written through it
+type CountingWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.W.Write(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// counts the bytes read through it
+type CountingReader struct {
+	R io.Reader
+	N int64
+}
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.R.Read(p)
+	c.N += int64(n)
+	return n, err
+}
+
+// describe how much was saved by sending size bytes as sent bytes with an encoding
+func Savings(enc string, size int64, sent int64) string {
+	saved := size - sent
+	var pct float64
+	if size > 0 {
+		pct = float64(saved) / float64(size) * 100
+	}
+	return fmt.Sprintf("%d bytes sent as %d with %s, %d bytes saved (%.0f%%)", size, sent, enc, saved, pct)
+}
+
+// ------- negotiation --------------------------------
+
+// encodings the server accepts for uploads, as far as we know
+type serverEncodings struct {
+	mu  sync.RWMutex
+	enc string
+}
+
+// pick up the encodings the server says it accepts
+func (s *serverEncodings) note(resp *http.Response) {
+	accept := resp.Header.Get("Accept-Encoding")
+	if accept == "" {
+		return
+	}
+	s.mu.Lock()
+	s.enc = NegotiateEncoding(accept)
+	s.mu.Unlock()
+}
+
+func (s *serverEncodings) get() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enc
+}
+
+// notes the encodings the server accepts from every response through it
+type negotiatingTransport struct {
+	base http.RoundTripper
+	t    *Transfer
+}
+
+func (nt *negotiatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := nt.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	nt.t.encodings.note(resp)
+	return resp, nil
+}
+
+// the encoding to upload a file's contents with, if any. contents of
+// end-to-end encrypted drives and contents that won't get any smaller
+// aren't compressed, and nothing is until the server says it accepts it.
+func (t *Transfer) uploadEncoding(path string) string {
+	if t.Keys != nil {
+		return ""
+	}
+	enc := t.encodings.get()
+	if enc == "" || !CompressibleFile(path) {
+		return ""
+	}
+	return enc
+}
@@ -0,0 +1,162 @@
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	env.SetEnv(false)
+
+	assert.Equal(t, EncodingZstd, NegotiateEncoding("gzip, zstd"))
+	assert.Equal(t, EncodingGzip, NegotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, EncodingGzip, NegotiateEncoding("zstd;q=0.5, gzip"))
+	assert.Equal(t, EncodingGzip, NegotiateEncoding("zstd;q=0, *"))
+	assert.Equal(t, EncodingZstd, NegotiateEncoding("*"))
+	assert.Equal(t, "", NegotiateEncoding("deflate, br"))
+	assert.Equal(t, "", NegotiateEncoding(""))
+}
+
+func TestCompressible(t *testing.T) {
+	env.SetEnv(false)
+
+	text := []byte(strings.Repeat(txtData, 20))
+	assert.True(t, Compressible("notes.txt", text))
+	assert.True(t, Compressible("data.csv", text))
+	assert.True(t, Compressible("server.log", text))
+	assert.True(t, Compressible("no-extension", text))
+	assert.True(t, Compressible("drawing.svg", []byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>")))
+
+	// by extension
+	assert.False(t, Compressible("photo.JPG", text))
+	assert.False(t, Compressible("movie.mp4", text))
+	assert.False(t, Compressible("backup.zip", text))
+
+	// by contents
+	assert.False(t, Compressible("misnamed.txt", []byte("\x89PNG\x0D\x0A\x1A\x0A")))
+	assert.False(t, Compressible("misnamed.txt", []byte("PK\x03\x04")))
+	assert.False(t, Compressible("misnamed.txt", []byte("\x1F\x8B\x08")))
+
+	for _, enc := range Encodings {
+		var buf bytes.Buffer
+		zw, err := NewEncoder(&buf, enc)
+		assert.NoError(t, err)
+		_, err = zw.Write(text)
+		assert.NoError(t, err)
+		assert.NoError(t, zw.Close())
+		assert.True(t, buf.Len() < len(text))
+
+		zr, err := NewDecoder(&buf, enc)
+		assert.NoError(t, err)
+		got, err := io.ReadAll(zr)
+		assert.NoError(t, err)
+		assert.Equal(t, text, got)
+		zr.Close()
+	}
+	_, err := NewEncoder(io.Discard, "br")
+	assert.Error(t, err)
+}
+
+func TestCompressedTransfers(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "server.log"), 2000)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	data, err := os.ReadFile(file.ClientPath)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	var (
+		stored   []byte
+		received int64 // compressed bytes received
+		sentAs   string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Encoding", AcceptEncoding)
+		switch r.Method {
+		case http.MethodPut:
+			sentAs = r.Header.Get("Content-Encoding")
+			if sentAs != "" {
+				body := &CountingReader{R: r.Body}
+				zr, err := NewDecoder(body, sentAs)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				defer func() { received = body.N }()
+				r.Body = zr
+				r.Header.Del("Content-Encoding")
+			}
+			f, _, err := r.FormFile("myFile")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			stored, _ = io.ReadAll(f)
+		case http.MethodGet:
+			if strings.HasPrefix(r.URL.Path, "/v1/blobs/") {
+				http.NotFound(w, r)
+				return
+			}
+			enc := NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+			w.Header().Set("Content-Encoding", enc)
+			zw, _ := NewEncoder(w, enc)
+			zw.Write(stored)
+			zw.Close()
+		}
+	}))
+	defer srv.Close()
+
+	// the server says it takes compressed uploads when
+	// it's asked whether it already has the contents
+	tr := NewTransfer()
+	if err := tr.Upload(http.MethodPut, file, srv.URL+"/v1/files/"+file.ID); err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, EncodingZstd, sentAs)
+	assert.Equal(t, data, stored)
+	assert.True(t, received < int64(len(data)))
+
+	// downloads are decompressed
+	if err := os.Remove(file.ClientPath); err != nil {
+		Fail(t, testDir, err)
+	}
+	if err := tr.Download(file.ClientPath, srv.URL+"/v1/files/"+file.ID, file.CheckSum); err != nil {
+		Fail(t, testDir, err)
+	}
+	got, err := os.ReadFile(file.ClientPath)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, data, got)
+
+	// nothing is compressed for servers that don't say they accept it
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			sentAs = r.Header.Get("Content-Encoding")
+		}
+		http.NotFound(w, r)
+	}))
+	defer plain.Close()
+	tr = NewTransfer()
+	tr.Upload(http.MethodPut, file, plain.URL+"/v1/files/"+file.ID)
+	assert.Equal(t, "", sentAs)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,274 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+bandwidth limits.
+
+uploads and downloads are each limited with a token bucket shared by every
+transfer, so running more of them at once doesn't use any more bandwidth.
+limits can be set for the whole day, and for windows of time during the day
+(i.e. 1 MB/s from 08:00 to 23:00 and unlimited overnight). the first window
+the current time falls in is used, otherwise the limits for the whole day are.
+a limit of 0 is unlimited.
+
+limits are applied to everything sent with Transfer.Client, and can be
+changed at any time with Transfer.SetLimits, including during a transfer.
+*/
+
+// the most that's read at once from a limited request or response,
+// so transfers are spread evenly over each second
+const limitChunkSize = 32 * 1024
+
+// upload and download limits in bytes per second
+type Limits struct {
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+	Windows  []*Window `json:"windows,omitempty"`
+}
+
+// limits for part of the day. times are in local time, formatted as HH:MM.
+// windows that end before they start run past midnight, and windows that
+// start and end at the same time last all day.
+type Window struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Upload   int64  `json:"upload"`
+	Download int64  `json:"download"`
+}
+
+// create a window from a span formatted as HH:MM-HH:MM
+func NewWindow(span string, upload int64, download int64) (*Window, error) {
+	start, end, ok := strings.Cut(span, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid window %q: expected HH:MM-HH:MM", span)
+	}
+	w := &Window{Start: strings.TrimSpace(start), End: strings.TrimSpace(end), Upload: upload, Download: download}
+	if _, err := minuteOfDay(w.Start); err != nil {
+		return nil, err
+	}
+	if _, err := minuteOfDay(w.End); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// whether t is in this window
+func (w *Window) Contains(t time.Time) bool {
+	start, err := minuteOfDay(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := minuteOfDay(w.End)
+	if err != nil {
+		return false
+	}
+	m := t.Hour()*60 + t.Minute()
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return m >= start && m < end
+	default:
+		return m >= start || m < end
+	}
+}
+
+// the span of this window, formatted as HH:MM-HH:MM
+func (w *Window) Span() string { return w.Start + "-" + w.End }
+
+// upload and download limits at time t
+func (l *Limits) Rates(t time.Time) (int64, int64) {
+	if l == nil {
+		return 0, 0
+	}
+	for _, w := range l.Windows {
+		if w.Contains(t) {
+			return w.Upload, w.Download
+		}
+	}
+	return l.Upload, l.Download
+}
+
+// add a window, replacing any window with the same span
+func (l *Limits) SetWindow(w *Window) {
+	for i, cur := range l.Windows {
+		if cur.Span() == w.Span() {
+			l.Windows[i] = w
+			return
+		}
+	}
+	l.Windows = append(l.Windows, w)
+}
+
+// whether nothing is limited
+func (l *Limits) IsEmpty() bool {
+	return l == nil || (l.Upload == 0 && l.Download == 0 && len(l.Windows) == 0)
+}
+
+var rateUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"GB", 1e9},
+	{"MB", 1e6},
+	{"KB", 1e3},
+	{"B", 1},
+}
+
+// parse a rate like 1MB, 512KB, or 1.5MB/s into bytes per second.
+// 0 and "unlimited" are both unlimited.
+func ParseRate(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "/S")
+	if s == "" || s == "0" || s == "UNLIMITED" {
+		return 0, nil
+	}
+	for _, u := range rateUnits {
+		if n, ok := strings.CutSuffix(s, u.suffix); ok {
+			v, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+			if err != nil || v < 0 {
+				break
+			}
+			return int64(v * u.size), nil
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q: expected something like 1MB or 512KB", s)
+	}
+	return v, nil
+}
+
+// format a rate in bytes per second for display, i.e. 1.5 MB/s
+func FormatRate(rate int64) string {
+	if rate <= 0 {
+		return "unlimited"
+	}
+	for _, u := range rateUnits {
+		if float64(rate) >= u.size {
+			return strings.TrimSuffix(strings.TrimSuffix(fmt.Sprintf("%.2f", float64(rate)/u.size), "0"), ".0") + " " + u.suffix + "/s"
+		}
+	}
+	return fmt.Sprintf("%d B/s", rate)
+}
+
+// ------- token buckets --------------------------------
+
+// a token bucket holding up to a second's worth of bytes
+type bucket struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// take n bytes from the bucket, waiting until they're available.
+// does nothing if rate is 0. transfers that take more than what's
+// in the bucket put it in debt, so whoever's next waits for them.
+func (b *bucket) wait(n int, rate int64) {
+	if rate <= 0 || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	if rate != b.rate {
+		// start over at the new rate
+		b.rate = rate
+		b.tokens = float64(rate)
+		b.last = now
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// ------- limited transfers --------------------------------
+
+// set the upload and download limits. takes effect immediately,
+// including for transfers that are already running. nil removes them.
+func (t *Transfer) SetLimits(l *Limits) {
+	t.limitMu.Lock()
+	defer t.limitMu.Unlock()
+	t.limits = l
+}
+
+// get the current upload and download limits. nil if there aren't any.
+func (t *Transfer) Limits() *Limits {
+	t.limitMu.RLock()
+	defer t.limitMu.RUnlock()
+	return t.limits
+}
+
+func (t *Transfer) rates() (int64, int64) {
+	return t.Limits().Rates(time.Now())
+}
+
+func (t *Transfer) waitUpload(n int) {
+	up, _ := t.rates()
+	t.up.wait(n, up)
+}
+
+func (t *Transfer) waitDownload(n int) {
+	_, down := t.rates()
+	t.down.wait(n, down)
+}
+
+// a request or response body that's read no faster than the limit allows
+type limitedBody struct {
+	io.ReadCloser
+	wait func(n int)
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > limitChunkSize {
+		p = p[:limitChunkSize]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.wait(n)
+	return n, err
+}
+
+// limits the bodies of everything sent and received through it
+type limitedTransport struct {
+	base http.RoundTripper
+	t    *Transfer
+}
+
+func (lt *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		req.Body = &limitedBody{ReadCloser: req.Body, wait: lt.t.waitUpload}
+	}
+	resp, err := lt.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, wait: lt.t.waitDownload}
+	return resp, nil
+}
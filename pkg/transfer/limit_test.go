@@ -0,0 +1,121 @@
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/env"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseRate(t *testing.T) {
+	env.SetEnv(false)
+
+	for in, want := range map[string]int64{
+		"0":         0,
+		"unlimited": 0,
+		"512":       512,
+		"512KB":     512000,
+		"1MB":       1000000,
+		"1.5mb/s":   1500000,
+		"2GB":       2000000000,
+	} {
+		got, err := ParseRate(in)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	for _, in := range []string{"fast", "-1MB", "1TB"} {
+		_, err := ParseRate(in)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, "1.5 MB/s", FormatRate(1500000))
+	assert.Equal(t, "512 KB/s", FormatRate(512000))
+	assert.Equal(t, "unlimited", FormatRate(0))
+}
+
+func TestLimitWindows(t *testing.T) {
+	env.SetEnv(false)
+
+	day, err := NewWindow("08:00-23:00", 1000000, 2000000)
+	assert.NoError(t, err)
+	night, err := NewWindow("23:30-06:00", 10, 20)
+	assert.NoError(t, err)
+	_, err = NewWindow("8am to 11pm", 0, 0)
+	assert.Error(t, err)
+
+	l := &Limits{Upload: 5, Download: 6, Windows: []*Window{day, night}}
+	at := func(hhmm string) time.Time {
+		tm, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	up, down := l.Rates(at("12:00"))
+	assert.Equal(t, int64(1000000), up)
+	assert.Equal(t, int64(2000000), down)
+
+	// windows can run past midnight
+	up, down = l.Rates(at("02:00"))
+	assert.Equal(t, int64(10), up)
+	assert.Equal(t, int64(20), down)
+
+	// outside of every window the limits for the whole day are used
+	up, down = l.Rates(at("23:15"))
+	assert.Equal(t, int64(5), up)
+	assert.Equal(t, int64(6), down)
+
+	// windows with the same span are replaced
+	l.SetWindow(&Window{Start: "08:00", End: "23:00"})
+	assert.Equal(t, 2, len(l.Windows))
+	up, _ = l.Rates(at("12:00"))
+	assert.Equal(t, int64(0), up)
+
+	var none *Limits
+	assert.True(t, none.IsEmpty())
+	up, down = none.Rates(at("12:00"))
+	assert.Equal(t, int64(0), up+down)
+}
+
+func TestLimitedTransfer(t *testing.T) {
+	env.SetEnv(false)
+
+	data := bytes.Repeat([]byte("a"), 64*1024)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	get := func(tr *Transfer) time.Duration {
+		start := time.Now()
+		resp, err := tr.Client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		got, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), len(got))
+		return time.Since(start)
+	}
+
+	tr := NewTransfer()
+	assert.True(t, get(tr) < 500*time.Millisecond)
+
+	// the first second's worth is free, the rest waits its turn
+	tr.SetLimits(&Limits{Download: 32 * 1024})
+	assert.True(t, get(tr) >= 900*time.Millisecond)
+
+	// uploads aren't limited by download limits
+	start := time.Now()
+	resp, err := tr.Client.Post(srv.URL, "text/plain", bytes.NewReader(data[:16*1024]))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
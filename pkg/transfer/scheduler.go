@@ -0,0 +1,162 @@
+package transfer
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+transfer scheduling.
+
+files are grouped into batches (see service/batch.go) and each batch is
+handed to a fixed number of workers. a batch is finished before the next
+one starts, so only Workers transfers are ever running at once no matter
+how many files there are.
+
+pinned files go first, in batches of their own. then everything else,
+smallest first, so a sync makes visible progress before it gets to the
+large files.
+*/
+
+// number of transfers run at once if none is given
+const DefaultWorkers = 4
+
+// a file that couldn't be transferred
+type FileError struct {
+	File *svc.File
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.File.Name, e.Err)
+}
+
+func (e *FileError) Unwrap() error { return e.Err }
+
+// every file that couldn't be transferred during a run
+type Errors []*FileError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("%d file(s) failed to transfer: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// nil if nothing failed, otherwise e
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// runs transfers with a bounded number of workers
+type Scheduler struct {
+	Workers int      // number of transfers to run at once
+	Pinned  []string // files, or directories of files, to transfer before anything else
+}
+
+// create a new scheduler. workers <= 0 uses DefaultWorkers.
+func NewScheduler(workers int, pinned []string) *Scheduler {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return &Scheduler{
+		Workers: workers,
+		Pinned:  pinned,
+	}
+}
+
+// whether a file is in one of the pinned paths
+func (s *Scheduler) IsPinned(file *svc.File) bool {
+	path := filepath.Clean(file.GetPath())
+	for _, p := range s.Pinned {
+		p = filepath.Clean(p)
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// build a queue of batches for files. batches of pinned files come first.
+func (s *Scheduler) Queue(files []*svc.File) *svc.Queue {
+	pinned := make([]*svc.File, 0)
+	rest := make([]*svc.File, 0, len(files))
+	for _, file := range files {
+		if s.IsPinned(file) {
+			pinned = append(pinned, file)
+		} else {
+			rest = append(rest, file)
+		}
+	}
+	q := svc.BuildFileQ(pinned)
+	others := svc.BuildFileQ(rest)
+	for b := others.Dequeue(); b != nil; b = others.Dequeue() {
+		q.Enqueue(b)
+	}
+	return q
+}
+
+// order the files in a batch, pinned files first, then smallest first
+func (s *Scheduler) order(b *svc.Batch) []*svc.File {
+	files := make([]*svc.File, 0, len(b.Files))
+	for _, file := range b.Files {
+		files = append(files, file)
+	}
+	sizes := make(map[string]int64, len(files))
+	for _, file := range files {
+		sizes[file.ID] = file.GetSize()
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		pi, pj := s.IsPinned(files[i]), s.IsPinned(files[j])
+		if pi != pj {
+			return pi
+		}
+		if sizes[files[i].ID] != sizes[files[j].ID] {
+			return sizes[files[i].ID] < sizes[files[j].ID]
+		}
+		return files[i].GetPath() < files[j].GetPath()
+	})
+	return files
+}
+
+// run transfer on every file in the queue, one batch at a time,
+// with at most s.Workers running at once. returns the files that
+// failed and why, if any did.
+func (s *Scheduler) Run(q *svc.Queue, transfer func(file *svc.File) error) Errors {
+	var (
+		mu   sync.Mutex
+		errs = make(Errors, 0)
+	)
+	for b := q.Dequeue(); b != nil; b = q.Dequeue() {
+		files := make(chan *svc.File)
+		var wg sync.WaitGroup
+		for i := 0; i < s.Workers && i < len(b.Files); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for file := range files {
+					if err := transfer(file); err != nil {
+						mu.Lock()
+						errs = append(errs, &FileError{File: file, Err: err})
+						mu.Unlock()
+					}
+				}
+			}()
+		}
+		for _, file := range s.order(b) {
+			files <- file
+		}
+		close(files)
+		wg.Wait()
+	}
+	return errs
+}
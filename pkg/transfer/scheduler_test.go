@@ -0,0 +1,93 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestScheduler(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+	pinnedDir := filepath.Join(testDir, "pinned")
+	if err := os.Mkdir(pinnedDir, 0755); err != nil {
+		Fail(t, testDir, err)
+	}
+	files := make([]*svc.File, 0)
+	for i := 1; i <= 20; i++ {
+		file, err := MakeTmpTxtFile(filepath.Join(testDir, fmt.Sprintf("tmp-%d.txt", i)), i)
+		if err != nil {
+			Fail(t, testDir, err)
+		}
+		files = append(files, file)
+	}
+	// largest of all, but pinned
+	pinned, err := MakeTmpTxtFile(filepath.Join(pinnedDir, "first.txt"), 100)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	files = append(files, pinned)
+
+	s := NewScheduler(3, []string{pinnedDir})
+	assert.True(t, s.IsPinned(pinned))
+	assert.False(t, s.IsPinned(files[0]))
+
+	var (
+		mu      sync.Mutex
+		order   = make([]*svc.File, 0)
+		running int32
+		most    int32
+	)
+	errs := s.Run(s.Queue(files), func(file *svc.File) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		mu.Lock()
+		order = append(order, file)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		if file.Name == "tmp-7.txt" {
+			return errors.New("server went away")
+		}
+		return nil
+	})
+
+	// every file is tried once, with no more than 3 running at once
+	assert.Equal(t, len(files), len(order))
+	assert.True(t, most <= 3)
+
+	// pinned files go first, and the rest start smallest first
+	assert.Equal(t, pinned.ID, order[0].ID)
+	first := make(map[string]bool)
+	for _, file := range order[1:4] {
+		first[file.Name] = true
+	}
+	assert.Equal(t, map[string]bool{"tmp-1.txt": true, "tmp-2.txt": true, "tmp-3.txt": true}, first)
+
+	// failures are returned to the caller
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, "tmp-7.txt", errs[0].File.Name)
+	assert.Error(t, errs.Err())
+	assert.Contains(t, errs.Error(), "server went away")
+	assert.NoError(t, Errors{}.Err())
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,13 +3,18 @@ package transfer
 import (
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sfs/pkg/auth"
@@ -17,6 +22,10 @@ import (
 	svc "github.com/sfs/pkg/service"
 )
 
+// files smaller than this are uploaded without first asking
+// the server whether it already has their contents.
+var BlobMinSize int64 = 64 * 1024
+
 // transfer handles the uploading and downloading of individual files
 // during synchronization events as well as one off file transfer
 // API calls.
@@ -24,19 +33,42 @@ type Transfer struct {
 	Tok    *auth.Token
 	log    *logger.Logger
 	Client *http.Client
+
+	// keys for end-to-end encrypted drives. when set, file contents and
+	// metadata are sealed before they're sent, and contents are opened
+	// after they're downloaded. see e2ee.go
+	Keys *Keys
+
+	// upload and download limits. see limit.go
+	limitMu sync.RWMutex
+	limits  *Limits
+	up      bucket
+	down    bucket
+
+	// encodings the server accepts for uploads. see encoding.go
+	encodings serverEncodings
 }
 
 func NewTransfer() *Transfer {
-	return &Transfer{
+	t := &Transfer{
 		Tok: auth.NewT(),
 		log: logger.NewLogger("Transfer", "None"),
-		Client: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	// no overall timeout, since a large file sent under a bandwidth
+	// limit can take much longer than any fixed timeout would allow.
+	t.Client = &http.Client{
+		Transport: &negotiatingTransport{
+			base: &limitedTransport{
+				base: &http.Transport{
+					TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+					ResponseHeaderTimeout: 30 * time.Second,
+				},
+				t: t,
 			},
+			t: t,
 		},
 	}
+	return t
 }
 
 func (t *Transfer) dump(resp *http.Response, body bool) {
@@ -57,9 +89,14 @@ func (t *Transfer) CreateArchive(path string) error {
 	return Zip(path, path+".zip")
 }
 
-// extract contents of a zip file archive
+// extract the contents of an archive next to it. the format
+// is taken from its extension (see archive.go)
 func (t *Transfer) ExtractArchive(path string) error {
-	return Unzip(path, filepath.Dir(path))
+	format, err := ArchiveFormatOf(path)
+	if err != nil {
+		return err
+	}
+	return ExtractArchive(path, filepath.Dir(path), format, DefaultArchiveLimits)
 }
 
 // prepare file transfer request header.
@@ -70,6 +107,11 @@ func (t *Transfer) PrepareFileReq(method string, destURL string, contentType str
 	}
 
 	// add file metadata to token
+	if t.Keys != nil {
+		if file, err = t.Keys.SealFile(file); err != nil {
+			return nil, fmt.Errorf("failed to seal file metadata: %v", err)
+		}
+	}
 	fileData, err := file.ToJSON()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file json string: %v", err)
@@ -87,14 +129,46 @@ func (t *Transfer) PrepareFileReq(method string, destURL string, contentType str
 // prepare and transfer a file for upload or download to the server.
 // server will handle whether this is a new file or an update to an existing file,
 // usually determined by the method.
+//
+// if the server already has the file's contents (i.e. from another file with
+// the same contents), only the file's checksum is sent.
+//
+// contents are encrypted first for end-to-end encrypted drives.
+//
+// files of at least ResumableMinSize are uploaded in parts. see uploads.go
 func (t *Transfer) Upload(method string, file *svc.File, destURL string) error {
+	if t.Keys == nil {
+		if sent, err := t.uploadBlob(method, file, destURL); err != nil || sent {
+			return err
+		}
+	}
+	if info, err := os.Stat(file.ClientPath); err == nil && info.Size() >= ResumableMinSize {
+		err := t.UploadResumable(method, file, destURL)
+		if !errors.Is(err, ErrNoUploadSessions) {
+			return err
+		}
+		t.log.Warn(fmt.Sprintf("%v. uploading %s all at once...", err, file.Name))
+		if method == http.MethodPost {
+			// the file was already added without its contents
+			method, destURL = http.MethodPut, file.Endpoint
+		}
+	}
+	return t.uploadForm(method, file, destURL)
+}
+
+// upload a file's contents all at once as a multipart form
+func (t *Transfer) uploadForm(method string, file *svc.File, destURL string) error {
 	var (
-		buf = new(bytes.Buffer)
-		w   = multipart.NewWriter(buf)
+		buf  = new(bytes.Buffer)
+		w    = multipart.NewWriter(buf)
+		name = filepath.Base(file.Path)
 	)
+	if t.Keys != nil {
+		name = file.ID
+	}
 
 	// create form file writer and prepare request
-	fw, err := w.CreateFormFile("myFile", filepath.Base(file.Path))
+	fw, err := w.CreateFormFile("myFile", name)
 	if err != nil {
 		return err
 	}
@@ -104,18 +178,35 @@ func (t *Transfer) Upload(method string, file *svc.File, destURL string) error {
 	if err != nil {
 		return err
 	}
-	if _, err = fw.Write(data); err != nil {
+	if t.Keys != nil {
+		err = t.Keys.Encrypt(fw, bytes.NewReader(data))
+	} else {
+		_, err = fw.Write(data)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to retrieve file data: %v", err)
 	}
 	if err := w.Close(); err != nil {
 		t.log.Error("failed to close writer: " + err.Error())
 	}
 
+	// compress the form if the server accepts it
+	body, enc, size := buf, t.uploadEncoding(file.ClientPath), int64(buf.Len())
+	if enc != "" {
+		if body, err = encodeBuffer(buf, enc); err != nil {
+			return fmt.Errorf("failed to compress %s: %v", file.Name, err)
+		}
+	}
+	sent := int64(body.Len())
+
 	// prepare request
-	req, err := t.PrepareFileReq(method, destURL, w.FormDataContentType(), file, buf)
+	req, err := t.PrepareFileReq(method, destURL, w.FormDataContentType(), file, body)
 	if err != nil {
 		return err
 	}
+	if enc != "" {
+		req.Header.Set("Content-Encoding", enc)
+	}
 
 	// send request
 	t.log.Log("INFO", fmt.Sprintf("uploading %s to %s...", file.Name, file.Endpoint))
@@ -124,44 +215,430 @@ func (t *Transfer) Upload(method string, file *svc.File, destURL string) error {
 		return fmt.Errorf("failed to send HTTP request: %v", err)
 	}
 	t.dump(resp, true)
+	if resp.StatusCode == http.StatusConflict {
+		// the server has changes to this file we haven't seen yet
+		return fmt.Errorf("server rejected update to %s: %v", file.Name, resp.Status)
+	}
+	if enc != "" && resp.StatusCode == http.StatusOK {
+		t.log.Info(fmt.Sprintf("uploaded %s: %s", file.Name, Savings(enc, size, sent)))
+	}
 	return nil
 }
 
+// ask the server whether a drive already has contents with the given
+// checksum. srvURL can be any URL on the server.
+func (t *Transfer) HasBlob(checksum string, driveID string, srvURL string) (bool, error) {
+	u, err := url.Parse(srvURL)
+	if err != nil {
+		return false, fmt.Errorf("invalid server URL: %v", err)
+	}
+	u.Path = "/v1/blobs/" + url.PathEscape(checksum)
+	u.RawQuery = url.Values{"drive": {driveID}}.Encode()
+
+	resp, err := t.Client.Get(u.String())
+	if err != nil {
+		return false, fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("failed to check for blob: %v", resp.Status)
+	}
+}
+
+// send only a file's checksum if the server already has its contents.
+// returns false if the contents still need to be uploaded.
+func (t *Transfer) uploadBlob(method string, file *svc.File, destURL string) (bool, error) {
+	info, err := os.Stat(file.ClientPath)
+	if err != nil || info.Size() < BlobMinSize {
+		return false, nil
+	}
+	algo := file.Algorithm
+	if !svc.ValidAlgorithm(algo) {
+		algo = svc.DefaultAlgorithm
+	}
+	checksum, err := svc.CalculateChecksumWith(file.ClientPath, algo)
+	if err != nil {
+		return false, nil
+	}
+	if ok, err := t.HasBlob(checksum, file.DriveID, destURL); err != nil || !ok {
+		return false, nil
+	}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return false, fmt.Errorf("invalid destination URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("blob", checksum)
+	u.RawQuery = q.Encode()
+	req, err := t.PrepareFileReq(method, u.String(), "application/octet-stream", file, new(bytes.Buffer))
+	if err != nil {
+		return false, err
+	}
+	t.log.Log("INFO", fmt.Sprintf("server already has the contents of %s. sending checksum...", file.Name))
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return true, nil
+	case resp.StatusCode == http.StatusNotFound:
+		// blob was removed since we asked
+		return false, nil
+	case resp.StatusCode == http.StatusConflict:
+		return true, fmt.Errorf("server rejected update to %s: %v", file.Name, resp.Status)
+	}
+	t.dump(resp, true)
+	return true, fmt.Errorf("failed to upload %s: %v", file.Name, resp.Status)
+}
+
 // download a known file from the given URL (associated server API endpoint).
 //
-// intended to run in its own goroutine.
-// download a known file that is only on the server, and is new to the client
-func (t *Transfer) Download(destPath string, srcURL string) error {
-	resp, err := t.Client.Get(srcURL)
+// contents are written to a partial file next to destPath, and are only moved
+// into place once they match their checksum, so a failed download never leaves
+// a half-written file behind. if a partial file is already there from an earlier
+// attempt, only the rest of the contents are requested.
+//
+// checksum is the checksum the contents are expected to have. the server's
+// ETag is used instead if it sends one, since the file may have changed since.
+//
+// contents are decrypted for end-to-end encrypted drives.
+func (t *Transfer) Download(destPath string, srcURL string, checksum string) error {
+	part := partialPath(destPath)
+	for attempt := 0; ; attempt++ {
+		etag, resumed, err := t.fetchPartial(part, srcURL, checksum)
+		if err != nil {
+			return err
+		}
+		if etag != "" {
+			checksum = etag
+		}
+		err = t.finishDownload(part, destPath, checksum)
+		if !errors.Is(err, ErrChecksumMismatch) {
+			return err
+		}
+		// whatever we have is no good
+		os.Remove(part)
+		if !resumed || attempt > 0 {
+			return err
+		}
+		// the partial file was for different contents. start over
+		t.log.Warn(fmt.Sprintf("partial download of %s doesn't match. starting over...", filepath.Base(destPath)))
+	}
+}
+
+// downloaded contents, or contents rebuilt from a delta,
+// didn't match their checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// suffix for partially downloaded files
+const PartialSuffix = ".sfs-part"
+
+// path to the partial file for a download to destPath. these are hidden
+// files in the same directory, so they can be renamed into place.
+func partialPath(destPath string) string {
+	return filepath.Join(filepath.Dir(destPath), "."+filepath.Base(destPath)+PartialSuffix)
+}
+
+// download contents into a partial file, picking up where it left off if
+// the server's contents still match checksum. returns the server's ETag, if
+// any, and whether the download was resumed. the partial file is kept if the
+// download is interrupted.
+func (t *Transfer) fetchPartial(part string, srcURL string, checksum string) (string, bool, error) {
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, svc.PERMS)
 	if err != nil {
-		return fmt.Errorf("failed to execute http request: %v", err)
+		return "", false, fmt.Errorf("failed to create partial file: %v", err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		t.dump(resp, true)
-		// server may be having issues.
-		// does necessarily not mean a client error occurred.
-		return nil
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", false, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, srcURL, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	// asking for an encoding ourselves means the response
+	// isn't decompressed for us. see encoding.go
+	req.Header.Set("Accept-Encoding", AcceptEncoding)
+	if offset > 0 && checksum != "" {
+		// the server only sends the rest if its contents haven't changed
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", strconv.Quote(checksum))
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to execute http request: %v", err)
 	}
 	defer resp.Body.Close()
 
-	// create (or truncate) file
-	file, err := os.Create(destPath)
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(part), "."), PartialSuffix)
+	etag := parseETag(resp.Header.Get("ETag"))
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// the server sent everything, either because we didn't have
+		// anything yet or its contents changed since we started
+		if err := f.Truncate(0); err != nil {
+			return "", false, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", false, err
+		}
+		offset = 0
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			os.Remove(part)
+			return "", false, fmt.Errorf("server sent the wrong part of %s: %s", name, resp.Header.Get("Content-Range"))
+		}
+		t.log.Info(fmt.Sprintf("resuming download of %s from byte %d...", name, offset))
+	case http.StatusRequestedRangeNotSatisfiable:
+		// we already have everything
+		return etag, true, nil
+	default:
+		t.dump(resp, true)
+		return "", false, fmt.Errorf("failed to download %s: %v", name, resp.Status)
+	}
+
+	var (
+		body io.Reader = resp.Body
+		wire           = &CountingReader{R: resp.Body}
+		enc            = resp.Header.Get("Content-Encoding")
+	)
+	if enc != "" {
+		d, err := NewDecoder(wire, enc)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to decompress %s: %v", name, err)
+		}
+		defer d.Close()
+		body = d
+	}
+	// whatever was decompressed so far is kept, since
+	// the rest is asked for by its uncompressed offset
+	n, err := io.Copy(f, body)
+	if err != nil {
+		return "", false, fmt.Errorf("download of %s was interrupted: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", false, fmt.Errorf("failed to write out file data: %v", err)
+	}
+	if enc != "" {
+		t.log.Info(fmt.Sprintf("downloaded %s: %s", name, Savings(enc, n, wire.N)))
+	}
+	return etag, offset > 0, nil
+}
+
+// get the checksum from a strong ETag. returns an empty string for weak or missing ones.
+func parseETag(etag string) string {
+	cs, err := strconv.Unquote(etag)
+	if err != nil {
+		return ""
+	}
+	return cs
+}
+
+// decrypt a finished download if need be, check it against checksum,
+// and move it into place. checksums we don't recognize aren't checked.
+func (t *Transfer) finishDownload(part string, destPath string, checksum string) error {
+	src := part
+	if t.Keys != nil {
+		plain, err := t.openToTemp(part, destPath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(plain)
+		src = plain
+	}
+
+	var (
+		cs   string
+		err  error
+		algo = svc.ChecksumAlgorithm(checksum)
+	)
+	switch {
+	case algo == MACAlgorithm && t.Keys != nil:
+		cs, err = t.Keys.Checksum(src)
+	case svc.ValidAlgorithm(algo):
+		cs, err = svc.CalculateChecksumWith(src, algo)
+	default:
+		cs = checksum
+	}
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum for %s: %v", filepath.Base(destPath), err)
+	}
+	if cs != checksum {
+		return fmt.Errorf("%w for %s: expected %s, got %s", ErrChecksumMismatch, filepath.Base(destPath), checksum, cs)
+	}
+
+	if err := os.Rename(src, destPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %v", filepath.Base(destPath), err)
+	}
+	if src != part {
+		os.Remove(part)
+	}
+	t.log.Log("INFO", fmt.Sprintf("%s downloaded to %s", filepath.Base(destPath), destPath))
+	return nil
+}
+
+// decrypt a downloaded file into a temporary file next to destPath. returns its path.
+func (t *Transfer) openToTemp(part string, destPath string) (string, error) {
+	f, err := os.Open(part)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	d, err := t.Keys.Decrypt(f, info.Size())
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %v", err)
+		return "", fmt.Errorf("failed to decrypt %s: %v", filepath.Base(destPath), err)
 	}
-	defer file.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+"-*"+PartialSuffix)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, d); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to decrypt %s: %v", filepath.Base(destPath), err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// ------- delta transfers --------------------------------
 
-	// write out data
+// retrieve the signature for the servers copy of a file.
+// returns nil if the server doesn't have a base version of the file.
+func (t *Transfer) GetSignature(file *svc.File, sigURL string) (*Signature, error) {
+	req, err := t.PrepareFileReq(http.MethodGet, sigURL, "application/json", file, new(bytes.Buffer))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		return nil, fmt.Errorf("failed to get file signature. server returned: %v", resp.Status)
+	}
 	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
+	if _, err = io.Copy(&buf, resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	return UnmarshalSignature(buf.Bytes())
+}
+
+// upload only the parts of a file that have changed since the last time it was
+// sent to the server. falls back to a full upload if the file is small, the
+// server has no base version to build from, or the delta is rejected.
+// end-to-end encrypted drives always use full uploads.
+func (t *Transfer) UploadDelta(file *svc.File, destURL string) error {
+	if t.Keys != nil {
+		return t.Upload(http.MethodPut, file, destURL)
+	}
+	info, err := os.Stat(file.ClientPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() < DeltaMinSize {
+		return t.Upload(http.MethodPut, file, destURL)
+	}
+	sig, err := t.GetSignature(file, destURL+"/sig")
+	if err != nil {
+		t.log.Warn(fmt.Sprintf("failed to get signature for %s, falling back to full upload: %v", file.Name, err))
+		return t.Upload(http.MethodPut, file, destURL)
+	}
+	if sig == nil || sig.IsEmpty() {
+		return t.Upload(http.MethodPut, file, destURL)
+	}
+	delta, err := NewDelta(sig, file.ClientPath)
+	if err != nil {
+		return err
+	}
+	data, err := delta.ToJSON()
 	if err != nil {
-		return fmt.Errorf("failed to copy file data to buffer: %v", err)
+		return fmt.Errorf("failed to encode delta: %v", err)
 	}
-	_, err = file.Write(buf.Bytes())
+	req, err := t.PrepareFileReq(http.MethodPut, destURL+"/delta", "application/json", file, bytes.NewBuffer(data))
 	if err != nil {
-		return fmt.Errorf("failed to write out file data: %v", err)
+		return err
 	}
+	t.log.Log("INFO", fmt.Sprintf("uploading delta for %s (%d of %d bytes changed)...", file.Name, delta.DataSize(), delta.Size))
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// servers copy may have changed since we got the signature
+		t.dump(resp, true)
+		t.log.Warn(fmt.Sprintf("delta for %s was rejected, falling back to full upload", file.Name))
+		return t.Upload(http.MethodPut, file, destURL)
+	}
+	t.log.Log("INFO", fmt.Sprintf("%s updated. %d bytes saved", file.Name, delta.Size-delta.DataSize()))
+	return nil
+}
 
-	t.log.Log("INFO", fmt.Sprintf("%s downloaded to %s", file.Name(), destPath))
+// download only the parts of a file that differ from the local copy and rebuild
+// it in place. falls back to a full download if there's no local copy to build from.
+// end-to-end encrypted drives always use full downloads.
+func (t *Transfer) DownloadDelta(file *svc.File, srcURL string) error {
+	if t.Keys != nil {
+		return t.Download(file.ClientPath, srcURL, file.CheckSum)
+	}
+	info, err := os.Stat(file.ClientPath)
+	if err != nil || info.Size() < DeltaMinSize {
+		return t.Download(file.ClientPath, srcURL, file.CheckSum)
+	}
+	sig, err := NewSignature(file.ID, file.ClientPath)
+	if err != nil {
+		return err
+	}
+	data, err := sig.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode signature: %v", err)
+	}
+	req, err := t.PrepareFileReq(http.MethodPost, srcURL+"/delta", "application/json", file, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute http request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		t.log.Warn(fmt.Sprintf("failed to get delta for %s, falling back to full download", file.Name))
+		return t.Download(file.ClientPath, srcURL, file.CheckSum)
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, resp.Body); err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	delta, err := UnmarshalDelta(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to decode delta: %v", err)
+	}
+	if err := ApplyDelta(file.ClientPath, file.ClientPath, delta); err != nil {
+		return fmt.Errorf("failed to apply delta: %v", err)
+	}
+	t.log.Log("INFO", fmt.Sprintf("%s downloaded to %s. %d bytes saved", file.Name, file.ClientPath, delta.Size-delta.DataSize()))
 	return nil
 }
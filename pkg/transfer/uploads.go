@@ -0,0 +1,336 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+resumable uploads (see service/uploads.go and server/uploads.go).
+
+large files are sent in parts through an upload session instead of in one
+request, so they're never read into memory all at once, and an upload that's
+interrupted only sends what the server doesn't already have when it's tried
+again. the server keeps unfinished sessions for svc.UploadSessionTTL, so this
+works across restarts too: a session for the same contents is picked back up
+rather than opening a new one.
+
+contents for end-to-end encrypted drives are encrypted into a temporary file
+first. since they're encrypted differently each time, those uploads can only
+be picked back up while the temporary file is still around.
+*/
+
+var (
+	// files at least this large are uploaded in parts
+	ResumableMinSize int64 = 16 * 1024 * 1024
+
+	// size of each part of a resumable upload
+	UploadPartSize int64 = 8 * 1024 * 1024
+
+	// number of parts of an upload sent at once
+	UploadWorkers = 4
+)
+
+// number of times missing parts are sent again before giving up on an upload.
+// the upload can still be picked up again later.
+const uploadRounds = 3
+
+// returned by UploadResumable when the server doesn't support upload sessions
+var ErrNoUploadSessions = errors.New("server does not support resumable uploads")
+
+// upload a file in parts through an upload session. new files (method is
+// POST) are added to the server without any contents first, then the
+// contents are uploaded to the new file.
+func (t *Transfer) UploadResumable(method string, file *svc.File, destURL string) error {
+	src := file.ClientPath
+	if t.Keys != nil {
+		sealed, err := t.sealToTemp(file)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(sealed)
+		src = sealed
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	checksum, err := svc.CalculateChecksumWith(src, svc.DefaultAlgorithm)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+	}
+
+	fileURL := destURL
+	if method == http.MethodPost {
+		if err := t.addEmpty(file, destURL); err != nil {
+			return err
+		}
+		fileURL = file.Endpoint
+	}
+
+	u, err := t.openUpload(fileURL, info.Size(), checksum)
+	if err != nil {
+		return err
+	}
+	if acked := u.Acknowledged(); acked > 0 {
+		t.log.Info(fmt.Sprintf("resuming upload of %s from byte %d of %d...", file.Name, acked, u.Size))
+	} else {
+		t.log.Info(fmt.Sprintf("uploading %s in parts (%d bytes)...", file.Name, u.Size))
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var (
+		enc        = t.uploadEncoding(src)
+		size, sent int64
+	)
+	for round := 0; round < uploadRounds && !u.Complete(); round++ {
+		n, wire, err := t.sendParts(f, fileURL, u, enc)
+		if err != nil {
+			t.log.Warn(fmt.Sprintf("failed to send parts of %s: %v", file.Name, err))
+		}
+		size, sent = size+n, sent+wire
+		if u, err = t.getUpload(fileURL, u.ID); err != nil {
+			return err
+		}
+	}
+	if !u.Complete() {
+		return fmt.Errorf("upload of %s is incomplete: %d of %d bytes sent", file.Name, u.Acknowledged(), u.Size)
+	}
+	if err := t.commitUpload(file, fileURL, u); err != nil {
+		return err
+	}
+	if enc != "" {
+		t.log.Info(fmt.Sprintf("uploaded %s: %s", file.Name, Savings(enc, size, sent)))
+	}
+	return nil
+}
+
+// encrypt a file's contents into a temporary file. returns its path.
+func (t *Transfer) sealToTemp(file *svc.File) (string, error) {
+	src, err := os.Open(file.ClientPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp("", "sfs-upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+	if err := t.Keys.Encrypt(tmp, src); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to encrypt %s: %v", file.Name, err)
+	}
+	return tmp.Name(), nil
+}
+
+// add a new file to the server without any contents
+func (t *Transfer) addEmpty(file *svc.File, destURL string) error {
+	req, err := t.PrepareFileReq(http.MethodPost, destURL, "application/octet-stream", file, new(bytes.Buffer))
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		return fmt.Errorf("failed to add %s to the server: %v", file.Name, resp.Status)
+	}
+	return nil
+}
+
+func decodeUpload(resp *http.Response) (*svc.UploadSession, error) {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return svc.UnmarshalUploadSession(data)
+}
+
+// pick up an unfinished upload of the same contents,
+// or open a new upload session if there isn't one
+func (t *Transfer) openUpload(fileURL string, size int64, checksum string) (*svc.UploadSession, error) {
+	resp, err := t.Client.Get(fileURL + "/uploads")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var uploads []*svc.UploadSession
+		if err := json.NewDecoder(resp.Body).Decode(&uploads); err != nil {
+			return nil, fmt.Errorf("failed to decode upload sessions: %v", err)
+		}
+		for _, u := range uploads {
+			if u.Size == size && u.CheckSum == checksum {
+				return u, nil
+			}
+		}
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, ErrNoUploadSessions
+	default:
+		t.dump(resp, true)
+		return nil, fmt.Errorf("failed to get upload sessions: %v", resp.Status)
+	}
+
+	q := url.Values{}
+	q.Set("size", strconv.FormatInt(size, 10))
+	q.Set("checksum", checksum)
+	resp, err = t.Client.Post(fileURL+"/uploads?"+q.Encode(), "application/json", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		return nil, fmt.Errorf("failed to open upload session: %v", resp.Status)
+	}
+	return decodeUpload(resp)
+}
+
+// get the latest state of an upload session
+func (t *Transfer) getUpload(fileURL string, uploadID string) (*svc.UploadSession, error) {
+	resp, err := t.Client.Get(fileURL + "/uploads/" + uploadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		return nil, fmt.Errorf("failed to get upload session: %v", resp.Status)
+	}
+	return decodeUpload(resp)
+}
+
+// send every part of an upload the server doesn't have yet, UploadWorkers
+// at a time, compressed with enc if it's set. returns the size of the parts
+// that were sent, and how many bytes that took.
+func (t *Transfer) sendParts(f *os.File, fileURL string, u *svc.UploadSession, enc string) (int64, int64, error) {
+	parts := make(chan svc.ByteRange)
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		errs       []error
+		size, sent int64
+	)
+	for i := 0; i < UploadWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				n, err := t.sendPart(f, fileURL, u.ID, part, enc)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					size, sent = size+part.Len(), sent+n
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, missing := range u.Missing() {
+		for start := missing.Start; start < missing.End; start += UploadPartSize {
+			end := start + UploadPartSize
+			if end > missing.End {
+				end = missing.End
+			}
+			parts <- svc.ByteRange{Start: start, End: end}
+		}
+	}
+	close(parts)
+	wg.Wait()
+	return size, sent, errors.Join(errs...)
+}
+
+// send a part of an upload, compressed with enc if it's set.
+// returns how many bytes were sent.
+func (t *Transfer) sendPart(f *os.File, fileURL string, uploadID string, part svc.ByteRange, enc string) (int64, error) {
+	var (
+		body io.Reader = io.NewSectionReader(f, part.Start, part.Len())
+		done           = make(chan int64, 1)
+	)
+	if enc != "" {
+		pr, pw := io.Pipe()
+		go func(src io.Reader) {
+			cw := &CountingWriter{W: pw}
+			zw, err := NewEncoder(cw, enc)
+			if err == nil {
+				if _, err = io.Copy(zw, src); err == nil {
+					err = zw.Close()
+				}
+			}
+			pw.CloseWithError(err)
+			done <- cw.N
+		}(body)
+		body = pr
+	} else {
+		done <- part.Len()
+	}
+
+	partURL := fmt.Sprintf("%s/uploads/%s?offset=%d", fileURL, uploadID, part.Start)
+	req, err := http.NewRequest(http.MethodPut, partURL, body)
+	if err != nil {
+		if c, ok := body.(io.Closer); ok {
+			c.Close()
+		}
+		return 0, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if enc != "" {
+		req.Header.Set("Content-Encoding", enc)
+	} else {
+		req.ContentLength = part.Len()
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send part at offset %d: %v", part.Start, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		return 0, fmt.Errorf("failed to send part at offset %d: %v", part.Start, resp.Status)
+	}
+	return <-done, nil
+}
+
+// replace the file's contents on the server with a finished upload
+func (t *Transfer) commitUpload(file *svc.File, fileURL string, u *svc.UploadSession) error {
+	req, err := t.PrepareFileReq(http.MethodPost, fileURL+"/uploads/"+u.ID, "application/json", file, new(bytes.Buffer))
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		t.log.Info(fmt.Sprintf("upload of %s finished", file.Name))
+		return nil
+	case http.StatusConflict:
+		// the server has changes to this file we haven't seen yet
+		return fmt.Errorf("server rejected update to %s: %v", file.Name, resp.Status)
+	default:
+		t.dump(resp, true)
+		return fmt.Errorf("failed to finish upload of %s: %v", file.Name, resp.Status)
+	}
+}
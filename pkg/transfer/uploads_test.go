@@ -0,0 +1,257 @@
+package transfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+// a server that only knows about upload sessions for one file
+type uploadServer struct {
+	mu        sync.Mutex
+	uploads   map[string]*svc.UploadSession
+	staged    map[string][]byte
+	received  int64          // bytes received in parts
+	failOnce  map[int64]bool // offsets of parts to reject the first time
+	added     bool           // whether the file was added without contents
+	committed []byte         // contents the file was updated with
+	sent      *svc.File      // file info sent with the commit
+}
+
+func newUploadServer() *uploadServer {
+	return &uploadServer{
+		uploads:  make(map[string]*svc.UploadSession),
+		staged:   make(map[string][]byte),
+		failOnce: make(map[int64]bool),
+	}
+}
+
+func (s *uploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/files/file-id")
+	switch {
+	case r.URL.Path == "/files" && r.Method == http.MethodPost:
+		s.added = true
+	case path == "/uploads" && r.Method == http.MethodGet:
+		uploads := make([]*svc.UploadSession, 0)
+		for _, u := range s.uploads {
+			uploads = append(uploads, u)
+		}
+		json.NewEncoder(w).Encode(uploads)
+	case path == "/uploads" && r.Method == http.MethodPost:
+		size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+		u := svc.NewUploadSession(&svc.File{ID: "file-id"}, size, r.URL.Query().Get("checksum"))
+		s.uploads[u.ID] = u
+		s.staged[u.ID] = make([]byte, size)
+		json.NewEncoder(w).Encode(u)
+	case strings.HasPrefix(path, "/uploads/"):
+		u, ok := s.uploads[strings.TrimPrefix(path, "/uploads/")]
+		if !ok {
+			http.Error(w, "upload session not found", http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(u)
+		case http.MethodPut:
+			offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+			if s.failOnce[offset] {
+				delete(s.failOnce, offset)
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			copy(s.staged[u.ID][offset:], data)
+			s.received += int64(len(data))
+			u.Add(svc.ByteRange{Start: offset, End: offset + int64(len(data))})
+			json.NewEncoder(w).Encode(u)
+		case http.MethodPost:
+			h, _ := svc.NewHash(svc.ChecksumAlgorithm(u.CheckSum))
+			h.Write(s.staged[u.ID])
+			if svc.FormatChecksum(svc.ChecksumAlgorithm(u.CheckSum), h.Sum(nil)) != u.CheckSum {
+				http.Error(w, "upload checksum mismatch", http.StatusUnprocessableEntity)
+				return
+			}
+			info, err := auth.NewT().Validate(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			s.sent, _ = svc.UnmarshalFileStr(info)
+			s.committed = s.staged[u.ID]
+			delete(s.uploads, u.ID)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestUploadResumable(t *testing.T) {
+	env.SetEnv(false)
+
+	defer func(min, part int64) { ResumableMinSize, UploadPartSize = min, part }(ResumableMinSize, UploadPartSize)
+	ResumableMinSize, UploadPartSize = 1024, 8*1024
+
+	testDir := GetTestingDir()
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "large.txt"), 2000)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	data, err := os.ReadFile(file.ClientPath)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	s := newUploadServer()
+	s.failOnce[2*UploadPartSize] = true
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	file.Endpoint = srv.URL + "/files/file-id"
+
+	// new files are added first, then uploaded in parts.
+	// parts that fail are sent again.
+	tr := NewTransfer()
+	if err := tr.Upload(http.MethodPost, file, srv.URL+"/files"); err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.True(t, s.added)
+	assert.Equal(t, data, s.committed)
+	assert.Equal(t, int64(len(data)), s.received)
+	assert.Equal(t, file.ID, s.sent.ID)
+	assert.Equal(t, 0, len(s.uploads))
+
+	// an interrupted upload is picked up where it left off
+	s.committed, s.received = nil, 0
+	checksum, err := svc.CalculateChecksumWith(file.ClientPath, svc.DefaultAlgorithm)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	u := svc.NewUploadSession(&svc.File{ID: "file-id"}, int64(len(data)), checksum)
+	u.Add(svc.ByteRange{Start: 0, End: 3 * UploadPartSize})
+	s.uploads[u.ID] = u
+	s.staged[u.ID] = make([]byte, len(data))
+	copy(s.staged[u.ID], data[:3*UploadPartSize])
+
+	if err := tr.Upload(http.MethodPut, file, file.Endpoint); err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.Equal(t, data, s.committed)
+	assert.Equal(t, int64(len(data))-3*UploadPartSize, s.received)
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadResumableFallback(t *testing.T) {
+	env.SetEnv(false)
+
+	defer func(min int64) { ResumableMinSize = min }(ResumableMinSize)
+	ResumableMinSize = 1024
+
+	testDir := GetTestingDir()
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "large.txt"), 100)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+
+	// servers without upload sessions get the whole file at once
+	var stored []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || strings.Contains(r.URL.Path, "/uploads") {
+			http.NotFound(w, r)
+			return
+		}
+		f, _, err := r.FormFile("myFile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stored, _ = io.ReadAll(f)
+	}))
+	defer srv.Close()
+
+	tr := NewTransfer()
+	if err := tr.Upload(http.MethodPut, file, srv.URL+"/files/file-id"); err != nil {
+		Fail(t, testDir, err)
+	}
+	assert.True(t, bytes.Equal([]byte(strings.Repeat(txtData, 100)), stored))
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUploadBlob(t *testing.T) {
+	env.SetEnv(false)
+
+	defer func(min int64) { BlobMinSize = min }(BlobMinSize)
+	BlobMinSize = 1024
+
+	testDir := GetTestingDir()
+	file, err := MakeTmpTxtFile(filepath.Join(testDir, "known.txt"), 100)
+	if err != nil {
+		Fail(t, testDir, err)
+	}
+	file.DriveID = "drive-id"
+
+	// a server that already has the contents, and answers
+	// requests to use them with claimStatus
+	var (
+		claimStatus int
+		askedFor    string
+		uploaded    bool
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/blobs/"):
+			askedFor = r.URL.Query().Get("drive")
+		case r.URL.Query().Get("blob") != "":
+			w.WriteHeader(claimStatus)
+		default:
+			uploaded = true
+		}
+	}))
+	defer srv.Close()
+
+	tr := NewTransfer()
+	upload := func(status int) error {
+		claimStatus, uploaded = status, false
+		return tr.Upload(http.MethodPut, file, srv.URL+"/v1/files/file-id")
+	}
+
+	// only the checksum is sent
+	assert.NoError(t, upload(http.StatusOK))
+	assert.Equal(t, "drive-id", askedFor)
+	assert.False(t, uploaded)
+
+	// the contents are sent if the blob is gone
+	assert.NoError(t, upload(http.StatusNotFound))
+	assert.True(t, uploaded)
+
+	// anything else is a failed upload
+	for _, status := range []int{http.StatusConflict, http.StatusRequestEntityTooLarge, http.StatusInsufficientStorage, http.StatusInternalServerError} {
+		assert.Error(t, upload(status), http.StatusText(status))
+		assert.False(t, uploaded)
+	}
+
+	if err := Clean(t, testDir); err != nil {
+		t.Fatal(err)
+	}
+}